@@ -14,7 +14,8 @@ import (
 )
 
 var (
-	portArg             = flag.Int("port", 9443, "server port to listen on\nports 9444 and 9445 reserved for other use")
+	portArg             = flag.Int("port", 9443, "server port to listen on\nports 9444, 9445 and 9446 reserved for other use")
+	metricsAddressArg   = flag.String("metrics-address", "", "address serving the metrics over http, e.g. 127.0.0.1:9447 behind kube-rbac-proxy\n(disabled by default)")
 	certArg             = flag.String("cert", "", "cert file path to enable TLS (disabled by default)")
 	keyArg              = flag.String("key", "", "private key file path to enable TLS (disabled by default)")
 	featuresArg         = flag.String("features", "", "enabled features, comma separated.\noptions: ['acm-alerting', 'alerting', 'legacy-dashboards', 'metrics', 'targets', 'perses-dashboards', 'cluster-health-analyzer']")
@@ -22,8 +23,15 @@ var (
 	configPathArg       = flag.String("config-path", "/opt/app-root/config", "config files path")
 	pluginConfigArg     = flag.String("plugin-config-path", "/etc/plugin/config.yaml", "plugin yaml configuration")
 	logLevelArg         = flag.String("log-level", logrus.InfoLevel.String(), "verbosity of logs\noptions: ['panic', 'fatal', 'error', 'warn', 'info', 'debug', 'trace']\n'trace' level will log all incoming requests")
-	alertmanagerUrlArg  = flag.String("alertmanager", "", "Alertmanager URL to proxy to for ACM mode")
-	thanosQuerierUrlArg = flag.String("thanos-querier", "", "Thanos Querier URL to proxy to for ACM mode")
+	alertmanagerUrlArg  = flag.String("alertmanager", "", "Alertmanager URL to proxy to for ACM mode\ncomma separated URLs are balanced as replicas")
+	thanosQuerierUrlArg = flag.String("thanos-querier", "", "Thanos Querier URL to proxy to for ACM mode\ncomma separated URLs are balanced as replicas")
+	persesUrlArg        = flag.String("perses", "", "Perses URL to proxy to for the perses-dashboards feature\ncomma separated URLs are balanced as replicas")
+	simulationArg       = flag.String("simulation", "", "alert scenario CSV file served in place of alertmanager and thanos-querier for ACM mode\nsee docs/incident_detection/simulate_scenarios")
+	devArg              = flag.Bool("dev", false, "development mode for ACM mode, serving simulated alertmanager and thanos-querier\nwith the built-in scenario unless -simulation is set")
+	kubeconfigArg       = flag.String("kubeconfig", "", "kubeconfig file path for ACM mode and the Perses proxy\nthe KUBECONFIG environment variable and the in-cluster config are used otherwise, in that order")
+	kubeContextArg      = flag.String("kube-context", "", "kubeconfig context for ACM mode (current context by default)")
+	asArg               = flag.String("as", "", "user to impersonate in the Kubernetes requests of ACM mode")
+	asGroupArg          = flag.String("as-group", "", "groups to impersonate in the Kubernetes requests of ACM mode, comma separated\nrequires -as")
 	tlsMinVersionArg    = flag.String("tls-min-version", "VersionTLS12", "minimum TLS version\noptions: ['VersionTLS10', 'VersionTLS11', 'VersionTLS12', 'VersionTLS13']")
 	tlsMaxVersionArg    = flag.String("tls-max-version", "", "maximum TLS version\noptions: ['VersionTLS10', 'VersionTLS11', 'VersionTLS12', 'VersionTLS13']\n(default is the highest supported by Go)")
 	tlsCipherSuitesArg  = flag.String("tls-cipher-suites", "", "comma-separated list of cipher suites for the server\nvalues are from tls package constants (https://golang.org/pkg/crypto/tls/#pkg-constants)")
@@ -34,6 +42,7 @@ func main() {
 	flag.Parse()
 
 	port := mergeEnvValueInt("PORT", *portArg)
+	metricsAddress := mergeEnvValue("MONITORING_PLUGIN_METRICS_ADDRESS", *metricsAddressArg)
 	cert := mergeEnvValue("CERT_FILE_PATH", *certArg)
 	key := mergeEnvValue("PRIVATE_KEY_FILE_PATH", *keyArg)
 	features := mergeEnvValue("MONITORING_PLUGIN_FEATURES", *featuresArg)
@@ -43,6 +52,12 @@ func main() {
 	logLevel := mergeEnvValue("MONITORING_PLUGIN_LOG_LEVEL", *logLevelArg)
 	alertmanagerUrl := mergeEnvValue("MONITORING_PLUGIN_ALERTMANAGER", *alertmanagerUrlArg)
 	thanosQuerierUrl := mergeEnvValue("MONITORING_PLUGIN_THANOS_QUERIER", *thanosQuerierUrlArg)
+	persesUrl := mergeEnvValue("MONITORING_PLUGIN_PERSES", *persesUrlArg)
+	simulation := mergeEnvValue("MONITORING_PLUGIN_SIMULATION", *simulationArg)
+	dev := mergeEnvValueBool("MONITORING_PLUGIN_DEV", *devArg)
+	kubeContext := mergeEnvValue("MONITORING_PLUGIN_KUBE_CONTEXT", *kubeContextArg)
+	impersonateUser := mergeEnvValue("MONITORING_PLUGIN_IMPERSONATE_USER", *asArg)
+	impersonateGroups := mergeEnvValue("MONITORING_PLUGIN_IMPERSONATE_GROUPS", *asGroupArg)
 	tlsMinVersion := mergeEnvValue("TLS_MIN_VERSION", *tlsMinVersionArg)
 	tlsMaxVersion := mergeEnvValue("TLS_MAX_VERSION", *tlsMaxVersionArg)
 	tlsCipherSuites := mergeEnvValue("TLS_CIPHER_SUITES", *tlsCipherSuitesArg)
@@ -77,6 +92,7 @@ func main() {
 
 	srv, err := server.CreateServer(context.Background(), &server.Config{
 		Port:             port,
+		MetricsAddress:   metricsAddress,
 		CertFile:         cert,
 		PrivateKeyFile:   key,
 		Features:         featuresSet,
@@ -85,9 +101,20 @@ func main() {
 		PluginConfigPath: pluginConfigPath,
 		AlertmanagerUrl:  alertmanagerUrl,
 		ThanosQuerierUrl: thanosQuerierUrl,
-		TLSMinVersion:    tlsMinVer,
-		TLSMaxVersion:    tlsMaxVer,
-		TLSCipherSuites:  tlsCiphers,
+		PersesUrl:        persesUrl,
+		Simulation:       simulation,
+		Dev:              dev,
+		KubeConfig: server.KubeConfig{
+			// The KUBECONFIG environment variable is read by the loader, so
+			// that its errors can tell it from the flag.
+			Path:              *kubeconfigArg,
+			Context:           kubeContext,
+			ImpersonateUser:   impersonateUser,
+			ImpersonateGroups: strings.FieldsFunc(impersonateGroups, func(r rune) bool { return r == ',' || r == ' ' }),
+		},
+		TLSMinVersion:   tlsMinVer,
+		TLSMaxVersion:   tlsMaxVer,
+		TLSCipherSuites: tlsCiphers,
 	})
 
 	if err != nil {
@@ -122,6 +149,19 @@ func mergeEnvValueInt(key string, arg int) int {
 	return i
 }
 
+func mergeEnvValueBool(key string, arg bool) bool {
+	if arg {
+		return arg
+	}
+
+	b, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return false
+	}
+
+	return b
+}
+
 func getCipherSuitesMap() map[string]uint16 {
 	result := make(map[string]uint16)
 
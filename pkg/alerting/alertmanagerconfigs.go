@@ -0,0 +1,454 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	amconfig "github.com/prometheus/alertmanager/config"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/alertmanager/types"
+	"github.com/prometheus/common/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/openshift/monitoring-plugin/pkg/api"
+)
+
+// AlertmanagerConfigResource is the resource of the AlertmanagerConfig objects
+// of the Prometheus operator, in the version served for user workloads.
+var AlertmanagerConfigResource = schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1beta1", Resource: "alertmanagerconfigs"}
+
+// templateField is a templated field of a receiver integration, with the
+// default Alertmanager uses when it is not set.
+type templateField struct {
+	name string
+	def  string
+	html bool
+}
+
+// integrationFields lists the templated fields of the integrations of an
+// AlertmanagerConfig receiver. The webhook integration has none, its payload
+// is rendered instead.
+var integrationFields = map[string][]templateField{
+	"discordConfigs":   {{name: "title", def: amconfig.DefaultDiscordConfig.Title}, {name: "message", def: amconfig.DefaultDiscordConfig.Message}},
+	"emailConfigs":     {{name: "subject", def: amconfig.DefaultEmailSubject}, {name: "html", def: amconfig.DefaultEmailConfig.HTML, html: true}, {name: "text"}},
+	"msteamsConfigs":   {{name: "title", def: amconfig.DefaultMSTeamsConfig.Title}, {name: "text", def: amconfig.DefaultMSTeamsConfig.Text}},
+	"opsgenieConfigs":  {{name: "message", def: amconfig.DefaultOpsGenieConfig.Message}, {name: "description", def: amconfig.DefaultOpsGenieConfig.Description}},
+	"pagerdutyConfigs": {{name: "description", def: amconfig.DefaultPagerdutyConfig.Description}},
+	"pushoverConfigs":  {{name: "title", def: amconfig.DefaultPushoverConfig.Title}, {name: "message", def: amconfig.DefaultPushoverConfig.Message}},
+	"slackConfigs": {
+		{name: "title", def: amconfig.DefaultSlackConfig.Title}, {name: "pretext", def: amconfig.DefaultSlackConfig.Pretext},
+		{name: "text", def: amconfig.DefaultSlackConfig.Text}, {name: "fallback", def: amconfig.DefaultSlackConfig.Fallback},
+	},
+	"snsConfigs":       {{name: "subject", def: amconfig.DefaultSNSConfig.Subject}, {name: "message", def: amconfig.DefaultSNSConfig.Message}},
+	"telegramConfigs":  {{name: "message", def: amconfig.DefaultTelegramConfig.Message}},
+	"victoropsConfigs": {{name: "entityDisplayName", def: amconfig.DefaultVictorOpsConfig.EntityDisplayName}, {name: "stateMessage", def: amconfig.DefaultVictorOpsConfig.StateMessage}},
+	"webexConfigs":     {{name: "message", def: amconfig.DefaultWebexConfig.Message}},
+	"webhookConfigs":   nil,
+	"wechatConfigs":    {{name: "message", def: amconfig.DefaultWechatConfig.Message}},
+}
+
+// requiredFields lists the fields without which an integration cannot send
+// notifications, any of them is enough.
+var requiredFields = map[string][]string{
+	"emailConfigs":     {"to"},
+	"pagerdutyConfigs": {"routingKey", "serviceKey"},
+	"webhookConfigs":   {"url", "urlSecret"},
+}
+
+var matchTypes = []string{"=", "!=", "=~", "!~"}
+
+type amMatcher struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	MatchType string `json:"matchType,omitempty"`
+	// Regex is the deprecated alternative to MatchType.
+	Regex bool `json:"regex,omitempty"`
+}
+
+type amRoute struct {
+	Receiver            string      `json:"receiver,omitempty"`
+	GroupBy             []string    `json:"groupBy,omitempty"`
+	GroupWait           string      `json:"groupWait,omitempty"`
+	GroupInterval       string      `json:"groupInterval,omitempty"`
+	RepeatInterval      string      `json:"repeatInterval,omitempty"`
+	Matchers            []amMatcher `json:"matchers,omitempty"`
+	Continue            bool        `json:"continue,omitempty"`
+	Routes              []amRoute   `json:"routes,omitempty"`
+	MuteTimeIntervals   []string    `json:"muteTimeIntervals,omitempty"`
+	ActiveTimeIntervals []string    `json:"activeTimeIntervals,omitempty"`
+}
+
+type amInhibitRule struct {
+	SourceMatch []amMatcher `json:"sourceMatch,omitempty"`
+	TargetMatch []amMatcher `json:"targetMatch,omitempty"`
+	Equal       []string    `json:"equal,omitempty"`
+}
+
+// amConfigSpec is the part of the AlertmanagerConfig spec which is
+// validated, the receivers are kept unstructured to handle every integration.
+type amConfigSpec struct {
+	Route         *amRoute                 `json:"route,omitempty"`
+	Receivers     []map[string]interface{} `json:"receivers,omitempty"`
+	InhibitRules  []amInhibitRule          `json:"inhibitRules,omitempty"`
+	TimeIntervals []struct {
+		Name string `json:"name"`
+	} `json:"timeIntervals,omitempty"`
+}
+
+func decodeAMConfigSpec(obj *unstructured.Unstructured) (amConfigSpec, error) {
+	var spec amConfigSpec
+	raw, found, err := unstructured.NestedFieldNoCopy(obj.Object, "spec")
+	if err != nil || !found {
+		return spec, fmt.Errorf("spec is required")
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return spec, err
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return spec, fmt.Errorf("invalid spec: %w", err)
+	}
+	return spec, nil
+}
+
+func validateMatchers(where string, matchers []amMatcher) []string {
+	var errs []string
+	for _, m := range matchers {
+		if !model.LabelName(m.Name).IsValid() {
+			errs = append(errs, fmt.Sprintf("%s: invalid matcher label name %q", where, m.Name))
+		}
+		if m.MatchType != "" && !contains(matchTypes, m.MatchType) {
+			errs = append(errs, fmt.Sprintf("%s: invalid match type %q of matcher %s", where, m.MatchType, m.Name))
+		}
+		if m.Regex || strings.HasSuffix(m.MatchType, "~") {
+			if _, err := regexp.Compile("^(?:" + m.Value + ")$"); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: invalid regex of matcher %s: %v", where, m.Name, err))
+			}
+		}
+	}
+	return errs
+}
+
+// AlertmanagerConfigs serves the AlertmanagerConfig API, and renders sample
+// notifications of their receivers.
+type AlertmanagerConfigs struct {
+	*Resources
+	tmpl *amtemplate.Template
+}
+
+// NewAlertmanagerConfigs returns the API of the AlertmanagerConfig objects,
+// the links of the rendered notifications point to externalURL.
+func NewAlertmanagerConfigs(client ClientFunc, externalURL *url.URL) (*AlertmanagerConfigs, error) {
+	tmpl, err := amtemplate.FromGlobs(nil)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.ExternalURL = externalURL
+	if tmpl.ExternalURL == nil {
+		tmpl.ExternalURL = &url.URL{}
+	}
+	a := &AlertmanagerConfigs{tmpl: tmpl}
+	a.Resources = &Resources{
+		client:   client,
+		resource: AlertmanagerConfigResource,
+		kind:     "AlertmanagerConfig",
+		validate: a.Validate,
+	}
+	return a, nil
+}
+
+// Validate checks the routes, receivers and inhibit rules of an
+// AlertmanagerConfig object. The templates of the receivers are rendered with
+// a sample alert, and unused receivers are reported as warnings.
+func (a *AlertmanagerConfigs) Validate(obj *unstructured.Unstructured) (errs []string, warnings []string) {
+	spec, err := decodeAMConfigSpec(obj)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+
+	receivers := map[string]bool{}
+	for i, receiver := range spec.Receivers {
+		name, _ := receiver["name"].(string)
+		where := fmt.Sprintf("receiver %q", name)
+		switch {
+		case name == "":
+			errs = append(errs, fmt.Sprintf("receiver %d: name is required", i))
+		case receivers[name]:
+			errs = append(errs, where+": duplicate receiver name")
+		}
+		receivers[name] = true
+
+		notifications := a.render(obj, receiver, sampleAlert(obj.GetNamespace(), nil, nil))
+		if len(notifications) == 0 {
+			warnings = append(warnings, where+": no integrations, notifications are dropped")
+		}
+		for _, n := range notifications {
+			if n.Error != "" {
+				errs = append(errs, fmt.Sprintf("%s: %s %d: %s", where, n.Integration, n.Index, n.Error))
+			}
+		}
+	}
+
+	intervals := map[string]bool{}
+	for _, ti := range spec.TimeIntervals {
+		intervals[ti.Name] = true
+	}
+	used := map[string]bool{}
+	var walk func(where string, route amRoute, root bool)
+	walk = func(where string, route amRoute, root bool) {
+		if route.Receiver == "" && root {
+			errs = append(errs, where+": receiver is required")
+		} else if route.Receiver != "" && !receivers[route.Receiver] {
+			errs = append(errs, fmt.Sprintf("%s: unknown receiver %q", where, route.Receiver))
+		}
+		used[route.Receiver] = true
+		for _, label := range route.GroupBy {
+			if label != "..." && !model.LabelName(label).IsValid() {
+				errs = append(errs, fmt.Sprintf("%s: invalid groupBy label %q", where, label))
+			}
+		}
+		for field, d := range map[string]string{"groupWait": route.GroupWait, "groupInterval": route.GroupInterval, "repeatInterval": route.RepeatInterval} {
+			if d == "" {
+				continue
+			}
+			if _, err := model.ParseDuration(d); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: invalid %s %q", where, field, d))
+			}
+		}
+		errs = append(errs, validateMatchers(where, route.Matchers)...)
+		for _, name := range append(append([]string{}, route.MuteTimeIntervals...), route.ActiveTimeIntervals...) {
+			if !intervals[name] {
+				errs = append(errs, fmt.Sprintf("%s: unknown time interval %q", where, name))
+			}
+		}
+		for i, child := range route.Routes {
+			walk(fmt.Sprintf("%s.routes[%d]", where, i), child, false)
+		}
+	}
+	if spec.Route != nil {
+		walk("route", *spec.Route, true)
+	} else if len(spec.Receivers) > 0 {
+		warnings = append(warnings, "spec has receivers but no route")
+	}
+	for name := range receivers {
+		if name != "" && !used[name] {
+			warnings = append(warnings, fmt.Sprintf("receiver %q: not used by any route", name))
+		}
+	}
+
+	for i, rule := range spec.InhibitRules {
+		where := fmt.Sprintf("inhibitRules[%d]", i)
+		errs = append(errs, validateMatchers(where, rule.SourceMatch)...)
+		errs = append(errs, validateMatchers(where, rule.TargetMatch)...)
+		for _, label := range rule.Equal {
+			if !model.LabelName(label).IsValid() {
+				errs = append(errs, fmt.Sprintf("%s: invalid equal label %q", where, label))
+			}
+		}
+		if len(rule.SourceMatch) == 0 || len(rule.TargetMatch) == 0 {
+			warnings = append(warnings, where+": no source or target matchers, the rule applies to every alert of the namespace")
+		}
+	}
+	// Maps are iterated in random order.
+	sort.Strings(errs)
+	sort.Strings(warnings)
+	return errs, warnings
+}
+
+// RenderedNotification is a notification of a receiver integration rendered
+// for a sample alert.
+type RenderedNotification struct {
+	Integration string            `json:"integration"`
+	Index       int               `json:"index"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// ReceiverTestRequest customizes the sample alert of a receiver test. Object
+// replaces the stored AlertmanagerConfig, to test changes before saving them.
+type ReceiverTestRequest struct {
+	Labels      map[string]string      `json:"labels,omitempty"`
+	Annotations map[string]string      `json:"annotations,omitempty"`
+	Object      map[string]interface{} `json:"object,omitempty"`
+}
+
+// ReceiverTestResponse holds the notifications a receiver would send for the
+// sample alert.
+type ReceiverTestResponse struct {
+	Receiver      string                 `json:"receiver"`
+	Alert         model.Alert            `json:"alert"`
+	Notifications []RenderedNotification `json:"notifications"`
+}
+
+func sampleAlert(namespace string, labels, annotations map[string]string) *types.Alert {
+	now := time.Now().UTC()
+	alert := &types.Alert{
+		Alert: model.Alert{
+			Labels: model.LabelSet{
+				model.AlertNameLabel: "TestAlert",
+				"namespace":          model.LabelValue(namespace),
+				"severity":           "warning",
+			},
+			Annotations: model.LabelSet{
+				"summary":     "Test notification",
+				"description": "This is a test notification sent from the console.",
+			},
+			StartsAt: now,
+			EndsAt:   now.Add(5 * time.Minute),
+		},
+		UpdatedAt: now,
+	}
+	for k, v := range labels {
+		alert.Labels[model.LabelName(k)] = model.LabelValue(v)
+	}
+	for k, v := range annotations {
+		alert.Annotations[model.LabelName(k)] = model.LabelValue(v)
+	}
+	return alert
+}
+
+// fieldValue returns the value of a templated field of an integration, the
+// email subject is a header.
+func fieldValue(integration string, config map[string]interface{}, field string) (string, bool) {
+	if integration == "emailConfigs" && field == "subject" {
+		headers, _ := config["headers"].([]interface{})
+		for _, h := range headers {
+			header, _ := h.(map[string]interface{})
+			if key, _ := header["key"].(string); strings.EqualFold(key, "subject") {
+				value, _ := header["value"].(string)
+				return value, true
+			}
+		}
+		return "", false
+	}
+	value, ok := config[field].(string)
+	return value, ok
+}
+
+// render renders the notifications of every integration of a receiver, as
+// Alertmanager would for the alert once the operator has generated the
+// configuration of obj.
+func (a *AlertmanagerConfigs) render(obj *unstructured.Unstructured, receiver map[string]interface{}, alert *types.Alert) []RenderedNotification {
+	name, _ := receiver["name"].(string)
+	// The operator prefixes the receivers with the namespace and the name of
+	// their AlertmanagerConfig.
+	fullName := fmt.Sprintf("%s/%s/%s", obj.GetNamespace(), obj.GetName(), name)
+	data := a.tmpl.Data(fullName, model.LabelSet{
+		model.AlertNameLabel: alert.Labels[model.AlertNameLabel],
+		"namespace":          alert.Labels["namespace"],
+	}, alert)
+
+	integrations := make([]string, 0, len(integrationFields))
+	for integration := range integrationFields {
+		integrations = append(integrations, integration)
+	}
+	sort.Strings(integrations)
+
+	notifications := []RenderedNotification{}
+	for _, integration := range integrations {
+		configs, _ := receiver[integration].([]interface{})
+		for i, c := range configs {
+			config, _ := c.(map[string]interface{})
+			n := RenderedNotification{Integration: integration, Index: i, Fields: map[string]string{}}
+			if required := requiredFields[integration]; len(required) > 0 {
+				found := false
+				for _, field := range required {
+					found = found || config[field] != nil
+				}
+				if !found {
+					n.Error = fmt.Sprintf("one of %s is required", strings.Join(required, ", "))
+				}
+			}
+
+			if integration == "webhookConfigs" {
+				payload, _ := json.MarshalIndent(struct {
+					*amtemplate.Data
+					Version         string `json:"version"`
+					GroupKey        string `json:"groupKey"`
+					TruncatedAlerts uint64 `json:"truncatedAlerts"`
+				}{Data: data, Version: "4", GroupKey: fmt.Sprintf("{}:{alertname=%q, namespace=%q}", data.GroupLabels["alertname"], data.GroupLabels["namespace"])}, "", "  ")
+				n.Fields["payload"] = string(payload)
+			}
+			for _, field := range integrationFields[integration] {
+				text, ok := fieldValue(integration, config, field.name)
+				if !ok {
+					text = field.def
+				}
+				var (
+					out string
+					err error
+				)
+				if field.html {
+					out, err = a.tmpl.ExecuteHTMLString(text, data)
+				} else {
+					out, err = a.tmpl.ExecuteTextString(text, data)
+				}
+				if err != nil && n.Error == "" {
+					n.Error = fmt.Sprintf("invalid template in %s: %v", field.name, err)
+				}
+				n.Fields[field.name] = out
+			}
+			notifications = append(notifications, n)
+		}
+	}
+	return notifications
+}
+
+// TestReceiverHandler renders the notifications the receiver named in the
+// path would send for a sample alert. Nothing is sent, the secrets of the
+// integrations are not read.
+func (a *AlertmanagerConfigs) TestReceiverHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ReceiverTestRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid receiver test: %w", err))
+				return
+			}
+		}
+		vars := mux.Vars(r)
+
+		obj := &unstructured.Unstructured{Object: req.Object}
+		if req.Object == nil {
+			resource, ok := a.namespaced(w, r)
+			if !ok {
+				return
+			}
+			var err error
+			if obj, err = resource.Get(r.Context(), vars["name"], metav1.GetOptions{}); err != nil {
+				api.WriteKubeError(w, err)
+				return
+			}
+		}
+		obj.SetNamespace(vars["namespace"])
+		obj.SetName(vars["name"])
+
+		spec, err := decodeAMConfigSpec(obj)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		for _, receiver := range spec.Receivers {
+			if receiver["name"] != vars["receiver"] {
+				continue
+			}
+			alert := sampleAlert(vars["namespace"], req.Labels, req.Annotations)
+			api.WriteJSON(w, http.StatusOK, ReceiverTestResponse{
+				Receiver:      vars["receiver"],
+				Alert:         alert.Alert,
+				Notifications: a.render(obj, receiver, alert),
+			})
+			return
+		}
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("receiver %s not found", vars["receiver"]))
+	}
+}
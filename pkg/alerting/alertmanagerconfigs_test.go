@@ -0,0 +1,84 @@
+package alerting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+const teamConfig = `{
+  "metadata": {"name": "routing"},
+  "spec": {
+    "route": {"receiver": "team", "groupBy": ["alertname"], "groupWait": "30s",
+      "routes": [{"receiver": "pager", "matchers": [{"name": "severity", "value": "critical"}]}]},
+    "receivers": [
+      {"name": "team", "slackConfigs": [{"channel": "#team", "title": "[{{ .Status | toUpper }}] {{ .CommonLabels.alertname }}"}]},
+      {"name": "pager", "webhookConfigs": [{"url": "https://pager.example.com"}]}
+    ],
+    "inhibitRules": [{"sourceMatch": [{"name": "severity", "value": "critical"}], "targetMatch": [{"name": "severity", "value": "warning"}], "equal": ["alertname"]}]
+  }
+}`
+
+func TestAlertmanagerConfigs(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		AlertmanagerConfigResource: "AlertmanagerConfigList",
+	})
+	externalURL, _ := url.Parse("https://alertmanager.example.com")
+	a, err := NewAlertmanagerConfigs(func(r *http.Request) (dynamic.Interface, error) { return client, nil }, externalURL)
+	require.NoError(t, err)
+	ns := map[string]string{"namespace": "team"}
+
+	rec, resp := resourceRequest(t, a.CreateHandler(), http.MethodPost, teamConfig, ns)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.Empty(t, resp.Errors)
+	require.Empty(t, resp.Warnings)
+	require.Equal(t, "AlertmanagerConfig", resp.Object["kind"])
+
+	invalid := `{"metadata": {"name": "bad"}, "spec": {
+	  "route": {"receiver": "missing", "groupWait": "soon", "matchers": [{"name": "severity", "value": "(", "matchType": "=~"}]},
+	  "receivers": [
+	    {"name": "hook", "webhookConfigs": [{}]},
+	    {"name": "mail", "emailConfigs": [{"to": "team@example.com", "html": "{{ .Broken"}]},
+	    {"name": "null"}
+	  ]
+	}}`
+	rec, resp = resourceRequest(t, a.CreateHandler(), http.MethodPost, invalid, ns)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	require.Len(t, resp.Errors, 5)
+	require.Contains(t, resp.Errors[0], `receiver "hook": webhookConfigs 0: one of url, urlSecret is required`)
+	require.Contains(t, resp.Errors[1], `receiver "mail": emailConfigs 0: invalid template in html`)
+	require.Contains(t, resp.Errors[2], `route: invalid groupWait "soon"`)
+	require.Contains(t, resp.Errors[3], `route: invalid regex of matcher severity`)
+	require.Contains(t, resp.Errors[4], `route: unknown receiver "missing"`)
+	require.Contains(t, resp.Warnings, `receiver "null": no integrations, notifications are dropped`)
+
+	vars := map[string]string{"namespace": "team", "name": "routing", "receiver": "team"}
+	rec, _ = resourceRequest(t, a.TestReceiverHandler(), http.MethodPost, "", vars)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var test ReceiverTestResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &test))
+	require.Len(t, test.Notifications, 1)
+	require.Equal(t, "slackConfigs", test.Notifications[0].Integration)
+	require.Equal(t, "[FIRING] TestAlert", test.Notifications[0].Fields["title"])
+	require.Contains(t, test.Notifications[0].Fields["fallback"], "[FIRING:1] TestAlert")
+
+	vars["receiver"] = "pager"
+	rec, _ = resourceRequest(t, a.TestReceiverHandler(), http.MethodPost, `{"labels":{"severity":"critical"}}`, vars)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &test))
+	payload := test.Notifications[0].Fields["payload"]
+	require.Contains(t, payload, `"receiver": "team/routing/pager"`)
+	require.Contains(t, payload, `"severity": "critical"`)
+	require.Contains(t, payload, `"externalURL": "https://alertmanager.example.com"`)
+
+	vars["receiver"] = "unknown"
+	rec, _ = resourceRequest(t, a.TestReceiverHandler(), http.MethodPost, "", vars)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
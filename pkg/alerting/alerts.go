@@ -0,0 +1,313 @@
+package alerting
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/sirupsen/logrus"
+
+	"github.com/openshift/monitoring-plugin/pkg/api"
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+var log = logrus.WithField("module", "alerting")
+
+const (
+	rulesPath    = "/api/v1/rules"
+	silencesPath = "/api/v2/silences"
+	silencePath  = "/api/v2/silence/"
+)
+
+// severityRanks orders severities from the most to the least severe, unknown
+// severities rank after info.
+var severityRanks = map[string]int{
+	"critical": 0,
+	"warning":  1,
+	"info":     2,
+	"none":     4,
+	"":         4,
+}
+
+func severityRank(severity string) int {
+	if rank, ok := severityRanks[severity]; ok {
+		return rank
+	}
+	return 3
+}
+
+// AlertsResponse is a page of joined alerts.
+type AlertsResponse struct {
+	Data []Alert `json:"data"`
+	// Total is the number of alerts matching the filters, across all pages.
+	Total    int      `json:"total"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// AlertFilter selects the alerts returned by the alerts API.
+type AlertFilter struct {
+	Severities []string
+	States     []string
+	Namespaces []string
+	Sources    []string
+	Labels     []*labels.Matcher
+}
+
+func (f AlertFilter) matches(a Alert) bool {
+	if len(f.Severities) > 0 && !contains(f.Severities, severityOf(a.Labels)) {
+		return false
+	}
+	if len(f.States) > 0 && !contains(f.States, a.State) {
+		return false
+	}
+	if len(f.Namespaces) > 0 && !contains(f.Namespaces, a.Labels["namespace"]) {
+		return false
+	}
+	if len(f.Sources) > 0 && !contains(f.Sources, a.Source) {
+		return false
+	}
+	for _, m := range f.Labels {
+		if !m.Matches(a.Labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// severityOf returns the severity label, "none" when it is missing.
+func severityOf(l map[string]string) string {
+	if s := l["severity"]; s != "" {
+		return s
+	}
+	return "none"
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// alertLess compares two alerts on a sort field, severity sorts the most
+// severe alerts first.
+var alertLess = map[string]func(a, b Alert) bool{
+	"alertname": func(a, b Alert) bool { return a.Labels["alertname"] < b.Labels["alertname"] },
+	"namespace": func(a, b Alert) bool { return a.Labels["namespace"] < b.Labels["namespace"] },
+	"state":     func(a, b Alert) bool { return a.State < b.State },
+	"source":    func(a, b Alert) bool { return a.Source < b.Source },
+	"severity": func(a, b Alert) bool {
+		return severityRank(a.Labels["severity"]) < severityRank(b.Labels["severity"])
+	},
+	"activeAt": func(a, b Alert) bool {
+		if a.ActiveAt == nil || b.ActiveAt == nil {
+			return a.ActiveAt != nil
+		}
+		return a.ActiveAt.Before(*b.ActiveAt)
+	},
+}
+
+// SortAlerts sorts alerts by a list of fields, each optionally prefixed with
+// "-" for a descending order. Ties are broken by alert name and labels.
+func SortAlerts(alerts []Alert, fields []string) error {
+	type key struct {
+		less func(a, b Alert) bool
+		desc bool
+	}
+	var keys []key
+	for _, f := range fields {
+		desc := strings.HasPrefix(f, "-")
+		less, ok := alertLess[strings.TrimPrefix(f, "-")]
+		if !ok {
+			return fmt.Errorf("invalid sort field %q", f)
+		}
+		keys = append(keys, key{less: less, desc: desc})
+	}
+	keys = append(keys, key{less: alertLess["alertname"]})
+
+	sort.SliceStable(alerts, func(i, j int) bool {
+		for _, k := range keys {
+			a, b := alerts[i], alerts[j]
+			if k.desc {
+				a, b = b, a
+			}
+			if k.less(a, b) {
+				return true
+			}
+			if k.less(b, a) {
+				return false
+			}
+		}
+		return labels.FromMap(alerts[i].Labels).String() < labels.FromMap(alerts[j].Labels).String()
+	})
+	return nil
+}
+
+// JoinAlerts flattens the alerts of the rule groups, joining each alert with
+// its rule, source and the active silences matching it.
+func JoinAlerts(groups []RuleGroup, silences []Silence) []Alert {
+	var active []CompiledSilence
+	for _, s := range silences {
+		if s.State() == SilenceActive {
+			active = append(active, CompileSilence(s))
+		}
+	}
+
+	alerts := []Alert{}
+	for _, g := range groups {
+		for _, rule := range g.Rules {
+			if rule.Type != "" && rule.Type != "alerting" {
+				continue
+			}
+			source := SourceUser
+			if rule.Labels["prometheus"] == platformPrometheus {
+				source = SourcePlatform
+			}
+			summary := RuleSummary{
+				Name:     rule.Name,
+				Group:    g.Name,
+				File:     g.File,
+				Query:    rule.Query,
+				Duration: rule.Duration,
+				Labels:   rule.Labels,
+			}
+			for _, ra := range rule.Alerts {
+				alert := Alert{
+					Labels:      ra.Labels,
+					Annotations: ra.Annotations,
+					State:       ra.State,
+					ActiveAt:    ra.ActiveAt,
+					Value:       ra.Value,
+					Source:      source,
+					Rule:        summary,
+				}
+				if alert.State == StateFiring {
+					for _, s := range active {
+						if s.Matches(alert.Labels) {
+							alert.SilencedBy = append(alert.SilencedBy, s.Silence)
+						}
+					}
+					if len(alert.SilencedBy) > 0 {
+						alert.State = StateSilenced
+					}
+				}
+				alerts = append(alerts, alert)
+			}
+		}
+	}
+	return alerts
+}
+
+// AlertsHandler serves the alerts of the Thanos Querier rules joined with their
+// rules, the Alertmanager silences and their source. The alerts can be
+// filtered with the severity, state, namespace, source and label parameters,
+// sorted with the sort parameter and paginated with limit and offset.
+func AlertsHandler(thanos, alertmanager monitoring.Upstream) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAlertFilter(r)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		offset, err := api.IntParam(r, "offset", 0)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		limit, err := api.IntParam(r, "limit", 0)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// The namespace is forwarded so that tenancy enforcing upstreams
+		// only return the alerts the user can read.
+		query := url.Values{"type": []string{"alert"}}
+		if len(filter.Namespaces) == 1 {
+			query.Set("namespace", filter.Namespaces[0])
+		}
+
+		var (
+			groups                struct{ Groups []RuleGroup }
+			silences              []Silence
+			rulesErr, silencesErr error
+			wg                    sync.WaitGroup
+		)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			rulesErr = thanos.Query(r, rulesPath, query, &groups)
+		}()
+		go func() {
+			defer wg.Done()
+			silencesErr = alertmanager.Get(r, silencesPath, nil, &silences)
+		}()
+		wg.Wait()
+
+		if rulesErr != nil {
+			log.WithError(rulesErr).Error("cannot fetch alerting rules")
+			api.WriteError(w, http.StatusBadGateway, rulesErr)
+			return
+		}
+		var warnings []string
+		if silencesErr != nil {
+			// As in the frontend, alerts are still listed but may actually be silenced.
+			log.WithError(silencesErr).Warn("cannot fetch silences")
+			warnings = append(warnings, fmt.Sprintf("cannot load silences, some alerts may actually be silenced: %v", silencesErr))
+		}
+
+		alerts := []Alert{}
+		for _, a := range JoinAlerts(groups.Groups, silences) {
+			if filter.matches(a) {
+				alerts = append(alerts, a)
+			}
+		}
+		if err := SortAlerts(alerts, api.ListParam(r, "sort")); err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		api.WriteJSON(w, http.StatusOK, AlertsResponse{
+			Data:     paginate(alerts, offset, limit),
+			Total:    len(alerts),
+			Warnings: warnings,
+		})
+	}
+}
+
+func parseAlertFilter(r *http.Request) (AlertFilter, error) {
+	filter := AlertFilter{
+		Severities: api.ListParam(r, "severity"),
+		States:     api.ListParam(r, "state"),
+		Namespaces: api.ListParam(r, "namespace"),
+		Sources:    api.ListParam(r, "source"),
+	}
+	// Label matchers are not comma separated as regular expressions may contain commas.
+	for _, l := range r.URL.Query()["label"] {
+		m, err := ParseLabelMatcher(l)
+		if err != nil {
+			return filter, err
+		}
+		filter.Labels = append(filter.Labels, m)
+	}
+	return filter, nil
+}
+
+// paginate returns the page of items starting at offset, a zero limit
+// returns every remaining item.
+func paginate[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,136 @@
+package alerting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+const testRules = `{"status":"success","data":{"groups":[{"name":"g","file":"f","rules":[
+  {"name":"Down","type":"alerting","query":"up == 0","labels":{"severity":"critical","prometheus":"openshift-monitoring/k8s"},"alerts":[
+    {"labels":{"alertname":"Down","severity":"critical","namespace":"a","job":"x"},"state":"firing","activeAt":"2024-01-01T00:00:00Z"},
+    {"labels":{"alertname":"Down","severity":"critical","namespace":"b","job":"y"},"state":"firing","activeAt":"2024-01-01T01:00:00Z"}
+  ]},
+  {"name":"Slow","type":"alerting","query":"latency > 1","labels":{"severity":"warning"},"alerts":[
+    {"labels":{"alertname":"Slow","severity":"warning","namespace":"a"},"state":"pending"}
+  ]}
+]}]}}`
+
+const testSilences = `[
+  {"id":"1","matchers":[{"name":"job","value":"x|z","isRegex":true}],"status":{"state":"active"}},
+  {"id":"2","matchers":[{"name":"alertname","value":"Down","isRegex":false}],"status":{"state":"expired"}}
+]`
+
+func staticUpstream(kind monitoring.KindType, body string) monitoring.Upstream {
+	return monitoring.Upstream{Kind: kind, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	})}
+}
+
+func getAlerts(t *testing.T, query string) AlertsResponse {
+	handler := AlertsHandler(
+		staticUpstream(monitoring.ThanosQuerierKind, testRules),
+		staticUpstream(monitoring.AlertManagerKind, testSilences),
+	)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/alerts?"+query, nil))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var resp AlertsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestAlertsJoin(t *testing.T) {
+	resp := getAlerts(t, "")
+	require.Equal(t, 3, resp.Total)
+
+	byJob := map[string]Alert{}
+	for _, a := range resp.Data {
+		byJob[a.Labels["job"]] = a
+	}
+	require.Equal(t, StateSilenced, byJob["x"].State)
+	require.Len(t, byJob["x"].SilencedBy, 1)
+	require.Equal(t, "1", byJob["x"].SilencedBy[0].ID)
+	require.Equal(t, StateFiring, byJob["y"].State)
+	require.Equal(t, SourcePlatform, byJob["x"].Source)
+	require.Equal(t, SourceUser, byJob[""].Source)
+	require.Equal(t, "up == 0", byJob["x"].Rule.Query)
+}
+
+func TestAlertsFilterSortPaginate(t *testing.T) {
+	resp := getAlerts(t, "namespace=a")
+	require.Equal(t, 2, resp.Total)
+
+	resp = getAlerts(t, "severity=warning,info")
+	require.Equal(t, 1, resp.Total)
+	require.Equal(t, "Slow", resp.Data[0].Labels["alertname"])
+
+	resp = getAlerts(t, "state=firing")
+	require.Equal(t, 1, resp.Total)
+	require.Equal(t, "y", resp.Data[0].Labels["job"])
+
+	resp = getAlerts(t, "label=job%3D~x%7Cy")
+	require.Equal(t, 2, resp.Total)
+
+	resp = getAlerts(t, "sort=-severity")
+	require.Equal(t, "Slow", resp.Data[0].Labels["alertname"])
+
+	resp = getAlerts(t, "sort=severity,-activeAt&limit=1&offset=0")
+	require.Equal(t, 3, resp.Total)
+	require.Len(t, resp.Data, 1)
+	require.Equal(t, "y", resp.Data[0].Labels["job"])
+
+	resp = getAlerts(t, "limit=2&offset=2")
+	require.Len(t, resp.Data, 1)
+}
+
+func TestIsSilenced(t *testing.T) {
+	notEqual := false
+	silence := Silence{Matchers: []Matcher{
+		{Name: "alertname", Value: "Down"},
+		{Name: "namespace", Value: "kube-.*", IsRegex: true, IsEqual: &notEqual},
+	}}
+	require.True(t, IsSilenced(map[string]string{"alertname": "Down", "namespace": "default"}, silence))
+	require.False(t, IsSilenced(map[string]string{"alertname": "Down", "namespace": "kube-system"}, silence))
+	require.False(t, IsSilenced(map[string]string{"alertname": "Up"}, silence))
+
+	// Compiled silences match many alerts, invalid regexes never match.
+	compiled := CompileSilences([]Silence{silence, {Matchers: []Matcher{{Name: "alertname", Value: "(", IsRegex: true}}}})
+	require.True(t, compiled[0].Matches(map[string]string{"alertname": "Down", "namespace": "default"}))
+	require.False(t, compiled[0].Matches(map[string]string{"alertname": "Down", "namespace": "kube-system"}))
+	require.False(t, compiled[1].Matches(map[string]string{"alertname": "("}))
+}
+
+func TestParseLabelMatcher(t *testing.T) {
+	for _, tc := range []struct {
+		in, name, value string
+		typ             labels.MatchType
+	}{
+		{in: "namespace=ns", name: "namespace", value: "ns", typ: labels.MatchEqual},
+		{in: `severity != "info"`, name: "severity", value: "info", typ: labels.MatchNotEqual},
+		{in: "alertname=~Kube.*", name: "alertname", value: "Kube.*", typ: labels.MatchRegexp},
+		{in: "pod!~web-.*", name: "pod", value: "web-.*", typ: labels.MatchNotRegexp},
+		// The values can contain operators.
+		{in: "foo=bar=~x", name: "foo", value: "bar=~x", typ: labels.MatchEqual},
+		{in: "a=b!=c", name: "a", value: "b!=c", typ: labels.MatchEqual},
+		{in: "a=~b=c", name: "a", value: "b=c", typ: labels.MatchRegexp},
+	} {
+		m, err := ParseLabelMatcher(tc.in)
+		require.NoError(t, err, tc.in)
+		require.Equal(t, tc.name, m.Name, tc.in)
+		require.Equal(t, tc.value, m.Value, tc.in)
+		require.Equal(t, tc.typ, m.Type, tc.in)
+	}
+
+	for _, in := range []string{"namespace", "=ns", "!=ns"} {
+		_, err := ParseLabelMatcher(in)
+		require.Error(t, err, in)
+	}
+}
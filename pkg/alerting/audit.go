@@ -0,0 +1,364 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/dynamic"
+
+	"github.com/openshift/monitoring-plugin/pkg/api"
+)
+
+const (
+	defaultAuditConfigMap = "monitoring-plugin-silence-history"
+	// maxAuditBytes keeps the history under the 1MiB limit of ConfigMaps.
+	maxAuditBytes = 900 * 1024
+	// auditQueueSize bounds the entries waiting to be appended, the requests
+	// recording more wait for the history to catch up.
+	auditQueueSize = 1000
+)
+
+// AuditConfig configures the history of the silence changes made through the
+// Alertmanager proxy.
+type AuditConfig struct {
+	Enabled     bool `yaml:"enabled,omitempty"`
+	StoreConfig `yaml:",inline"`
+	// MaxEntries bounds the history, the oldest entries are dropped first.
+	// Entries are also dropped to keep the history under 900KiB.
+	MaxEntries int `yaml:"maxEntries,omitempty"`
+	// The history can only be read by the users who can create silences, as
+	// it has the matchers of every namespace.
+	SilenceAccessConfig `yaml:",inline"`
+}
+
+func (c AuditConfig) withDefaults() AuditConfig {
+	if c.MaxEntries == 0 {
+		c.MaxEntries = 1000
+	}
+	return c
+}
+
+// Audit actions.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditExpire = "expire"
+)
+
+// AuditEntry records a silence change.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Action    string    `json:"action"`
+	SilenceID string    `json:"silenceID"`
+	Matchers  []Matcher `json:"matchers,omitempty"`
+	// Changes lists the fields changed by an update.
+	Changes []AuditChange `json:"changes,omitempty"`
+}
+
+// AuditChange is a field changed by a silence update.
+type AuditChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// UserFunc returns the name of the user making a request.
+type UserFunc func(r *http.Request) string
+
+// Audit records the silence changes going through the Alertmanager proxy in
+// an append-only history. The entries are queued by the requests and appended
+// in batches by Run.
+type Audit struct {
+	config AuditConfig
+	user   UserFunc
+	// access authorizes the users reading the history.
+	access AccessFunc
+	store  Store[AuditEntry]
+	queue  chan AuditEntry
+}
+
+func NewAudit(config AuditConfig, client dynamic.Interface, user UserFunc, access AccessFunc) (*Audit, error) {
+	store, err := NewStore[AuditEntry](config.StoreConfig, client, defaultAuditConfigMap, "history.json")
+	if err != nil {
+		return nil, err
+	}
+	return &Audit{config: config.withDefaults(), user: user, access: access, store: store, queue: make(chan AuditEntry, auditQueueSize)}, nil
+}
+
+// Run appends the queued entries to the history until ctx is done, with a
+// single update of the store for the entries queued meanwhile.
+func (a *Audit) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			a.flush(context.WithoutCancel(ctx), nil)
+			return
+		case entry := <-a.queue:
+			a.flush(ctx, []AuditEntry{entry})
+		}
+	}
+}
+
+// flush appends batch and the queued entries to the history.
+func (a *Audit) flush(ctx context.Context, batch []AuditEntry) {
+queued:
+	for len(batch) < auditQueueSize {
+		select {
+		case entry := <-a.queue:
+			batch = append(batch, entry)
+		default:
+			break queued
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if err := a.append(ctx, batch); err != nil {
+		log.WithError(err).Errorf("cannot record %d silence changes", len(batch))
+	}
+}
+
+func (a *Audit) append(ctx context.Context, batch []AuditEntry) error {
+	// Other replicas of the plugin append to the same history.
+	return a.store.Update(ctx, func(entries []AuditEntry) ([]AuditEntry, error) {
+		entries = append(entries, batch...)
+		if len(entries) > a.config.MaxEntries {
+			entries = entries[len(entries)-a.config.MaxEntries:]
+		}
+		return trimEntries(entries, maxAuditBytes)
+	})
+}
+
+// trimEntries drops the oldest entries until the history encodes in size
+// bytes.
+func trimEntries(entries []AuditEntry, size int) ([]AuditEntry, error) {
+	for len(entries) > 0 {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if len(data) <= size {
+			break
+		}
+		// Drop the share of the entries exceeding the size, at least one.
+		drop := max(1, len(entries)*(len(data)-size)/len(data))
+		entries = entries[drop:]
+	}
+	return entries, nil
+}
+
+// captureWriter keeps the status and body of a response while writing it.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *captureWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Middleware records the silences created, updated and expired through next.
+func (a *Audit) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(path, silencesPath):
+			a.auditPost(w, r, next)
+		case r.Method == http.MethodDelete && strings.Contains(path, silencePath):
+			a.auditDelete(w, r, next)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (a *Audit) auditPost(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var silence Silence
+	if err := json.Unmarshal(body, &silence); err != nil {
+		// Alertmanager reports the invalid silence.
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	var previous *Silence
+	if silence.ID != "" {
+		prefix := strings.TrimSuffix(r.URL.Path, silencesPath)
+		previous = a.getSilence(r, prefix+silencePath+silence.ID, next)
+	}
+
+	cw := &captureWriter{ResponseWriter: w}
+	next.ServeHTTP(cw, r)
+	if cw.status < 200 || cw.status > 299 {
+		return
+	}
+
+	var created struct {
+		SilenceID string `json:"silenceID"`
+	}
+	json.Unmarshal(cw.body.Bytes(), &created)
+	entry := AuditEntry{
+		Time:      time.Now().UTC(),
+		User:      a.user(r),
+		Action:    AuditCreate,
+		SilenceID: created.SilenceID,
+		Matchers:  silence.Matchers,
+	}
+	if previous != nil {
+		entry.Action = AuditUpdate
+		entry.Changes = silenceChanges(*previous, silence)
+		if created.SilenceID != "" && created.SilenceID != previous.ID {
+			// Alertmanager replaces the silences whose matchers changed.
+			entry.Changes = append(entry.Changes, AuditChange{Field: "id", Old: previous.ID, New: created.SilenceID})
+		}
+	}
+	a.record(entry)
+}
+
+func (a *Audit) auditDelete(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, silencePath)+len(silencePath):]
+	previous := a.getSilence(r, r.URL.Path, next)
+
+	cw := &captureWriter{ResponseWriter: w}
+	next.ServeHTTP(cw, r)
+	if cw.status != 0 && (cw.status < 200 || cw.status > 299) {
+		return
+	}
+
+	entry := AuditEntry{
+		Time:      time.Now().UTC(),
+		User:      a.user(r),
+		Action:    AuditExpire,
+		SilenceID: id,
+	}
+	if previous != nil {
+		entry.Matchers = previous.Matchers
+	}
+	a.record(entry)
+}
+
+func (a *Audit) record(entry AuditEntry) {
+	a.queue <- entry
+}
+
+// getSilence reads a silence through next with the credentials of r.
+func (a *Audit) getSilence(r *http.Request, path string, next http.Handler) *Silence {
+	req := r.Clone(r.Context())
+	req.Method = http.MethodGet
+	req.URL.Path = path
+	req.URL.RawPath = ""
+	req.URL.RawQuery = ""
+	req.RequestURI = ""
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	req.Header.Del("Content-Type")
+	req.Header.Del("Accept-Encoding")
+
+	rec := &captureWriter{ResponseWriter: discardWriter{header: http.Header{}}}
+	next.ServeHTTP(rec, req)
+	if rec.status != http.StatusOK {
+		return nil
+	}
+	var silence Silence
+	if err := json.Unmarshal(rec.body.Bytes(), &silence); err != nil {
+		return nil
+	}
+	return &silence
+}
+
+type discardWriter struct {
+	header http.Header
+}
+
+func (w discardWriter) Header() http.Header         { return w.header }
+func (w discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardWriter) WriteHeader(int)             {}
+
+func silenceChanges(old, updated Silence) []AuditChange {
+	var changes []AuditChange
+	add := func(field, o, n string) {
+		if o != n {
+			changes = append(changes, AuditChange{Field: field, Old: o, New: n})
+		}
+	}
+	matchers := func(ms []Matcher) string {
+		s := make([]string, 0, len(ms))
+		for _, m := range ms {
+			s = append(s, m.String())
+		}
+		return strings.Join(s, ", ")
+	}
+	add("matchers", matchers(old.Matchers), matchers(updated.Matchers))
+	add("startsAt", old.StartsAt.UTC().Format(time.RFC3339), updated.StartsAt.UTC().Format(time.RFC3339))
+	add("endsAt", old.EndsAt.UTC().Format(time.RFC3339), updated.EndsAt.UTC().Format(time.RFC3339))
+	add("createdBy", old.CreatedBy, updated.CreatedBy)
+	add("comment", old.Comment, updated.Comment)
+	return changes
+}
+
+// ListHandler serves the history, newest first, filtered with the id, user
+// and action parameters and bounded by limit, to the users who can create
+// silences.
+func (a *Audit) ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := a.access(r); err != nil {
+			api.WriteKubeError(w, err)
+			return
+		}
+		limit, err := api.IntParam(r, "limit", 0)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		query := r.URL.Query()
+		id, user, actions := query.Get("id"), query.Get("user"), api.ListParam(r, "action")
+
+		entries, err := a.store.Load(r.Context())
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, fmt.Errorf("cannot load silence history: %w", err))
+			return
+		}
+
+		resp := []AuditEntry{}
+		for _, e := range slices.Backward(entries) {
+			if id != "" && e.SilenceID != id && !slices.ContainsFunc(e.Changes, func(c AuditChange) bool { return c.Field == "id" && c.Old == id }) {
+				continue
+			}
+			if user != "" && e.User != user {
+				continue
+			}
+			if len(actions) > 0 && !contains(actions, e.Action) {
+				continue
+			}
+			resp = append(resp, e)
+			if limit > 0 && len(resp) == limit {
+				break
+			}
+		}
+		api.WriteJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,137 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestAuditMiddleware(t *testing.T) {
+	endsAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	am := &fakeAlertmanager{silences: []Silence{{
+		ID:        "s1",
+		Matchers:  []Matcher{{Name: "alertname", Value: "Down"}},
+		EndsAt:    endsAt,
+		CreatedBy: "alice",
+	}}}
+	audit, err := NewAudit(AuditConfig{
+		StoreConfig: StoreConfig{File: filepath.Join(t.TempDir(), "history.json")},
+		MaxEntries:  2,
+	}, nil, func(r *http.Request) string { return r.Header.Get("X-Forwarded-User") }, func(r *http.Request) (string, error) {
+		if r.Header.Get("Authorization") != "Bearer ops" {
+			return "", apierrors.NewForbidden(schema.GroupResource{Resource: "alertmanagers/api"}, "main", errors.New("the user cannot create silences"))
+		}
+		return "ops-user", nil
+	})
+	require.NoError(t, err)
+	handler := audit.Middleware(am)
+
+	send := func(method, path string, body interface{}) {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		req.Header.Set("X-Forwarded-User", "bob")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	send(http.MethodPost, silencesPath, Silence{Matchers: []Matcher{{Name: "namespace", Value: "ns"}}, CreatedBy: "bob"})
+	updated := am.silences[0]
+	updated.EndsAt = endsAt.Add(time.Hour)
+	updated.Comment = "extended"
+	send(http.MethodPost, silencesPath, updated)
+	send(http.MethodDelete, silencePath+"s1", nil)
+	audit.flush(t.Context(), nil)
+
+	// Only the users who can create silences read the history.
+	rec := httptest.NewRecorder()
+	audit.ListHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/silence-history", nil))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/silence-history", nil)
+	req.Header.Set("Authorization", "Bearer ops")
+	audit.ListHandler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var entries []AuditEntry
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+
+	// The history is bounded to the 2 most recent entries, newest first.
+	require.Len(t, entries, 2)
+	require.Equal(t, AuditExpire, entries[0].Action)
+	require.Equal(t, "s1", entries[0].SilenceID)
+	require.Equal(t, "bob", entries[0].User)
+	require.Equal(t, am.silences[0].Matchers, entries[0].Matchers)
+
+	require.Equal(t, AuditUpdate, entries[1].Action)
+	require.Equal(t, []AuditChange{
+		{Field: "endsAt", Old: "2024-06-01T00:00:00Z", New: "2024-06-01T01:00:00Z"},
+		{Field: "comment", Old: "", New: "extended"},
+	}, entries[1].Changes)
+}
+
+// countingStore counts the updates of a store.
+type countingStore[T any] struct {
+	Store[T]
+	updates atomic.Int32
+}
+
+func (s *countingStore[T]) Update(ctx context.Context, update func([]T) ([]T, error)) error {
+	s.updates.Add(1)
+	return s.Store.Update(ctx, update)
+}
+
+func TestAuditRun(t *testing.T) {
+	store := &countingStore[AuditEntry]{Store: FileStore[AuditEntry]{Path: filepath.Join(t.TempDir(), "history.json")}}
+	audit := &Audit{config: AuditConfig{}.withDefaults(), store: store, queue: make(chan AuditEntry, auditQueueSize)}
+	for i := range 5 {
+		audit.record(AuditEntry{Action: AuditCreate, SilenceID: fmt.Sprint(i)})
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		audit.Run(ctx)
+		close(done)
+	}()
+
+	// The queued entries are appended at once.
+	require.Eventually(t, func() bool {
+		entries, err := store.Load(t.Context())
+		return err == nil && len(entries) == 5
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(1), store.updates.Load())
+
+	cancel()
+	<-done
+}
+
+func TestTrimEntries(t *testing.T) {
+	var entries []AuditEntry
+	for i := range 100 {
+		entries = append(entries, AuditEntry{User: "alice", Action: AuditCreate, SilenceID: fmt.Sprint(i)})
+	}
+	trimmed, err := trimEntries(entries, 4096)
+	require.NoError(t, err)
+	data, err := json.MarshalIndent(trimmed, "", "  ")
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(data), 4096)
+	require.NotEmpty(t, trimmed)
+	require.Equal(t, "99", trimmed[len(trimmed)-1].SilenceID)
+
+	trimmed, err = trimEntries(entries, 1<<20)
+	require.NoError(t, err)
+	require.Len(t, trimmed, 100)
+}
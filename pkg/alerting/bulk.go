@@ -0,0 +1,370 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/openshift/monitoring-plugin/pkg/api"
+)
+
+// maxBulkItems bounds the number of silences changed by a bulk request.
+const maxBulkItems = 1000
+
+// Bulk actions.
+const (
+	BulkCreate = "create"
+	BulkExtend = "extend"
+	BulkExpire = "expire"
+)
+
+// Bulk result statuses.
+const (
+	BulkSucceeded = "succeeded"
+	BulkFailed    = "failed"
+	BulkDryRun    = "dry-run"
+)
+
+// BulkRequest creates, extends or expires many silences at once.
+type BulkRequest struct {
+	Action string `json:"action"`
+	// DryRun returns the changes without applying them.
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Silences are created as is by the create action.
+	Silences []Silence `json:"silences,omitempty"`
+	// Alerts selects firing alerts, the create action adds a silence matching
+	// the labels of each of them.
+	Alerts []string `json:"alerts,omitempty"`
+	// Incident is the group id of an incident of the cluster health
+	// analyzer, the create action adds a silence for each of its firing
+	// alerts which is not silenced yet.
+	Incident string `json:"incident,omitempty"`
+	// Duration of the silences created for alerts, or added by the extend
+	// action, in the Prometheus duration format.
+	Duration  string `json:"duration,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+	CreatedBy string `json:"createdBy,omitempty"`
+
+	// Selector picks the silences of the extend and expire actions.
+	Selector *BulkSelector `json:"selector,omitempty"`
+}
+
+// BulkSelector selects existing silences. Every set field must match, expired
+// silences are never selected.
+type BulkSelector struct {
+	IDs       []string `json:"ids,omitempty"`
+	CreatedBy string   `json:"createdBy,omitempty"`
+	// Matchers must all be present in the silence, in the name=value form.
+	Matchers []string `json:"matchers,omitempty"`
+	// Labels selects the silences which silence an alert with these labels.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Incident selects the silences which silence an alert of the incident
+	// with this group id.
+	Incident string `json:"incident,omitempty"`
+}
+
+// BulkResult is the outcome of a bulk request for one silence.
+type BulkResult struct {
+	ID      string   `json:"id,omitempty"`
+	Status  string   `json:"status"`
+	Error   string   `json:"error,omitempty"`
+	Silence *Silence `json:"silence,omitempty"`
+}
+
+// BulkResponse holds a result per silence, in the order they were processed.
+type BulkResponse struct {
+	Action    string       `json:"action"`
+	DryRun    bool         `json:"dryRun,omitempty"`
+	Results   []BulkResult `json:"results"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+}
+
+// BulkHandler applies a BulkRequest. Failures of single silences are
+// reported in their result and do not stop the other changes.
+func (s *Silences) BulkHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BulkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid bulk request: %w", err))
+			return
+		}
+
+		var (
+			planned []Silence
+			err     error
+		)
+		switch req.Action {
+		case BulkCreate:
+			planned, err = s.planCreate(r, req)
+		case BulkExtend:
+			planned, err = s.planExtend(r, req)
+		case BulkExpire:
+			planned, err = s.selectSilences(r, req.Selector)
+		default:
+			err = fmt.Errorf("invalid action %q, expected one of %s, %s or %s", req.Action, BulkCreate, BulkExtend, BulkExpire)
+		}
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if len(planned) > maxBulkItems {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("bulk request changes %d silences, the limit is %d", len(planned), maxBulkItems))
+			return
+		}
+
+		resp := BulkResponse{Action: req.Action, DryRun: req.DryRun, Results: []BulkResult{}}
+		for i := range planned {
+			silence := planned[i]
+			result := BulkResult{ID: silence.ID, Silence: &silence}
+			switch {
+			case req.DryRun:
+				result.Status = BulkDryRun
+			case req.Action == BulkExpire:
+				err = s.expire(r, silence.ID)
+			default:
+				result.ID, err = s.post(r, silence)
+				silence.ID = result.ID
+			}
+			if !req.DryRun {
+				result.Status = BulkSucceeded
+				if err != nil {
+					result.Status = BulkFailed
+					result.Error = err.Error()
+				}
+			}
+			if result.Status == BulkFailed {
+				resp.Failed++
+			} else {
+				resp.Succeeded++
+			}
+			resp.Results = append(resp.Results, result)
+		}
+
+		if !req.DryRun && len(planned) > 0 {
+			s.invalidate()
+			log.Infof("bulk %s of %d silences, %d failed", req.Action, len(planned), resp.Failed)
+		}
+		api.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+func parseBulkDuration(d string) (time.Duration, error) {
+	if d == "" {
+		return 0, fmt.Errorf("duration is required")
+	}
+	duration, err := model.ParseDuration(d)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", d)
+	}
+	return time.Duration(duration), nil
+}
+
+func (s *Silences) planCreate(r *http.Request, req BulkRequest) ([]Silence, error) {
+	planned := append([]Silence{}, req.Silences...)
+	for i, silence := range planned {
+		if len(silence.Matchers) == 0 {
+			return nil, fmt.Errorf("silence %d has no matchers", i)
+		}
+		planned[i].ID = ""
+		planned[i].Status = nil
+	}
+	if len(req.Alerts) == 0 && req.Incident == "" {
+		return planned, nil
+	}
+
+	duration, err := parseBulkDuration(req.Duration)
+	if err != nil {
+		return nil, err
+	}
+	if req.CreatedBy == "" {
+		return nil, fmt.Errorf("createdBy is required to silence alerts")
+	}
+	now := time.Now().UTC()
+	newSilence := func(labels map[string]string) Silence {
+		silence := Silence{
+			StartsAt:  now,
+			EndsAt:    now.Add(duration),
+			CreatedBy: req.CreatedBy,
+			Comment:   req.Comment,
+		}
+		for name, value := range labels {
+			silence.Matchers = append(silence.Matchers, Matcher{Name: name, Value: value})
+		}
+		sortMatchers(silence.Matchers)
+		return silence
+	}
+
+	if req.Incident != "" {
+		alerts, err := s.incidentAlerts(r, req.Incident)
+		if err != nil {
+			return nil, err
+		}
+		for _, alert := range alerts {
+			if alert.Firing && !alert.Silenced {
+				planned = append(planned, newSilence(incidentAlertLabels(alert)))
+			}
+		}
+	}
+	if len(req.Alerts) == 0 {
+		return planned, nil
+	}
+
+	var filter AlertFilter
+	for _, a := range req.Alerts {
+		m, err := ParseLabelMatcher(a)
+		if err != nil {
+			return nil, err
+		}
+		filter.Labels = append(filter.Labels, m)
+	}
+	filter.States = []string{StateFiring}
+
+	var groups struct{ Groups []RuleGroup }
+	if err := s.thanos.Query(r, rulesPath, url.Values{"type": []string{"alert"}}, &groups); err != nil {
+		return nil, fmt.Errorf("cannot fetch alerts: %w", err)
+	}
+	active, err := s.list(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch silences: %w", err)
+	}
+
+	for _, alert := range JoinAlerts(groups.Groups, active) {
+		if filter.matches(alert) {
+			planned = append(planned, newSilence(alert.Labels))
+		}
+	}
+	return planned, nil
+}
+
+// incidentAlerts returns the alerts of the incident with the group id.
+func (s *Silences) incidentAlerts(r *http.Request, groupID string) ([]IncidentAlert, error) {
+	resp, err := s.incidents.load(r, 1, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch incident %s: %w", groupID, err)
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("incident %s not found", groupID)
+	}
+	if len(resp.Warnings) > 0 {
+		return nil, fmt.Errorf("cannot fetch the alerts of incident %s: %s", groupID, strings.Join(resp.Warnings, "; "))
+	}
+	return resp.Data[0].Alerts, nil
+}
+
+// incidentAlertLabels returns the labels identifying an alert of an incident,
+// as the ALERTS series of the incident API.
+func incidentAlertLabels(alert IncidentAlert) map[string]string {
+	labels := map[string]string{"alertname": alert.Alertname}
+	if alert.Namespace != "" {
+		labels["namespace"] = alert.Namespace
+	}
+	if alert.Severity != "" {
+		labels["severity"] = alert.Severity
+	}
+	return labels
+}
+
+func (s *Silences) planExtend(r *http.Request, req BulkRequest) ([]Silence, error) {
+	duration, err := parseBulkDuration(req.Duration)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := s.selectSilences(r, req.Selector)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for i := range selected {
+		if selected[i].EndsAt.Before(now) {
+			selected[i].EndsAt = now
+		}
+		selected[i].EndsAt = selected[i].EndsAt.Add(duration)
+		selected[i].Status = nil
+		selected[i].UpdatedAt = nil
+	}
+	return selected, nil
+}
+
+// selectSilences returns the active and pending silences picked by selector.
+func (s *Silences) selectSilences(r *http.Request, selector *BulkSelector) ([]Silence, error) {
+	if selector == nil || (len(selector.IDs) == 0 && selector.CreatedBy == "" && len(selector.Matchers) == 0 && len(selector.Labels) == 0 && selector.Incident == "") {
+		return nil, fmt.Errorf("a selector is required")
+	}
+	var incidentLabels []map[string]string
+	if selector.Incident != "" {
+		alerts, err := s.incidentAlerts(r, selector.Incident)
+		if err != nil {
+			return nil, err
+		}
+		for _, alert := range alerts {
+			incidentLabels = append(incidentLabels, incidentAlertLabels(alert))
+		}
+	}
+	var matchers []Matcher
+	for _, m := range selector.Matchers {
+		matcher, err := parseMatcher(m)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	silences, err := s.list(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch silences: %w", err)
+	}
+	filter := SilenceFilter{States: []string{SilenceActive, SilencePending}, Matchers: matchers}
+	if selector.CreatedBy != "" {
+		filter.Creators = []string{selector.CreatedBy}
+	}
+
+	selected := []Silence{}
+	for _, silence := range silences {
+		if !filter.matches(silence) {
+			continue
+		}
+		if len(selector.IDs) > 0 && !contains(selector.IDs, silence.ID) {
+			continue
+		}
+		compiled := CompileSilence(silence)
+		if len(selector.Labels) > 0 && !compiled.Matches(selector.Labels) {
+			continue
+		}
+		if selector.Incident != "" && !slices.ContainsFunc(incidentLabels, compiled.Matches) {
+			continue
+		}
+		selected = append(selected, silence)
+	}
+	return selected, nil
+}
+
+// post creates or updates a silence and returns its id.
+func (s *Silences) post(r *http.Request, silence Silence) (string, error) {
+	req, err := s.alertmanager.NewRequest(r, http.MethodPost, silencesPath, nil, silence)
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := s.alertmanager.Do(req, &created); err != nil {
+		return "", err
+	}
+	return created.SilenceID, nil
+}
+
+func (s *Silences) expire(r *http.Request, id string) error {
+	req, err := s.alertmanager.NewRequest(r, http.MethodDelete, silencePath+url.PathEscape(id), nil, nil)
+	if err != nil {
+		return err
+	}
+	return s.alertmanager.Do(req, nil)
+}
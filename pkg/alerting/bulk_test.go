@@ -0,0 +1,161 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+// fakeAlertmanager serves the silence endpoints used by the bulk API.
+type fakeAlertmanager struct {
+	mu       sync.Mutex
+	silences []Silence
+	posted   []Silence
+	expired  []string
+	// now is set to store the posted silences, with their start moved to now
+	// like Alertmanager does.
+	now func() time.Time
+}
+
+func (f *fakeAlertmanager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == silencesPath:
+		json.NewEncoder(w).Encode(f.silences)
+	case r.Method == http.MethodPost && r.URL.Path == silencesPath:
+		var s Silence
+		json.NewDecoder(r.Body).Decode(&s)
+		f.posted = append(f.posted, s)
+		id := s.ID
+		if id == "" {
+			id = "new"
+		}
+		if f.now != nil {
+			if now := f.now(); s.StartsAt.Before(now) {
+				s.StartsAt = now
+			}
+			if id == "new" {
+				id = fmt.Sprintf("new-%d", len(f.posted))
+			}
+			s.ID, s.Status = id, &SilenceStatus{State: SilenceActive}
+			f.silences = append(f.silences, s)
+		}
+		w.Write([]byte(`{"silenceID":"` + id + `"}`))
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, silencePath):
+		id := strings.TrimPrefix(r.URL.Path, silencePath)
+		for _, s := range f.silences {
+			if s.ID == id {
+				json.NewEncoder(w).Encode(s)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, silencePath):
+		id := strings.TrimPrefix(r.URL.Path, silencePath)
+		if id == "broken" {
+			http.Error(w, "silence not found", http.StatusNotFound)
+			return
+		}
+		f.expired = append(f.expired, id)
+		if f.now != nil {
+			f.silences = slices.DeleteFunc(f.silences, func(s Silence) bool { return s.ID == id })
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func bulk(t *testing.T, s *Silences, req string) BulkResponse {
+	rec := httptest.NewRecorder()
+	s.BulkHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/silences/bulk", bytes.NewBufferString(req)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var resp BulkResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestBulkSilences(t *testing.T) {
+	endsAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	active := &SilenceStatus{State: SilenceActive}
+	am := &fakeAlertmanager{silences: []Silence{
+		{ID: "a", CreatedBy: "alice", EndsAt: endsAt, Status: active, Matchers: []Matcher{{Name: "namespace", Value: "ns1"}}},
+		{ID: "broken", CreatedBy: "alice", EndsAt: endsAt, Status: active, Matchers: []Matcher{{Name: "namespace", Value: "ns2"}}},
+		{ID: "b", CreatedBy: "bob", EndsAt: endsAt, Status: active, Matchers: []Matcher{{Name: "namespace", Value: "ns.*", IsRegex: true}}},
+		{ID: "c", CreatedBy: "alice", EndsAt: endsAt, Status: &SilenceStatus{State: SilenceExpired}},
+	}}
+	s := NewSilences(monitoring.Upstream{Kind: monitoring.AlertManagerKind, Handler: am}, monitoring.Upstream{})
+
+	resp := bulk(t, s, `{"action":"expire","dryRun":true,"selector":{"createdBy":"alice"}}`)
+	require.Equal(t, 2, resp.Succeeded)
+	require.Equal(t, BulkDryRun, resp.Results[0].Status)
+	require.Empty(t, am.expired)
+
+	resp = bulk(t, s, `{"action":"expire","selector":{"createdBy":"alice"}}`)
+	require.Equal(t, 1, resp.Succeeded)
+	require.Equal(t, 1, resp.Failed)
+	require.Equal(t, BulkFailed, resp.Results[1].Status)
+	require.Equal(t, []string{"a"}, am.expired)
+
+	resp = bulk(t, s, `{"action":"extend","duration":"2h","selector":{"labels":{"namespace":"ns1"}}}`)
+	require.Equal(t, 2, resp.Succeeded)
+	require.Len(t, am.posted, 2)
+	require.Equal(t, "a", am.posted[0].ID)
+	require.Equal(t, endsAt.Add(2*time.Hour), am.posted[0].EndsAt)
+
+	rec := httptest.NewRecorder()
+	s.BulkHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/silences/bulk", bytes.NewBufferString(`{"action":"expire","selector":{}}`)))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestBulkSilencesIncident(t *testing.T) {
+	endsAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	active := &SilenceStatus{State: SilenceActive}
+	am := &fakeAlertmanager{silences: []Silence{
+		{ID: "etcd", CreatedBy: "alice", EndsAt: endsAt, Status: active, Matchers: []Matcher{{Name: "namespace", Value: "openshift-etcd"}}},
+		{ID: "other", CreatedBy: "alice", EndsAt: endsAt, Status: active, Matchers: []Matcher{{Name: "namespace", Value: "openshift-dns"}}},
+	}}
+	thanos := monitoring.Upstream{Kind: monitoring.ThanosQuerierKind, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		switch {
+		case strings.HasPrefix(query, "ALERTS"):
+			w.Write([]byte(testIncidentAlerts))
+		case strings.Contains(query, `group_id="g1"`):
+			w.Write([]byte(testIncidentSeries))
+		default:
+			w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`))
+		}
+	})}
+	s := NewSilences(monitoring.Upstream{Kind: monitoring.AlertManagerKind, Handler: am}, thanos)
+	s.incidents.now = func() time.Time { return time.Unix(31500, 0) }
+
+	// Only the firing alerts which are not silenced get a silence.
+	resp := bulk(t, s, `{"action":"create","incident":"g1","duration":"1h","createdBy":"alice"}`)
+	require.Equal(t, 1, resp.Succeeded)
+	require.Equal(t, []Matcher{
+		{Name: "alertname", Value: "EtcdDown"},
+		{Name: "namespace", Value: "openshift-etcd"},
+		{Name: "severity", Value: "critical"},
+	}, am.posted[0].Matchers)
+
+	resp = bulk(t, s, `{"action":"expire","selector":{"incident":"g1"}}`)
+	require.Equal(t, 1, resp.Succeeded)
+	require.Equal(t, []string{"etcd"}, am.expired)
+
+	rec := httptest.NewRecorder()
+	s.BulkHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/silences/bulk", bytes.NewBufferString(`{"action":"expire","selector":{"incident":"missing"}}`)))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "incident missing not found")
+}
@@ -0,0 +1,527 @@
+package alerting
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/openshift/monitoring-plugin/pkg/api"
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+const (
+	// incidentsMetric maps the alerts to the incidents and components found
+	// by the cluster health analyzer.
+	incidentsMetric = "cluster_health_components_map"
+	// IncidentsStep is the interval of the health analyzer samples. Samples
+	// less than a step apart belong to the same run, an incident or alert is
+	// resolved once no sample was seen for two steps.
+	IncidentsStep = 300 * time.Second
+
+	maxIncidentDays = 15
+	// maxAlertsQueryLength bounds the length of the ALERTS queries of an
+	// incident, longer queries are split.
+	maxAlertsQueryLength = 2048
+)
+
+// incidentSeverities maps the values of the health analyzer series to
+// severities, from the least to the most severe.
+var incidentSeverities = []string{"info", "warning", "critical"}
+
+func incidentSeverity(value string) string {
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 || i >= len(incidentSeverities) {
+		return ""
+	}
+	return incidentSeverities[i]
+}
+
+// SeverityInterval is a period during which an incident had a severity.
+type SeverityInterval struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Severity string    `json:"severity"`
+}
+
+// ActiveInterval is a period during which an alert was firing.
+type ActiveInterval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// IncidentAlert is an alert grouped in an incident.
+type IncidentAlert struct {
+	Alertname string    `json:"alertname"`
+	Namespace string    `json:"namespace,omitempty"`
+	Severity  string    `json:"severity"`
+	Component string    `json:"component"`
+	Layer     string    `json:"layer,omitempty"`
+	Silenced  bool      `json:"silenced"`
+	Firing    bool      `json:"firing"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	// Intervals are the firing periods of the alert, only returned with the
+	// details of an incident.
+	Intervals []ActiveInterval `json:"intervals,omitempty"`
+}
+
+// IncidentComponent groups the alerts of an incident by component.
+type IncidentComponent struct {
+	Component string   `json:"component"`
+	Layer     string   `json:"layer,omitempty"`
+	Alerts    []string `json:"alerts"`
+}
+
+// Incident is a group of alerts correlated by the cluster health analyzer.
+type Incident struct {
+	GroupID string `json:"groupId"`
+	// Severity is the latest severity of the incident.
+	Severity string    `json:"severity"`
+	Firing   bool      `json:"firing"`
+	Silenced bool      `json:"silenced"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	// Timeline holds the escalations of the severity, periods without
+	// samples are left out.
+	Timeline   []SeverityInterval  `json:"timeline"`
+	Components []IncidentComponent `json:"components"`
+	Alerts     []IncidentAlert     `json:"alerts"`
+}
+
+// IncidentsResponse holds the incidents active during a time range.
+type IncidentsResponse struct {
+	Data     []Incident `json:"data"`
+	Warnings []string   `json:"warnings,omitempty"`
+}
+
+// samples are the values of merged series by timestamp, in seconds.
+type samples map[int64]string
+
+// mergeHighest merges values, keeping the highest severity of each timestamp.
+func (s samples) mergeHighest(values []TimelinePoint) {
+	for _, v := range values {
+		ts := int64(math.Round(v.Time))
+		if current, ok := s[ts]; !ok || v.Value > current {
+			s[ts] = v.Value
+		}
+	}
+}
+
+func (s samples) sorted() []int64 {
+	ts := make([]int64, 0, len(s))
+	for t := range s {
+		ts = append(ts, t)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+	return ts
+}
+
+// contiguous reports whether two samples belong to the same run.
+func contiguous(prev, next int64) bool {
+	return next-prev <= int64(IncidentsStep.Seconds())
+}
+
+// intervalEnd returns the end of the period covered by the sample at ts,
+// which never extends past now.
+func intervalEnd(ts int64, now time.Time) time.Time {
+	end := time.Unix(ts, 0).Add(IncidentsStep).UTC()
+	if end.After(now) {
+		return now
+	}
+	return end
+}
+
+func isFiring(last int64, now time.Time) bool {
+	return now.Sub(time.Unix(last, 0)) < 2*IncidentsStep
+}
+
+// severityTimeline splits samples in runs of a severity, gaps longer than a
+// step end a run.
+func severityTimeline(s samples, now time.Time) []SeverityInterval {
+	var timeline []SeverityInterval
+	var prev int64
+	for i, ts := range s.sorted() {
+		severity := incidentSeverity(s[ts])
+		if i == 0 || !contiguous(prev, ts) || timeline[len(timeline)-1].Severity != severity {
+			if i > 0 && contiguous(prev, ts) {
+				// An escalation ends the previous run at the new sample.
+				timeline[len(timeline)-1].End = time.Unix(ts, 0).UTC()
+			}
+			timeline = append(timeline, SeverityInterval{Start: time.Unix(ts, 0).UTC(), Severity: severity})
+		}
+		timeline[len(timeline)-1].End = intervalEnd(ts, now)
+		prev = ts
+	}
+	return timeline
+}
+
+// activeIntervals splits timestamps in runs, gaps longer than a step end a
+// run.
+func activeIntervals(ts []int64, now time.Time) []ActiveInterval {
+	var intervals []ActiveInterval
+	for i, t := range ts {
+		if i == 0 || !contiguous(ts[i-1], t) {
+			intervals = append(intervals, ActiveInterval{Start: time.Unix(t, 0).UTC()})
+		}
+		intervals[len(intervals)-1].End = intervalEnd(t, now)
+	}
+	return intervals
+}
+
+type incidentAlertKey struct {
+	alertname, namespace, severity string
+}
+
+type incidentBuilder struct {
+	incident   Incident
+	samples    samples
+	latest     int64
+	components map[string]*IncidentComponent
+	alerts     map[incidentAlertKey]*alertBuilder
+}
+
+type alertBuilder struct {
+	alert   IncidentAlert
+	samples samples
+	latest  int64
+}
+
+func lastTimestamp(values []TimelinePoint) int64 {
+	last := int64(math.MinInt64)
+	for _, v := range values {
+		if ts := int64(math.Round(v.Time)); ts > last {
+			last = ts
+		}
+	}
+	return last
+}
+
+// BuildIncidents groups the series of the health analyzer by incident, as the
+// incidents page does: the samples of an incident keep the highest severity
+// of its series, the silenced state and the severity of an alert come from
+// its latest series. Watchdog, which always fires, is ignored.
+func BuildIncidents(series []TimelineSeries, now time.Time) []Incident {
+	builders := map[string]*incidentBuilder{}
+	for _, s := range series {
+		m := s.Metric
+		if m["src_alertname"] == "Watchdog" || len(s.Values) == 0 {
+			continue
+		}
+		b, ok := builders[m["group_id"]]
+		if !ok {
+			b = &incidentBuilder{
+				incident:   Incident{GroupID: m["group_id"]},
+				samples:    samples{},
+				latest:     math.MinInt64,
+				components: map[string]*IncidentComponent{},
+				alerts:     map[incidentAlertKey]*alertBuilder{},
+			}
+			builders[m["group_id"]] = b
+		}
+		b.samples.mergeHighest(s.Values)
+		last := lastTimestamp(s.Values)
+		if last >= b.latest {
+			b.latest = last
+			b.incident.Silenced = m["silenced"] == "true"
+		}
+
+		component, ok := b.components[m["component"]]
+		if !ok {
+			component = &IncidentComponent{Component: m["component"], Layer: m["layer"], Alerts: []string{}}
+			b.components[m["component"]] = component
+		}
+		if m["src_alertname"] != "" && !contains(component.Alerts, m["src_alertname"]) {
+			component.Alerts = append(component.Alerts, m["src_alertname"])
+		}
+
+		key := incidentAlertKey{m["src_alertname"], m["src_namespace"], m["src_severity"]}
+		if key.alertname == "" {
+			continue
+		}
+		a, ok := b.alerts[key]
+		if !ok {
+			a = &alertBuilder{
+				alert:   IncidentAlert{Alertname: key.alertname, Namespace: key.namespace, Severity: key.severity, Component: m["component"], Layer: m["layer"]},
+				samples: samples{},
+				latest:  math.MinInt64,
+			}
+			b.alerts[key] = a
+		}
+		a.samples.mergeHighest(s.Values)
+		if last >= a.latest {
+			a.latest = last
+			a.alert.Silenced = m["silenced"] == "true"
+		}
+	}
+
+	incidents := make([]Incident, 0, len(builders))
+	for _, b := range builders {
+		incident := b.incident
+		ts := b.samples.sorted()
+		first, last := ts[0], ts[len(ts)-1]
+		incident.Severity = incidentSeverity(b.samples[last])
+		incident.Firing = isFiring(last, now)
+		incident.Start = time.Unix(first, 0).UTC()
+		incident.End = intervalEnd(last, now)
+		incident.Timeline = severityTimeline(b.samples, now)
+
+		incident.Components = []IncidentComponent{}
+		for _, c := range b.components {
+			sort.Strings(c.Alerts)
+			incident.Components = append(incident.Components, *c)
+		}
+		sort.Slice(incident.Components, func(i, j int) bool {
+			return incident.Components[i].Component < incident.Components[j].Component
+		})
+
+		incident.Alerts = []IncidentAlert{}
+		for _, a := range b.alerts {
+			alert := a.alert
+			ts := a.samples.sorted()
+			alert.Firing = isFiring(ts[len(ts)-1], now)
+			alert.Start = time.Unix(ts[0], 0).UTC()
+			alert.End = intervalEnd(ts[len(ts)-1], now)
+			incident.Alerts = append(incident.Alerts, alert)
+		}
+		sortIncidentAlerts(incident.Alerts)
+		incidents = append(incidents, incident)
+	}
+	sort.Slice(incidents, func(i, j int) bool {
+		if !incidents[i].Start.Equal(incidents[j].Start) {
+			return incidents[i].Start.Before(incidents[j].Start)
+		}
+		return incidents[i].GroupID < incidents[j].GroupID
+	})
+	return incidents
+}
+
+func sortIncidentAlerts(alerts []IncidentAlert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].Start.Equal(alerts[j].Start) {
+			return alerts[i].Start.Before(alerts[j].Start)
+		}
+		return alerts[i].Alertname < alerts[j].Alertname
+	})
+}
+
+// alertsQueries returns the queries of the firing ALERTS series of the
+// alerts, split to keep them short enough for a GET request.
+func alertsQueries(alerts []IncidentAlert) []string {
+	var (
+		queries []string
+		parts   []string
+		length  int
+	)
+	seen := map[string]bool{}
+	for _, a := range alerts {
+		selector := []string{fmt.Sprintf("alertname=%q", a.Alertname), `alertstate="firing"`}
+		if a.Namespace != "" {
+			selector = append(selector, fmt.Sprintf("namespace=%q", a.Namespace))
+		}
+		if a.Severity != "" {
+			selector = append(selector, fmt.Sprintf("severity=%q", a.Severity))
+		}
+		q := "ALERTS{" + strings.Join(selector, ", ") + "}"
+		if seen[q] {
+			continue
+		}
+		seen[q] = true
+		if len(parts) > 0 && length+len(q)+4 > maxAlertsQueryLength {
+			queries = append(queries, strings.Join(parts, " or "))
+			parts, length = nil, 0
+		}
+		parts = append(parts, q)
+		length += len(q) + 4
+	}
+	if len(parts) > 0 {
+		queries = append(queries, strings.Join(parts, " or "))
+	}
+	return queries
+}
+
+// addAlertIntervals sets the firing periods of the alerts of an incident
+// from their ALERTS series, within the time range of the incident padded by
+// half a step.
+func addAlertIntervals(incident *Incident, series []TimelineSeries, now time.Time) {
+	pad := int64(IncidentsStep.Seconds() / 2)
+	from, to := incident.Start.Unix()-pad, incident.End.Unix()+pad
+	byKey := map[incidentAlertKey]samples{}
+	for _, s := range series {
+		if s.Metric["alertname"] == "Watchdog" {
+			continue
+		}
+		key := incidentAlertKey{s.Metric["alertname"], s.Metric["namespace"], s.Metric["severity"]}
+		if byKey[key] == nil {
+			byKey[key] = samples{}
+		}
+		for _, v := range s.Values {
+			if ts := int64(math.Round(v.Time)); ts >= from && ts <= to {
+				byKey[key][ts] = v.Value
+			}
+		}
+	}
+	for i := range incident.Alerts {
+		a := &incident.Alerts[i]
+		s := byKey[incidentAlertKey{a.Alertname, a.Namespace, a.Severity}]
+		if len(s) == 0 {
+			continue
+		}
+		ts := s.sorted()
+		a.Intervals = activeIntervals(ts, now)
+		a.Start = a.Intervals[0].Start
+		a.End = a.Intervals[len(a.Intervals)-1].End
+		a.Firing = isFiring(ts[len(ts)-1], now)
+	}
+	sortIncidentAlerts(incident.Alerts)
+}
+
+// Incidents serves the incidents API from the health analyzer metrics of
+// Thanos. Responses are cached per user until the next step.
+type Incidents struct {
+	thanos monitoring.Upstream
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedIncidents
+}
+
+type cachedIncidents struct {
+	resp    IncidentsResponse
+	expires time.Time
+}
+
+func NewIncidents(thanos monitoring.Upstream) *Incidents {
+	return &Incidents{thanos: thanos, now: time.Now, cache: map[string]cachedIncidents{}}
+}
+
+// queryRange runs a range query with the step of the health analyzer.
+func (inc *Incidents) queryRange(r *http.Request, query string, start, end time.Time) ([]TimelineSeries, error) {
+	params := url.Values{
+		"query": {query},
+		"start": {strconv.FormatInt(start.Unix(), 10)},
+		"end":   {strconv.FormatInt(end.Unix(), 10)},
+		"step":  {strconv.FormatInt(int64(IncidentsStep.Seconds()), 10)},
+	}
+	var data struct {
+		Result []TimelineSeries `json:"result"`
+	}
+	if err := inc.thanos.Query(r, queryRangePath, params, &data); err != nil {
+		return nil, err
+	}
+	return data.Result, nil
+}
+
+// load returns the incidents of the last days, and the details of the
+// alerts when groupID selects an incident.
+func (inc *Incidents) load(r *http.Request, days int, groupID string) (IncidentsResponse, error) {
+	// Align on the step so that refreshes within a step share their samples
+	// and the cache.
+	now := inc.now().UTC()
+	end := now.Truncate(IncidentsStep)
+	key := fmt.Sprintf("%s|%d|%s|%d", monitoring.TenantKey(r), days, groupID, end.Unix())
+
+	inc.mu.Lock()
+	cached, ok := inc.cache[key]
+	inc.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.resp, nil
+	}
+
+	query := incidentsMetric
+	if groupID != "" {
+		query = fmt.Sprintf("%s{group_id=%q}", incidentsMetric, groupID)
+	}
+	start := end.Add(-time.Duration(days) * 24 * time.Hour)
+	series, err := inc.queryRange(r, query, start, end)
+	if err != nil {
+		return IncidentsResponse{}, err
+	}
+	resp := IncidentsResponse{Data: BuildIncidents(series, end)}
+
+	if groupID != "" {
+		for i := range resp.Data {
+			var alerts []TimelineSeries
+			for _, q := range alertsQueries(resp.Data[i].Alerts) {
+				result, err := inc.queryRange(r, q, start, end)
+				if err != nil {
+					log.WithError(err).Warn("cannot fetch the alerts of incident " + groupID)
+					resp.Warnings = append(resp.Warnings, fmt.Sprintf("cannot fetch alerts: %v", err))
+					break
+				}
+				alerts = append(alerts, result...)
+			}
+			addAlertIntervals(&resp.Data[i], alerts, end)
+		}
+	}
+
+	inc.mu.Lock()
+	defer inc.mu.Unlock()
+	for k, c := range inc.cache {
+		if now.After(c.expires) {
+			delete(inc.cache, k)
+		}
+	}
+	if len(resp.Warnings) == 0 {
+		inc.cache[key] = cachedIncidents{resp: resp, expires: end.Add(IncidentsStep)}
+	}
+	return resp, nil
+}
+
+func parseIncidentDays(r *http.Request) (int, error) {
+	days, err := api.IntParam(r, "days", 1)
+	if err != nil {
+		return 0, err
+	}
+	if days < 1 || days > maxIncidentDays {
+		return 0, fmt.Errorf("days must be between 1 and %d", maxIncidentDays)
+	}
+	return days, nil
+}
+
+// ListHandler serves the incidents of the last days, one by default.
+func (inc *Incidents) ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		days, err := parseIncidentDays(r)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		resp, err := inc.load(r, days, "")
+		if err != nil {
+			api.WriteError(w, http.StatusBadGateway, err)
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+// GetHandler serves the incident of the group id in the path, with the
+// firing periods of its alerts.
+func (inc *Incidents) GetHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		days, err := parseIncidentDays(r)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		groupID := mux.Vars(r)["group"]
+		resp, err := inc.load(r, days, groupID)
+		if err != nil {
+			api.WriteError(w, http.StatusBadGateway, err)
+			return
+		}
+		if len(resp.Data) == 0 {
+			api.WriteError(w, http.StatusNotFound, fmt.Errorf("incident %s not found", groupID))
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,102 @@
+package alerting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+const testIncidentSeries = `{"status":"success","data":{"resultType":"matrix","result":[
+  {"metric":{"group_id":"g1","component":"etcd","layer":"core","src_alertname":"EtcdDown","src_namespace":"openshift-etcd","src_severity":"critical","silenced":"false"},
+   "values":[[30000,"1"],[30300,"1"],[30600,"1"],[30900,"1"],[31200,"2"],[31500,"2"]]},
+  {"metric":{"group_id":"g1","component":"network","layer":"core","src_alertname":"NetDown","src_namespace":"openshift-network","src_severity":"warning","silenced":"true"},
+   "values":[[30000,"1"],[30300,"1"],[32400,"1"]]},
+  {"metric":{"group_id":"g2","component":"monitoring","src_alertname":"Watchdog"},"values":[[30000,"0"]]},
+  {"metric":{"group_id":"g3","component":"monitoring","layer":"core","src_alertname":"TargetDown","src_namespace":"openshift-monitoring","src_severity":"info","silenced":"false"},
+   "values":[[35700,"0"]]}
+]}}`
+
+const testIncidentAlerts = `{"status":"success","data":{"resultType":"matrix","result":[
+  {"metric":{"alertname":"EtcdDown","alertstate":"firing","namespace":"openshift-etcd","severity":"critical"},
+   "values":[[30300,"1"],[30600,"1"],[30900,"1"],[31200,"1"],[35000,"1"]]}
+]}}`
+
+func TestIncidents(t *testing.T) {
+	var queries []string
+	thanos := monitoring.Upstream{Kind: monitoring.ThanosQuerierKind, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		queries = append(queries, query)
+		require.Equal(t, "300", r.URL.Query().Get("step"))
+		if strings.HasPrefix(query, "ALERTS") {
+			w.Write([]byte(testIncidentAlerts))
+			return
+		}
+		w.Write([]byte(testIncidentSeries))
+	})}
+	inc := NewIncidents(thanos)
+	inc.now = func() time.Time { return time.Unix(36100, 0) }
+	at := func(s int64) time.Time { return time.Unix(s, 0).UTC() }
+
+	get := func(handler http.Handler, target string, vars map[string]string) IncidentsResponse {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, mux.SetURLVars(httptest.NewRequest(http.MethodGet, target, nil), vars))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp IncidentsResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := get(inc.ListHandler(), "/api/v1/incidents?days=3", nil)
+	require.Equal(t, []string{incidentsMetric}, queries)
+	require.Len(t, resp.Data, 2)
+
+	g1 := resp.Data[0]
+	require.Equal(t, "g1", g1.GroupID)
+	require.Equal(t, "warning", g1.Severity)
+	require.False(t, g1.Firing)
+	require.True(t, g1.Silenced)
+	require.Equal(t, at(30000), g1.Start)
+	require.Equal(t, at(32700), g1.End)
+	require.Equal(t, []SeverityInterval{
+		{Start: at(30000), End: at(31200), Severity: "warning"},
+		{Start: at(31200), End: at(31800), Severity: "critical"},
+		{Start: at(32400), End: at(32700), Severity: "warning"},
+	}, g1.Timeline)
+	require.Equal(t, []IncidentComponent{
+		{Component: "etcd", Layer: "core", Alerts: []string{"EtcdDown"}},
+		{Component: "network", Layer: "core", Alerts: []string{"NetDown"}},
+	}, g1.Components)
+	require.Len(t, g1.Alerts, 2)
+	require.Equal(t, "EtcdDown", g1.Alerts[0].Alertname)
+	require.False(t, g1.Alerts[0].Silenced)
+	require.True(t, g1.Alerts[1].Silenced)
+
+	g3 := resp.Data[1]
+	require.Equal(t, "info", g3.Severity)
+	require.True(t, g3.Firing)
+	require.Equal(t, at(36000), g3.End)
+
+	// Requests within the same step are served from the cache.
+	get(inc.ListHandler(), "/api/v1/incidents?days=3", nil)
+	require.Len(t, queries, 1)
+
+	resp = get(inc.GetHandler(), "/api/v1/incidents/g1", map[string]string{"group": "g1"})
+	require.Equal(t, `cluster_health_components_map{group_id="g1"}`, queries[1])
+	require.Contains(t, queries[2], `ALERTS{alertname="EtcdDown", alertstate="firing", namespace="openshift-etcd", severity="critical"} or ALERTS{alertname="NetDown"`)
+	etcd := resp.Data[0].Alerts[1]
+	require.Equal(t, "EtcdDown", etcd.Alertname)
+	require.Equal(t, []ActiveInterval{{Start: at(30300), End: at(31500)}}, etcd.Intervals)
+	require.Empty(t, resp.Data[0].Alerts[0].Intervals)
+
+	rec := httptest.NewRecorder()
+	inc.ListHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/incidents?days=30", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,103 @@
+package alerting
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// IsSilenced reports whether a firing alert with the given labels is matched
+// by every matcher of the silence, as isAlertSilenced does in the frontend.
+// CompileSilence avoids compiling the regex matchers for every alert.
+func IsSilenced(alertLabels map[string]string, silence Silence) bool {
+	return CompileSilence(silence).Matches(alertLabels)
+}
+
+// CompiledSilence is a silence with its regex matchers compiled, to be
+// matched against many alerts.
+type CompiledSilence struct {
+	Silence
+	matchers []compiledMatcher
+}
+
+type compiledMatcher struct {
+	Matcher
+	// re is nil for equality matchers and invalid regexes, which never match.
+	re *regexp.Regexp
+}
+
+// CompileSilence compiles the matchers of a silence.
+func CompileSilence(silence Silence) CompiledSilence {
+	c := CompiledSilence{Silence: silence, matchers: make([]compiledMatcher, 0, len(silence.Matchers))}
+	for _, m := range silence.Matchers {
+		cm := compiledMatcher{Matcher: m}
+		if m.IsRegex {
+			cm.re, _ = regexp.Compile("^(?:" + m.Value + ")$")
+		}
+		c.matchers = append(c.matchers, cm)
+	}
+	return c
+}
+
+// CompileSilences compiles the matchers of every silence.
+func CompileSilences(silences []Silence) []CompiledSilence {
+	compiled := make([]CompiledSilence, 0, len(silences))
+	for _, s := range silences {
+		compiled = append(compiled, CompileSilence(s))
+	}
+	return compiled
+}
+
+// Matches reports whether the alert with the given labels is silenced.
+func (c CompiledSilence) Matches(alertLabels map[string]string) bool {
+	for _, m := range c.matchers {
+		if !m.matches(alertLabels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m compiledMatcher) matches(value string) bool {
+	isMatch := value == m.Value
+	if m.IsRegex {
+		if m.re == nil {
+			return false
+		}
+		isMatch = m.re.MatchString(value)
+	}
+	if !m.Equal() && value != "" {
+		return !isMatch
+	}
+	return isMatch
+}
+
+// ParseLabelMatcher parses a name=value, name!=value, name=~regex or
+// name!~regex label filter. The name ends at the first operator, the longest
+// one when several start there, so that values can contain operators.
+func ParseLabelMatcher(s string) (*labels.Matcher, error) {
+	index, token, typ := -1, "", labels.MatchEqual
+	for _, op := range []struct {
+		token string
+		typ   labels.MatchType
+	}{
+		{"!=", labels.MatchNotEqual},
+		{"=~", labels.MatchRegexp},
+		{"!~", labels.MatchNotRegexp},
+		{"=", labels.MatchEqual},
+	} {
+		i := strings.Index(s, op.token)
+		if i < 0 || (index >= 0 && (i > index || (i == index && len(op.token) <= len(token)))) {
+			continue
+		}
+		index, token, typ = i, op.token, op.typ
+	}
+	if index <= 0 {
+		return nil, fmt.Errorf("invalid label matcher %q, expected name=value", s)
+	}
+	name := strings.TrimSpace(s[:index])
+	value := strings.Trim(strings.TrimSpace(s[index+len(token):]), `"`)
+	return labels.NewMatcher(typ, name, value)
+}
@@ -0,0 +1,290 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/openshift/monitoring-plugin/pkg/api"
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+const (
+	queryRangePath = "/api/v1/query_range"
+
+	// maxPreviewPoints bounds the steps of a preview, as Prometheus does for
+	// range queries.
+	maxPreviewPoints = 11000
+	// maxPreviewSeries bounds the alerts returned by a preview.
+	maxPreviewSeries   = 500
+	defaultPreviewStep = time.Minute
+)
+
+// TimelineSeries is an ALERTS like series, in the shape of the range query
+// results consumed by the incidents charts.
+type TimelineSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values []TimelinePoint   `json:"values"`
+}
+
+// TimelinePoint is a [timestamp, value] pair of a timeline series.
+type TimelinePoint struct {
+	Time  float64
+	Value string
+}
+
+func (p TimelinePoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{p.Time, p.Value})
+}
+
+func (p *TimelinePoint) UnmarshalJSON(b []byte) error {
+	var pair []json.RawMessage
+	if err := json.Unmarshal(b, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return fmt.Errorf("invalid sample %s", b)
+	}
+	if err := json.Unmarshal(pair[0], &p.Time); err != nil {
+		return err
+	}
+	return json.Unmarshal(pair[1], &p.Value)
+}
+
+// PreviewInterval is a period during which an alert would have been pending
+// or firing.
+type PreviewInterval struct {
+	Labels map[string]string `json:"labels"`
+	State  string            `json:"state"`
+	Start  time.Time         `json:"start"`
+	End    time.Time         `json:"end"`
+}
+
+// PreviewResponse describes when an alert rule would have been active.
+type PreviewResponse struct {
+	Timeline  []TimelineSeries  `json:"timeline"`
+	Intervals []PreviewInterval `json:"intervals"`
+	Warnings  []string          `json:"warnings,omitempty"`
+}
+
+// previewRequest holds the parameters of a rule preview.
+type previewRequest struct {
+	expr       string
+	forPeriod  time.Duration
+	start, end time.Time
+	step       time.Duration
+	alertname  string
+	labels     map[string]string
+}
+
+func parsePreviewRequest(r *http.Request) (previewRequest, error) {
+	if err := r.ParseForm(); err != nil {
+		return previewRequest{}, err
+	}
+	req := previewRequest{
+		expr:      r.Form.Get("expr"),
+		alertname: r.Form.Get("alertname"),
+		labels:    map[string]string{},
+	}
+	if req.expr == "" {
+		return req, fmt.Errorf("expr is required")
+	}
+	if _, err := parser.ParseExpr(req.expr); err != nil {
+		return req, fmt.Errorf("invalid expr: %w", err)
+	}
+	if req.alertname == "" {
+		req.alertname = "Preview"
+	}
+
+	var err error
+	if f := r.Form.Get("for"); f != "" {
+		if req.forPeriod, err = monitoring.ParseDuration(f); err != nil {
+			return req, err
+		}
+	}
+	req.end = time.Now().UTC()
+	if e := r.Form.Get("end"); e != "" {
+		if req.end, err = monitoring.ParseTime(e); err != nil {
+			return req, err
+		}
+	}
+	req.start = req.end.Add(-24 * time.Hour)
+	if s := r.Form.Get("start"); s != "" {
+		if req.start, err = monitoring.ParseTime(s); err != nil {
+			return req, err
+		}
+	}
+	if !req.end.After(req.start) {
+		return req, fmt.Errorf("end must be after start")
+	}
+	req.step = defaultPreviewStep
+	if s := r.Form.Get("step"); s != "" {
+		if req.step, err = monitoring.ParseDuration(s); err != nil {
+			return req, err
+		}
+		if req.step <= 0 {
+			return req, fmt.Errorf("step must be positive")
+		}
+	}
+	if points := req.end.Sub(req.start) / req.step; points > maxPreviewPoints {
+		req.step = req.end.Sub(req.start) / maxPreviewPoints
+	}
+
+	for _, l := range r.Form["label"] {
+		m, err := ParseLabelMatcher(l)
+		if err != nil || m.Type != labels.MatchEqual {
+			return req, fmt.Errorf("invalid label %q, expected name=value", l)
+		}
+		req.labels[m.Name] = m.Value
+	}
+	return req, nil
+}
+
+// PreviewHandler evaluates an alerting rule expression over a time range with
+// a range query, and reports when its alerts would have been pending and
+// firing given the for period of the rule.
+func PreviewHandler(thanos monitoring.Upstream) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := parsePreviewRequest(r)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		params := url.Values{
+			"query": {req.expr},
+			"start": {formatSeconds(req.start)},
+			"end":   {formatSeconds(req.end)},
+			"step":  {strconv.FormatFloat(req.step.Seconds(), 'f', -1, 64)},
+		}
+		var data struct {
+			ResultType string           `json:"resultType"`
+			Result     []TimelineSeries `json:"result"`
+		}
+		if err := thanos.Query(r, queryRangePath, params, &data); err != nil {
+			api.WriteError(w, http.StatusBadGateway, err)
+			return
+		}
+		if data.ResultType != "matrix" {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("expr must return a vector, got %s", data.ResultType))
+			return
+		}
+
+		resp := PreviewResponse{Timeline: []TimelineSeries{}, Intervals: []PreviewInterval{}}
+		if len(data.Result) > maxPreviewSeries {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("expr returned %d series, only the first %d are previewed", len(data.Result), maxPreviewSeries))
+			data.Result = data.Result[:maxPreviewSeries]
+		}
+		for _, s := range data.Result {
+			timeline, intervals := evaluateSeries(req, s)
+			resp.Timeline = append(resp.Timeline, timeline...)
+			resp.Intervals = append(resp.Intervals, intervals...)
+		}
+		sort.SliceStable(resp.Intervals, func(i, j int) bool {
+			return resp.Intervals[i].Start.Before(resp.Intervals[j].Start)
+		})
+		api.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+func formatSeconds(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', -1, 64)
+}
+
+// alertLabels returns the labels of the alerts of a series, like Prometheus
+// does for the ALERTS series of a rule.
+func alertLabels(req previewRequest, metric map[string]string) map[string]string {
+	l := map[string]string{}
+	for k, v := range metric {
+		if k != labels.MetricName {
+			l[k] = v
+		}
+	}
+	for k, v := range req.labels {
+		l[k] = v
+	}
+	l[labels.AlertName] = req.alertname
+	return l
+}
+
+// evaluateSeries replays the alert state machine on the samples of a series:
+// an alert is pending from the first sample of a run of consecutive steps,
+// and firing once it has been pending for the for period.
+func evaluateSeries(req previewRequest, s TimelineSeries) ([]TimelineSeries, []PreviewInterval) {
+	base := alertLabels(req, s.Metric)
+	withState := func(state string) map[string]string {
+		l := map[string]string{"alertstate": state}
+		for k, v := range base {
+			l[k] = v
+		}
+		return l
+	}
+	pending := TimelineSeries{Metric: withState(StatePending)}
+	firing := TimelineSeries{Metric: withState(StateFiring)}
+
+	var (
+		intervals []PreviewInterval
+		current   *PreviewInterval
+		activeAt  time.Time
+		last      time.Time
+		running   bool
+	)
+	closeInterval := func() {
+		if current != nil {
+			intervals = append(intervals, *current)
+			current = nil
+		}
+	}
+
+	step := req.step.Seconds()
+	for _, p := range s.Values {
+		v, err := strconv.ParseFloat(p.Value, 64)
+		if err != nil || math.IsNaN(v) {
+			continue
+		}
+		t := time.UnixMilli(int64(math.Round(p.Time * 1000))).UTC()
+		// A missed step resolves the alert, as the expression returned nothing.
+		if !running || t.Sub(last).Seconds() > step*1.5 {
+			closeInterval()
+			activeAt = t
+			running = true
+		}
+		last = t
+
+		state := StatePending
+		if t.Sub(activeAt) >= req.forPeriod {
+			state = StateFiring
+		}
+		point := TimelinePoint{Time: p.Time, Value: "1"}
+		if state == StateFiring {
+			firing.Values = append(firing.Values, point)
+		} else {
+			pending.Values = append(pending.Values, point)
+		}
+
+		if current != nil && current.State != state {
+			closeInterval()
+		}
+		if current == nil {
+			current = &PreviewInterval{Labels: base, State: state, Start: t}
+		}
+		current.End = t.Add(req.step)
+	}
+	closeInterval()
+
+	var timeline []TimelineSeries
+	for _, series := range []TimelineSeries{pending, firing} {
+		if len(series.Values) > 0 {
+			timeline = append(timeline, series)
+		}
+	}
+	return timeline, intervals
+}
@@ -0,0 +1,42 @@
+package alerting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+func TestPreview(t *testing.T) {
+	// Samples every minute from 0 to 4m, then from 10m to 11m.
+	thanos := staticUpstream(monitoring.ThanosQuerierKind, `{"status":"success","data":{"resultType":"matrix","result":[
+	  {"metric":{"__name__":"up","job":"api"},"values":[[0,"0"],[60,"0"],[120,"0"],[180,"0"],[240,"0"],[600,"0"],[660,"0"]]}
+	]}}`)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/rules/preview?expr=up+%3D%3D+0&for=2m&start=0&end=720&step=60&alertname=Down&label=severity%3Dcritical", nil)
+	PreviewHandler(thanos).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var resp PreviewResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	labels := map[string]string{"alertname": "Down", "job": "api", "severity": "critical"}
+	at := func(s int64) time.Time { return time.Unix(s, 0).UTC() }
+	require.Equal(t, []PreviewInterval{
+		{Labels: labels, State: StatePending, Start: at(0), End: at(120)},
+		{Labels: labels, State: StateFiring, Start: at(120), End: at(300)},
+		{Labels: labels, State: StatePending, Start: at(600), End: at(720)},
+	}, resp.Intervals)
+
+	require.Len(t, resp.Timeline, 2)
+	require.Equal(t, StatePending, resp.Timeline[0].Metric["alertstate"])
+	require.Len(t, resp.Timeline[0].Values, 4)
+	require.Equal(t, StateFiring, resp.Timeline[1].Metric["alertstate"])
+	require.Equal(t, []TimelinePoint{{120, "1"}, {180, "1"}, {240, "1"}}, resp.Timeline[1].Values)
+}
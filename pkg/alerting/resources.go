@@ -0,0 +1,206 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/openshift/monitoring-plugin/pkg/api"
+)
+
+// ClientFunc returns a client acting with the credentials of the user of a
+// request, so that the API server enforces the permissions of the user.
+type ClientFunc func(r *http.Request) (dynamic.Interface, error)
+
+// ValidateFunc returns the errors which make an object invalid, and lint
+// warnings which do not prevent it from being applied.
+type ValidateFunc func(obj *unstructured.Unstructured) (errs []string, warnings []string)
+
+// ResourceResponse holds an object, or the errors which prevented it from
+// being applied, with the lint warnings of its content.
+type ResourceResponse struct {
+	Object   map[string]interface{} `json:"object,omitempty"`
+	DryRun   bool                   `json:"dryRun,omitempty"`
+	Errors   []string               `json:"errors,omitempty"`
+	Warnings []string               `json:"warnings,omitempty"`
+}
+
+// Resources serves the API of a namespaced resource of the monitoring stack.
+// Requests are made with the credentials of the user, which restricts them
+// to the namespaces the user has access to.
+type Resources struct {
+	client   ClientFunc
+	resource schema.GroupVersionResource
+	kind     string
+	validate ValidateFunc
+}
+
+func (res *Resources) namespaced(w http.ResponseWriter, r *http.Request) (dynamic.ResourceInterface, bool) {
+	client, err := res.client(r)
+	if err != nil {
+		api.WriteError(w, http.StatusUnauthorized, err)
+		return nil, false
+	}
+	return client.Resource(res.resource).Namespace(mux.Vars(r)["namespace"]), true
+}
+
+// ListHandler serves the objects of the namespace in the path.
+func (res *Resources) ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resource, ok := res.namespaced(w, r)
+		if !ok {
+			return
+		}
+		list, err := resource.List(r.Context(), metav1.ListOptions{LabelSelector: r.URL.Query().Get("labelSelector")})
+		if err != nil {
+			api.WriteKubeError(w, err)
+			return
+		}
+		items := make([]map[string]interface{}, 0, len(list.Items))
+		for _, item := range list.Items {
+			items = append(items, item.Object)
+		}
+		api.WriteJSON(w, http.StatusOK, items)
+	}
+}
+
+// GetHandler serves the object named in the path, with its lint warnings.
+func (res *Resources) GetHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resource, ok := res.namespaced(w, r)
+		if !ok {
+			return
+		}
+		obj, err := resource.Get(r.Context(), mux.Vars(r)["name"], metav1.GetOptions{})
+		if err != nil {
+			api.WriteKubeError(w, err)
+			return
+		}
+		_, warnings := res.validate(obj)
+		api.WriteJSON(w, http.StatusOK, ResourceResponse{Object: obj.Object, Warnings: warnings})
+	}
+}
+
+// CreateHandler creates the object of the request body in the namespace of
+// the path. With the dryRun query parameter the object is only validated by
+// the API server and the admission webhooks, without being persisted.
+func (res *Resources) CreateHandler() http.HandlerFunc {
+	return res.apply(false)
+}
+
+// UpdateHandler replaces the object named in the path. The object of the
+// latest version is replaced when the body has no resourceVersion. The dryRun
+// query parameter is honoured as on create.
+func (res *Resources) UpdateHandler() http.HandlerFunc {
+	return res.apply(true)
+}
+
+func (res *Resources) apply(update bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		obj := &unstructured.Unstructured{}
+		if err := json.NewDecoder(r.Body).Decode(&obj.Object); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", res.kind, err))
+			return
+		}
+		vars := mux.Vars(r)
+		gvk := res.resource.GroupVersion().WithKind(res.kind)
+		if obj.GetAPIVersion() == "" {
+			obj.SetAPIVersion(gvk.GroupVersion().String())
+		}
+		if obj.GetKind() == "" {
+			obj.SetKind(gvk.Kind)
+		}
+		if obj.GroupVersionKind() != gvk {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("expected a %s, got %s", gvk, obj.GroupVersionKind()))
+			return
+		}
+		if ns := obj.GetNamespace(); ns != "" && ns != vars["namespace"] {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("namespace %s does not match the namespace %s of the path", ns, vars["namespace"]))
+			return
+		}
+		obj.SetNamespace(vars["namespace"])
+		if update {
+			if name := obj.GetName(); name != "" && name != vars["name"] {
+				api.WriteError(w, http.StatusBadRequest, fmt.Errorf("name %s does not match the name %s of the path", name, vars["name"]))
+				return
+			}
+			obj.SetName(vars["name"])
+		} else if obj.GetName() == "" && obj.GetGenerateName() == "" {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("metadata.name is required"))
+			return
+		}
+
+		dryRun := r.URL.Query().Get("dryRun") == "true"
+		errs, warnings := res.validate(obj)
+		if len(errs) > 0 {
+			api.WriteJSON(w, http.StatusUnprocessableEntity, ResourceResponse{DryRun: dryRun, Errors: errs, Warnings: warnings})
+			return
+		}
+
+		resource, ok := res.namespaced(w, r)
+		if !ok {
+			return
+		}
+		var options []string
+		if dryRun {
+			options = []string{metav1.DryRunAll}
+		}
+		var (
+			applied *unstructured.Unstructured
+			err     error
+			verb    = "created"
+		)
+		if update {
+			verb = "updated"
+			if obj.GetResourceVersion() == "" {
+				current, err := resource.Get(r.Context(), obj.GetName(), metav1.GetOptions{})
+				if err != nil {
+					api.WriteKubeError(w, err)
+					return
+				}
+				obj.SetResourceVersion(current.GetResourceVersion())
+			}
+			applied, err = resource.Update(r.Context(), obj, metav1.UpdateOptions{DryRun: options})
+		} else {
+			applied, err = resource.Create(r.Context(), obj, metav1.CreateOptions{DryRun: options})
+		}
+		if err != nil {
+			api.WriteKubeError(w, err)
+			return
+		}
+
+		status := http.StatusOK
+		if !update && !dryRun {
+			status = http.StatusCreated
+		}
+		if !dryRun {
+			log.Infof("%s %s %s/%s", verb, res.kind, applied.GetNamespace(), applied.GetName())
+		}
+		api.WriteJSON(w, status, ResourceResponse{Object: applied.Object, DryRun: dryRun, Warnings: warnings})
+	}
+}
+
+// DeleteHandler deletes the object named in the path.
+func (res *Resources) DeleteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resource, ok := res.namespaced(w, r)
+		if !ok {
+			return
+		}
+		var options metav1.DeleteOptions
+		if r.URL.Query().Get("dryRun") == "true" {
+			options.DryRun = []string{metav1.DryRunAll}
+		}
+		if err := resource.Delete(r.Context(), mux.Vars(r)["name"], options); err != nil {
+			api.WriteKubeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
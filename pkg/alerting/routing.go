@@ -0,0 +1,413 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	amconfig "github.com/prometheus/alertmanager/config"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/timeinterval"
+	"github.com/prometheus/common/model"
+
+	"github.com/openshift/monitoring-plugin/pkg/api"
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+const (
+	statusPath = "/api/v2/status"
+	alertsPath = "/api/v2/alerts"
+)
+
+// RoutingRequest is the label set of the alert to route.
+type RoutingRequest struct {
+	Labels map[string]string `json:"labels"`
+}
+
+// RoutedTo is a route of the routing tree matched by an alert, with the
+// notification settings applied to the alert.
+type RoutedTo struct {
+	// Path holds the matchers of the route and of its parents.
+	Path         []string `json:"path"`
+	Receiver     string   `json:"receiver"`
+	Integrations []string `json:"integrations"`
+	Continue     bool     `json:"continue,omitempty"`
+	// GroupKey identifies the notification group of the alert, alerts with
+	// the same key are sent in the same notification.
+	GroupKey       string            `json:"groupKey"`
+	GroupLabels    map[string]string `json:"groupLabels"`
+	GroupWait      string            `json:"groupWait"`
+	GroupInterval  string            `json:"groupInterval"`
+	RepeatInterval string            `json:"repeatInterval"`
+	// MutedBy holds the time intervals muting the route now.
+	MutedBy []string `json:"mutedBy,omitempty"`
+}
+
+// Inhibition is an inhibit rule muting the alert, with the firing alert
+// which inhibits it.
+type Inhibition struct {
+	SourceMatchers []string          `json:"sourceMatchers"`
+	TargetMatchers []string          `json:"targetMatchers"`
+	Equal          []string          `json:"equal,omitempty"`
+	Source         map[string]string `json:"source"`
+}
+
+// RoutingResponse tells where Alertmanager would send an alert, and what
+// would prevent the notifications from being sent.
+type RoutingResponse struct {
+	Labels      map[string]string `json:"labels"`
+	Routes      []RoutedTo        `json:"routes"`
+	InhibitedBy []Inhibition      `json:"inhibitedBy"`
+	SilencedBy  []string          `json:"silencedBy"`
+	Warnings    []string          `json:"warnings,omitempty"`
+}
+
+// integrations returns the kinds of the integrations of a receiver, like
+// slack or webhook.
+func integrations(receiver *amconfig.Receiver) []string {
+	kinds := []string{}
+	v := reflect.ValueOf(receiver).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if kind, ok := strings.CutSuffix(tag, "_configs"); ok && v.Field(i).Kind() == reflect.Slice && v.Field(i).Len() > 0 {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// routeNode is a node of the routing tree, with the options inherited from
+// its parents, as built by the Alertmanager dispatcher.
+type routeNode struct {
+	parent          *routeNode
+	receiver        string
+	groupBy         map[model.LabelName]bool
+	groupByAll      bool
+	groupWait       time.Duration
+	groupInterval   time.Duration
+	repeatInterval  time.Duration
+	muteIntervals   []string
+	activeIntervals []string
+	matchers        labels.Matchers
+	cont            bool
+	routes          []*routeNode
+}
+
+// configMatchers merges the deprecated match and match_re settings with the
+// matchers of a route or an inhibit rule.
+func configMatchers(match map[string]string, matchRE amconfig.MatchRegexps, matchers amconfig.Matchers) labels.Matchers {
+	var all labels.Matchers
+	for name, value := range match {
+		if m, err := labels.NewMatcher(labels.MatchEqual, name, value); err == nil {
+			all = append(all, m)
+		}
+	}
+	for name, re := range matchRE {
+		if m, err := labels.NewMatcher(labels.MatchRegexp, name, re.String()); err == nil {
+			all = append(all, m)
+		}
+	}
+	all = append(all, matchers...)
+	sort.Sort(all)
+	return all
+}
+
+func newRouteNode(cr *amconfig.Route, parent *routeNode) *routeNode {
+	node := &routeNode{
+		groupWait:      30 * time.Second,
+		groupInterval:  5 * time.Minute,
+		repeatInterval: 4 * time.Hour,
+		groupBy:        map[model.LabelName]bool{},
+	}
+	if parent != nil {
+		*node = *parent
+	}
+	node.parent = parent
+	if cr.Receiver != "" {
+		node.receiver = cr.Receiver
+	}
+	if cr.GroupBy != nil {
+		node.groupBy = map[model.LabelName]bool{}
+		for _, name := range cr.GroupBy {
+			node.groupBy[name] = true
+		}
+		node.groupByAll = false
+	} else if cr.GroupByAll {
+		node.groupByAll = true
+	}
+	if cr.GroupWait != nil {
+		node.groupWait = time.Duration(*cr.GroupWait)
+	}
+	if cr.GroupInterval != nil {
+		node.groupInterval = time.Duration(*cr.GroupInterval)
+	}
+	if cr.RepeatInterval != nil {
+		node.repeatInterval = time.Duration(*cr.RepeatInterval)
+	}
+	node.matchers = configMatchers(cr.Match, cr.MatchRE, cr.Matchers)
+	node.muteIntervals = cr.MuteTimeIntervals
+	node.activeIntervals = cr.ActiveTimeIntervals
+	node.cont = cr.Continue
+	node.routes = nil
+	for _, child := range cr.Routes {
+		node.routes = append(node.routes, newRouteNode(child, node))
+	}
+	return node
+}
+
+// match returns the deepest routes matching lset, searching the children
+// from left to right until one without continue matches.
+func (n *routeNode) match(lset model.LabelSet) []*routeNode {
+	if !n.matchers.Matches(lset) {
+		return nil
+	}
+	var all []*routeNode
+	for _, child := range n.routes {
+		matches := child.match(lset)
+		all = append(all, matches...)
+		if matches != nil && !child.cont {
+			break
+		}
+	}
+	if len(all) == 0 {
+		all = append(all, n)
+	}
+	return all
+}
+
+// path returns the matchers of the route and of its parents, from the root.
+func (n *routeNode) path() []string {
+	if n.parent == nil {
+		return []string{n.matchers.String()}
+	}
+	return append(n.parent.path(), n.matchers.String())
+}
+
+// groupLabels returns the labels grouping the alert on the route.
+func (n *routeNode) groupLabels(lset model.LabelSet) model.LabelSet {
+	group := model.LabelSet{}
+	for name, value := range lset {
+		if n.groupByAll || n.groupBy[name] {
+			group[name] = value
+		}
+	}
+	return group
+}
+
+// mutedBy returns the time intervals of a route which mute it at now.
+func mutedBy(config *amconfig.Config, route *routeNode, now time.Time) []string {
+	intervals := map[string][]timeinterval.TimeInterval{}
+	for _, ti := range config.MuteTimeIntervals {
+		intervals[ti.Name] = ti.TimeIntervals
+	}
+	for _, ti := range config.TimeIntervals {
+		intervals[ti.Name] = ti.TimeIntervals
+	}
+	contains := func(name string) bool {
+		for _, ti := range intervals[name] {
+			if ti.ContainsTime(now) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var muted []string
+	for _, name := range route.muteIntervals {
+		if contains(name) {
+			muted = append(muted, name)
+		}
+	}
+	if active := route.activeIntervals; len(active) > 0 {
+		inactive := true
+		for _, name := range active {
+			inactive = inactive && !contains(name)
+		}
+		if inactive {
+			muted = append(muted, active...)
+		}
+	}
+	return muted
+}
+
+// Route matches an alert against the routing tree of config, honouring the
+// continue setting of the routes.
+func Route(config *amconfig.Config, lset model.LabelSet, now time.Time) []RoutedTo {
+	receivers := map[string]*amconfig.Receiver{}
+	for i := range config.Receivers {
+		receivers[config.Receivers[i].Name] = &config.Receivers[i]
+	}
+
+	routes := []RoutedTo{}
+	for _, route := range newRouteNode(config.Route, nil).match(lset) {
+		group := route.groupLabels(lset)
+		path := route.path()
+		routed := RoutedTo{
+			Path:           path,
+			Receiver:       route.receiver,
+			Integrations:   []string{},
+			Continue:       route.cont,
+			GroupKey:       fmt.Sprintf("%s:%s", strings.Join(path, "/"), group),
+			GroupLabels:    map[string]string{},
+			GroupWait:      model.Duration(route.groupWait).String(),
+			GroupInterval:  model.Duration(route.groupInterval).String(),
+			RepeatInterval: model.Duration(route.repeatInterval).String(),
+			MutedBy:        mutedBy(config, route, now),
+		}
+		if receiver, ok := receivers[routed.Receiver]; ok {
+			routed.Integrations = integrations(receiver)
+		}
+		for name, value := range group {
+			routed.GroupLabels[string(name)] = string(value)
+		}
+		routes = append(routes, routed)
+	}
+	return routes
+}
+
+// Inhibitions returns the inhibit rules of config which mute an alert, given
+// the active alerts, as the Alertmanager inhibitor does.
+func Inhibitions(config *amconfig.Config, lset model.LabelSet, active []model.LabelSet) []Inhibition {
+	inhibitions := []Inhibition{}
+	fp := lset.Fingerprint()
+	for _, cr := range config.InhibitRules {
+		sourceMatchers := configMatchers(cr.SourceMatch, cr.SourceMatchRE, cr.SourceMatchers)
+		targetMatchers := configMatchers(cr.TargetMatch, cr.TargetMatchRE, cr.TargetMatchers)
+		if !targetMatchers.Matches(lset) {
+			continue
+		}
+		// An alert matching both sides is not inhibited by the alerts which
+		// match both sides too.
+		twoSided := sourceMatchers.Matches(lset)
+	Sources:
+		for _, source := range active {
+			if source.Fingerprint() == fp || !sourceMatchers.Matches(source) {
+				continue
+			}
+			if twoSided && targetMatchers.Matches(source) {
+				continue
+			}
+			for _, name := range cr.Equal {
+				if source[name] != lset[name] {
+					continue Sources
+				}
+			}
+
+			inhibition := Inhibition{Source: map[string]string{}}
+			for _, m := range sourceMatchers {
+				inhibition.SourceMatchers = append(inhibition.SourceMatchers, m.String())
+			}
+			for _, m := range targetMatchers {
+				inhibition.TargetMatchers = append(inhibition.TargetMatchers, m.String())
+			}
+			for _, name := range cr.Equal {
+				inhibition.Equal = append(inhibition.Equal, string(name))
+			}
+			for name, value := range source {
+				inhibition.Source[string(name)] = string(value)
+			}
+			inhibitions = append(inhibitions, inhibition)
+			break
+		}
+	}
+	return inhibitions
+}
+
+func parseRoutingRequest(r *http.Request) (RoutingRequest, error) {
+	var req RoutingRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, fmt.Errorf("invalid routing request: %w", err)
+		}
+	} else {
+		req.Labels = map[string]string{}
+		for _, l := range r.URL.Query()["label"] {
+			name, value, ok := strings.Cut(l, "=")
+			if !ok {
+				return req, fmt.Errorf("invalid label %q, expected name=value", l)
+			}
+			req.Labels[name] = value
+		}
+	}
+	if len(req.Labels) == 0 {
+		return req, fmt.Errorf("labels are required")
+	}
+	for name := range req.Labels {
+		if !model.LabelName(name).IsValid() {
+			return req, fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return req, nil
+}
+
+// RoutingHandler simulates the routing of an alert with the live
+// configuration of Alertmanager: the routes and receivers it reaches, its
+// notification groups, and the inhibit rules and silences muting it.
+func RoutingHandler(alertmanager monitoring.Upstream) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := parseRoutingRequest(r)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var status struct {
+			Config struct {
+				Original string `json:"original"`
+			} `json:"config"`
+		}
+		if err := alertmanager.Get(r, statusPath, nil, &status); err != nil {
+			api.WriteError(w, http.StatusBadGateway, err)
+			return
+		}
+		config, err := amconfig.Load(status.Config.Original)
+		if err != nil {
+			api.WriteError(w, http.StatusBadGateway, fmt.Errorf("cannot parse the alertmanager configuration: %w", err))
+			return
+		}
+
+		lset := model.LabelSet{}
+		for name, value := range req.Labels {
+			lset[model.LabelName(name)] = model.LabelValue(value)
+		}
+		resp := RoutingResponse{
+			Labels:     req.Labels,
+			Routes:     Route(config, lset, time.Now()),
+			SilencedBy: []string{},
+		}
+
+		var alerts []struct {
+			Labels map[string]string `json:"labels"`
+		}
+		if err := alertmanager.Get(r, alertsPath, nil, &alerts); err != nil {
+			log.WithError(err).Warn("cannot fetch alerts to evaluate inhibitions")
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("inhibitions are unknown: %v", err))
+		} else {
+			active := make([]model.LabelSet, 0, len(alerts))
+			for _, a := range alerts {
+				source := model.LabelSet{}
+				for name, value := range a.Labels {
+					source[model.LabelName(name)] = model.LabelValue(value)
+				}
+				active = append(active, source)
+			}
+			resp.InhibitedBy = Inhibitions(config, lset, active)
+		}
+
+		var silences []Silence
+		if err := alertmanager.Get(r, silencesPath, nil, &silences); err != nil {
+			log.WithError(err).Warn("cannot fetch silences")
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("silences are unknown: %v", err))
+		}
+		for _, silence := range silences {
+			if silence.State() == SilenceActive && IsSilenced(req.Labels, silence) {
+				resp.SilencedBy = append(resp.SilencedBy, silence.ID)
+			}
+		}
+		api.WriteJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,91 @@
+package alerting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+const routingConfig = `
+route:
+  receiver: default
+  group_by: [namespace]
+  routes:
+  - receiver: audit
+    matchers: ['severity=~"critical|warning"']
+    continue: true
+  - receiver: team
+    matchers: [namespace="team"]
+    group_by: [alertname]
+    group_wait: 1m
+    routes:
+    - receiver: pager
+      matchers: [severity="critical"]
+  - receiver: unreachable
+    matchers: [namespace="team"]
+receivers:
+- name: default
+- name: audit
+  webhook_configs: [{url: "https://audit.example.com"}]
+- name: team
+- name: pager
+  pagerduty_configs: [{routing_key: secret}]
+- name: unreachable
+inhibit_rules:
+- source_matchers: [alertname="NamespaceDown"]
+  target_matchers: [severity="critical"]
+  equal: [namespace]
+`
+
+func TestRouting(t *testing.T) {
+	am := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case statusPath:
+			json.NewEncoder(w).Encode(map[string]interface{}{"config": map[string]string{"original": routingConfig}})
+		case alertsPath:
+			w.Write([]byte(`[{"labels":{"alertname":"NamespaceDown","namespace":"team","severity":"warning"}},{"labels":{"alertname":"NamespaceDown","namespace":"other"}}]`))
+		case silencesPath:
+			w.Write([]byte(`[{"id":"s1","status":{"state":"active"},"matchers":[{"name":"alertname","value":"Api.*","isRegex":true}]},{"id":"s2","status":{"state":"expired"},"matchers":[{"name":"namespace","value":"team"}]}]`))
+		}
+	})
+	handler := RoutingHandler(monitoring.Upstream{Kind: monitoring.AlertManagerKind, Handler: am})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/alertmanager/routing?label=alertname=ApiDown&label=namespace=team&label=severity=critical", nil))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var resp RoutingResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Routes, 2)
+	require.Equal(t, "audit", resp.Routes[0].Receiver)
+	require.Equal(t, []string{"webhook"}, resp.Routes[0].Integrations)
+	require.True(t, resp.Routes[0].Continue)
+	require.Equal(t, `{}/{severity=~"critical|warning"}:{namespace="team"}`, resp.Routes[0].GroupKey)
+
+	require.Equal(t, "pager", resp.Routes[1].Receiver)
+	require.Equal(t, []string{"{}", `{namespace="team"}`, `{severity="critical"}`}, resp.Routes[1].Path)
+	require.Equal(t, map[string]string{"alertname": "ApiDown"}, resp.Routes[1].GroupLabels)
+	require.Equal(t, "1m", resp.Routes[1].GroupWait)
+	require.Equal(t, "4h", resp.Routes[1].RepeatInterval)
+
+	require.Len(t, resp.InhibitedBy, 1)
+	require.Equal(t, "NamespaceDown", resp.InhibitedBy[0].Source["alertname"])
+	require.Equal(t, []string{"namespace"}, resp.InhibitedBy[0].Equal)
+	require.Equal(t, []string{"s1"}, resp.SilencedBy)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/alertmanager/routing", strings.NewReader(`{"labels":{"namespace":"other"}}`)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	resp = RoutingResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Routes, 1)
+	require.Equal(t, "default", resp.Routes[0].Receiver)
+	require.Equal(t, []string{}, resp.Routes[0].Integrations)
+	require.Empty(t, resp.InhibitedBy)
+}
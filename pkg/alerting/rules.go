@@ -0,0 +1,177 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/template"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// PrometheusRuleResource is the resource of the PrometheusRule objects of the
+// Prometheus operator.
+var PrometheusRuleResource = schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1", Resource: "prometheusrules"}
+
+// templateDefs are the variables available to the templates of the labels
+// and annotations of an alerting rule.
+var templateDefs = strings.Join([]string{
+	"{{$labels := .Labels}}",
+	"{{$externalLabels := .ExternalLabels}}",
+	"{{$externalURL := .ExternalURL}}",
+	"{{$value := .Value}}",
+}, "")
+
+// ruleGroupsSpec is the part of the PrometheusRule spec which is validated,
+// other fields are kept as is.
+type ruleGroupsSpec struct {
+	Groups []struct {
+		Name     string `json:"name"`
+		Interval string `json:"interval,omitempty"`
+		Rules    []struct {
+			Record        string             `json:"record,omitempty"`
+			Alert         string             `json:"alert,omitempty"`
+			Expr          intstr.IntOrString `json:"expr"`
+			For           string             `json:"for,omitempty"`
+			KeepFiringFor string             `json:"keep_firing_for,omitempty"`
+			Labels        map[string]string  `json:"labels,omitempty"`
+			Annotations   map[string]string  `json:"annotations,omitempty"`
+		} `json:"rules"`
+	} `json:"groups"`
+}
+
+// ValidatePrometheusRule parses the expressions and templates of the rules of
+// a PrometheusRule object, as Prometheus does when loading them. It returns
+// the errors which make Prometheus reject the rules, and lint warnings for
+// the conventions of the console, like the severity label and the summary and
+// description annotations.
+func ValidatePrometheusRule(obj *unstructured.Unstructured) (errs []string, warnings []string) {
+	spec, found, err := unstructured.NestedFieldNoCopy(obj.Object, "spec")
+	if err != nil || !found {
+		return []string{"spec is required"}, nil
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	var rules ruleGroupsSpec
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return []string{fmt.Sprintf("invalid spec: %v", err)}, nil
+	}
+	if len(rules.Groups) == 0 {
+		warnings = append(warnings, "spec has no rule groups")
+	}
+
+	groups := map[string]bool{}
+	for i, g := range rules.Groups {
+		where := fmt.Sprintf("group %q", g.Name)
+		if g.Name == "" {
+			where = fmt.Sprintf("group %d", i)
+			errs = append(errs, where+": name is required")
+		} else if groups[g.Name] {
+			errs = append(errs, where+": duplicate group name")
+		}
+		groups[g.Name] = true
+		if g.Interval != "" {
+			if _, err := model.ParseDuration(g.Interval); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: invalid interval %q", where, g.Interval))
+			}
+		}
+
+		for j, rule := range g.Rules {
+			where := fmt.Sprintf("group %q, rule %d", g.Name, j)
+			switch {
+			case rule.Record != "" && rule.Alert != "":
+				errs = append(errs, where+": only one of record and alert can be set")
+				continue
+			case rule.Record == "" && rule.Alert == "":
+				errs = append(errs, where+": one of record or alert is required")
+				continue
+			case rule.Alert != "":
+				where = fmt.Sprintf("group %q, alert %q", g.Name, rule.Alert)
+			default:
+				where = fmt.Sprintf("group %q, record %q", g.Name, rule.Record)
+			}
+
+			if rule.Expr == (intstr.IntOrString{}) || rule.Expr.String() == "" {
+				errs = append(errs, where+": expr is required")
+			} else if _, err := parser.ParseExpr(rule.Expr.String()); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: invalid expr: %v", where, err))
+			}
+			for name := range rule.Labels {
+				if !model.LabelName(name).IsValid() || name == model.MetricNameLabel {
+					errs = append(errs, fmt.Sprintf("%s: invalid label name %q", where, name))
+				}
+			}
+
+			if rule.Record != "" {
+				if !model.IsValidMetricName(model.LabelValue(rule.Record)) {
+					errs = append(errs, where+": invalid metric name")
+				}
+				if rule.For != "" || rule.KeepFiringFor != "" || len(rule.Annotations) > 0 {
+					errs = append(errs, where+": for, keep_firing_for and annotations are only valid for alerts")
+				}
+				continue
+			}
+
+			for field, d := range map[string]string{"for": rule.For, "keep_firing_for": rule.KeepFiringFor} {
+				if d == "" {
+					continue
+				}
+				if _, err := model.ParseDuration(d); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: invalid %s %q", where, field, d))
+				}
+			}
+			for name, text := range rule.Labels {
+				if err := parseTemplate(rule.Alert, text); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: invalid template in label %s: %v", where, name, err))
+				}
+			}
+			for name, text := range rule.Annotations {
+				if !model.LabelName(name).IsValid() {
+					errs = append(errs, fmt.Sprintf("%s: invalid annotation name %q", where, name))
+				}
+				if err := parseTemplate(rule.Alert, text); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: invalid template in annotation %s: %v", where, name, err))
+				}
+			}
+
+			switch severity, ok := rule.Labels["severity"]; {
+			case !ok:
+				warnings = append(warnings, where+": no severity label")
+			case severity != "critical" && severity != "warning" && severity != "info" && severity != "none":
+				warnings = append(warnings, fmt.Sprintf("%s: severity %q is not one of critical, warning, info or none", where, severity))
+			}
+			for _, name := range []string{"summary", "description"} {
+				if rule.Annotations[name] == "" {
+					warnings = append(warnings, fmt.Sprintf("%s: no %s annotation", where, name))
+				}
+			}
+		}
+	}
+	// Labels and annotations are iterated in random order.
+	sort.Strings(errs)
+	sort.Strings(warnings)
+	return errs, warnings
+}
+
+func parseTemplate(alert, text string) error {
+	return template.NewTemplateExpander(context.Background(), templateDefs+text, "__alert_"+alert, nil, 0, nil, nil, nil).ParseTest()
+}
+
+// NewPrometheusRules returns the API of the PrometheusRule objects, which
+// rejects the rules that Prometheus would fail to load.
+func NewPrometheusRules(client ClientFunc) *Resources {
+	return &Resources{
+		client:   client,
+		resource: PrometheusRuleResource,
+		kind:     "PrometheusRule",
+		validate: ValidatePrometheusRule,
+	}
+}
@@ -0,0 +1,88 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+const validRule = `{
+  "metadata": {"name": "api"},
+  "spec": {"groups": [{"name": "api", "rules": [
+    {"alert": "APIDown", "expr": "up{job=\"api\"} == 0", "for": "5m",
+     "labels": {"severity": "critical"},
+     "annotations": {"summary": "API is down", "description": "{{ $labels.instance }} is down"}}
+  ]}]}
+}`
+
+func resourceRequest(t *testing.T, handler http.Handler, method, body string, vars map[string]string) (*httptest.ResponseRecorder, ResourceResponse) {
+	rec := httptest.NewRecorder()
+	req := mux.SetURLVars(httptest.NewRequest(method, "/", bytes.NewBufferString(body)), vars)
+	handler.ServeHTTP(rec, req)
+
+	var resp ResourceResponse
+	if rec.Code != http.StatusNoContent && rec.Body.Len() > 0 && rec.Body.Bytes()[0] == '{' {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	}
+	return rec, resp
+}
+
+func TestPrometheusRules(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		PrometheusRuleResource: "PrometheusRuleList",
+	})
+	p := NewPrometheusRules(func(r *http.Request) (dynamic.Interface, error) { return client, nil })
+	ns := map[string]string{"namespace": "team"}
+	named := map[string]string{"namespace": "team", "name": "api"}
+
+	rec, resp := resourceRequest(t, p.CreateHandler(), http.MethodPost, validRule, ns)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.Empty(t, resp.Warnings)
+	obj, err := client.Resource(PrometheusRuleResource).Namespace("team").Get(t.Context(), "api", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "PrometheusRule", obj.GetKind())
+
+	rec, _ = resourceRequest(t, p.CreateHandler(), http.MethodPost, `{"metadata":{"name":"x","namespace":"other"},"spec":{"groups":[]}}`, ns)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	invalid := `{"spec": {"groups": [{"name": "api", "rules": [
+	  {"alert": "Bad", "expr": "up ==", "labels": {"severity": "urgent"}, "annotations": {"summary": "{{ .Broken"}},
+	  {"record": "job:up", "expr": "sum(up)", "for": "5m"}
+	]}]}}`
+	rec, resp = resourceRequest(t, p.UpdateHandler(), http.MethodPut, invalid, named)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	require.Len(t, resp.Errors, 3)
+	require.Contains(t, resp.Errors[0], `alert "Bad": invalid expr`)
+	require.Contains(t, resp.Errors[1], `alert "Bad": invalid template in annotation summary`)
+	require.Contains(t, resp.Errors[2], `record "job:up": for, keep_firing_for and annotations are only valid for alerts`)
+	require.Equal(t, []string{
+		`group "api", alert "Bad": no description annotation`,
+		`group "api", alert "Bad": severity "urgent" is not one of critical, warning, info or none`,
+	}, resp.Warnings)
+
+	updated := `{"spec": {"groups": [{"name": "api", "rules": [{"alert": "APIDown", "expr": "up == 0"}]}]}}`
+	rec, resp = resourceRequest(t, p.UpdateHandler(), http.MethodPut, updated, named)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Len(t, resp.Warnings, 3)
+
+	rec, _ = resourceRequest(t, p.ListHandler(), http.MethodGet, "", ns)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var items []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &items))
+	require.Len(t, items, 1)
+
+	rec, _ = resourceRequest(t, p.DeleteHandler(), http.MethodDelete, "", named)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	rec, _ = resourceRequest(t, p.GetHandler(), http.MethodGet, "", named)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,538 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/common/model"
+	"github.com/robfig/cron/v3"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/openshift/monitoring-plugin/pkg/api"
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+// ScheduleConfig configures the recurring silences managed by the backend.
+type ScheduleConfig struct {
+	Enabled     bool `yaml:"enabled,omitempty"`
+	StoreConfig `yaml:",inline"`
+	// Interval between two reconciliations of the silences.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// TokenFile holds the bearer token used to manage the silences, as there
+	// is no user behind the scheduler.
+	TokenFile string `yaml:"tokenFile,omitempty"`
+	// The schedules can only be changed by the users who can create silences.
+	SilenceAccessConfig `yaml:",inline"`
+}
+
+const defaultSchedulesConfigMap = "monitoring-plugin-silence-schedules"
+
+func (c ScheduleConfig) withDefaults() ScheduleConfig {
+	if c.Interval == 0 {
+		c.Interval = time.Minute
+	}
+	if c.TokenFile == "" {
+		c.TokenFile = serviceAccountDir + "/token"
+	}
+	return c
+}
+
+// SilenceAccessConfig identifies the Alertmanager resource of the silences,
+// the resources shared by every user can only be changed by the users who can
+// create silences, with the create permission on its api subresource.
+type SilenceAccessConfig struct {
+	AlertmanagerNamespace string `yaml:"alertmanagerNamespace,omitempty"`
+	AlertmanagerName      string `yaml:"alertmanagerName,omitempty"`
+}
+
+// AccessAttributes returns the permission checked by kube-rbac-proxy to create
+// silences.
+func (c SilenceAccessConfig) AccessAttributes() authorizationv1.ResourceAttributes {
+	if c.AlertmanagerNamespace == "" {
+		c.AlertmanagerNamespace = "openshift-monitoring"
+	}
+	if c.AlertmanagerName == "" {
+		c.AlertmanagerName = "main"
+	}
+	return authorizationv1.ResourceAttributes{
+		Namespace:   c.AlertmanagerNamespace,
+		Verb:        "create",
+		Group:       "monitoring.coreos.com",
+		Resource:    "alertmanagers",
+		Subresource: "api",
+		Name:        c.AlertmanagerName,
+	}
+}
+
+// AccessFunc authorizes the user of a request and returns their name. The
+// errors are API server errors, unauthorized or forbidden for the users who
+// are not allowed.
+type AccessFunc func(r *http.Request) (string, error)
+
+// NewScheduleStore returns the store of the schedules selected by the config.
+func NewScheduleStore(config ScheduleConfig, client dynamic.Interface) (Store[SilenceSchedule], error) {
+	return NewStore[SilenceSchedule](config.StoreConfig, client, defaultSchedulesConfigMap, "schedules.json")
+}
+
+// SilenceSchedule is a recurring silence: a silence with the matchers is
+// created for each start of the cron schedule and lasts for the duration.
+type SilenceSchedule struct {
+	Name string `json:"name"`
+	// Schedule is a 5 fields cron expression, or a descriptor like @weekly.
+	Schedule string `json:"schedule"`
+	// Timezone of the schedule, defaults to UTC.
+	Timezone string    `json:"timezone,omitempty"`
+	Duration string    `json:"duration"`
+	Matchers []Matcher `json:"matchers"`
+	Comment  string    `json:"comment,omitempty"`
+	// CreatedBy is the authenticated user who last changed the schedule, the
+	// value sent by the client is ignored.
+	CreatedBy string `json:"createdBy"`
+	Disabled  bool   `json:"disabled,omitempty"`
+	// SilenceIDs are the silences created by the scheduler, the only ones it
+	// expires. The value sent by the client is ignored.
+	SilenceIDs []string `json:"silenceIDs,omitempty"`
+}
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([-_.a-zA-Z0-9]*[a-zA-Z0-9])?$`)
+
+func (s SilenceSchedule) parse() (cron.Schedule, time.Duration, error) {
+	if !nameRegexp.MatchString(s.Name) {
+		return nil, 0, fmt.Errorf("invalid schedule name %q", s.Name)
+	}
+	spec := s.Schedule
+	if s.Timezone != "" {
+		spec = "CRON_TZ=" + s.Timezone + " " + spec
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid schedule %q: %w", s.Schedule, err)
+	}
+	duration, err := model.ParseDuration(s.Duration)
+	if err != nil || duration <= 0 {
+		return nil, 0, fmt.Errorf("invalid duration %q", s.Duration)
+	}
+	if len(s.Matchers) == 0 {
+		return nil, 0, fmt.Errorf("schedule %s has no matchers", s.Name)
+	}
+	if s.CreatedBy == "" {
+		return nil, 0, fmt.Errorf("schedule %s has no creator", s.Name)
+	}
+	return schedule, time.Duration(duration), nil
+}
+
+// window returns the window of the schedule active at now, or the next one.
+func window(schedule cron.Schedule, duration time.Duration, now time.Time) (time.Time, time.Time) {
+	start := schedule.Next(now.Add(-duration))
+	return start, start.Add(duration)
+}
+
+// scheduleMarker is appended to the comment of the managed silences, to
+// identify them in Alertmanager. As any user can type it, the silences are
+// only managed when their id is stored with the schedule.
+func scheduleMarker(name string) string {
+	return "[silence-schedule:" + name + "]"
+}
+
+var scheduleMarkerRegexp = regexp.MustCompile(`\[silence-schedule:([^\]]+)\]$`)
+
+func scheduleOf(s Silence) string {
+	if m := scheduleMarkerRegexp.FindStringSubmatch(s.Comment); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// ScheduleStatus reports the silences of a schedule.
+type ScheduleStatus struct {
+	NextStart *time.Time `json:"nextStart,omitempty"`
+	SilenceID string     `json:"silenceID,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// ScheduleWithStatus is a schedule of the schedules API.
+type ScheduleWithStatus struct {
+	SilenceSchedule
+	Status ScheduleStatus `json:"status"`
+}
+
+// Scheduler creates the silences of the schedules ahead of their windows and
+// expires the silences of removed or changed schedules.
+type Scheduler struct {
+	config       ScheduleConfig
+	store        Store[SilenceSchedule]
+	alertmanager monitoring.Upstream
+	// access authorizes the changes of the schedules, as the silences are
+	// created with the credentials of the scheduler.
+	access AccessFunc
+
+	// standby is set while another replica holds the lease of the scheduler,
+	// the silences are then only observed to report the status.
+	standby atomic.Bool
+
+	// mu serializes the changes of the store and the reconciliations.
+	mu     sync.Mutex
+	status map[string]ScheduleStatus
+}
+
+func NewScheduler(config ScheduleConfig, store Store[SilenceSchedule], alertmanager monitoring.Upstream, access AccessFunc) *Scheduler {
+	return &Scheduler{
+		config:       config.withDefaults(),
+		store:        store,
+		alertmanager: alertmanager,
+		access:       access,
+		status:       map[string]ScheduleStatus{},
+	}
+}
+
+// Run reconciles the silences every interval until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Reconcile(ctx); err != nil {
+			log.WithError(err).Error("cannot reconcile scheduled silences")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Elect runs the leader election of the replicas sharing the ConfigMap store,
+// with a Lease of the same name, so that only one of them creates and expires
+// the silences. The backend needs to get, create and update the Lease. It
+// returns once the election is started, until ctx is done.
+func (s *Scheduler) Elect(ctx context.Context, client kubernetes.Interface) error {
+	namespace, name, err := s.config.configMapName(defaultSchedulesConfigMap)
+	if err != nil {
+		return err
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("cannot get the identity of the replica: %w", err)
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Client:     client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   30 * time.Second,
+		RenewDeadline:   20 * time.Second,
+		RetryPeriod:     5 * time.Second,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				log.Info("leading the silence schedules")
+				s.standby.Store(false)
+			},
+			OnStoppedLeading: func() {
+				log.Info("stopped leading the silence schedules")
+				s.standby.Store(true)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	s.standby.Store(true)
+	go func() {
+		// Run returns when the lease is lost, the replica runs for it again.
+		for ctx.Err() == nil {
+			elector.Run(ctx)
+		}
+	}()
+	return nil
+}
+
+// Reconcile creates the silence of every window starting before the next
+// reconciliation, and expires the managed silences which no longer match a
+// schedule.
+func (s *Scheduler) Reconcile(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reconcile(ctx, time.Now().UTC())
+}
+
+func (s *Scheduler) reconcile(ctx context.Context, now time.Time) error {
+	schedules, err := s.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	var silences []Silence
+	if err := s.do(ctx, http.MethodGet, silencesPath, nil, &silences); err != nil {
+		return fmt.Errorf("cannot list silences: %w", err)
+	}
+
+	status := map[string]ScheduleStatus{}
+	ids := map[string][]string{}
+	for _, schedule := range schedules {
+		var managed []Silence
+		for _, silence := range silences {
+			if scheduleOf(silence) == schedule.Name && slices.Contains(schedule.SilenceIDs, silence.ID) && silence.State() != SilenceExpired {
+				managed = append(managed, silence)
+			}
+		}
+		st, stale := s.reconcileSchedule(ctx, schedule, managed, now)
+		status[schedule.Name] = st
+		if s.standby.Load() {
+			continue
+		}
+
+		// The stale silences belong to outdated windows, they are expired by
+		// the leader.
+		var kept []string
+		for _, silence := range managed {
+			if slices.ContainsFunc(stale, func(e Silence) bool { return e.ID == silence.ID }) && s.expire(ctx, schedule.Name, silence.ID) {
+				continue
+			}
+			kept = append(kept, silence.ID)
+		}
+		if st.SilenceID != "" && !slices.Contains(kept, st.SilenceID) {
+			kept = append(kept, st.SilenceID)
+		}
+		if !slices.Equal(kept, schedule.SilenceIDs) {
+			ids[schedule.Name] = kept
+		}
+	}
+	s.status = status
+
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.store.Update(ctx, func(schedules []SilenceSchedule) ([]SilenceSchedule, error) {
+		for i := range schedules {
+			if kept, ok := ids[schedules[i].Name]; ok {
+				schedules[i].SilenceIDs = kept
+			}
+		}
+		return schedules, nil
+	})
+}
+
+// expire expires a silence of a schedule and reports whether it succeeded.
+func (s *Scheduler) expire(ctx context.Context, name, id string) bool {
+	if err := s.do(ctx, http.MethodDelete, silencePath+url.PathEscape(id), nil, nil); err != nil {
+		log.WithError(err).Warnf("cannot expire silence %s of schedule %s", id, name)
+		return false
+	}
+	log.Infof("expired silence %s of schedule %s", id, name)
+	return true
+}
+
+// reconcileSchedule creates the silence of the current or next window of a
+// schedule when due. It returns the managed silences to expire.
+func (s *Scheduler) reconcileSchedule(ctx context.Context, schedule SilenceSchedule, existing []Silence, now time.Time) (ScheduleStatus, []Silence) {
+	if schedule.Disabled {
+		return ScheduleStatus{}, existing
+	}
+	cronSchedule, duration, err := schedule.parse()
+	if err != nil {
+		// Keep the silences of a broken schedule until it is fixed.
+		return ScheduleStatus{Error: err.Error()}, nil
+	}
+
+	start, end := window(cronSchedule, duration, now)
+	status := ScheduleStatus{NextStart: &start}
+	if start.After(now.Add(2 * s.config.Interval)) {
+		return status, existing
+	}
+
+	// Alertmanager moves the start of the silences to now, the silences of the
+	// windows already started are found back by their end and matchers.
+	desired := Silence{
+		Matchers:  schedule.Matchers,
+		StartsAt:  maxTime(start, now),
+		EndsAt:    end,
+		CreatedBy: schedule.CreatedBy,
+		Comment:   strings.TrimSpace(schedule.Comment + " " + scheduleMarker(schedule.Name)),
+	}
+	var stale []Silence
+	for _, silence := range existing {
+		if status.SilenceID == "" && sameWindow(silence, desired) {
+			status.SilenceID = silence.ID
+			continue
+		}
+		stale = append(stale, silence)
+	}
+	if status.SilenceID != "" || s.standby.Load() {
+		return status, stale
+	}
+
+	var created struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := s.do(ctx, http.MethodPost, silencesPath, desired, &created); err != nil {
+		status.Error = fmt.Sprintf("cannot create silence: %v", err)
+		return status, stale
+	}
+	log.Infof("created silence %s of schedule %s from %s to %s", created.SilenceID, schedule.Name, start, end)
+	status.SilenceID = created.SilenceID
+	return status, stale
+}
+
+// sameWindow reports whether an existing silence of the schedule is the
+// desired one. The start is ignored as Alertmanager moves it to the creation
+// time for the windows already started, and extra matchers are ignored as the
+// Alertmanager aggregation adds a source matcher.
+func sameWindow(existing, desired Silence) bool {
+	if !existing.EndsAt.Equal(desired.EndsAt) {
+		return false
+	}
+	for _, m := range desired.Matchers {
+		if !slices.ContainsFunc(existing.Matchers, func(e Matcher) bool { return e.String() == m.String() }) {
+			return false
+		}
+	}
+	return true
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// do sends a request to Alertmanager with the credentials of the scheduler.
+func (s *Scheduler) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	req, err := s.alertmanager.NewRequest(nil, method, path, nil, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	token, err := os.ReadFile(s.config.TokenFile)
+	if err != nil {
+		return fmt.Errorf("cannot read the token of the scheduler: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	return s.alertmanager.Do(req, out)
+}
+
+// ListHandler serves the schedules with their status.
+func (s *Scheduler) ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		schedules, err := s.store.Load(r.Context())
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp := []ScheduleWithStatus{}
+		for _, schedule := range schedules {
+			resp = append(resp, ScheduleWithStatus{SilenceSchedule: schedule, Status: s.status[schedule.Name]})
+		}
+		api.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+// PutHandler creates or replaces the schedule named in the path, for the users
+// who can create silences.
+func (s *Scheduler) PutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.access(r)
+		if err != nil {
+			api.WriteKubeError(w, err)
+			return
+		}
+		var schedule SilenceSchedule
+		if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid schedule: %w", err))
+			return
+		}
+		schedule.Name = mux.Vars(r)["name"]
+		schedule.CreatedBy = user
+		if _, _, err := schedule.parse(); err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		err = s.update(r.Context(), func(schedules []SilenceSchedule) []SilenceSchedule {
+			i := slices.IndexFunc(schedules, func(s SilenceSchedule) bool { return s.Name == schedule.Name })
+			if i < 0 {
+				schedule.SilenceIDs = nil
+				return append(schedules, schedule)
+			}
+			schedule.SilenceIDs = schedules[i].SilenceIDs
+			schedules[i] = schedule
+			return schedules
+		})
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, schedule)
+	}
+}
+
+// DeleteHandler removes the schedule named in the path and expires its
+// silences, which are no longer known once the schedule is removed.
+func (s *Scheduler) DeleteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := s.access(r); err != nil {
+			api.WriteKubeError(w, err)
+			return
+		}
+		name := mux.Vars(r)["name"]
+		var removed *SilenceSchedule
+		err := s.update(r.Context(), func(schedules []SilenceSchedule) []SilenceSchedule {
+			removed = nil
+			return slices.DeleteFunc(schedules, func(s SilenceSchedule) bool {
+				if s.Name == name {
+					removed = &s
+				}
+				return s.Name == name
+			})
+		})
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if removed == nil {
+			api.WriteError(w, http.StatusNotFound, fmt.Errorf("schedule %s not found", name))
+			return
+		}
+		for _, id := range removed.SilenceIDs {
+			s.expire(r.Context(), name, id)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// update changes the stored schedules and reconciles the silences right away.
+// The change is retried on conflicts with the other replicas.
+func (s *Scheduler) update(ctx context.Context, change func([]SilenceSchedule) []SilenceSchedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.store.Update(ctx, func(schedules []SilenceSchedule) ([]SilenceSchedule, error) {
+		return change(schedules), nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.reconcile(ctx, time.Now().UTC()); err != nil {
+		log.WithError(err).Warn("cannot reconcile scheduled silences")
+	}
+	return nil
+}
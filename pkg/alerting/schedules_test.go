@@ -0,0 +1,246 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+// scheduleConfig returns a config with the token file of the scheduler.
+func scheduleConfig(t *testing.T) ScheduleConfig {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("scheduler\n"), 0o600))
+	return ScheduleConfig{TokenFile: tokenFile}
+}
+
+func TestSchedulerReconcile(t *testing.T) {
+	ctx := context.Background()
+	store := FileStore[SilenceSchedule]{Path: filepath.Join(t.TempDir(), "schedules.json")}
+	require.NoError(t, store.Save(ctx, []SilenceSchedule{{
+		Name:      "weekly",
+		Schedule:  "0 22 * * 6",
+		Duration:  "4h",
+		Matchers:  []Matcher{{Name: "namespace", Value: "batch"}},
+		Comment:   "weekly maintenance",
+		CreatedBy: "ops",
+	}}))
+
+	// Silences typed with the marker of a schedule are not managed.
+	forged := Silence{
+		ID:      "forged",
+		Comment: "mine " + scheduleMarker("weekly"),
+		Status:  &SilenceStatus{State: SilenceActive},
+	}
+	am := &fakeAlertmanager{silences: []Silence{forged}}
+	s := NewScheduler(scheduleConfig(t), store, monitoring.Upstream{Kind: monitoring.AlertManagerKind, Handler: am}, nil)
+	silenceIDs := func() []string {
+		schedules, err := store.Load(ctx)
+		require.NoError(t, err)
+		if len(schedules) == 0 {
+			return nil
+		}
+		return schedules[0].SilenceIDs
+	}
+
+	// Saturday 2024-06-01 23:00 UTC is within the window.
+	now := time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC)
+	require.NoError(t, s.reconcile(ctx, now))
+
+	require.Len(t, am.posted, 1)
+	// The window has started, the silence starts now.
+	require.Equal(t, now, am.posted[0].StartsAt)
+	require.Equal(t, time.Date(2024, 6, 2, 2, 0, 0, 0, time.UTC), am.posted[0].EndsAt)
+	require.Equal(t, "weekly", scheduleOf(am.posted[0]))
+	require.Empty(t, am.expired)
+	require.Equal(t, "new", s.status["weekly"].SilenceID)
+	require.Equal(t, []string{"new"}, silenceIDs())
+
+	// The existing silence of the window is kept.
+	created := am.posted[0]
+	created.ID = "new"
+	created.Status = &SilenceStatus{State: SilenceActive}
+	am.silences = []Silence{forged, created}
+	require.NoError(t, s.reconcile(ctx, now.Add(time.Hour)))
+	require.Len(t, am.posted, 1)
+
+	// Out of the window, the next one is reported but not created yet, and
+	// the silence of the past window is expired.
+	require.NoError(t, s.reconcile(ctx, now.Add(24*time.Hour)))
+	require.Len(t, am.posted, 1)
+	require.Equal(t, time.Date(2024, 6, 8, 22, 0, 0, 0, time.UTC), *s.status["weekly"].NextStart)
+	require.Equal(t, []string{"new"}, am.expired)
+	require.Empty(t, silenceIDs())
+
+	// The silences of a deleted schedule are expired.
+	am.silences, am.expired = []Silence{forged}, nil
+	require.NoError(t, s.reconcile(ctx, now))
+	require.Equal(t, []string{"new"}, silenceIDs())
+	s.access = func(r *http.Request) (string, error) { return "ops", nil }
+	rec := httptest.NewRecorder()
+	s.DeleteHandler().ServeHTTP(rec, mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/api/v1/silence-schedules/weekly", nil), map[string]string{"name": "weekly"}))
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, []string{"new"}, am.expired)
+
+	// Without its token, the scheduler sends no request.
+	s.config.TokenFile = filepath.Join(t.TempDir(), "missing")
+	require.ErrorContains(t, s.reconcile(ctx, now), "cannot read the token of the scheduler")
+}
+
+// TestSchedulerElection checks that the replicas waiting for the lease only
+// report the status of the schedules.
+func TestSchedulerElection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := FileStore[SilenceSchedule]{Path: filepath.Join(t.TempDir(), "schedules.json")}
+	require.NoError(t, store.Save(ctx, []SilenceSchedule{{
+		Name:      "nightly",
+		Schedule:  "0 1 * * *",
+		Duration:  "6h",
+		Matchers:  []Matcher{{Name: "namespace", Value: "batch"}},
+		CreatedBy: "ops",
+	}}))
+	am := &fakeAlertmanager{}
+	config := scheduleConfig(t)
+	config.ConfigMap = "monitoring/schedules"
+	s := NewScheduler(config, store, monitoring.Upstream{Kind: monitoring.AlertManagerKind, Handler: am}, nil)
+
+	now := time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC)
+	s.standby.Store(true)
+	require.NoError(t, s.reconcile(ctx, now))
+	require.Empty(t, am.posted)
+	require.Empty(t, am.expired)
+	require.NotNil(t, s.status["nightly"].NextStart)
+
+	client := kubefake.NewSimpleClientset()
+	require.NoError(t, s.Elect(ctx, client))
+	require.Eventually(t, func() bool { return !s.standby.Load() }, 5*time.Second, 10*time.Millisecond)
+	lease, err := client.CoordinationV1().Leases("monitoring").Get(ctx, "schedules", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NotEmpty(t, *lease.Spec.HolderIdentity)
+
+	require.NoError(t, s.reconcile(ctx, now))
+	require.Len(t, am.posted, 1)
+}
+
+// TestSchedulerStartedWindow checks that the silence of a started window is
+// kept, although Alertmanager moves its start to the time of its creation.
+func TestSchedulerStartedWindow(t *testing.T) {
+	ctx := context.Background()
+	store := FileStore[SilenceSchedule]{Path: filepath.Join(t.TempDir(), "schedules.json")}
+	require.NoError(t, store.Save(ctx, []SilenceSchedule{{
+		Name:      "nightly",
+		Schedule:  "0 1 * * *",
+		Duration:  "6h",
+		Matchers:  []Matcher{{Name: "namespace", Value: "batch"}},
+		CreatedBy: "ops",
+	}}))
+
+	now := time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC)
+	// The clock of Alertmanager is a few seconds ahead.
+	am := &fakeAlertmanager{now: func() time.Time { return now.Add(3 * time.Second) }}
+	s := NewScheduler(scheduleConfig(t), store, monitoring.Upstream{Kind: monitoring.AlertManagerKind, Handler: am}, nil)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.reconcile(ctx, now))
+		now = now.Add(time.Minute)
+	}
+	require.Len(t, am.posted, 1)
+	require.Empty(t, am.expired)
+	require.Equal(t, "new-1", s.status["nightly"].SilenceID)
+}
+
+func TestScheduleHandlersAccess(t *testing.T) {
+	store := FileStore[SilenceSchedule]{Path: filepath.Join(t.TempDir(), "schedules.json")}
+	am := &fakeAlertmanager{}
+	access := func(r *http.Request) (string, error) {
+		if r.Header.Get("Authorization") != "Bearer ops" {
+			return "", apierrors.NewForbidden(schema.GroupResource{Resource: "alertmanagers/api"}, "main", errors.New("the user cannot create silences"))
+		}
+		return "ops-user", nil
+	}
+	s := NewScheduler(scheduleConfig(t), store, monitoring.Upstream{Kind: monitoring.AlertManagerKind, Handler: am}, access)
+
+	request := func(handler http.Handler, method, token string) *httptest.ResponseRecorder {
+		body := `{"schedule": "@daily", "duration": "1h", "matchers": [{"name": "namespace", "value": "batch"}], "createdBy": "someone-else"}`
+		req := mux.SetURLVars(httptest.NewRequest(method, "/api/v1/silence-schedules/daily", strings.NewReader(body)), map[string]string{"name": "daily"})
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusForbidden, request(s.PutHandler(), http.MethodPut, "viewer").Code)
+	schedules, err := store.Load(t.Context())
+	require.NoError(t, err)
+	require.Empty(t, schedules)
+
+	rec := request(s.PutHandler(), http.MethodPut, "ops")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	schedules, err = store.Load(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, "ops-user", schedules[0].CreatedBy)
+
+	require.Equal(t, http.StatusForbidden, request(s.DeleteHandler(), http.MethodDelete, "viewer").Code)
+	require.Equal(t, http.StatusNoContent, request(s.DeleteHandler(), http.MethodDelete, "ops").Code)
+}
+
+func TestConfigMapStore(t *testing.T) {
+	ctx := context.Background()
+	store := ConfigMapStore[SilenceSchedule]{
+		Client:    dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()),
+		Namespace: "openshift-monitoring",
+		Name:      "schedules",
+		Key:       "schedules.json",
+	}
+
+	schedules, err := store.Load(ctx)
+	require.NoError(t, err)
+	require.Empty(t, schedules)
+
+	want := []SilenceSchedule{{Name: "a", Schedule: "@daily", Duration: "1h", CreatedBy: "ops"}}
+	require.NoError(t, store.Save(ctx, want))
+	want[0].Duration = "2h"
+	require.NoError(t, store.Save(ctx, want))
+
+	schedules, err = store.Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, want, schedules)
+
+	// Updates are retried when another writer changed the ConfigMap.
+	client := store.Client.(*dynamicfake.FakeDynamicClient)
+	conflicts := 1
+	client.PrependReactor("update", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			return false, nil, nil
+		}
+		conflicts--
+		return true, nil, apierrors.NewConflict(configMapResource.GroupResource(), store.Name, errors.New("changed"))
+	})
+	calls := 0
+	require.NoError(t, store.Update(ctx, func(schedules []SilenceSchedule) ([]SilenceSchedule, error) {
+		calls++
+		return append(schedules, SilenceSchedule{Name: "b", Schedule: "@weekly", Duration: "1h"}), nil
+	}))
+	require.Equal(t, 2, calls)
+	schedules, err = store.Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, []string{schedules[0].Name, schedules[1].Name})
+}
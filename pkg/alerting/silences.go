@@ -0,0 +1,391 @@
+package alerting
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift/monitoring-plugin/pkg/api"
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+// silencesCacheTTL is how long the silences of a user are reused between
+// pages, writes through the silence APIs and the proxied Alertmanager drop the
+// cache.
+const silencesCacheTTL = 10 * time.Second
+
+// Silences serves the silence APIs on top of an Alertmanager upstream, the
+// Thanos Querier upstream resolves the alerts to silence.
+type Silences struct {
+	alertmanager monitoring.Upstream
+	thanos       monitoring.Upstream
+	// incidents resolves the alerts of the incidents selected by the bulk
+	// requests.
+	incidents *Incidents
+
+	mu    sync.Mutex
+	cache map[string]cachedSilences
+}
+
+type cachedSilences struct {
+	silences []Silence
+	expires  time.Time
+}
+
+func NewSilences(alertmanager, thanos monitoring.Upstream) *Silences {
+	return &Silences{
+		alertmanager: alertmanager,
+		thanos:       thanos,
+		incidents:    NewIncidents(thanos),
+		cache:        map[string]cachedSilences{},
+	}
+}
+
+// list returns the silences readable by the user of r.
+func (s *Silences) list(r *http.Request) ([]Silence, error) {
+	key := monitoring.TenantKey(r)
+	now := time.Now()
+
+	s.mu.Lock()
+	cached, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.silences, nil
+	}
+
+	var silences []Silence
+	if err := s.alertmanager.Get(r, silencesPath, nil, &silences); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, c := range s.cache {
+		if now.After(c.expires) {
+			delete(s.cache, k)
+		}
+	}
+	s.cache[key] = cachedSilences{silences: silences, expires: now.Add(silencesCacheTTL)}
+	return silences, nil
+}
+
+// invalidate drops the cached silences of every user.
+func (s *Silences) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache = map[string]cachedSilences{}
+}
+
+// Middleware drops the cached silences after the silence writes proxied to
+// the Alertmanager by next, so that the silence APIs list them right away.
+func (s *Silences) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		path := r.URL.Path
+		if (r.Method == http.MethodPost && strings.HasSuffix(path, silencesPath)) ||
+			(r.Method == http.MethodDelete && strings.Contains(path, silencePath)) {
+			s.invalidate()
+		}
+	})
+}
+
+// SilenceFilter selects the silences returned by the silences API.
+type SilenceFilter struct {
+	// Namespace keeps the silences with an equality matcher on the namespace.
+	Namespace string
+	States    []string
+	Creators  []string
+	// Clusters and Text are matched fuzzily, as in the frontend filters.
+	Clusters []string
+	Text     string
+	// Matchers must all be present in a silence.
+	Matchers []Matcher
+}
+
+func (f SilenceFilter) matches(s Silence) bool {
+	if f.Namespace != "" && !hasMatcher(s, Matcher{Name: "namespace", Value: f.Namespace}) {
+		return false
+	}
+	if len(f.States) > 0 && !contains(f.States, s.State()) {
+		return false
+	}
+	if len(f.Creators) > 0 && !contains(f.Creators, s.CreatedBy) {
+		return false
+	}
+	if len(f.Clusters) > 0 {
+		cluster := s.MatcherValue("cluster")
+		found := false
+		for _, c := range f.Clusters {
+			if fuzzyMatch(c, cluster) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Text != "" && !fuzzyMatch(f.Text, s.Name()) &&
+		!strings.Contains(strings.ToLower(s.Comment), strings.ToLower(f.Text)) &&
+		!strings.Contains(strings.ToLower(s.CreatedBy), strings.ToLower(f.Text)) {
+		return false
+	}
+	for _, m := range f.Matchers {
+		if !hasMatcher(s, m) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortMatchers(matchers []Matcher) {
+	slices.SortFunc(matchers, func(a, b Matcher) int { return strings.Compare(a.Name, b.Name) })
+}
+
+func hasMatcher(s Silence, want Matcher) bool {
+	for _, m := range s.Matchers {
+		if m.Name == want.Name && m.Value == want.Value && m.IsRegex == want.IsRegex && m.Equal() == want.Equal() {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzyMatch reports whether the characters of needle appear in order in
+// haystack, ignoring case, like the fuzzysearch package of the frontend.
+func fuzzyMatch(needle, haystack string) bool {
+	hay := []rune(strings.ToLower(haystack))
+	i := 0
+	for _, c := range strings.ToLower(needle) {
+		for i < len(hay) && hay[i] != c {
+			i++
+		}
+		if i == len(hay) {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// silenceKey holds the sort fields of a silence, it is also the position of
+// a page cursor so that pages stay consistent when silences are added.
+type silenceKey struct {
+	ID        string    `json:"id"`
+	State     string    `json:"state,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func keyOf(s Silence) silenceKey {
+	k := silenceKey{
+		ID:        s.ID,
+		State:     s.State(),
+		Name:      s.Name(),
+		CreatedBy: s.CreatedBy,
+		StartsAt:  s.StartsAt,
+		EndsAt:    s.EndsAt,
+	}
+	if s.UpdatedAt != nil {
+		k.UpdatedAt = *s.UpdatedAt
+	}
+	return k
+}
+
+var silenceStateRanks = map[string]int{
+	SilenceActive:  0,
+	SilencePending: 1,
+	SilenceExpired: 2,
+}
+
+var silenceCompare = map[string]func(a, b silenceKey) int{
+	"state": func(a, b silenceKey) int {
+		return silenceStateRanks[a.State] - silenceStateRanks[b.State]
+	},
+	"name":      func(a, b silenceKey) int { return strings.Compare(a.Name, b.Name) },
+	"createdBy": func(a, b silenceKey) int { return strings.Compare(a.CreatedBy, b.CreatedBy) },
+	"startsAt":  func(a, b silenceKey) int { return a.StartsAt.Compare(b.StartsAt) },
+	"endsAt":    func(a, b silenceKey) int { return a.EndsAt.Compare(b.EndsAt) },
+	"updatedAt": func(a, b silenceKey) int { return a.UpdatedAt.Compare(b.UpdatedAt) },
+}
+
+const defaultSilenceSort = "state,endsAt"
+
+// silenceOrder compares silence keys on sort fields, each optionally prefixed
+// with "-" for a descending order. Ties are broken by id.
+type silenceOrder []func(a, b silenceKey) int
+
+func parseSilenceOrder(fields []string) (silenceOrder, error) {
+	var order silenceOrder
+	for _, f := range fields {
+		compare, ok := silenceCompare[strings.TrimPrefix(f, "-")]
+		if !ok {
+			return nil, fmt.Errorf("invalid sort field %q", f)
+		}
+		if strings.HasPrefix(f, "-") {
+			asc := compare
+			compare = func(a, b silenceKey) int { return asc(b, a) }
+		}
+		order = append(order, compare)
+	}
+	return order, nil
+}
+
+func (o silenceOrder) compare(a, b silenceKey) int {
+	for _, compare := range o {
+		if c := compare(a, b); c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// silenceCursor is the opaque position of the next page.
+type silenceCursor struct {
+	Sort  string     `json:"sort"`
+	After silenceKey `json:"after"`
+}
+
+func encodeCursor(c silenceCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (silenceCursor, error) {
+	var c silenceCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
+
+// SilencesResponse is a page of silences.
+type SilencesResponse struct {
+	Data []Silence `json:"data"`
+	// Total is the number of silences matching the filters, across all pages.
+	Total int `json:"total"`
+	// NextCursor is set when more silences follow this page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// ListHandler serves the silences filtered with the namespace, state,
+// creator, cluster, text and matcher parameters. Silences are sorted with the
+// sort parameter and paginated with limit and the cursor of the previous page.
+func (s *Silences) ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseSilenceFilter(r)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		limit, err := api.IntParam(r, "limit", 0)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		sortParam := strings.Join(api.ListParam(r, "sort"), ",")
+		if sortParam == "" {
+			sortParam = defaultSilenceSort
+		}
+		order, err := parseSilenceOrder(strings.Split(sortParam, ","))
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var after *silenceKey
+		if c := r.URL.Query().Get("cursor"); c != "" {
+			cursor, err := decodeCursor(c)
+			if err != nil {
+				api.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+			if cursor.Sort != sortParam {
+				api.WriteError(w, http.StatusBadRequest, fmt.Errorf("cursor was created for sort %q", cursor.Sort))
+				return
+			}
+			after = &cursor.After
+		}
+
+		silences, err := s.list(r)
+		if err != nil {
+			log.WithError(err).Error("cannot fetch silences")
+			api.WriteError(w, http.StatusBadGateway, err)
+			return
+		}
+
+		type keyed struct {
+			silence Silence
+			key     silenceKey
+		}
+		var matched []keyed
+		for _, silence := range silences {
+			if filter.matches(silence) {
+				matched = append(matched, keyed{silence: silence, key: keyOf(silence)})
+			}
+		}
+		slices.SortStableFunc(matched, func(a, b keyed) int { return order.compare(a.key, b.key) })
+
+		resp := SilencesResponse{Data: []Silence{}, Total: len(matched)}
+		for _, m := range matched {
+			if after != nil && order.compare(m.key, *after) <= 0 {
+				continue
+			}
+			if limit > 0 && len(resp.Data) == limit {
+				last := keyOf(resp.Data[len(resp.Data)-1])
+				resp.NextCursor = encodeCursor(silenceCursor{Sort: sortParam, After: last})
+				break
+			}
+			resp.Data = append(resp.Data, m.silence)
+		}
+		api.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+func parseSilenceFilter(r *http.Request) (SilenceFilter, error) {
+	query := r.URL.Query()
+	filter := SilenceFilter{
+		Namespace: query.Get("namespace"),
+		States:    api.ListParam(r, "state"),
+		Creators:  api.ListParam(r, "creator"),
+		Clusters:  api.ListParam(r, "cluster"),
+		Text:      query.Get("text"),
+	}
+	for _, s := range query["matcher"] {
+		m, err := parseMatcher(s)
+		if err != nil {
+			return filter, err
+		}
+		filter.Matchers = append(filter.Matchers, m)
+	}
+	return filter, nil
+}
+
+// parseMatcher parses a silence matcher in the name=value form of ParseLabelMatcher.
+func parseMatcher(s string) (Matcher, error) {
+	lm, err := ParseLabelMatcher(s)
+	if err != nil {
+		return Matcher{}, err
+	}
+	op := lm.Type.String()
+	isEqual := op == "=" || op == "=~"
+	return Matcher{
+		Name:    lm.Name,
+		Value:   lm.Value,
+		IsRegex: op == "=~" || op == "!~",
+		IsEqual: &isEqual,
+	}, nil
+}
@@ -0,0 +1,108 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+func listSilences(t *testing.T, s *Silences, query url.Values) SilencesResponse {
+	rec := httptest.NewRecorder()
+	s.ListHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/silences?"+query.Encode(), nil))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var resp SilencesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestSilencesList(t *testing.T) {
+	var silences []Silence
+	for i := 0; i < 5; i++ {
+		state := SilenceActive
+		if i%2 == 1 {
+			state = SilenceExpired
+		}
+		silences = append(silences, Silence{
+			ID:        fmt.Sprintf("s%d", i),
+			Matchers:  []Matcher{{Name: "alertname", Value: fmt.Sprintf("Alert%d", i)}, {Name: "namespace", Value: "ns"}},
+			CreatedBy: fmt.Sprintf("user%d", i%2),
+			Comment:   "maintenance",
+			Status:    &SilenceStatus{State: state},
+		})
+	}
+	body, err := json.Marshal(silences)
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+	s := NewSilences(monitoring.Upstream{Kind: monitoring.AlertManagerKind, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write(body)
+	})}, monitoring.Upstream{})
+
+	resp := listSilences(t, s, url.Values{"state": {"active"}, "creator": {"user0"}})
+	require.Equal(t, 3, resp.Total)
+
+	resp = listSilences(t, s, url.Values{"text": {"alrt3"}})
+	require.Equal(t, 1, resp.Total)
+	require.Equal(t, "s3", resp.Data[0].ID)
+
+	resp = listSilences(t, s, url.Values{"matcher": {"namespace=ns"}, "namespace": {"ns"}})
+	require.Equal(t, 5, resp.Total)
+	resp = listSilences(t, s, url.Values{"matcher": {"namespace!=ns"}})
+	require.Equal(t, 0, resp.Total)
+
+	var ids []string
+	query := url.Values{"sort": {"-name"}, "limit": {"2"}}
+	for {
+		resp = listSilences(t, s, query)
+		for _, silence := range resp.Data {
+			ids = append(ids, silence.ID)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", resp.NextCursor)
+	}
+	require.Equal(t, []string{"s4", "s3", "s2", "s1", "s0"}, ids)
+
+	// Every page was served from the cached silences.
+	require.Equal(t, int32(1), calls.Load())
+}
+
+func TestSilencesMiddleware(t *testing.T) {
+	var calls atomic.Int32
+	alertmanager := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			calls.Add(1)
+			w.Write([]byte("[]"))
+		}
+	})
+	s := NewSilences(monitoring.Upstream{Kind: monitoring.AlertManagerKind, Handler: alertmanager}, monitoring.Upstream{})
+	proxy := s.Middleware(alertmanager)
+
+	listSilences(t, s, nil)
+	listSilences(t, s, nil)
+	require.Equal(t, int32(1), calls.Load())
+
+	// Reads through the proxy keep the cache.
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, silencesPath, nil))
+	listSilences(t, s, nil)
+	require.Equal(t, int32(2), calls.Load())
+
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, silencesPath, nil))
+	listSilences(t, s, nil)
+	require.Equal(t, int32(3), calls.Load())
+
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, silencePath+"s1", nil))
+	listSilences(t, s, nil)
+	require.Equal(t, int32(4), calls.Load())
+}
@@ -0,0 +1,230 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// StoreConfig selects where a list of items managed by the backend is
+// persisted: a local JSON file for testing, or a ConfigMap.
+type StoreConfig struct {
+	File string `yaml:"file,omitempty"`
+	// ConfigMap is the namespace/name of the ConfigMap, the namespace
+	// defaults to the one of the plugin.
+	ConfigMap string `yaml:"configMap,omitempty"`
+}
+
+// configMapName returns the namespace and name of the ConfigMap.
+func (c StoreConfig) configMapName(defaultName string) (string, string, error) {
+	namespace, name, found := strings.Cut(c.ConfigMap, "/")
+	if !found {
+		namespace, name = "", c.ConfigMap
+	}
+	if name == "" {
+		name = defaultName
+	}
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return "", "", fmt.Errorf("cannot find the namespace of configmap %s: %w", name, err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	return namespace, name, nil
+}
+
+// Store persists a list of items.
+type Store[T any] interface {
+	Load(ctx context.Context) ([]T, error)
+	Save(ctx context.Context, items []T) error
+	// Update saves the items returned by update from the stored ones, and
+	// retries on conflicts with concurrent writers.
+	Update(ctx context.Context, update func([]T) ([]T, error)) error
+}
+
+// NewStore returns the store selected by config, the ConfigMap defaults to
+// defaultName and keeps the items under key.
+func NewStore[T any](config StoreConfig, client dynamic.Interface, defaultName, key string) (Store[T], error) {
+	if config.File != "" {
+		return FileStore[T]{Path: config.File}, nil
+	}
+	namespace, name, err := config.configMapName(defaultName)
+	if err != nil {
+		return nil, err
+	}
+	return ConfigMapStore[T]{Client: client, Namespace: namespace, Name: name, Key: key}, nil
+}
+
+// FileStore keeps the items in a JSON file.
+type FileStore[T any] struct {
+	Path string
+}
+
+func (s FileStore[T]) Load(ctx context.Context) ([]T, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeItems[T](data)
+}
+
+func (s FileStore[T]) Save(ctx context.Context, items []T) error {
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write and rename so that readers never see a partial file.
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+func (s FileStore[T]) Update(ctx context.Context, update func([]T) ([]T, error)) error {
+	items, err := s.Load(ctx)
+	if err != nil {
+		return err
+	}
+	items, err = update(items)
+	if err != nil {
+		return err
+	}
+	return s.Save(ctx, items)
+}
+
+var configMapResource = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// ConfigMapStore keeps the items as JSON under a key of a ConfigMap, which is
+// created on the first save.
+type ConfigMapStore[T any] struct {
+	Client    dynamic.Interface
+	Namespace string
+	Name      string
+	Key       string
+}
+
+func (s ConfigMapStore[T]) Load(ctx context.Context) ([]T, error) {
+	cm, err := s.Client.Resource(configMapResource).Namespace(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot get configmap %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	data, _, err := unstructured.NestedString(cm.Object, "data", s.Key)
+	if err != nil || data == "" {
+		return nil, err
+	}
+	return decodeItems[T]([]byte(data))
+}
+
+func (s ConfigMapStore[T]) Save(ctx context.Context, items []T) error {
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	client := s.Client.Resource(configMapResource).Namespace(s.Namespace)
+	cm, err := client.Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      s.Name,
+				"namespace": s.Namespace,
+			},
+			"data": map[string]interface{}{s.Key: string(data)},
+		}}
+		_, err = client.Create(ctx, cm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("cannot get configmap %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	if err := unstructured.SetNestedField(cm.Object, string(data), "data", s.Key); err != nil {
+		return err
+	}
+	_, err = client.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+func (s ConfigMapStore[T]) Update(ctx context.Context, update func([]T) ([]T, error)) error {
+	client := s.Client.Resource(configMapResource).Namespace(s.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := client.Get(ctx, s.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			// Creating the ConfigMap fails with a conflict if another
+			// writer created it first.
+			items, err := update(nil)
+			if err != nil {
+				return err
+			}
+			err = s.Save(ctx, items)
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(configMapResource.GroupResource(), s.Name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("cannot get configmap %s/%s: %w", s.Namespace, s.Name, err)
+		}
+		var items []T
+		if data, _, _ := unstructured.NestedString(cm.Object, "data", s.Key); data != "" {
+			if items, err = decodeItems[T]([]byte(data)); err != nil {
+				return err
+			}
+		}
+		if items, err = update(items); err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(cm.Object, string(data), "data", s.Key); err != nil {
+			return err
+		}
+		// The update fails with a conflict if the ConfigMap changed since
+		// it was read.
+		_, err = client.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func decodeItems[T any](data []byte) ([]T, error) {
+	var items []T
+	if strings.TrimSpace(string(data)) == "" {
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("cannot decode stored items: %w", err)
+	}
+	return items, nil
+}
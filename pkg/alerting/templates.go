@@ -0,0 +1,142 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/common/model"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/openshift/monitoring-plugin/pkg/api"
+)
+
+const defaultTemplatesConfigMap = "monitoring-plugin-silence-templates"
+
+// TemplatesConfig configures the store of the silence templates.
+type TemplatesConfig struct {
+	Enabled     bool `yaml:"enabled,omitempty"`
+	StoreConfig `yaml:",inline"`
+	// The templates can only be changed by the users who can create silences.
+	SilenceAccessConfig `yaml:",inline"`
+}
+
+// SilenceTemplate is a reusable set of matchers with a default duration and
+// comment, used to prefill the silence form.
+type SilenceTemplate struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Matchers    []Matcher `json:"matchers"`
+	Duration    string    `json:"duration,omitempty"`
+	Comment     string    `json:"comment,omitempty"`
+}
+
+func (t SilenceTemplate) validate() error {
+	if !nameRegexp.MatchString(t.Name) {
+		return fmt.Errorf("invalid template name %q", t.Name)
+	}
+	if len(t.Matchers) == 0 {
+		return fmt.Errorf("template %s has no matchers", t.Name)
+	}
+	if t.Duration != "" {
+		if d, err := model.ParseDuration(t.Duration); err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q", t.Duration)
+		}
+	}
+	return nil
+}
+
+// Templates serves the silence templates API.
+type Templates struct {
+	store Store[SilenceTemplate]
+	// access authorizes the changes of the templates, which are shared by
+	// every user and saved with the credentials of the backend.
+	access AccessFunc
+}
+
+func NewTemplates(config TemplatesConfig, client dynamic.Interface, access AccessFunc) (*Templates, error) {
+	store, err := NewStore[SilenceTemplate](config.StoreConfig, client, defaultTemplatesConfigMap, "templates.json")
+	if err != nil {
+		return nil, err
+	}
+	return &Templates{store: store, access: access}, nil
+}
+
+// ListHandler serves every template.
+func (t *Templates) ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		templates, err := t.store.Load(r.Context())
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if templates == nil {
+			templates = []SilenceTemplate{}
+		}
+		api.WriteJSON(w, http.StatusOK, templates)
+	}
+}
+
+// PutHandler creates or replaces the template named in the path.
+func (t *Templates) PutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := t.access(r); err != nil {
+			api.WriteKubeError(w, err)
+			return
+		}
+		var template SilenceTemplate
+		if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid template: %w", err))
+			return
+		}
+		template.Name = mux.Vars(r)["name"]
+		if err := template.validate(); err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		err := t.store.Update(r.Context(), func(templates []SilenceTemplate) ([]SilenceTemplate, error) {
+			if i := slices.IndexFunc(templates, func(e SilenceTemplate) bool { return e.Name == template.Name }); i >= 0 {
+				templates[i] = template
+				return templates, nil
+			}
+			return append(templates, template), nil
+		})
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, template)
+	}
+}
+
+// DeleteHandler removes the template named in the path.
+func (t *Templates) DeleteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := t.access(r); err != nil {
+			api.WriteKubeError(w, err)
+			return
+		}
+		name := mux.Vars(r)["name"]
+
+		found := false
+		err := t.store.Update(r.Context(), func(templates []SilenceTemplate) ([]SilenceTemplate, error) {
+			i := slices.IndexFunc(templates, func(e SilenceTemplate) bool { return e.Name == name })
+			if found = i >= 0; !found {
+				return templates, nil
+			}
+			return slices.Delete(templates, i, i+1), nil
+		})
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !found {
+			api.WriteError(w, http.StatusNotFound, fmt.Errorf("template %s not found", name))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
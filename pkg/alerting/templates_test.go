@@ -0,0 +1,51 @@
+package alerting
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestTemplateHandlersAccess(t *testing.T) {
+	store := FileStore[SilenceTemplate]{Path: filepath.Join(t.TempDir(), "templates.json")}
+	access := func(r *http.Request) (string, error) {
+		if r.Header.Get("Authorization") != "Bearer ops" {
+			return "", apierrors.NewForbidden(schema.GroupResource{Resource: "alertmanagers/api"}, "main", errors.New("the user cannot create silences"))
+		}
+		return "ops-user", nil
+	}
+	templates := &Templates{store: store, access: access}
+
+	request := func(handler http.Handler, method, token string) *httptest.ResponseRecorder {
+		body := `{"matchers": [{"name": "namespace", "value": "batch"}], "duration": "2h"}`
+		req := mux.SetURLVars(httptest.NewRequest(method, "/api/v1/silence-templates/batch", strings.NewReader(body)), map[string]string{"name": "batch"})
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusForbidden, request(templates.PutHandler(), http.MethodPut, "viewer").Code)
+	saved, err := store.Load(t.Context())
+	require.NoError(t, err)
+	require.Empty(t, saved)
+
+	rec := request(templates.PutHandler(), http.MethodPut, "ops")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	saved, err = store.Load(t.Context())
+	require.NoError(t, err)
+	require.Len(t, saved, 1)
+	require.Equal(t, "batch", saved[0].Name)
+
+	require.Equal(t, http.StatusForbidden, request(templates.DeleteHandler(), http.MethodDelete, "viewer").Code)
+	require.Equal(t, http.StatusNoContent, request(templates.DeleteHandler(), http.MethodDelete, "ops").Code)
+	require.Equal(t, http.StatusNotFound, request(templates.DeleteHandler(), http.MethodDelete, "ops").Code)
+}
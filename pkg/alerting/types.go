@@ -0,0 +1,163 @@
+package alerting
+
+import (
+	"strings"
+	"time"
+)
+
+// Alert states, as reported by Prometheus with the addition of "silenced" for
+// firing alerts matched by an active silence.
+const (
+	StateFiring   = "firing"
+	StatePending  = "pending"
+	StateSilenced = "silenced"
+)
+
+// Silence states, as reported by Alertmanager.
+const (
+	SilenceActive  = "active"
+	SilencePending = "pending"
+	SilenceExpired = "expired"
+)
+
+// Alert sources, matching the Platform and User sources of the frontend.
+const (
+	SourcePlatform = "platform"
+	SourceUser     = "user"
+)
+
+// platformPrometheus is the value of the prometheus label of the rules
+// evaluated by the platform monitoring stack.
+const platformPrometheus = "openshift-monitoring/k8s"
+
+// RuleGroup is a group of the Prometheus /api/v1/rules response.
+type RuleGroup struct {
+	Name     string  `json:"name"`
+	File     string  `json:"file"`
+	Interval float64 `json:"interval,omitempty"`
+	Rules    []Rule  `json:"rules"`
+}
+
+// Rule is an alerting rule of the Prometheus /api/v1/rules response.
+type Rule struct {
+	Name        string            `json:"name"`
+	Query       string            `json:"query"`
+	Duration    float64           `json:"duration"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Alerts      []RuleAlert       `json:"alerts,omitempty"`
+	Health      string            `json:"health,omitempty"`
+	LastError   string            `json:"lastError,omitempty"`
+	State       string            `json:"state,omitempty"`
+	Type        string            `json:"type,omitempty"`
+}
+
+// RuleAlert is an active alert of a rule.
+type RuleAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	State       string            `json:"state"`
+	ActiveAt    *time.Time        `json:"activeAt,omitempty"`
+	Value       string            `json:"value,omitempty"`
+}
+
+// Matcher is a label matcher of an Alertmanager silence.
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	// IsEqual defaults to true when omitted, as in the Alertmanager API.
+	IsEqual *bool `json:"isEqual,omitempty"`
+}
+
+// Equal reports whether the matcher is an equality (= or =~) matcher.
+func (m Matcher) Equal() bool {
+	return m.IsEqual == nil || *m.IsEqual
+}
+
+// String formats the matcher as in the silences list of the frontend.
+func (m Matcher) String() string {
+	op := "="
+	switch {
+	case m.IsRegex && m.Equal():
+		op = "=~"
+	case m.IsRegex:
+		op = "!~"
+	case !m.Equal():
+		op = "!="
+	}
+	return m.Name + op + m.Value
+}
+
+// SilenceStatus is the status of an Alertmanager silence.
+type SilenceStatus struct {
+	State string `json:"state"`
+}
+
+// Silence is an Alertmanager silence.
+type Silence struct {
+	ID        string         `json:"id,omitempty"`
+	Matchers  []Matcher      `json:"matchers"`
+	StartsAt  time.Time      `json:"startsAt"`
+	EndsAt    time.Time      `json:"endsAt"`
+	CreatedBy string         `json:"createdBy"`
+	Comment   string         `json:"comment"`
+	UpdatedAt *time.Time     `json:"updatedAt,omitempty"`
+	Status    *SilenceStatus `json:"status,omitempty"`
+}
+
+// State returns the state of the silence, or an empty string for silences
+// which were not read from Alertmanager.
+func (s Silence) State() string {
+	if s.Status == nil {
+		return ""
+	}
+	return s.Status.State
+}
+
+// Name returns the display name of the silence: the value of its alertname
+// matcher, or its matchers without one.
+func (s Silence) Name() string {
+	for _, m := range s.Matchers {
+		if m.Name == "alertname" && m.Value != "" {
+			return m.Value
+		}
+	}
+	matchers := make([]string, 0, len(s.Matchers))
+	for _, m := range s.Matchers {
+		matchers = append(matchers, m.String())
+	}
+	return strings.Join(matchers, ", ")
+}
+
+// MatcherValue returns the value of the first matcher on the label name.
+func (s Silence) MatcherValue(name string) string {
+	for _, m := range s.Matchers {
+		if m.Name == name {
+			return m.Value
+		}
+	}
+	return ""
+}
+
+// RuleSummary identifies the rule of an alert.
+type RuleSummary struct {
+	Name     string            `json:"name"`
+	Group    string            `json:"group"`
+	File     string            `json:"file,omitempty"`
+	Query    string            `json:"query"`
+	Duration float64           `json:"duration"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// Alert is an alert joined with its rule and silences.
+type Alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	State       string            `json:"state"`
+	ActiveAt    *time.Time        `json:"activeAt,omitempty"`
+	Value       string            `json:"value,omitempty"`
+	Source      string            `json:"source"`
+	Rule        RuleSummary       `json:"rule"`
+	SilencedBy  []Silence         `json:"silencedBy,omitempty"`
+}
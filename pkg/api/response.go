@@ -0,0 +1,85 @@
+// Package api holds the helpers shared by the JSON APIs of the backend.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+var log = logrus.WithField("module", "api")
+
+// Error is the body of the error responses.
+type Error struct {
+	Error string `json:"error"`
+}
+
+// WriteJSON writes v as the JSON body of a response with the given status.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.WithError(err).Error("cannot marshal response")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// WriteError writes err as a JSON error response.
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, Error{Error: err.Error()})
+}
+
+// WriteKubeError writes an error of the Kubernetes API server with its status
+// code, or as a bad gateway for the errors without status.
+func WriteKubeError(w http.ResponseWriter, err error) {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) && status.Status().Code != 0 {
+		WriteError(w, int(status.Status().Code), err)
+		return
+	}
+	WriteError(w, http.StatusBadGateway, err)
+}
+
+// IntParam returns the integer query parameter name of r, or def when unset.
+func IntParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, &ParamError{Name: name, Value: v}
+	}
+	return i, nil
+}
+
+// ParamError is returned for invalid query parameters.
+type ParamError struct {
+	Name  string
+	Value string
+}
+
+func (e *ParamError) Error() string {
+	return "invalid " + e.Name + " parameter " + strconv.Quote(e.Value)
+}
+
+// ListParam returns the values of a repeated or comma separated query parameter.
+func ListParam(r *http.Request, name string) []string {
+	var values []string
+	for _, v := range r.URL.Query()[name] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,264 @@
+package dashboards
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// BundleVersion is the version of the bundle format, bundles of other
+// versions are rejected on import.
+const BundleVersion = 1
+
+const (
+	bundleManifestFile = "manifest.json"
+	// maxBundleFiles bounds the entries read from a tarball.
+	maxBundleFiles = 1000
+	// maxBundleSize bounds the decompressed size of a tarball.
+	maxBundleSize = 64 << 20
+)
+
+// BundleKind is the kind of the dashboards of a bundle.
+type BundleKind string
+
+const (
+	LegacyKind BundleKind = "legacy"
+	PersesKind BundleKind = "perses"
+)
+
+// BundleEntry identifies a dashboard of a bundle: the key of a legacy
+// dashboard ConfigMap, or a Perses dashboard of a project.
+type BundleEntry struct {
+	Kind BundleKind `json:"kind" yaml:"kind"`
+	// Namespace is the namespace of the ConfigMap, or the Perses project.
+	Namespace string `json:"namespace" yaml:"namespace"`
+	// Name is the name of the ConfigMap, or of the Perses dashboard.
+	Name string `json:"name" yaml:"name"`
+	Key  string `json:"key,omitempty" yaml:"key,omitempty"`
+	// SHA256 is the hex checksum of the dashboard definition.
+	SHA256 string `json:"sha256" yaml:"sha256"`
+	// Path is the file of the definition in a tarball.
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+func (e BundleEntry) String() string {
+	if e.Kind == LegacyKind {
+		return fmt.Sprintf("%s %s/%s/%s", e.Kind, e.Namespace, e.Name, e.Key)
+	}
+	return fmt.Sprintf("%s %s/%s", e.Kind, e.Namespace, e.Name)
+}
+
+// BundleDashboard is a dashboard of a bundle with its definition, the JSON of
+// the legacy dashboard or of the Perses dashboard.
+type BundleDashboard struct {
+	BundleEntry `yaml:",inline"`
+	Data        string `json:"data" yaml:"data"`
+}
+
+// BundleManifest describes the content of a tarball, and is the first
+// document of a YAML bundle without the dashboards.
+type BundleManifest struct {
+	Version    int           `json:"version" yaml:"version"`
+	Created    time.Time     `json:"created" yaml:"created"`
+	Dashboards []BundleEntry `json:"dashboards,omitempty" yaml:"dashboards,omitempty"`
+}
+
+type Bundle struct {
+	Version    int
+	Created    time.Time
+	Dashboards []BundleDashboard
+}
+
+func checksum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// newBundleDashboard returns the dashboard with the checksum of its
+// definition.
+func newBundleDashboard(entry BundleEntry, data string) BundleDashboard {
+	entry.SHA256 = checksum(data)
+	return BundleDashboard{BundleEntry: entry, Data: data}
+}
+
+// verify checks the version of the bundle and the checksums of the
+// dashboards.
+func (b *Bundle) verify() error {
+	if b.Version != BundleVersion {
+		return fmt.Errorf("unsupported bundle version %d, expected %d", b.Version, BundleVersion)
+	}
+	for _, d := range b.Dashboards {
+		if d.Kind != LegacyKind && d.Kind != PersesKind {
+			return fmt.Errorf("%s: unknown kind %q", d.BundleEntry, d.Kind)
+		}
+		if d.Namespace == "" || d.Name == "" || (d.Kind == LegacyKind && d.Key == "") {
+			return fmt.Errorf("%s: namespace, name and the key of legacy dashboards are required", d.BundleEntry)
+		}
+		if sum := checksum(d.Data); d.SHA256 != sum {
+			return fmt.Errorf("%s: checksum mismatch, expected %s but got %s", d.BundleEntry, d.SHA256, sum)
+		}
+	}
+	return nil
+}
+
+// WriteTarball writes the bundle as a gzipped tarball holding the manifest and
+// a file per dashboard.
+func (b *Bundle) WriteTarball(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest := BundleManifest{Version: b.Version, Created: b.Created}
+	files := make([]BundleDashboard, 0, len(b.Dashboards))
+	for _, d := range b.Dashboards {
+		if d.Kind == LegacyKind {
+			d.Path = path.Join(string(d.Kind), d.Namespace, d.Name, d.Key)
+		} else {
+			d.Path = path.Join(string(d.Kind), d.Namespace, d.Name+".json")
+		}
+		manifest.Dashboards = append(manifest.Dashboards, d.BundleEntry)
+		files = append(files, d)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, bundleManifestFile, data, b.Created); err != nil {
+		return err
+	}
+	for _, d := range files {
+		if err := writeTarFile(tw, d.Path, []byte(d.Data), b.Created); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// WriteYAML writes the bundle as a multi-document YAML stream: the manifest
+// without the dashboards, then a document per dashboard.
+func (b *Bundle) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	if err := enc.Encode(BundleManifest{Version: b.Version, Created: b.Created}); err != nil {
+		return err
+	}
+	for _, d := range b.Dashboards {
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// ReadBundle reads and verifies a bundle, either a gzipped tarball or a YAML
+// stream.
+func ReadBundle(data []byte) (*Bundle, error) {
+	var b *Bundle
+	var err error
+	if len(data) > 1 && data[0] == 0x1f && data[1] == 0x8b {
+		b, err = readTarball(data)
+	} else {
+		b, err = readYAML(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := b.verify(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func readTarball(data []byte) (*Bundle, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tarball: %w", err)
+	}
+	// The limit is shared by the headers and files of the tarball.
+	limited := &io.LimitedReader{R: gz, N: maxBundleSize + 1}
+	tr := tar.NewReader(limited)
+	files := map[string]string{}
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if limited.N == 0 {
+			return nil, fmt.Errorf("invalid tarball: more than %d bytes decompressed", maxBundleSize)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid tarball: %w", err)
+		}
+		if i == maxBundleFiles {
+			return nil, fmt.Errorf("invalid tarball: more than %d files", maxBundleFiles)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if limited.N == 0 {
+			return nil, fmt.Errorf("invalid tarball: more than %d bytes decompressed", maxBundleSize)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid tarball: %w", err)
+		}
+		files[path.Clean(hdr.Name)] = string(content)
+	}
+
+	manifestData, ok := files[bundleManifestFile]
+	if !ok {
+		return nil, fmt.Errorf("invalid tarball: no %s", bundleManifestFile)
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal([]byte(manifestData), &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", bundleManifestFile, err)
+	}
+	b := &Bundle{Version: manifest.Version, Created: manifest.Created}
+	for _, entry := range manifest.Dashboards {
+		content, ok := files[path.Clean(entry.Path)]
+		if !ok {
+			return nil, fmt.Errorf("%s: file %q not found", entry, entry.Path)
+		}
+		b.Dashboards = append(b.Dashboards, BundleDashboard{BundleEntry: entry, Data: content})
+	}
+	return b, nil
+}
+
+func readYAML(data []byte) (*Bundle, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var manifest BundleManifest
+	if err := dec.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	b := &Bundle{Version: manifest.Version, Created: manifest.Created}
+	for {
+		var d BundleDashboard
+		err := dec.Decode(&d)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: dashboard %d: %w", len(b.Dashboards), err)
+		}
+		b.Dashboards = append(b.Dashboards, d)
+	}
+	return b, nil
+}
@@ -0,0 +1,488 @@
+package dashboards
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/openshift/monitoring-plugin/pkg/api"
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+const (
+	defaultDuration    = "30m"
+	defaultPanelHeight = 8
+	// defaultSpan is the width of the legacy panels without span, out of 12.
+	defaultSpan = 12
+)
+
+var (
+	labelValuesQuery = regexp.MustCompile(`^label_values\(\s*(?:(.+?)\s*,\s*)?([a-zA-Z_][a-zA-Z0-9_]*)\s*\)$`)
+	labelNamesQuery  = regexp.MustCompile(`^label_names\(\s*(.*?)\s*\)$`)
+	queryResultQuery = regexp.MustCompile(`^query_result\(\s*(.+)\s*\)$`)
+	invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+)
+
+// grafanaUnits maps the Grafana units to the Perses ones. The other units are
+// reported and shown as decimal numbers.
+var grafanaUnits = map[string]string{
+	"":            "decimal",
+	"none":        "decimal",
+	"short":       "decimal",
+	"percent":     "percent",
+	"percentunit": "percent-decimal",
+	"bytes":       "bytes",
+	"decbytes":    "bytes",
+	"Bps":         "bytes/sec",
+	"binBps":      "bytes/sec",
+	"bps":         "bits/sec",
+	"ms":          "milliseconds",
+	"s":           "seconds",
+	"dtdurations": "seconds",
+	"m":           "minutes",
+	"h":           "hours",
+	"d":           "days",
+	"ops":         "ops/sec",
+	"reqps":       "requests/sec",
+	"rps":         "reads/sec",
+	"wps":         "writes/sec",
+}
+
+// UnsupportedPanel is a legacy panel left out of a converted dashboard.
+type UnsupportedPanel struct {
+	Row    string `json:"row,omitempty"`
+	Title  string `json:"title"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// Conversion is a Perses dashboard converted from a legacy one, with the
+// parts of the legacy dashboard which could not be converted as is.
+type Conversion struct {
+	Dashboard   PersesDashboard    `json:"dashboard"`
+	Unsupported []UnsupportedPanel `json:"unsupported,omitempty"`
+	Warnings    []string           `json:"warnings,omitempty"`
+}
+
+func (c *Conversion) warnf(format string, args ...interface{}) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Convert converts a legacy dashboard to a Perses dashboard of the project.
+// The dashboard is named after name, or the uid or title of the board.
+func Convert(name, project string, board Board) Conversion {
+	c := Conversion{
+		Dashboard: PersesDashboard{
+			Kind:     persesDashboardKind,
+			Metadata: PersesMetadata{Name: dashboardName(name, board), Project: project},
+			Spec: DashboardSpec{
+				Duration: boardDuration(board),
+				Panels:   map[string]PersesPanel{},
+				Layouts:  []PersesLayout{},
+			},
+		},
+	}
+	if board.Title != "" {
+		c.Dashboard.Spec.Display = &Display{Name: board.Title}
+	}
+
+	for _, v := range board.Templating.List {
+		if variable, ok := c.convertVariable(v); ok {
+			c.Dashboard.Spec.Variables = append(c.Dashboard.Spec.Variables, variable)
+		}
+	}
+	for i, row := range board.PanelRows() {
+		c.convertRow(i, row)
+	}
+	return c
+}
+
+func dashboardName(name string, board Board) string {
+	for _, candidate := range []string{name, board.UID, board.Title} {
+		slug := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(candidate), "-"), "-.")
+		if len(slug) > 75 {
+			slug = slug[:75]
+		}
+		if slug != "" {
+			return slug
+		}
+	}
+	return "dashboard"
+}
+
+// boardDuration converts the relative time range of the board, the legacy
+// dashboards page defaults to the last 30 minutes.
+func boardDuration(board Board) string {
+	if board.Time == nil || !strings.HasPrefix(board.Time.From, "now-") {
+		return defaultDuration
+	}
+	d, err := model.ParseDuration(strings.TrimPrefix(board.Time.From, "now-"))
+	if err != nil || d <= 0 {
+		return defaultDuration
+	}
+	return d.String()
+}
+
+func (c *Conversion) convertVariable(v TemplateVariable) (PersesVariable, bool) {
+	spec := PersesVariableSpec{
+		Name: v.Name,
+		Display: &Display{
+			Name:        v.Label,
+			Description: v.Description,
+			Hidden:      v.Hide != 0,
+		},
+	}
+	if spec.Display.Name == "" {
+		spec.Display.Name = v.Name
+	}
+	query := strings.TrimSpace(string(v.Query))
+
+	switch v.Type {
+	case "constant", "textbox":
+		spec.Value = query
+		spec.Constant = v.Type == "constant"
+		return PersesVariable{Kind: "TextVariable", Spec: spec}, true
+	case "query":
+		plugin, ok := c.queryVariablePlugin(v, query)
+		if !ok {
+			return PersesVariable{}, false
+		}
+		spec.Plugin = plugin
+		spec.CapturingRegexp = strings.Trim(v.Regex, "/")
+	case "custom", "interval":
+		values := []string{}
+		for _, o := range v.Options {
+			values = append(values, o.Value)
+		}
+		if len(values) == 0 {
+			for _, value := range strings.Split(query, ",") {
+				if value = strings.TrimSpace(value); value != "" {
+					values = append(values, value)
+				}
+			}
+		}
+		spec.Plugin = &Plugin{Kind: "StaticListVariable", Spec: map[string]interface{}{"values": values}}
+	default:
+		c.warnf("variable %q: type %q is not supported", v.Name, v.Type)
+		return PersesVariable{}, false
+	}
+
+	spec.AllowAllValue = v.IncludeAll
+	spec.AllowMultiple = v.Multi
+	if v.IncludeAll && v.AllValue != "" && v.AllValue != ".*" {
+		spec.CustomAllValue = v.AllValue
+	}
+	var selected []string
+	for _, o := range v.Options {
+		if o.Selected && o.Value != "$__all" {
+			selected = append(selected, o.Value)
+		}
+	}
+	switch {
+	case len(selected) == 1 && !v.Multi:
+		spec.DefaultValue = selected[0]
+	case len(selected) > 0 && v.Multi:
+		spec.DefaultValue = selected
+	}
+	return PersesVariable{Kind: "ListVariable", Spec: spec}, true
+}
+
+func (c *Conversion) queryVariablePlugin(v TemplateVariable, query string) (*Plugin, bool) {
+	spec := map[string]interface{}{}
+	if v.Datasource != nil && v.Datasource.Name != "" && !strings.HasPrefix(v.Datasource.Name, "$") {
+		spec["datasource"] = map[string]string{"kind": "PrometheusDatasource", "name": v.Datasource.Name}
+	}
+	if m := labelValuesQuery.FindStringSubmatch(query); m != nil {
+		spec["labelName"] = m[2]
+		if m[1] != "" {
+			spec["matchers"] = []string{m[1]}
+		}
+		return &Plugin{Kind: "PrometheusLabelValuesVariable", Spec: spec}, true
+	}
+	if m := labelNamesQuery.FindStringSubmatch(query); m != nil {
+		if m[1] != "" {
+			spec["matchers"] = []string{m[1]}
+		}
+		return &Plugin{Kind: "PrometheusLabelNamesVariable", Spec: spec}, true
+	}
+	if m := queryResultQuery.FindStringSubmatch(query); m != nil {
+		spec["expr"] = m[1]
+		spec["labelName"] = "__name__"
+		c.warnf("variable %q: query_result is converted to the metric names of the query, check the capturing regexp", v.Name)
+		return &Plugin{Kind: "PrometheusPromQLVariable", Spec: spec}, true
+	}
+	c.warnf("variable %q: query %q is not supported", v.Name, query)
+	return nil, false
+}
+
+// convertRow adds the panels of a row in a grid layout. The panels with a
+// grid position keep it, the others are laid out left to right from their
+// span, as on the legacy dashboards page.
+func (c *Conversion) convertRow(index int, row Row) {
+	grid := GridLayout{Items: []GridItem{}}
+	if row.Title != "" && row.ShowTitle {
+		grid.Display = &GridDisplay{Title: row.Title, Collapse: &GridCollapse{Open: !row.Collapse}}
+	}
+
+	top := -1
+	for _, p := range row.Panels {
+		if p.GridPos != nil && (top < 0 || p.GridPos.Y < top) {
+			top = p.GridPos.Y
+		}
+	}
+	x, y, lineHeight := 0, 0, 0
+	for i, p := range row.Panels {
+		panel, ok := c.convertPanel(row, p)
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%d_%d", index, i)
+		c.Dashboard.Spec.Panels[key] = panel
+
+		item := GridItem{Content: map[string]string{"$ref": "#/spec/panels/" + key}}
+		if p.GridPos != nil {
+			item.X, item.Y, item.Width, item.Height = p.GridPos.X, p.GridPos.Y-top, p.GridPos.W, p.GridPos.H
+		} else {
+			item.Width = panelSpan(p) * persesGridColumns / 12
+			item.Height = defaultPanelHeight
+			if x+item.Width > persesGridColumns {
+				x, y, lineHeight = 0, y+lineHeight, 0
+			}
+			item.X, item.Y = x, y
+			x += item.Width
+			lineHeight = max(lineHeight, item.Height)
+		}
+		grid.Items = append(grid.Items, item)
+	}
+	c.Dashboard.Spec.Layouts = append(c.Dashboard.Spec.Layouts, PersesLayout{Kind: persesGridKind, Spec: grid})
+}
+
+// panelSpan returns the width of a legacy panel out of 12 columns, from its
+// span or breakpoint percentage.
+func panelSpan(p Panel) int {
+	span := p.Span
+	if span <= 0 {
+		var percent int
+		if _, err := fmt.Sscanf(strings.TrimSuffix(p.Breakpoint, "%"), "%d", &percent); err == nil && percent > 0 {
+			span = (12*percent + 50) / 100
+		}
+	}
+	if span <= 0 || span > 12 {
+		span = defaultSpan
+	}
+	return span
+}
+
+func (c *Conversion) convertPanel(row Row, p Panel) (PersesPanel, bool) {
+	panel := PersesPanel{
+		Kind: "Panel",
+		Spec: PersesPanelSpec{Display: Display{Name: p.Title, Description: p.Description}},
+	}
+	format := c.panelFormat(p)
+
+	switch p.Type {
+	case "graph", "timeseries":
+		spec := map[string]interface{}{"yAxis": map[string]interface{}{"format": format}}
+		if p.Legend == nil || p.Legend.Show {
+			spec["legend"] = map[string]string{"position": "bottom"}
+		}
+		if p.Stack {
+			spec["visual"] = map[string]string{"stack": "all"}
+		}
+		panel.Spec.Plugin = Plugin{Kind: "TimeSeriesChart", Spec: spec}
+	case "singlestat", "stat":
+		panel.Spec.Plugin = Plugin{Kind: "StatChart", Spec: map[string]interface{}{"calculation": "last-number", "format": format}}
+	case "gauge":
+		panel.Spec.Plugin = Plugin{Kind: "GaugeChart", Spec: map[string]interface{}{"calculation": "last-number", "format": format}}
+	case "bargauge":
+		panel.Spec.Plugin = Plugin{Kind: "BarChart", Spec: map[string]interface{}{"calculation": "last-number", "format": format}}
+	case "grafana-piechart-panel", "piechart":
+		panel.Spec.Plugin = Plugin{Kind: "PieChart", Spec: map[string]interface{}{"calculation": "last-number", "radius": 50}}
+	case "table":
+		panel.Spec.Plugin = Plugin{Kind: "Table", Spec: map[string]interface{}{}}
+	case "text":
+		content := p.Content
+		var options struct {
+			Content string `json:"content"`
+		}
+		if content == "" && json.Unmarshal(p.Options, &options) == nil {
+			content = options.Content
+		}
+		panel.Spec.Plugin = Plugin{Kind: "Markdown", Spec: map[string]interface{}{"text": content}}
+	default:
+		c.Unsupported = append(c.Unsupported, UnsupportedPanel{
+			Row:    row.Title,
+			Title:  p.Title,
+			Type:   p.Type,
+			Reason: fmt.Sprintf("panel type %q has no Perses equivalent", p.Type),
+		})
+		return PersesPanel{}, false
+	}
+
+	for _, t := range p.Targets {
+		if strings.TrimSpace(t.Expr) == "" {
+			continue
+		}
+		spec := map[string]interface{}{"query": t.Expr}
+		if t.LegendFormat != "" {
+			spec["seriesNameFormat"] = t.LegendFormat
+		}
+		if ds := p.Datasource; ds != nil && ds.Name != "" {
+			if strings.HasPrefix(ds.Name, "$") {
+				c.warnf("panel %q: datasource variable %q is not supported, the default datasource is used", p.Title, ds.Name)
+			} else {
+				spec["datasource"] = map[string]string{"kind": "PrometheusDatasource", "name": ds.Name}
+			}
+		}
+		query := PersesQuery{Kind: "TimeSeriesQuery"}
+		query.Spec.Plugin = Plugin{Kind: "PrometheusTimeSeriesQuery", Spec: spec}
+		panel.Spec.Queries = append(panel.Spec.Queries, query)
+	}
+	return panel, true
+}
+
+func (c *Conversion) panelFormat(p Panel) map[string]interface{} {
+	unit, ok := grafanaUnits[p.unit()]
+	if !ok {
+		c.warnf("panel %q: unit %q is not supported, the values are shown as decimal numbers", p.Title, p.unit())
+		unit = "decimal"
+	}
+	format := map[string]interface{}{"unit": unit}
+	if d := p.decimals(); d != nil {
+		format["decimalPlaces"] = *d
+	}
+	return format
+}
+
+// maxRequestSize bounds the bodies of the dashboards APIs.
+const maxRequestSize = 16 << 20
+
+// LegacyDashboard is a legacy dashboard definition to convert. Data is the
+// dashboard JSON, either as an object or as the string stored in a ConfigMap.
+type LegacyDashboard struct {
+	Name string          `json:"name"`
+	Data json.RawMessage `json:"data"`
+}
+
+func (d LegacyDashboard) board() (Board, error) {
+	data := []byte(d.Data)
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		data = []byte(s)
+	}
+	return ParseBoard(data)
+}
+
+// ConvertRequest converts legacy dashboards, and applies them to a Perses
+// project when Apply is set.
+type ConvertRequest struct {
+	Project string `json:"project,omitempty"`
+	Apply   bool   `json:"apply,omitempty"`
+	// Overwrite replaces the Perses dashboards of the same name when applying,
+	// they are reported as errors otherwise.
+	Overwrite  bool              `json:"overwrite,omitempty"`
+	Dashboards []LegacyDashboard `json:"dashboards"`
+}
+
+// ConvertResult is the conversion of one of the dashboards of a request.
+type ConvertResult struct {
+	Name string `json:"name"`
+	*Conversion
+	// Applied is "created" or "updated" for the dashboards written to Perses.
+	Applied string `json:"applied,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type ConvertResponse struct {
+	Results []ConvertResult `json:"results"`
+}
+
+// Converter serves the conversion of legacy dashboards to Perses dashboards.
+type Converter struct {
+	// perses is nil unless the Perses proxy is configured, the dashboards are
+	// only converted then.
+	perses *monitoring.Upstream
+}
+
+func NewConverter(perses *monitoring.Upstream) *Converter {
+	return &Converter{perses: perses}
+}
+
+// ConvertHandler converts the dashboards of a ConvertRequest. The errors of
+// the individual dashboards are reported in their result.
+func (c *Converter) ConvertHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ConvertRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		if len(req.Dashboards) == 0 {
+			api.WriteError(w, http.StatusBadRequest, errors.New("no dashboards to convert"))
+			return
+		}
+		if req.Apply {
+			if c.perses == nil {
+				api.WriteError(w, http.StatusBadRequest, errors.New("cannot apply dashboards, the perses proxy is not configured"))
+				return
+			}
+			if req.Project == "" {
+				api.WriteError(w, http.StatusBadRequest, errors.New("project is required to apply dashboards"))
+				return
+			}
+		}
+
+		resp := ConvertResponse{Results: make([]ConvertResult, 0, len(req.Dashboards))}
+		for _, d := range req.Dashboards {
+			result := ConvertResult{Name: d.Name}
+			board, err := d.board()
+			if err != nil {
+				result.Error = fmt.Sprintf("invalid dashboard: %v", err)
+				resp.Results = append(resp.Results, result)
+				continue
+			}
+			conversion := Convert(d.Name, req.Project, board)
+			result.Conversion = &conversion
+			if req.Apply {
+				result.Applied, err = c.apply(r, conversion.Dashboard, req.Overwrite)
+				if err != nil {
+					log.WithError(err).Warnf("cannot apply dashboard %s to project %s", conversion.Dashboard.Metadata.Name, req.Project)
+					result.Error = err.Error()
+				}
+			}
+			resp.Results = append(resp.Results, result)
+		}
+		api.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+// apply creates the dashboard through the Perses proxy on behalf of the user,
+// replacing the existing one when overwrite is set.
+func (c *Converter) apply(r *http.Request, dashboard PersesDashboard, overwrite bool) (string, error) {
+	req, err := c.perses.NewRequest(r, http.MethodPost, persesDashboardPath(dashboard.Metadata.Project, ""), nil, dashboard)
+	if err != nil {
+		return "", err
+	}
+	err = c.perses.Do(req, nil)
+	var upstreamErr *monitoring.UpstreamError
+	if err == nil {
+		return "created", nil
+	}
+	if !overwrite || !errors.As(err, &upstreamErr) || upstreamErr.Status != http.StatusConflict {
+		return "", err
+	}
+
+	req, err = c.perses.NewRequest(r, http.MethodPut, persesDashboardPath(dashboard.Metadata.Project, dashboard.Metadata.Name), nil, dashboard)
+	if err != nil {
+		return "", err
+	}
+	if err := c.perses.Do(req, nil); err != nil {
+		return "", err
+	}
+	return "updated", nil
+}
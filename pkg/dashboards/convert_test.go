@@ -0,0 +1,144 @@
+package dashboards
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+const legacyBoard = `{
+  "title": "etcd",
+  "time": {"from": "now-1h", "to": "now"},
+  "templating": {"list": [
+    {"name": "instance", "type": "query", "query": "label_values(etcd_server_has_leader, instance)", "includeAll": true, "hide": 0,
+     "options": [{"selected": true, "value": "10.0.0.1:2379"}]},
+    {"name": "interval", "type": "interval", "query": "1m,5m", "hide": 2},
+    {"name": "datasource", "type": "datasource", "query": "prometheus"}
+  ]},
+  "rows": [
+    {"title": "Overview", "showTitle": true, "panels": [
+      {"type": "singlestat", "title": "Up", "span": 3, "format": "none", "targets": [{"expr": "sum(up{job=\"etcd\"})"}]},
+      {"type": "graph", "title": "RPC rate", "span": 9, "stack": true, "yaxes": [{"format": "ops"}],
+       "targets": [{"expr": "sum(rate(grpc_server_started_total{instance=~\"$instance\"}[$__rate_interval]))", "legendFormat": "{{instance}}"}]},
+      {"type": "heatmap", "title": "Latency", "span": 6}
+    ]},
+    {"title": "Disk", "panels": [
+      {"type": "table", "title": "Sizes", "format": "bytes", "targets": [{"expr": "etcd_mvcc_db_total_size_in_bytes"}]}
+    ]}
+  ]
+}`
+
+func TestConvert(t *testing.T) {
+	board, err := ParseBoard([]byte(legacyBoard))
+	require.NoError(t, err)
+	c := Convert("grafana-dashboard-etcd", "openshift-config-managed", board)
+
+	d := c.Dashboard
+	require.Equal(t, "grafana-dashboard-etcd", d.Metadata.Name)
+	require.Equal(t, "openshift-config-managed", d.Metadata.Project)
+	require.Equal(t, "etcd", d.Spec.Display.Name)
+	require.Equal(t, "1h", d.Spec.Duration)
+
+	require.Len(t, d.Spec.Variables, 2)
+	instance := d.Spec.Variables[0].Spec
+	require.Equal(t, "PrometheusLabelValuesVariable", instance.Plugin.Kind)
+	require.Equal(t, "instance", instance.Plugin.Spec["labelName"])
+	require.Equal(t, []string{"etcd_server_has_leader"}, instance.Plugin.Spec["matchers"])
+	require.True(t, instance.AllowAllValue)
+	require.Equal(t, "10.0.0.1:2379", instance.DefaultValue)
+	interval := d.Spec.Variables[1].Spec
+	require.Equal(t, []string{"1m", "5m"}, interval.Plugin.Spec["values"])
+	require.True(t, interval.Display.Hidden)
+	require.Equal(t, []string{`variable "datasource": type "datasource" is not supported`}, c.Warnings)
+
+	require.Equal(t, []UnsupportedPanel{{Row: "Overview", Title: "Latency", Type: "heatmap", Reason: `panel type "heatmap" has no Perses equivalent`}}, c.Unsupported)
+	require.Len(t, d.Spec.Panels, 3)
+	graph := d.Spec.Panels["0_1"].Spec
+	require.Equal(t, "TimeSeriesChart", graph.Plugin.Kind)
+	require.Equal(t, map[string]string{"stack": "all"}, graph.Plugin.Spec["visual"])
+	query := graph.Queries[0].Spec.Plugin
+	require.Equal(t, "PrometheusTimeSeriesQuery", query.Kind)
+	require.Equal(t, "{{instance}}", query.Spec["seriesNameFormat"])
+	require.Equal(t, map[string]interface{}{"unit": "decimal"}, d.Spec.Panels["0_0"].Spec.Plugin.Spec["format"])
+	require.Equal(t, map[string]interface{}{"format": map[string]interface{}{"unit": "ops/sec"}}, graph.Plugin.Spec["yAxis"])
+
+	require.Len(t, d.Spec.Layouts, 2)
+	overview := d.Spec.Layouts[0].Spec
+	require.Equal(t, "Overview", overview.Display.Title)
+	require.Equal(t, []GridItem{
+		{X: 0, Y: 0, Width: 6, Height: 8, Content: map[string]string{"$ref": "#/spec/panels/0_0"}},
+		{X: 6, Y: 0, Width: 18, Height: 8, Content: map[string]string{"$ref": "#/spec/panels/0_1"}},
+	}, overview.Items)
+	// The title of the rows is hidden unless showTitle is set.
+	require.Nil(t, d.Spec.Layouts[1].Spec.Display)
+}
+
+func TestConvertGrafanaGrid(t *testing.T) {
+	data, err := os.ReadFile("../../web/cypress/fixtures/coo/coo140_perses/import/acm-vm-status.json")
+	require.NoError(t, err)
+	board, err := ParseBoard(data)
+	require.NoError(t, err)
+	c := Convert("", "", board)
+
+	// The dashboards without name are named after their uid.
+	require.Equal(t, "lmd6v93sz", c.Dashboard.Metadata.Name)
+	require.Empty(t, c.Unsupported)
+	require.Len(t, c.Dashboard.Spec.Panels, 4)
+	require.Equal(t, "StatChart", c.Dashboard.Spec.Panels["0_1"].Spec.Plugin.Kind)
+	require.Equal(t, GridItem{X: 8, Y: 0, Width: 8, Height: 3, Content: map[string]string{"$ref": "#/spec/panels/0_1"}}, c.Dashboard.Spec.Layouts[0].Spec.Items[1])
+	for _, v := range c.Dashboard.Spec.Variables {
+		require.NotEmpty(t, v.Spec.Name)
+	}
+}
+
+// fakePersesAPI creates the dashboards, answering with a conflict for the
+// existing ones.
+type fakePersesAPI struct {
+	existing map[string]bool
+	methods  []string
+}
+
+func (f *fakePersesAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.methods = append(f.methods, r.Method+" "+r.URL.Path)
+	var d PersesDashboard
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPost && f.existing[d.Metadata.Name] {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message":"document already exists"}`))
+		return
+	}
+	w.Write([]byte(`{}`))
+}
+
+func TestConvertHandlerApply(t *testing.T) {
+	perses := &fakePersesAPI{existing: map[string]bool{"etcd": true}}
+	handler := NewConverter(&monitoring.Upstream{Kind: monitoring.PersesKind, Handler: perses}).ConvertHandler()
+	convert := func(body string) ConvertResponse {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/legacy-dashboards/convert", strings.NewReader(body)))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp ConvertResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := convert(`{"project":"team-a","apply":true,"dashboards":[{"name":"etcd","data":{"title":"etcd"}},{"name":"nodes","data":{"title":"nodes"}},{"name":"broken","data":"{"}]}`)
+	require.Len(t, resp.Results, 3)
+	require.Contains(t, resp.Results[0].Error, "status 409")
+	require.Equal(t, "created", resp.Results[1].Applied)
+	require.Contains(t, resp.Results[2].Error, "invalid dashboard")
+
+	resp = convert(`{"project":"team-a","apply":true,"overwrite":true,"dashboards":[{"name":"etcd","data":{"title":"etcd"}}]}`)
+	require.Equal(t, "updated", resp.Results[0].Applied)
+	require.Equal(t, "PUT /api/v1/projects/team-a/dashboards/etcd", perses.methods[len(perses.methods)-1])
+}
@@ -0,0 +1,201 @@
+package dashboards
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/openshift/monitoring-plugin/pkg/alerting"
+	"github.com/openshift/monitoring-plugin/pkg/api"
+)
+
+// DashboardLabel marks the ConfigMaps holding legacy dashboards, as for the
+// console.
+const DashboardLabel = "console.openshift.io/dashboard"
+
+var configMapsResource = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// DashboardReport is the validation of a legacy dashboard, stored in the key
+// of a ConfigMap.
+type DashboardReport struct {
+	Namespace string `json:"namespace"`
+	ConfigMap string `json:"configMap"`
+	Key       string `json:"key"`
+	Valid     bool   `json:"valid"`
+	Validation
+}
+
+// DiscoveryResponse reports the legacy dashboards visible to the user.
+type DiscoveryResponse struct {
+	Dashboards []DashboardReport `json:"dashboards"`
+	Total      int               `json:"total"`
+	Invalid    int               `json:"invalid"`
+}
+
+// NamespacesFunc returns the namespaces the user of a request can access.
+type NamespacesFunc func(r *http.Request) (map[string]bool, error)
+
+// maxNamespaceLists bounds the concurrent lists of the namespaces of users who
+// cannot list the ConfigMaps of the cluster.
+const maxNamespaceLists = 8
+
+// Discovery lists and validates the legacy dashboard ConfigMaps with the
+// credentials of the user, so that only the namespaces they can access are
+// listed.
+type Discovery struct {
+	client     alerting.ClientFunc
+	namespaces NamespacesFunc
+}
+
+func NewDiscovery(client alerting.ClientFunc, namespaces NamespacesFunc) *Discovery {
+	return &Discovery{client: client, namespaces: namespaces}
+}
+
+// ListHandler reports the legacy dashboards of every namespace, or of the
+// namespace query parameter.
+func (d *Discovery) ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, err := d.client(r)
+		if err != nil {
+			api.WriteError(w, http.StatusUnauthorized, err)
+			return
+		}
+		items, err := d.list(r, client)
+		if err != nil {
+			api.WriteKubeError(w, err)
+			return
+		}
+
+		var reports []DashboardReport
+		for _, item := range items {
+			data, _, err := unstructured.NestedStringMap(item.Object, "data")
+			if err != nil {
+				reports = append(reports, DashboardReport{
+					Namespace:  item.GetNamespace(),
+					ConfigMap:  item.GetName(),
+					Validation: Validation{Errors: []string{err.Error()}},
+				})
+				continue
+			}
+			for key, definition := range data {
+				_, validation := ValidateBoard([]byte(definition))
+				reports = append(reports, DashboardReport{
+					Namespace:  item.GetNamespace(),
+					ConfigMap:  item.GetName(),
+					Key:        key,
+					Validation: validation,
+				})
+			}
+		}
+		api.WriteJSON(w, http.StatusOK, newDiscoveryResponse(reports))
+	}
+}
+
+// list returns the dashboard ConfigMaps of the namespace query parameter, or of
+// the cluster. Users who cannot list the ConfigMaps of the cluster get those
+// of their namespaces.
+func (d *Discovery) list(r *http.Request, client dynamic.Interface) ([]unstructured.Unstructured, error) {
+	options := metav1.ListOptions{LabelSelector: DashboardLabel + "=true"}
+	list, err := client.Resource(configMapsResource).Namespace(r.URL.Query().Get("namespace")).List(r.Context(), options)
+	if err == nil {
+		return list.Items, nil
+	}
+	if !apierrors.IsForbidden(err) || r.URL.Query().Get("namespace") != "" || d.namespaces == nil {
+		return nil, err
+	}
+
+	namespaces, err := d.namespaces(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		mu    sync.Mutex
+		items []unstructured.Unstructured
+	)
+	g, ctx := errgroup.WithContext(r.Context())
+	g.SetLimit(maxNamespaceLists)
+	for namespace := range namespaces {
+		g.Go(func() error {
+			list, err := client.Resource(configMapsResource).Namespace(namespace).List(ctx, options)
+			if apierrors.IsForbidden(err) {
+				// Projects can be visible without their ConfigMaps.
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			items = append(items, list.Items...)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ValidateHandler validates the legacy dashboard definition of the request
+// body, before it is stored in a ConfigMap.
+func (d *Discovery) ValidateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		_, validation := ValidateBoard(data)
+		status := http.StatusOK
+		if len(validation.Errors) > 0 {
+			status = http.StatusUnprocessableEntity
+		}
+		api.WriteJSON(w, status, validation)
+	}
+}
+
+// newDiscoveryResponse sorts the reports and flags the dashboards sharing a
+// uid, as only one of them can be linked to.
+func newDiscoveryResponse(reports []DashboardReport) DiscoveryResponse {
+	sort.Slice(reports, func(i, j int) bool {
+		a, b := reports[i], reports[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.ConfigMap != b.ConfigMap {
+			return a.ConfigMap < b.ConfigMap
+		}
+		return a.Key < b.Key
+	})
+
+	owners := map[string][]string{}
+	for _, r := range reports {
+		if r.UID != "" {
+			owners[r.UID] = append(owners[r.UID], r.Namespace+"/"+r.ConfigMap+"/"+r.Key)
+		}
+	}
+	resp := DiscoveryResponse{Dashboards: make([]DashboardReport, 0, len(reports)), Total: len(reports)}
+	for _, r := range reports {
+		self := r.Namespace + "/" + r.ConfigMap + "/" + r.Key
+		for _, other := range owners[r.UID] {
+			if other != self {
+				r.Errors = append(r.Errors, fmt.Sprintf("uid %q: duplicate of %s", r.UID, other))
+			}
+		}
+		r.Valid = len(r.Errors) == 0
+		if !r.Valid {
+			resp.Invalid++
+		}
+		resp.Dashboards = append(resp.Dashboards, r)
+	}
+	return resp
+}
@@ -0,0 +1,95 @@
+package dashboards
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func dashboardConfigMap(namespace, name string, labeled bool, data map[string]interface{}) runtime.Object {
+	cm := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name},
+		"data":       data,
+	}}
+	if labeled {
+		cm.SetLabels(map[string]string{DashboardLabel: "true"})
+	}
+	return cm
+}
+
+func TestDiscovery(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		configMapsResource: "ConfigMapList",
+	},
+		dashboardConfigMap("openshift-config-managed", "etcd", true, map[string]interface{}{"etcd.json": `{"uid": "etcd", "title": "etcd", "panels": []}`}),
+		dashboardConfigMap("team-a", "copies", true, map[string]interface{}{
+			"copy.json":   `{"uid": "etcd", "title": "copy", "panels": []}`,
+			"broken.json": `{"title": "broken"}`,
+		}),
+		dashboardConfigMap("team-a", "other", false, map[string]interface{}{"other.json": `{}`}),
+	)
+	d := NewDiscovery(func(r *http.Request) (dynamic.Interface, error) { return client, nil },
+		func(r *http.Request) (map[string]bool, error) {
+			return map[string]bool{"team-a": true, "team-b": true}, nil
+		})
+
+	list := func(query string) DiscoveryResponse {
+		rec := httptest.NewRecorder()
+		d.ListHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/legacy-dashboards"+query, nil))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp DiscoveryResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := list("")
+	require.Equal(t, 3, resp.Total)
+	require.Equal(t, 3, resp.Invalid)
+	require.Equal(t, "openshift-config-managed/etcd/etcd.json", resp.Dashboards[0].Namespace+"/"+resp.Dashboards[0].ConfigMap+"/"+resp.Dashboards[0].Key)
+	require.Equal(t, []string{`uid "etcd": duplicate of team-a/copies/copy.json`}, resp.Dashboards[0].Errors)
+	require.Equal(t, []string{"rows or panels are required"}, resp.Dashboards[1].Errors)
+	require.Equal(t, []string{`uid "etcd": duplicate of openshift-config-managed/etcd/etcd.json`}, resp.Dashboards[2].Errors)
+
+	resp = list("?namespace=openshift-config-managed")
+	require.Equal(t, 1, resp.Total)
+	require.True(t, resp.Dashboards[0].Valid)
+
+	// Users who cannot list the ConfigMaps of the cluster get those of their
+	// namespaces.
+	client.PrependReactor("list", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if ns := action.GetNamespace(); ns == "" || ns == "team-b" {
+			return true, nil, apierrors.NewForbidden(configMapsResource.GroupResource(), "", errors.New("denied"))
+		}
+		return false, nil, nil
+	})
+	resp = list("")
+	require.Equal(t, 2, resp.Total)
+	require.Equal(t, "team-a", resp.Dashboards[0].Namespace)
+	require.Equal(t, "team-a", resp.Dashboards[1].Namespace)
+
+	rec := httptest.NewRecorder()
+	d.ListHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/legacy-dashboards?namespace=team-b", nil))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	validate := func(body string) int {
+		rec := httptest.NewRecorder()
+		d.ValidateHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/legacy-dashboards/validate", strings.NewReader(body)))
+		return rec.Code
+	}
+	require.Equal(t, http.StatusOK, validate(legacyBoard))
+	require.Equal(t, http.StatusUnprocessableEntity, validate(`{"title": "t", "panels": [{"type": "graph", "targets": [{"expr": "up{"}]}]}`))
+}
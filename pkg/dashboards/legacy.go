@@ -0,0 +1,198 @@
+// Package dashboards holds the backend APIs of the legacy-dashboards and
+// perses-dashboards features.
+package dashboards
+
+import (
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.WithField("module", "dashboards")
+
+// Board is a legacy dashboard definition, as stored in the dashboard
+// ConfigMaps. It is a subset of the Grafana dashboard model, the panels are
+// either grouped in rows or laid out on a grid with row panels as separators.
+type Board struct {
+	UID        string     `json:"uid,omitempty"`
+	Title      string     `json:"title"`
+	Tags       []string   `json:"tags,omitempty"`
+	Rows       []Row      `json:"rows,omitempty"`
+	Panels     []Panel    `json:"panels,omitempty"`
+	Templating Templating `json:"templating"`
+	Time       *struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	} `json:"time,omitempty"`
+}
+
+type Row struct {
+	Title     string  `json:"title,omitempty"`
+	ShowTitle bool    `json:"showTitle,omitempty"`
+	Collapse  bool    `json:"collapse,omitempty"`
+	Panels    []Panel `json:"panels"`
+}
+
+type Templating struct {
+	List []TemplateVariable `json:"list"`
+}
+
+type Panel struct {
+	ID          json.RawMessage `json:"id,omitempty"`
+	Type        string          `json:"type"`
+	Title       string          `json:"title"`
+	Description string          `json:"description,omitempty"`
+	Datasource  *Datasource     `json:"datasource,omitempty"`
+	Span        int             `json:"span,omitempty"`
+	Breakpoint  string          `json:"breakpoint,omitempty"`
+	GridPos     *GridPos        `json:"gridPos,omitempty"`
+	Collapsed   bool            `json:"collapsed,omitempty"`
+	// Panels are the panels of a collapsed row panel.
+	Panels   []Panel  `json:"panels,omitempty"`
+	Targets  []Target `json:"targets,omitempty"`
+	Stack    bool     `json:"stack,omitempty"`
+	Legend   *Legend  `json:"legend,omitempty"`
+	Format   string   `json:"format,omitempty"`
+	Units    string   `json:"units,omitempty"`
+	Decimals *int     `json:"decimals,omitempty"`
+	YAxes    []struct {
+		Format string `json:"format"`
+	} `json:"yaxes,omitempty"`
+	FieldConfig *struct {
+		Defaults struct {
+			Unit     string `json:"unit,omitempty"`
+			Decimals *int   `json:"decimals,omitempty"`
+		} `json:"defaults"`
+	} `json:"fieldConfig,omitempty"`
+	Options json.RawMessage `json:"options,omitempty"`
+	Content string          `json:"content,omitempty"`
+}
+
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type Legend struct {
+	Show bool `json:"show"`
+}
+
+type Target struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+	RefID        string `json:"refId,omitempty"`
+}
+
+// Datasource references a datasource by name. Grafana also allows a plain
+// string, which is read as the name.
+type Datasource struct {
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
+	UID  string `json:"uid,omitempty"`
+}
+
+func (d *Datasource) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*d = Datasource{Name: name}
+		return nil
+	}
+	type plain Datasource
+	return json.Unmarshal(b, (*plain)(d))
+}
+
+type TemplateVariable struct {
+	Name        string           `json:"name"`
+	Label       string           `json:"label,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Type        string           `json:"type"`
+	Datasource  *Datasource      `json:"datasource,omitempty"`
+	Hide        int              `json:"hide,omitempty"`
+	IncludeAll  bool             `json:"includeAll,omitempty"`
+	AllValue    string           `json:"allValue,omitempty"`
+	Multi       bool             `json:"multi,omitempty"`
+	Query       VariableQuery    `json:"query,omitempty"`
+	Regex       string           `json:"regex,omitempty"`
+	Options     []VariableOption `json:"options,omitempty"`
+}
+
+// VariableQuery is the query of a variable, a string in the legacy format and
+// an object with a query field in the recent Grafana versions.
+type VariableQuery string
+
+func (q *VariableQuery) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*q = VariableQuery(s)
+		return nil
+	}
+	var obj struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+	*q = VariableQuery(obj.Query)
+	return nil
+}
+
+type VariableOption struct {
+	Selected bool   `json:"selected"`
+	Text     string `json:"text,omitempty"`
+	Value    string `json:"value"`
+}
+
+// PanelRows groups the panels of the board in rows, as the legacy dashboards
+// page does for the boards without rows: the row panels start a new row and
+// the panels before the first one are in an untitled row.
+func (b Board) PanelRows() []Row {
+	if len(b.Rows) > 0 {
+		return b.Rows
+	}
+	var rows []Row
+	for _, p := range b.Panels {
+		switch {
+		case p.Type == "row":
+			rows = append(rows, Row{Title: p.Title, ShowTitle: true, Collapse: p.Collapsed, Panels: p.Panels})
+		case len(rows) == 0:
+			rows = append(rows, Row{Panels: []Panel{p}})
+		default:
+			rows[len(rows)-1].Panels = append(rows[len(rows)-1].Panels, p)
+		}
+	}
+	return rows
+}
+
+// unit returns the Grafana unit of the panel, from the most to the least
+// recent of the panel formats.
+func (p Panel) unit() string {
+	if p.FieldConfig != nil && p.FieldConfig.Defaults.Unit != "" {
+		return p.FieldConfig.Defaults.Unit
+	}
+	if p.Format != "" {
+		return p.Format
+	}
+	if p.Units != "" {
+		return p.Units
+	}
+	if len(p.YAxes) > 0 {
+		return p.YAxes[0].Format
+	}
+	return ""
+}
+
+func (p Panel) decimals() *int {
+	if p.FieldConfig != nil && p.FieldConfig.Defaults.Decimals != nil {
+		return p.FieldConfig.Defaults.Decimals
+	}
+	return p.Decimals
+}
+
+// ParseBoard decodes the JSON definition of a legacy dashboard.
+func ParseBoard(data []byte) (Board, error) {
+	var b Board
+	err := json.Unmarshal(data, &b)
+	return b, err
+}
@@ -0,0 +1,106 @@
+package dashboards
+
+// The Perses resources written by the backend, with the fields it fills. The
+// plugin specs are left untyped as they depend on the plugin kind.
+
+const (
+	persesDashboardKind = "Dashboard"
+	persesGridKind      = "Grid"
+	// persesGridColumns is the width of the Perses grid layouts, legacy rows
+	// have 12 columns.
+	persesGridColumns = 24
+)
+
+type PersesMetadata struct {
+	Name    string `json:"name"`
+	Project string `json:"project,omitempty"`
+}
+
+type PersesDashboard struct {
+	Kind     string         `json:"kind"`
+	Metadata PersesMetadata `json:"metadata"`
+	Spec     DashboardSpec  `json:"spec"`
+}
+
+type DashboardSpec struct {
+	Display   *Display               `json:"display,omitempty"`
+	Duration  string                 `json:"duration"`
+	Variables []PersesVariable       `json:"variables,omitempty"`
+	Panels    map[string]PersesPanel `json:"panels"`
+	Layouts   []PersesLayout         `json:"layouts"`
+}
+
+type Display struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Hidden      bool   `json:"hidden,omitempty"`
+}
+
+type Plugin struct {
+	Kind string                 `json:"kind"`
+	Spec map[string]interface{} `json:"spec"`
+}
+
+type PersesVariable struct {
+	Kind string             `json:"kind"`
+	Spec PersesVariableSpec `json:"spec"`
+}
+
+type PersesVariableSpec struct {
+	Name            string      `json:"name"`
+	Display         *Display    `json:"display,omitempty"`
+	DefaultValue    interface{} `json:"defaultValue,omitempty"`
+	AllowAllValue   bool        `json:"allowAllValue,omitempty"`
+	AllowMultiple   bool        `json:"allowMultiple,omitempty"`
+	CustomAllValue  string      `json:"customAllValue,omitempty"`
+	CapturingRegexp string      `json:"capturingRegexp,omitempty"`
+	// Value and Constant are the fields of the text variables.
+	Value    string  `json:"value,omitempty"`
+	Constant bool    `json:"constant,omitempty"`
+	Plugin   *Plugin `json:"plugin,omitempty"`
+}
+
+type PersesPanel struct {
+	Kind string          `json:"kind"`
+	Spec PersesPanelSpec `json:"spec"`
+}
+
+type PersesPanelSpec struct {
+	Display Display       `json:"display"`
+	Plugin  Plugin        `json:"plugin"`
+	Queries []PersesQuery `json:"queries,omitempty"`
+}
+
+type PersesQuery struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Plugin Plugin `json:"plugin"`
+	} `json:"spec"`
+}
+
+type PersesLayout struct {
+	Kind string     `json:"kind"`
+	Spec GridLayout `json:"spec"`
+}
+
+type GridLayout struct {
+	Display *GridDisplay `json:"display,omitempty"`
+	Items   []GridItem   `json:"items"`
+}
+
+type GridDisplay struct {
+	Title    string        `json:"title"`
+	Collapse *GridCollapse `json:"collapse,omitempty"`
+}
+
+type GridCollapse struct {
+	Open bool `json:"open"`
+}
+
+type GridItem struct {
+	X       int               `json:"x"`
+	Y       int               `json:"y"`
+	Width   int               `json:"width"`
+	Height  int               `json:"height"`
+	Content map[string]string `json:"content"`
+}
@@ -0,0 +1,576 @@
+package dashboards
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/openshift/monitoring-plugin/pkg/alerting"
+	"github.com/openshift/monitoring-plugin/pkg/api"
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+// ConflictPolicy selects how the imported dashboards replace the existing
+// dashboards of the same name with a different definition.
+type ConflictPolicy string
+
+const (
+	// SkipConflicts keeps the existing dashboards.
+	SkipConflicts ConflictPolicy = "skip"
+	// OverwriteConflicts replaces the existing dashboards.
+	OverwriteConflicts ConflictPolicy = "overwrite"
+	// RenameConflicts imports the dashboards under a new name, the legacy
+	// dashboards in a new ConfigMap.
+	RenameConflicts ConflictPolicy = "rename"
+)
+
+const (
+	renameSuffix = "-imported"
+	// maxRenameAttempts bounds the names tried by the rename policy.
+	maxRenameAttempts = 10
+)
+
+// Import statuses, comparing the imported dashboards with the existing ones.
+const (
+	statusNew       = "new"
+	statusUnchanged = "unchanged"
+	statusChanged   = "changed"
+)
+
+// Import actions, which are reported but not applied for dry runs.
+const (
+	actionCreated = "created"
+	actionUpdated = "updated"
+	actionRenamed = "renamed"
+	actionSkipped = "skipped"
+)
+
+// ImportResult is the import of one of the dashboards of a bundle.
+type ImportResult struct {
+	BundleEntry
+	// Status is new, unchanged or changed, compared to the existing dashboard.
+	Status string `json:"status,omitempty"`
+	// Diff is the unified diff from the existing definition to the imported
+	// one, for the changed dashboards.
+	Diff string `json:"diff,omitempty"`
+	// Action is created, updated, renamed or skipped. The invalid dashboards
+	// have no action.
+	Action string `json:"action,omitempty"`
+	// RenamedTo is the ConfigMap or the Perses dashboard created by the rename
+	// policy.
+	RenamedTo string   `json:"renamedTo,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+type ImportResponse struct {
+	Policy  ConflictPolicy `json:"policy"`
+	DryRun  bool           `json:"dryRun"`
+	Results []ImportResult `json:"results"`
+}
+
+// Transfer exports and imports bundles of legacy and Perses dashboards on
+// behalf of the user, to promote dashboards between clusters.
+type Transfer struct {
+	// client is nil unless the legacy dashboards feature can reach the API
+	// server.
+	client alerting.ClientFunc
+	// perses is nil unless the Perses proxy is configured.
+	perses *monitoring.Upstream
+	now    func() time.Time
+}
+
+func NewTransfer(client alerting.ClientFunc, perses *monitoring.Upstream) *Transfer {
+	return &Transfer{client: client, perses: perses, now: time.Now}
+}
+
+// ExportHandler exports the dashboards selected by the legacy and perses query
+// parameters, as a gzipped tarball or with format=yaml as a YAML stream. The
+// parameters are a namespace, or a namespace and the name of a ConfigMap,
+// and a Perses project, or a project and the name of a dashboard.
+func (t *Transfer) ExportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format == "" {
+			format = "tar"
+		}
+		if format != "tar" && format != "yaml" {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q, expected tar or yaml", format))
+			return
+		}
+		legacy, perses := query["legacy"], query["perses"]
+		if len(legacy) == 0 && len(perses) == 0 {
+			api.WriteError(w, http.StatusBadRequest, errors.New("no dashboards to export, set the legacy or perses parameters"))
+			return
+		}
+		if err := t.available(len(legacy) > 0, len(perses) > 0); err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		for _, selector := range append(append([]string{}, legacy...), perses...) {
+			if _, _, err := parseSelector(selector); err != nil {
+				api.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		bundle := &Bundle{Version: BundleVersion, Created: t.now().UTC().Truncate(time.Second)}
+		if len(legacy) > 0 {
+			client, err := t.client(r)
+			if err != nil {
+				api.WriteError(w, http.StatusUnauthorized, err)
+				return
+			}
+			for _, selector := range legacy {
+				dashboards, err := exportLegacy(r.Context(), client, selector)
+				if err != nil {
+					api.WriteKubeError(w, err)
+					return
+				}
+				bundle.Dashboards = append(bundle.Dashboards, dashboards...)
+			}
+		}
+		for _, selector := range perses {
+			dashboards, err := t.exportPerses(r, selector)
+			if err != nil {
+				writeUpstreamError(w, err)
+				return
+			}
+			bundle.Dashboards = append(bundle.Dashboards, dashboards...)
+		}
+		if len(bundle.Dashboards) == 0 {
+			api.WriteError(w, http.StatusNotFound, errors.New("no dashboards found"))
+			return
+		}
+
+		var buf bytes.Buffer
+		filename := "dashboards-" + bundle.Created.Format("20060102T150405Z")
+		contentType := "application/gzip"
+		var err error
+		if format == "yaml" {
+			filename += ".yaml"
+			contentType = "application/yaml"
+			err = bundle.WriteYAML(&buf)
+		} else {
+			filename += ".tar.gz"
+			err = bundle.WriteTarball(&buf)
+		}
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			log.WithError(err).Warn("cannot write the dashboards bundle")
+		}
+	}
+}
+
+// ImportHandler imports the bundle of the request body. The dashboards are
+// validated and compared to the existing ones, then applied according to the
+// policy query parameter, skip by default, unless dryRun is set. The errors of
+// the individual dashboards are reported in their result.
+func (t *Transfer) ImportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		policy := ConflictPolicy(r.URL.Query().Get("policy"))
+		switch policy {
+		case "":
+			policy = SkipConflicts
+		case SkipConflicts, OverwriteConflicts, RenameConflicts:
+		default:
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("unknown policy %q, expected skip, overwrite or rename", policy))
+			return
+		}
+		dryRun := r.URL.Query().Get("dryRun") == "true"
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		bundle, err := ReadBundle(data)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		var hasLegacy, hasPerses bool
+		for _, d := range bundle.Dashboards {
+			hasLegacy = hasLegacy || d.Kind == LegacyKind
+			hasPerses = hasPerses || d.Kind == PersesKind
+		}
+		if err := t.available(hasLegacy, hasPerses); err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		var client dynamic.Interface
+		if hasLegacy {
+			if client, err = t.client(r); err != nil {
+				api.WriteError(w, http.StatusUnauthorized, err)
+				return
+			}
+		}
+
+		imp := &importer{transfer: t, r: r, client: client, policy: policy, dryRun: dryRun, renamed: map[string]string{}}
+		resp := ImportResponse{Policy: policy, DryRun: dryRun, Results: make([]ImportResult, 0, len(bundle.Dashboards))}
+		for _, d := range bundle.Dashboards {
+			result := ImportResult{BundleEntry: d.BundleEntry}
+			result.Path = ""
+			if d.Kind == LegacyKind {
+				err = imp.legacy(d, &result)
+			} else {
+				err = imp.perses(d, &result)
+			}
+			if err != nil {
+				log.WithError(err).Warnf("cannot import dashboard %s", d.BundleEntry)
+				result.Errors = append(result.Errors, err.Error())
+			}
+			resp.Results = append(resp.Results, result)
+		}
+		api.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+// available checks that the dashboards of the requested kinds can be read and
+// written.
+func (t *Transfer) available(legacy, perses bool) error {
+	if legacy && t.client == nil {
+		return errors.New("legacy dashboards are not available, the backend cannot reach the API server")
+	}
+	if perses && t.perses == nil {
+		return errors.New("perses dashboards are not available, the perses proxy is not configured")
+	}
+	return nil
+}
+
+// parseSelector parses a namespace, or a namespace and a name separated by a
+// slash.
+func parseSelector(selector string) (string, string, error) {
+	namespace, name, hasName := strings.Cut(selector, "/")
+	if namespace == "" || (hasName && (name == "" || strings.Contains(name, "/"))) {
+		return "", "", fmt.Errorf("invalid selector %q, expected a namespace or namespace/name", selector)
+	}
+	return namespace, name, nil
+}
+
+// writeUpstreamError writes an error of Perses with its status code.
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	var upstreamErr *monitoring.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		api.WriteError(w, upstreamErr.Status, err)
+		return
+	}
+	api.WriteError(w, http.StatusBadGateway, err)
+}
+
+// exportLegacy returns the dashboards of a ConfigMap, or of the dashboard
+// ConfigMaps of a namespace.
+func exportLegacy(ctx context.Context, client dynamic.Interface, selector string) ([]BundleDashboard, error) {
+	namespace, name, _ := parseSelector(selector)
+	configMaps := client.Resource(configMapsResource).Namespace(namespace)
+	var items []unstructured.Unstructured
+	if name != "" {
+		cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *cm)
+	} else {
+		list, err := configMaps.List(ctx, metav1.ListOptions{LabelSelector: DashboardLabel + "=true"})
+		if err != nil {
+			return nil, err
+		}
+		items = list.Items
+	}
+
+	var dashboards []BundleDashboard
+	for _, item := range items {
+		data, _, err := unstructured.NestedStringMap(item.Object, "data")
+		if err != nil {
+			return nil, fmt.Errorf("configmap %s/%s: %w", item.GetNamespace(), item.GetName(), err)
+		}
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry := BundleEntry{Kind: LegacyKind, Namespace: item.GetNamespace(), Name: item.GetName(), Key: key}
+			dashboards = append(dashboards, newBundleDashboard(entry, data[key]))
+		}
+	}
+	return dashboards, nil
+}
+
+// exportPerses returns a Perses dashboard, or the dashboards of a project,
+// without the metadata set by Perses.
+func (t *Transfer) exportPerses(r *http.Request, selector string) ([]BundleDashboard, error) {
+	project, name, _ := parseSelector(selector)
+	var docs []persesDocument
+	if name != "" {
+		var doc persesDocument
+		if err := t.perses.Get(r, persesDashboardPath(project, name), nil, &doc); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	} else if err := t.perses.Get(r, persesDashboardPath(project, ""), nil, &docs); err != nil {
+		return nil, err
+	}
+
+	dashboards := make([]BundleDashboard, 0, len(docs))
+	for _, doc := range docs {
+		doc.Metadata.Project = project
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		entry := BundleEntry{Kind: PersesKind, Namespace: project, Name: doc.Metadata.Name}
+		dashboards = append(dashboards, newBundleDashboard(entry, string(data)))
+	}
+	return dashboards, nil
+}
+
+// persesDashboardPath returns the path of a dashboard of the Perses API, or of
+// the dashboards of the project without name.
+func persesDashboardPath(project, name string) string {
+	p := "/api/v1/projects/" + url.PathEscape(project) + "/dashboards"
+	if name != "" {
+		p += "/" + url.PathEscape(name)
+	}
+	return p
+}
+
+// importer applies the dashboards of a bundle.
+type importer struct {
+	transfer *Transfer
+	r        *http.Request
+	client   dynamic.Interface
+	policy   ConflictPolicy
+	dryRun   bool
+	// renamed maps the renamed ConfigMaps to the ConfigMaps created by the
+	// rename policy, so that the keys of a ConfigMap are imported together.
+	renamed map[string]string
+}
+
+// legacy imports a legacy dashboard in the key of its ConfigMap, which is
+// created with the dashboard label if needed.
+func (imp *importer) legacy(d BundleDashboard, result *ImportResult) error {
+	_, validation := ValidateBoard([]byte(d.Data))
+	result.Errors, result.Warnings = validation.Errors, validation.Warnings
+	if len(result.Errors) > 0 {
+		return nil
+	}
+
+	ctx := imp.r.Context()
+	configMaps := imp.client.Resource(configMapsResource).Namespace(d.Namespace)
+	name := d.Name
+	if renamed, ok := imp.renamed[d.Namespace+"/"+d.Name]; ok {
+		name, result.RenamedTo = renamed, renamed
+	}
+	cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	var existing string
+	var found bool
+	if cm != nil {
+		data, _, _ := unstructured.NestedStringMap(cm.Object, "data")
+		existing, found = data[d.Key]
+	}
+	if !imp.compare(result, existing, found, d.Data) {
+		return nil
+	}
+
+	if result.Status == statusChanged && imp.policy == RenameConflicts {
+		name, err = imp.freeName(d.Name, func(candidate string) (bool, error) {
+			_, err := configMaps.Get(ctx, candidate, metav1.GetOptions{})
+			return existsOrError(err)
+		})
+		if err != nil {
+			return err
+		}
+		imp.renamed[d.Namespace+"/"+d.Name] = name
+		cm, result.RenamedTo = nil, name
+		if validation.UID != "" {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("uid %q is shared with the dashboard of configmap %s", validation.UID, d.Name))
+		}
+	}
+	if imp.dryRun {
+		return nil
+	}
+
+	if cm == nil {
+		cm = &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": d.Namespace,
+				"labels":    map[string]interface{}{DashboardLabel: "true"},
+			},
+			"data": map[string]interface{}{d.Key: d.Data},
+		}}
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		return err
+	}
+	if err := unstructured.SetNestedField(cm.Object, d.Data, "data", d.Key); err != nil {
+		return err
+	}
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// perses imports a Perses dashboard in its project.
+func (imp *importer) perses(d BundleDashboard, result *ImportResult) error {
+	doc, validation := validatePersesDashboard([]byte(d.Data))
+	result.Errors, result.Warnings = validation.Errors, validation.Warnings
+	if len(result.Errors) > 0 {
+		return nil
+	}
+	doc.Metadata = PersesMetadata{Name: d.Name, Project: d.Namespace}
+	imported, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	perses := imp.transfer.perses
+	var existing persesDocument
+	err = perses.Get(imp.r, persesDashboardPath(d.Namespace, d.Name), nil, &existing)
+	found, err := existsOrError(err)
+	if err != nil {
+		return err
+	}
+	var current []byte
+	if found {
+		existing.Metadata.Project = d.Namespace
+		if current, err = json.MarshalIndent(existing, "", "  "); err != nil {
+			return err
+		}
+	}
+	if !imp.compare(result, string(current), found, string(imported)) {
+		return nil
+	}
+
+	method, path := http.MethodPost, persesDashboardPath(d.Namespace, "")
+	if result.Status == statusChanged {
+		switch imp.policy {
+		case OverwriteConflicts:
+			method, path = http.MethodPut, persesDashboardPath(d.Namespace, d.Name)
+		case RenameConflicts:
+			name, err := imp.freeName(d.Name, func(candidate string) (bool, error) {
+				return existsOrError(perses.Get(imp.r, persesDashboardPath(d.Namespace, candidate), nil, nil))
+			})
+			if err != nil {
+				return err
+			}
+			doc.Metadata.Name, result.RenamedTo = name, name
+		}
+	}
+	if imp.dryRun {
+		return nil
+	}
+	req, err := perses.NewRequest(imp.r, method, path, nil, doc)
+	if err != nil {
+		return err
+	}
+	return perses.Do(req, nil)
+}
+
+// compare sets the status of the result and the action planned by the
+// policy, and returns whether the dashboard is written.
+func (imp *importer) compare(result *ImportResult, existing string, found bool, imported string) bool {
+	switch {
+	case !found:
+		result.Status, result.Action = statusNew, actionCreated
+		if result.RenamedTo != "" {
+			result.Action = actionRenamed
+		}
+		return true
+	case canonicalJSON(existing) == canonicalJSON(imported):
+		result.Status, result.Action = statusUnchanged, actionSkipped
+		return false
+	}
+
+	result.Status = statusChanged
+	result.Diff, _ = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(canonicalJSON(existing)),
+		B:        difflib.SplitLines(canonicalJSON(imported)),
+		FromFile: "existing",
+		ToFile:   "imported",
+		Context:  3,
+	})
+	switch imp.policy {
+	case OverwriteConflicts:
+		result.Action = actionUpdated
+	case RenameConflicts:
+		result.Action = actionRenamed
+	default:
+		result.Action = actionSkipped
+		return false
+	}
+	return true
+}
+
+// freeName returns the first name derived from name which does not exist.
+func (imp *importer) freeName(name string, exists func(string) (bool, error)) (string, error) {
+	for i := 1; i <= maxRenameAttempts; i++ {
+		candidate := name + renameSuffix
+		if i > 1 {
+			candidate = fmt.Sprintf("%s%s-%d", name, renameSuffix, i)
+		}
+		found, err := exists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("cannot rename %s, the %d candidate names exist", name, maxRenameAttempts)
+}
+
+// existsOrError returns whether a get request found the object, or its error
+// when it failed for another reason.
+func existsOrError(err error) (bool, error) {
+	var upstreamErr *monitoring.UpstreamError
+	switch {
+	case err == nil:
+		return true, nil
+	case apierrors.IsNotFound(err), errors.As(err, &upstreamErr) && upstreamErr.Status == http.StatusNotFound:
+		return false, nil
+	}
+	return false, err
+}
+
+// canonicalJSON indents the JSON with sorted keys, so that definitions are
+// compared and diffed regardless of their formatting.
+func canonicalJSON(data string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		return data
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return data
+	}
+	return string(out)
+}
@@ -0,0 +1,238 @@
+package dashboards
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+const persesBoard = `{"kind": "Dashboard", "metadata": {"name": "%s", "project": "team-a", "version": 3},
+  "spec": {"duration": "1h", "panels": {"0_0": {"kind": "Panel", "spec": {"queries": [
+    {"kind": "TimeSeriesQuery", "spec": {"plugin": {"kind": "PrometheusTimeSeriesQuery", "spec": {"query": "%s"}}}}
+  ]}}}}}`
+
+// fakePersesStore serves the dashboards API of Perses from memory.
+type fakePersesStore struct {
+	dashboards map[string]string
+}
+
+func (f *fakePersesStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/projects/"), "/")
+	project := parts[0]
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 2:
+		var names []string
+		for key := range f.dashboards {
+			if strings.HasPrefix(key, project+"/") {
+				names = append(names, key)
+			}
+		}
+		sort.Strings(names)
+		docs := make([]string, 0, len(names))
+		for _, key := range names {
+			docs = append(docs, f.dashboards[key])
+		}
+		w.Write([]byte("[" + strings.Join(docs, ",") + "]"))
+	case r.Method == http.MethodGet:
+		doc, ok := f.dashboards[project+"/"+parts[2]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(doc))
+	case r.Method == http.MethodPost || r.Method == http.MethodPut:
+		var doc persesDocument
+		data, _ := io.ReadAll(r.Body)
+		if json.Unmarshal(data, &doc) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		key := project + "/" + doc.Metadata.Name
+		if _, ok := f.dashboards[key]; ok && r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.dashboards[key] = string(data)
+		w.Write(data)
+	}
+}
+
+func newTestTransfer(perses map[string]string, objects ...runtime.Object) (*Transfer, dynamic.Interface, *fakePersesStore) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		configMapsResource: "ConfigMapList",
+	}, objects...)
+	store := &fakePersesStore{dashboards: perses}
+	t := NewTransfer(func(r *http.Request) (dynamic.Interface, error) { return client, nil }, &monitoring.Upstream{Kind: monitoring.PersesKind, Handler: store})
+	t.now = func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return t, client, store
+}
+
+func persesDashboard(name, query string) string {
+	return strings.NewReplacer("\n", "").Replace(strings.Replace(strings.Replace(persesBoard, "%s", name, 1), "%s", query, 1))
+}
+
+func TestBundleFormats(t *testing.T) {
+	transfer, _, _ := newTestTransfer(map[string]string{
+		"team-a/api":   persesDashboard("api", "sum(rate(http_requests_total[5m]))"),
+		"team-a/nodes": persesDashboard("nodes", "up"),
+	}, dashboardConfigMap("openshift-config-managed", "etcd", true, map[string]interface{}{"etcd.json": legacyBoard}))
+
+	export := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		transfer.ExportHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/dashboards/export?"+query, nil))
+		return rec
+	}
+
+	rec := export("legacy=openshift-config-managed&perses=team-a/api&format=yaml")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, `attachment; filename="dashboards-20260102T030405Z.yaml"`, rec.Header().Get("Content-Disposition"))
+	yamlBundle, err := ReadBundle(rec.Body.Bytes())
+	require.NoError(t, err)
+
+	rec = export("legacy=openshift-config-managed&perses=team-a/api")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "application/gzip", rec.Header().Get("Content-Type"))
+	tarBundle, err := ReadBundle(rec.Body.Bytes())
+	require.NoError(t, err)
+
+	require.Equal(t, BundleVersion, yamlBundle.Version)
+	require.Equal(t, transfer.now(), yamlBundle.Created)
+	require.Len(t, yamlBundle.Dashboards, 2)
+	require.Equal(t, BundleEntry{Kind: LegacyKind, Namespace: "openshift-config-managed", Name: "etcd", Key: "etcd.json", SHA256: checksum(legacyBoard)}, yamlBundle.Dashboards[0].BundleEntry)
+	require.Equal(t, legacyBoard, yamlBundle.Dashboards[0].Data)
+	// The metadata set by Perses is not exported.
+	require.NotContains(t, yamlBundle.Dashboards[1].Data, "version")
+	for i, d := range tarBundle.Dashboards {
+		require.Equal(t, yamlBundle.Dashboards[i].Data, d.Data)
+		require.Equal(t, yamlBundle.Dashboards[i].SHA256, d.SHA256)
+	}
+	require.Equal(t, "perses/team-a/api.json", tarBundle.Dashboards[1].Path)
+
+	rec = export("perses=team-a&format=zip")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = export("legacy=a/b/c")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = export("legacy=openshift-config-managed/missing")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	yamlBundle.Dashboards[0].Data += " "
+	var buf bytes.Buffer
+	require.NoError(t, yamlBundle.WriteYAML(&buf))
+	_, err = ReadBundle(buf.Bytes())
+	require.ErrorContains(t, err, "legacy openshift-config-managed/etcd/etcd.json: checksum mismatch")
+
+	// The decompressed size of tarballs is bounded.
+	bomb := Bundle{Version: BundleVersion, Dashboards: []BundleDashboard{
+		newBundleDashboard(BundleEntry{Kind: PersesKind, Namespace: "team-a", Name: "bomb"}, strings.Repeat("0", maxBundleSize)),
+	}}
+	buf.Reset()
+	require.NoError(t, bomb.WriteTarball(&buf))
+	_, err = ReadBundle(buf.Bytes())
+	require.ErrorContains(t, err, "invalid tarball: more than 67108864 bytes decompressed")
+}
+
+func TestImport(t *testing.T) {
+	changedBoard := strings.Replace(legacyBoard, `"title": "etcd"`, `"title": "etcd v2"`, 1)
+	invalidBoard := `{"title": "broken", "panels": [{"type": "graph", "targets": [{"expr": "up{"}]}]}`
+	bundle := &Bundle{Version: BundleVersion, Dashboards: []BundleDashboard{
+		newBundleDashboard(BundleEntry{Kind: LegacyKind, Namespace: "ns", Name: "etcd", Key: "etcd.json"}, changedBoard),
+		newBundleDashboard(BundleEntry{Kind: LegacyKind, Namespace: "ns", Name: "etcd", Key: "new.json"}, `{"title": "new", "panels": []}`),
+		newBundleDashboard(BundleEntry{Kind: LegacyKind, Namespace: "ns", Name: "broken", Key: "broken.json"}, invalidBoard),
+		newBundleDashboard(BundleEntry{Kind: PersesKind, Namespace: "team-a", Name: "api"}, persesDashboard("api", "sum(up)")),
+		newBundleDashboard(BundleEntry{Kind: PersesKind, Namespace: "team-a", Name: "nodes"}, persesDashboard("nodes", "up")),
+	}}
+	var buf bytes.Buffer
+	require.NoError(t, bundle.WriteTarball(&buf))
+
+	importBundle := func(transfer *Transfer, query string) ImportResponse {
+		rec := httptest.NewRecorder()
+		transfer.ImportHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/dashboards/import?"+query, bytes.NewReader(buf.Bytes())))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp ImportResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp
+	}
+	summary := func(resp ImportResponse) []string {
+		var out []string
+		for _, r := range resp.Results {
+			out = append(out, strings.Join([]string{r.Name, r.Key, r.Status, r.Action, r.RenamedTo}, " "))
+		}
+		return out
+	}
+	newTarget := func() (*Transfer, dynamic.Interface, *fakePersesStore) {
+		return newTestTransfer(map[string]string{
+			"team-a/api":   persesDashboard("api", "sum(rate(http_requests_total[5m]))"),
+			"team-a/nodes": persesDashboard("nodes", "up"),
+		}, dashboardConfigMap("ns", "etcd", true, map[string]interface{}{"etcd.json": legacyBoard}))
+	}
+
+	transfer, client, store := newTarget()
+	resp := importBundle(transfer, "dryRun=true")
+	require.Equal(t, SkipConflicts, resp.Policy)
+	require.Equal(t, []string{
+		"etcd etcd.json changed skipped ",
+		"etcd new.json new created ",
+		"broken broken.json   ",
+		"api  changed skipped ",
+		"nodes  unchanged skipped ",
+	}, summary(resp))
+	require.Contains(t, resp.Results[0].Diff, "-  \"title\": \"etcd\"\n")
+	require.Contains(t, resp.Results[0].Diff, "+  \"title\": \"etcd v2\"\n }\n")
+	require.Contains(t, resp.Results[2].Errors[0], "invalid PromQL")
+	cm, err := client.Resource(configMapsResource).Namespace("ns").Get(t.Context(), "etcd", metav1.GetOptions{})
+	require.NoError(t, err)
+	data, _, _ := unstructured.NestedStringMap(cm.Object, "data")
+	require.Len(t, data, 1)
+
+	resp = importBundle(transfer, "policy=overwrite")
+	require.Equal(t, "etcd etcd.json changed updated ", summary(resp)[0])
+	require.Equal(t, "api  changed updated ", summary(resp)[3])
+	cm, err = client.Resource(configMapsResource).Namespace("ns").Get(t.Context(), "etcd", metav1.GetOptions{})
+	require.NoError(t, err)
+	data, _, _ = unstructured.NestedStringMap(cm.Object, "data")
+	require.Equal(t, map[string]string{"etcd.json": changedBoard, "new.json": `{"title": "new", "panels": []}`}, data)
+	require.Contains(t, store.dashboards["team-a/api"], "sum(up)")
+
+	transfer, client, store = newTarget()
+	resp = importBundle(transfer, "policy=rename")
+	require.Equal(t, []string{
+		"etcd etcd.json changed renamed etcd-imported",
+		"etcd new.json new renamed etcd-imported",
+		"broken broken.json   ",
+		"api  changed renamed api-imported",
+		"nodes  unchanged skipped ",
+	}, summary(resp))
+	cm, err = client.Resource(configMapsResource).Namespace("ns").Get(t.Context(), "etcd-imported", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "true", cm.GetLabels()[DashboardLabel])
+	data, _, _ = unstructured.NestedStringMap(cm.Object, "data")
+	require.Len(t, data, 2)
+	require.Contains(t, store.dashboards["team-a/api"], "http_requests_total")
+	require.Contains(t, store.dashboards["team-a/api-imported"], "sum(up)")
+
+	rec := httptest.NewRecorder()
+	transfer.ImportHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/dashboards/import?policy=merge", bytes.NewReader(buf.Bytes())))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	legacyOnly := NewTransfer(nil, &monitoring.Upstream{Kind: monitoring.PersesKind, Handler: store})
+	rec = httptest.NewRecorder()
+	legacyOnly.ImportHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/dashboards/import", bytes.NewReader(buf.Bytes())))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "legacy dashboards are not available")
+}
@@ -0,0 +1,369 @@
+package dashboards
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// consolePanelTypes are the panel types rendered by the legacy dashboards
+// page, the other panels are shown as unsupported.
+var consolePanelTypes = map[string]bool{
+	"row":                    true,
+	"gauge":                  true,
+	"grafana-piechart-panel": true,
+	"graph":                  true,
+	"singlestat":             true,
+	"table":                  true,
+}
+
+// builtinVariables are set by the legacy dashboards page, with the values
+// used to parse the queries referencing them.
+var builtinVariables = map[string]string{
+	"__interval":      "5m",
+	"__rate_interval": "5m",
+	"__range":         "1h",
+	"__range_s":       "3600",
+	"__range_ms":      "3600000",
+}
+
+// variableRef matches the $name, ${name} and [[name]] variable references.
+var variableRef = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)(?::[^}]*)?\}|\$([a-zA-Z_][a-zA-Z0-9_]*)|\[\[([a-zA-Z_][a-zA-Z0-9_]*)\]\]`)
+
+var autoIntervalVariable = regexp.MustCompile(`^__auto_interval_[a-z]+$`)
+
+// Validation holds the errors which prevent a legacy dashboard from being
+// rendered correctly, and lint warnings.
+type Validation struct {
+	UID      string   `json:"uid,omitempty"`
+	Title    string   `json:"title,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (v *Validation) errorf(format string, args ...interface{}) {
+	v.Errors = append(v.Errors, fmt.Sprintf(format, args...))
+}
+
+func (v *Validation) warnf(format string, args ...interface{}) {
+	v.Warnings = append(v.Warnings, fmt.Sprintf(format, args...))
+}
+
+// ValidateBoard validates the JSON definition of a legacy dashboard: its
+// schema, the variables referenced by the queries and the PromQL of the
+// queries once the variables are replaced.
+func ValidateBoard(data []byte) (Board, Validation) {
+	var v Validation
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		v.errorf("invalid JSON: %v", err)
+		return Board{}, v
+	}
+	validateSchema(&v, raw)
+	if len(v.Errors) > 0 {
+		return Board{}, v
+	}
+	board, err := ParseBoard(data)
+	if err != nil {
+		v.errorf("invalid dashboard: %v", err)
+		return Board{}, v
+	}
+	v.UID, v.Title = board.UID, board.Title
+
+	variables := map[string]TemplateVariable{}
+	for i, variable := range board.Templating.List {
+		if _, ok := variables[variable.Name]; ok {
+			v.errorf("templating.list %d: duplicate variable %q", i, variable.Name)
+		}
+		variables[variable.Name] = variable
+	}
+	for i, variable := range board.Templating.List {
+		if variable.Type != "query" {
+			continue
+		}
+		where := fmt.Sprintf("templating.list %d", i)
+		query := strings.TrimSpace(string(variable.Query))
+		if m := labelValuesQuery.FindStringSubmatch(query); m != nil {
+			query = m[1]
+		} else if m := queryResultQuery.FindStringSubmatch(query); m != nil {
+			query = m[1]
+		} else if labelNamesQuery.MatchString(query) {
+			continue
+		}
+		if query != "" {
+			validateQuery(&v, where, query, variables)
+		}
+	}
+
+	if len(board.Rows) > 0 {
+		for i, row := range board.Rows {
+			for j, p := range row.Panels {
+				validatePanel(&v, fmt.Sprintf("rows %d: panels %d", i, j), p, variables)
+			}
+		}
+	} else {
+		for i, p := range board.Panels {
+			validatePanel(&v, fmt.Sprintf("panels %d", i), p, variables)
+			for j, nested := range p.Panels {
+				validatePanel(&v, fmt.Sprintf("panels %d: panels %d", i, j), nested, variables)
+			}
+		}
+	}
+	return board, v
+}
+
+// validateSchema checks the types of the fields read by the legacy
+// dashboards page, the unknown fields are ignored as they are by the page.
+func validateSchema(v *Validation, raw map[string]interface{}) {
+	if _, ok := raw["title"].(string); !ok {
+		v.errorf("title: required string")
+	}
+	rows, hasRows := raw["rows"]
+	panels, hasPanels := raw["panels"]
+	if !hasRows && !hasPanels {
+		v.errorf("rows or panels are required")
+	}
+	if hasRows {
+		list, ok := rows.([]interface{})
+		if !ok {
+			v.errorf("rows: expected an array")
+		}
+		for i, row := range list {
+			obj, ok := row.(map[string]interface{})
+			if !ok {
+				v.errorf("rows %d: expected an object", i)
+				continue
+			}
+			validatePanelsSchema(v, fmt.Sprintf("rows %d: ", i), obj["panels"], false)
+		}
+	}
+	if hasPanels {
+		validatePanelsSchema(v, "", panels, true)
+	}
+
+	if templating, ok := raw["templating"]; ok {
+		obj, ok := templating.(map[string]interface{})
+		if !ok {
+			v.errorf("templating: expected an object")
+			return
+		}
+		list, ok := obj["list"].([]interface{})
+		if obj["list"] != nil && !ok {
+			v.errorf("templating.list: expected an array")
+		}
+		for i, item := range list {
+			variable, ok := item.(map[string]interface{})
+			if !ok {
+				v.errorf("templating.list %d: expected an object", i)
+				continue
+			}
+			for _, field := range []string{"name", "type"} {
+				if s, ok := variable[field].(string); !ok || s == "" {
+					v.errorf("templating.list %d: %s: required string", i, field)
+				}
+			}
+		}
+	}
+}
+
+func validatePanelsSchema(v *Validation, where string, panels interface{}, nested bool) {
+	if panels == nil {
+		return
+	}
+	list, ok := panels.([]interface{})
+	if !ok {
+		v.errorf("%spanels: expected an array", where)
+		return
+	}
+	for i, item := range list {
+		at := fmt.Sprintf("%spanels %d", where, i)
+		panel, ok := item.(map[string]interface{})
+		if !ok {
+			v.errorf("%s: expected an object", at)
+			continue
+		}
+		if s, ok := panel["type"].(string); !ok || s == "" {
+			v.errorf("%s: type: required string", at)
+		}
+		if targets, ok := panel["targets"]; ok && targets != nil {
+			list, ok := targets.([]interface{})
+			if !ok {
+				v.errorf("%s: targets: expected an array", at)
+			}
+			for j, target := range list {
+				obj, ok := target.(map[string]interface{})
+				if !ok {
+					v.errorf("%s: targets %d: expected an object", at, j)
+					continue
+				}
+				if expr, ok := obj["expr"]; ok && expr != nil {
+					if _, ok := expr.(string); !ok {
+						v.errorf("%s: targets %d: expr: expected a string", at, j)
+					}
+				}
+			}
+		}
+		if nested {
+			validatePanelsSchema(v, at+": ", panel["panels"], false)
+		}
+	}
+}
+
+func validatePanel(v *Validation, where string, p Panel, variables map[string]TemplateVariable) {
+	if !consolePanelTypes[p.Type] {
+		v.warnf("%s: panel type %q is not rendered by the legacy dashboards page", where, p.Type)
+	}
+	for i, t := range p.Targets {
+		if strings.TrimSpace(t.Expr) == "" {
+			continue
+		}
+		validateQuery(v, fmt.Sprintf("%s: targets %d", where, i), t.Expr, variables)
+	}
+}
+
+// validateQuery reports the variables referenced by the query but not
+// defined, and the PromQL errors of the query once the variables are
+// replaced with sample values.
+func validateQuery(v *Validation, where string, query string, variables map[string]TemplateVariable) {
+	var undefined []string
+	expr := replaceVariables(query, func(name string, before string) string {
+		if value, ok := builtinVariables[name]; ok {
+			return value
+		}
+		if autoIntervalVariable.MatchString(name) {
+			return "5m"
+		}
+		variable, ok := variables[name]
+		if !ok {
+			undefined = append(undefined, name)
+		}
+		return sampleValue(variable, before)
+	})
+	sort.Strings(undefined)
+	for i, name := range undefined {
+		if i == 0 || undefined[i-1] != name {
+			v.errorf("%s: undefined variable %q", where, name)
+		}
+	}
+	if _, err := parser.ParseExpr(expr); err != nil {
+		v.errorf("%s: invalid PromQL: %v", where, err)
+	}
+}
+
+// replaceVariables replaces the variable references of the query with the
+// values of fn, which gets the text of the query before the reference.
+func replaceVariables(query string, fn func(name string, before string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range variableRef.FindAllStringSubmatchIndex(query, -1) {
+		name := ""
+		for i := 2; i < len(m); i += 2 {
+			if m[i] >= 0 {
+				name = query[m[i]:m[i+1]]
+			}
+		}
+		b.WriteString(query[last:m[0]])
+		b.WriteString(fn(name, query[:m[0]]))
+		last = m[1]
+	}
+	b.WriteString(query[last:])
+	return b.String()
+}
+
+// sampleValue returns a value of the variable which keeps the query valid
+// where the variable is referenced: a duration in a range or subquery, a
+// string in a label matcher, and an option of the variable otherwise.
+func sampleValue(variable TemplateVariable, before string) string {
+	if strings.Count(before, `"`)%2 == 1 {
+		return "x"
+	}
+	trimmed := strings.TrimRight(before, " ")
+	if variable.Type == "interval" || strings.HasSuffix(trimmed, "[") || strings.HasSuffix(trimmed, ":") || strings.HasSuffix(trimmed, "offset") {
+		return "5m"
+	}
+	for _, o := range variable.Options {
+		if o.Value != "" && o.Value != "$__all" {
+			return o.Value
+		}
+	}
+	return "1"
+}
+
+// persesDocument is the portable part of a Perses dashboard, without the
+// metadata set by Perses.
+type persesDocument struct {
+	Kind     string          `json:"kind"`
+	Metadata PersesMetadata  `json:"metadata"`
+	Spec     json.RawMessage `json:"spec"`
+}
+
+// persesQueries holds the fields of a Perses dashboard spec read to validate
+// its Prometheus queries.
+type persesQueries struct {
+	Variables []struct {
+		Spec struct {
+			Name string `json:"name"`
+		} `json:"spec"`
+	} `json:"variables"`
+	Panels map[string]struct {
+		Spec struct {
+			Queries []struct {
+				Spec struct {
+					Plugin struct {
+						Kind string `json:"kind"`
+						Spec struct {
+							Query string `json:"query"`
+						} `json:"spec"`
+					} `json:"plugin"`
+				} `json:"spec"`
+			} `json:"queries"`
+		} `json:"spec"`
+	} `json:"panels"`
+}
+
+// validatePersesDashboard validates the JSON definition of a Perses dashboard
+// and the PromQL of its Prometheus queries, as for the legacy dashboards.
+func validatePersesDashboard(data []byte) (persesDocument, Validation) {
+	var v Validation
+	var doc persesDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		v.errorf("invalid JSON: %v", err)
+		return doc, v
+	}
+	if doc.Kind != persesDashboardKind {
+		v.errorf("kind: expected %q but got %q", persesDashboardKind, doc.Kind)
+	}
+	if doc.Metadata.Name == "" {
+		v.errorf("metadata.name: required string")
+	}
+	v.UID = doc.Metadata.Name
+	var spec persesQueries
+	if err := json.Unmarshal(doc.Spec, &spec); err != nil {
+		v.errorf("spec: %v", err)
+		return doc, v
+	}
+
+	variables := map[string]TemplateVariable{}
+	for _, variable := range spec.Variables {
+		variables[variable.Spec.Name] = TemplateVariable{Name: variable.Spec.Name}
+	}
+	keys := make([]string, 0, len(spec.Panels))
+	for key := range spec.Panels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for i, q := range spec.Panels[key].Spec.Queries {
+			plugin := q.Spec.Plugin
+			if plugin.Kind != "PrometheusTimeSeriesQuery" || strings.TrimSpace(plugin.Spec.Query) == "" {
+				continue
+			}
+			validateQuery(&v, fmt.Sprintf("panels %s: queries %d", key, i), plugin.Spec.Query, variables)
+		}
+	}
+	return doc, v
+}
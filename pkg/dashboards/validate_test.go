@@ -0,0 +1,71 @@
+package dashboards
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateBoard(t *testing.T) {
+	board, v := ValidateBoard([]byte(legacyBoard))
+	require.Equal(t, "etcd", board.Title)
+	require.Empty(t, v.Errors)
+	require.Equal(t, []string{`rows 0: panels 2: panel type "heatmap" is not rendered by the legacy dashboards page`}, v.Warnings)
+
+	for _, tc := range []struct {
+		name   string
+		board  string
+		errors []string
+	}{
+		{
+			name:   "invalid JSON",
+			board:  `{"title":`,
+			errors: []string{"invalid JSON: unexpected end of JSON input"},
+		},
+		{
+			name:  "schema",
+			board: `{"title": 1, "panels": [{"targets": [{"expr": 1}]}, "x"], "templating": {"list": [{"name": "a"}]}}`,
+			errors: []string{
+				"title: required string",
+				"panels 0: type: required string",
+				"panels 0: targets 0: expr: expected a string",
+				"panels 1: expected an object",
+				"templating.list 0: type: required string",
+			},
+		},
+		{
+			name: "variables and queries",
+			board: `{"title": "t", "uid": "u",
+			  "templating": {"list": [
+			    {"name": "job", "type": "query", "query": "label_values(up{cluster=\"$cluster\"}, job)"},
+			    {"name": "job", "type": "custom"}
+			  ]},
+			  "panels": [{"type": "graph", "targets": [
+			    {"expr": "rate(http_requests_total{job=~\"$job\"}[$interval])"},
+			    {"expr": "sum(up) by ("}
+			  ]}]}`,
+			errors: []string{
+				`templating.list 1: duplicate variable "job"`,
+				`templating.list 0: undefined variable "cluster"`,
+				`panels 0: targets 0: undefined variable "interval"`,
+				`panels 0: targets 1: invalid PromQL: 1:13: parse error: unclosed left parenthesis`,
+			},
+		},
+		{
+			name: "sample values",
+			board: `{"title": "t", "templating": {"list": [
+			    {"name": "quantile", "type": "custom", "options": [{"value": "$__all"}, {"value": "0.99"}]},
+			    {"name": "step", "type": "interval"}
+			  ]},
+			  "rows": [{"panels": [{"type": "graph", "targets": [
+			    {"expr": "histogram_quantile($quantile, rate(x_bucket[$__rate_interval] offset $step))"},
+			    {"expr": "max_over_time(up[${__range}:[[step]]])"}
+			  ]}]}]}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, v := ValidateBoard([]byte(tc.board))
+			require.Equal(t, tc.errors, v.Errors)
+		})
+	}
+}
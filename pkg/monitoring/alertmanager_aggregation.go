@@ -0,0 +1,467 @@
+package monitoring
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// AlertmanagerAggregationConfig configures the aggregation of the alerts and
+// silences of several Alertmanagers, e.g. the ones of spoke clusters which are
+// not federated to the hub.
+type AlertmanagerAggregationConfig struct {
+	Enabled  bool                `yaml:"enabled,omitempty"`
+	Backends []FederationBackend `yaml:"backends,omitempty"`
+	// SourceLabel is added to alerts, and as an equality matcher to silences,
+	// with the name of the backend they come from. Silences created with a
+	// SourceLabel matcher are sent to the backend it names, without it.
+	SourceLabel string `yaml:"sourceLabel,omitempty"`
+	// LocalName is the source of the default Alertmanager, which is
+	// aggregated with the backends and receives the silences without source
+	// matcher. Defaults to local-cluster.
+	LocalName string `yaml:"localName,omitempty"`
+}
+
+const (
+	alertsPath      = "/api/v2/alerts"
+	alertGroupsPath = "/api/v2/alerts/groups"
+	silencesPath    = "/api/v2/silences"
+	silencePath     = "/api/v2/silence/"
+)
+
+type alertmanagerAggregation struct {
+	config   AlertmanagerAggregationConfig
+	backends []federationBackend
+	next     http.Handler
+	// sharedToken is set when a backend is queried with a bearer token file
+	// instead of the credentials of the user.
+	sharedToken bool
+}
+
+func alertmanagerAggregationHandler(config AlertmanagerAggregationConfig, serviceCAfile string, proxyConfig ProxyConfig, next http.Handler) (http.Handler, error) {
+	if !config.Enabled {
+		return next, nil
+	}
+	if len(config.Backends) == 0 {
+		return nil, fmt.Errorf("alertmanager aggregation is enabled without any backends")
+	}
+	if config.SourceLabel == "" {
+		config.SourceLabel = "cluster"
+	}
+	if config.LocalName == "" {
+		config.LocalName = "local-cluster"
+	}
+
+	backendConfig := ProxyConfig{Retry: proxyConfig.Retry, CircuitBreaker: proxyConfig.CircuitBreaker}
+	a := &alertmanagerAggregation{config: config, next: next}
+	for _, b := range config.Backends {
+		if b.Name == config.LocalName {
+			return nil, fmt.Errorf("alertmanager backend %s has the name of the default alertmanager", b.Name)
+		}
+		caFile := b.CAFile
+		if caFile == "" {
+			caFile = serviceCAfile
+		}
+		proxy, err := createProxy(AlertManagerKind, []string{b.URL}, caFile, backendConfig)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create proxy for alertmanager backend %s: %w", b.Name, err)
+		}
+		log.Infof("aggregating %s %s at %s", AlertManagerKind, b.Name, b.URL)
+		a.backends = append(a.backends, federationBackend{FederationBackend: b, handler: proxy})
+		a.sharedToken = a.sharedToken || b.BearerTokenFile != ""
+	}
+	// The default upstream is queried with the credentials of the user, it
+	// is the last backend.
+	a.backends = append(a.backends, federationBackend{FederationBackend: FederationBackend{Name: config.LocalName}, handler: next})
+	return a, nil
+}
+
+func (a *alertmanagerAggregation) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(path, alertGroupsPath):
+		a.serveList(w, r, a.mergeGroups)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, alertsPath):
+		a.serveList(w, r, a.mergeAlerts)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, silencesPath):
+		a.serveList(w, r, a.mergeSilences)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, silencesPath):
+		a.createSilence(w, r)
+	case strings.Contains(path, silencePath):
+		a.serveSilence(w, r)
+	default:
+		a.next.ServeHTTP(w, r)
+	}
+}
+
+// backendRequest clones r for a backend, using its credentials when set.
+func backendRequest(r *http.Request, b federationBackend, body []byte) (*http.Request, error) {
+	sub := r.Clone(r.Context())
+	sub.Header.Del("Accept-Encoding")
+	if body != nil {
+		sub.Body = io.NopCloser(bytes.NewReader(body))
+		sub.ContentLength = int64(len(body))
+	}
+	if b.BearerTokenFile != "" {
+		token, err := os.ReadFile(b.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read bearer token: %w", err)
+		}
+		sub.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return sub, nil
+}
+
+type listResult struct {
+	name     string
+	items    []map[string]interface{}
+	upstream *bufferedResponse
+	err      error
+}
+
+// authorize checks that the default upstream lists the silences of the user
+// of r before the backends queried with a shared token are, and writes its
+// response otherwise.
+func (a *alertmanagerAggregation) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if !a.sharedToken {
+		return true
+	}
+	prefix := r.URL.Path[:strings.Index(r.URL.Path, "/api/v2/")]
+	get, err := http.NewRequestWithContext(r.Context(), http.MethodGet, prefix+silencesPath, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	get.Header = r.Header.Clone()
+	get.Header.Del("Accept-Encoding")
+	resp := newBufferedResponse()
+	a.next.ServeHTTP(resp, get)
+	if status := resp.statusCode(); status < 200 || status > 299 {
+		resp.copyTo(w)
+		return false
+	}
+	return true
+}
+
+func (a *alertmanagerAggregation) serveList(w http.ResponseWriter, r *http.Request, merge func([]listResult) []map[string]interface{}) {
+	results := make([]listResult, len(a.backends))
+	backends := a.backends
+	if a.sharedToken {
+		// Users the default upstream fails to serve must not get the lists of
+		// the backends queried with a shared token, so it is queried first.
+		last := len(backends) - 1
+		results[last] = a.list(r, backends[last])
+		if res := results[last]; res.err != nil {
+			if res.upstream != nil && res.upstream.statusCode() != http.StatusOK {
+				res.upstream.copyTo(w)
+			} else {
+				http.Error(w, fmt.Sprintf("alertmanager %s failed: %v", res.name, res.err), http.StatusBadGateway)
+			}
+			return
+		}
+		backends = backends[:last]
+	}
+
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Add(1)
+		go func(i int, b federationBackend) {
+			defer wg.Done()
+			results[i] = a.list(r, b)
+		}(i, b)
+	}
+	wg.Wait()
+
+	var succeeded []listResult
+	for _, res := range results {
+		if res.err != nil {
+			log.WithError(res.err).Warnf("%s backend %s failed", AlertManagerKind, res.name)
+			w.Header().Add("Warning", fmt.Sprintf("199 - %q", fmt.Sprintf("alertmanager %s failed: %v", res.name, res.err)))
+			continue
+		}
+		succeeded = append(succeeded, res)
+	}
+	if len(succeeded) == 0 {
+		http.Error(w, "all alertmanager backends failed", http.StatusBadGateway)
+		return
+	}
+
+	body, err := json.Marshal(merge(succeeded))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, body)
+}
+
+// list gets the items listed by a backend.
+func (a *alertmanagerAggregation) list(r *http.Request, b federationBackend) listResult {
+	res := listResult{name: b.Name}
+	sub, err := backendRequest(r, b, nil)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	res.upstream = newBufferedResponse()
+	b.handler.ServeHTTP(res.upstream, sub)
+	if status := res.upstream.statusCode(); status != http.StatusOK {
+		res.err = fmt.Errorf("unexpected status %d: %s", status, strings.TrimSpace(res.upstream.body.String()))
+		return res
+	}
+	res.err = json.Unmarshal(res.upstream.body.Bytes(), &res.items)
+	return res
+}
+
+// annotateLabels adds the source label to the labels of an alert unless it is
+// present. It returns the key deduplicating the alert, its fingerprint and
+// source, so that the identical alerts of several sources are all kept.
+func (a *alertmanagerAggregation) annotateLabels(item map[string]interface{}, source string) string {
+	labels, _ := item["labels"].(map[string]interface{})
+	if labels == nil {
+		labels = map[string]interface{}{}
+		item["labels"] = labels
+	}
+	if _, ok := labels[a.config.SourceLabel]; !ok {
+		labels[a.config.SourceLabel] = source
+	}
+	fingerprint, _ := item["fingerprint"].(string)
+	if fingerprint == "" {
+		return ""
+	}
+	value, _ := labels[a.config.SourceLabel].(string)
+	return fingerprint + "|" + value
+}
+
+func (a *alertmanagerAggregation) mergeAlerts(results []listResult) []map[string]interface{} {
+	merged := []map[string]interface{}{}
+	seen := map[string]bool{}
+	for _, res := range results {
+		for _, alert := range res.items {
+			key := a.annotateLabels(alert, res.name)
+			if key != "" && seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, alert)
+		}
+	}
+	return merged
+}
+
+func (a *alertmanagerAggregation) mergeGroups(results []listResult) []map[string]interface{} {
+	merged := []map[string]interface{}{}
+	byKey := map[string]map[string]interface{}{}
+	seen := map[string]map[string]bool{}
+	for _, res := range results {
+		for _, group := range res.items {
+			labels, _ := json.Marshal(group["labels"])
+			receiver, _ := json.Marshal(group["receiver"])
+			key := string(labels) + string(receiver)
+
+			alerts, _ := group["alerts"].([]interface{})
+			existing, ok := byKey[key]
+			if !ok {
+				existing = group
+				existing["alerts"] = []interface{}{}
+				byKey[key] = existing
+				seen[key] = map[string]bool{}
+				merged = append(merged, existing)
+			}
+
+			for _, item := range alerts {
+				alert, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				alertKey := a.annotateLabels(alert, res.name)
+				if alertKey != "" && seen[key][alertKey] {
+					continue
+				}
+				seen[key][alertKey] = true
+				existing["alerts"] = append(existing["alerts"].([]interface{}), alert)
+			}
+		}
+	}
+	return merged
+}
+
+func (a *alertmanagerAggregation) mergeSilences(results []listResult) []map[string]interface{} {
+	merged := []map[string]interface{}{}
+	seen := map[string]bool{}
+	for _, res := range results {
+		for _, silence := range res.items {
+			id, _ := silence["id"].(string)
+			if id != "" && seen[id] {
+				continue
+			}
+			seen[id] = true
+			a.annotateMatchers(silence, res.name)
+			merged = append(merged, silence)
+		}
+	}
+	return merged
+}
+
+// annotateMatchers adds a source label matcher to a silence unless it already
+// matches on the source label.
+func (a *alertmanagerAggregation) annotateMatchers(silence map[string]interface{}, source string) {
+	matchers, _ := silence["matchers"].([]interface{})
+	for _, m := range matchers {
+		if matcher, ok := m.(map[string]interface{}); ok && matcher["name"] == a.config.SourceLabel {
+			return
+		}
+	}
+	silence["matchers"] = append(matchers, map[string]interface{}{
+		"name":    a.config.SourceLabel,
+		"value":   source,
+		"isEqual": true,
+		"isRegex": false,
+	})
+}
+
+// createSilence sends a new or updated silence to the backend named by its
+// source label matcher, or to the default upstream without one, which is
+// listed as the local source. With shared tokens, only the users allowed to
+// list the silences of the default upstream are.
+func (a *alertmanagerAggregation) createSilence(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var silence map[string]interface{}
+	if err := json.Unmarshal(body, &silence); err != nil {
+		http.Error(w, fmt.Sprintf("invalid silence: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	backend, ok := a.silenceBackend(r, silence)
+	if !ok {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		a.next.ServeHTTP(w, r)
+		return
+	}
+
+	body, err = json.Marshal(silence)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sub, err := backendRequest(r, backend, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Debugf("routing silence to %s backend %s", AlertManagerKind, backend.Name)
+	backend.handler.ServeHTTP(w, sub)
+}
+
+// silenceBackend returns the backend named by the source label matcher of a
+// silence. The matcher is removed from new silences, as it is the source of
+// the alerts of the backend, and from updated silences when it was added by
+// annotateMatchers, so that updates do not widen the silences with a real
+// source label matcher.
+func (a *alertmanagerAggregation) silenceBackend(r *http.Request, silence map[string]interface{}) (federationBackend, bool) {
+	matchers, _ := silence["matchers"].([]interface{})
+	for i, m := range matchers {
+		matcher, ok := m.(map[string]interface{})
+		if !ok || matcher["name"] != a.config.SourceLabel || matcher["isRegex"] == true || matcher["isEqual"] == false {
+			continue
+		}
+		for _, b := range a.backends {
+			if matcher["value"] != b.Name {
+				continue
+			}
+			if id, _ := silence["id"].(string); id == "" || !a.hasSourceMatcher(r, b, id) {
+				silence["matchers"] = append(matchers[:i:i], matchers[i+1:]...)
+			}
+			return b, true
+		}
+	}
+	return federationBackend{}, false
+}
+
+// hasSourceMatcher reports whether the silence stored by the backend has its
+// own source label matcher.
+func (a *alertmanagerAggregation) hasSourceMatcher(r *http.Request, b federationBackend, id string) bool {
+	prefix := strings.TrimSuffix(r.URL.Path, silencesPath)
+	get, err := http.NewRequestWithContext(r.Context(), http.MethodGet, prefix+silencePath+id, nil)
+	if err != nil {
+		return false
+	}
+	get.Header = r.Header.Clone()
+	sub, err := backendRequest(get, b, nil)
+	if err != nil {
+		return false
+	}
+	resp := newBufferedResponse()
+	b.handler.ServeHTTP(resp, sub)
+	if resp.statusCode() != http.StatusOK {
+		return false
+	}
+	var existing struct {
+		Matchers []struct {
+			Name string `json:"name"`
+		} `json:"matchers"`
+	}
+	if err := json.Unmarshal(resp.body.Bytes(), &existing); err != nil {
+		return false
+	}
+	for _, m := range existing.Matchers {
+		if m.Name == a.config.SourceLabel {
+			return true
+		}
+	}
+	return false
+}
+
+// serveSilence looks a silence up by id on every backend and the default
+// upstream, as ids are only known to the Alertmanager which created the
+// silence.
+func (a *alertmanagerAggregation) serveSilence(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
+		return
+	}
+	var last *bufferedResponse
+	for _, b := range a.backends {
+		sub, err := backendRequest(r, b, nil)
+		if err != nil {
+			log.WithError(err).Warnf("%s backend %s failed", AlertManagerKind, b.Name)
+			continue
+		}
+		resp := newBufferedResponse()
+		b.handler.ServeHTTP(resp, sub)
+		last = resp
+		if resp.statusCode() == http.StatusNotFound {
+			continue
+		}
+		if resp.statusCode() == http.StatusOK && r.Method == http.MethodGet {
+			var silence map[string]interface{}
+			if err := json.Unmarshal(resp.body.Bytes(), &silence); err == nil {
+				a.annotateMatchers(silence, b.Name)
+				if body, err := json.Marshal(silence); err == nil {
+					writeJSON(w, body)
+					return
+				}
+			}
+		}
+		resp.copyTo(w)
+		return
+	}
+
+	if last == nil {
+		a.next.ServeHTTP(w, r)
+		return
+	}
+	last.copyTo(w)
+}
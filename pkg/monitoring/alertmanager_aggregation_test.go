@@ -0,0 +1,148 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertmanagerAggregation(t *testing.T) {
+	var created []string
+	backend := func(name string, alerts string, silence string) federationBackend {
+		return federationBackend{
+			FederationBackend: FederationBackend{Name: name},
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPost:
+					body, _ := io.ReadAll(r.Body)
+					created = append(created, name+" "+string(body))
+					w.Write([]byte(`{"silenceID":"1"}`))
+				case strings.HasSuffix(r.URL.Path, silencesPath):
+					w.Write([]byte("[" + silence + "]"))
+				case strings.Contains(r.URL.Path, silencePath):
+					if silence == "" || !strings.Contains(silence, `"id":"`+strings.TrimPrefix(r.URL.Path, silencePath)+`"`) {
+						http.NotFound(w, r)
+						return
+					}
+					w.Write([]byte(silence))
+				default:
+					w.Write([]byte(alerts))
+				}
+			}),
+		}
+	}
+
+	local := backend("local-cluster", `[{"fingerprint":"d","labels":{"alertname":"D"}}]`,
+		`{"id":"local","matchers":[{"name":"alertname","value":"D","isEqual":true,"isRegex":false}]}`)
+	a := &alertmanagerAggregation{
+		config: AlertmanagerAggregationConfig{Enabled: true, SourceLabel: "cluster", LocalName: "local-cluster"},
+		backends: []federationBackend{
+			backend("hub", `[{"fingerprint":"a","labels":{"alertname":"A"}},{"fingerprint":"b","labels":{"alertname":"B","cluster":"east"}}]`,
+				`{"id":"native","matchers":[{"name":"cluster","value":"hub","isEqual":true,"isRegex":false}]}`),
+			backend("spoke", `[{"fingerprint":"a","labels":{"alertname":"A"}},{"fingerprint":"c","labels":{"alertname":"C"}},{"fingerprint":"c","labels":{"alertname":"C"}}]`,
+				`{"id":"added","matchers":[{"name":"alertname","value":"C","isEqual":true,"isRegex":false}]}`),
+			local,
+		},
+		next: local.handler,
+	}
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/alerts", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// Alerts with the same fingerprint are kept for every source.
+	var alerts []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &alerts))
+	var sources []string
+	for _, alert := range alerts {
+		labels := alert["labels"].(map[string]interface{})
+		sources = append(sources, labels["alertname"].(string)+"@"+labels["cluster"].(string))
+	}
+	require.Equal(t, []string{"A@hub", "B@east", "A@spoke", "C@spoke", "D@local-cluster"}, sources)
+
+	// Silences of the default Alertmanager are listed and looked up.
+	rec = httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/silences", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var silences []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &silences))
+	require.Len(t, silences, 3)
+	rec = httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/silence/local", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `{"isEqual":true,"isRegex":false,"name":"cluster","value":"local-cluster"}`)
+
+	// Silences are routed by their cluster matcher, which is removed unless
+	// the updated silence has it.
+	post := func(body string) {
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v2/silences", strings.NewReader(body)))
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	post(`{"matchers":[{"name":"alertname","value":"C","isEqual":true,"isRegex":false},{"name":"cluster","value":"spoke","isEqual":true,"isRegex":false}]}`)
+	post(`{"id":"added","matchers":[{"name":"alertname","value":"C","isEqual":true,"isRegex":false},{"name":"cluster","value":"spoke","isEqual":true,"isRegex":false}]}`)
+	post(`{"id":"native","matchers":[{"name":"cluster","value":"hub","isEqual":true,"isRegex":false}]}`)
+	post(`{"matchers":[{"name":"alertname","value":"D","isEqual":true,"isRegex":false}]}`)
+	require.Equal(t, []string{
+		`spoke {"matchers":[{"isEqual":true,"isRegex":false,"name":"alertname","value":"C"}]}`,
+		`spoke {"id":"added","matchers":[{"isEqual":true,"isRegex":false,"name":"alertname","value":"C"}]}`,
+		`hub {"id":"native","matchers":[{"isEqual":true,"isRegex":false,"name":"cluster","value":"hub"}]}`,
+		`local-cluster {"matchers":[{"name":"alertname","value":"D","isEqual":true,"isRegex":false}]}`,
+	}, created)
+}
+
+func TestAlertmanagerAggregationSharedToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("shared\n"), 0o600))
+
+	var spokeRequests []string
+	spoke := federationBackend{
+		FederationBackend: FederationBackend{Name: "spoke", BearerTokenFile: tokenFile},
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			spokeRequests = append(spokeRequests, r.Method+" "+r.URL.Path+" "+r.Header.Get("Authorization"))
+			if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, silencesPath) {
+				w.Write([]byte("[]"))
+			}
+		}),
+	}
+	local := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer user" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("[]"))
+	})
+	a := &alertmanagerAggregation{
+		config:      AlertmanagerAggregationConfig{Enabled: true, SourceLabel: "cluster", LocalName: "local-cluster"},
+		backends:    []federationBackend{spoke, {FederationBackend: FederationBackend{Name: "local-cluster"}, handler: local}},
+		next:        local,
+		sharedToken: true,
+	}
+
+	send := func(method, path, token, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	silence := `{"matchers":[{"name":"cluster","value":"spoke","isEqual":true,"isRegex":false}]}`
+
+	// Users refused by the default Alertmanager never reach the spoke.
+	require.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/v2/silences", "other", ""))
+	require.Equal(t, http.StatusForbidden, send(http.MethodPost, "/api/v2/silences", "other", silence))
+	require.Equal(t, http.StatusForbidden, send(http.MethodDelete, "/api/v2/silence/1", "other", ""))
+	require.Empty(t, spokeRequests)
+
+	require.Equal(t, http.StatusOK, send(http.MethodGet, "/api/v2/silences", "user", ""))
+	require.Equal(t, http.StatusOK, send(http.MethodPost, "/api/v2/silences", "user", silence))
+	require.Equal(t, http.StatusOK, send(http.MethodDelete, "/api/v2/silence/1", "user", ""))
+	require.Equal(t, []string{"GET /api/v2/silences Bearer shared", "POST /api/v2/silences Bearer shared", "DELETE /api/v2/silence/1 Bearer shared"}, spokeRequests)
+}
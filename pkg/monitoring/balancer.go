@@ -0,0 +1,436 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type BalancingStrategy string
+
+const (
+	RoundRobin       BalancingStrategy = "round-robin"
+	LeastConnections BalancingStrategy = "least-connections"
+	// AnyHealthy sends every request to the same healthy replica until it
+	// fails. It suits Alertmanager, where replicas share state through gossip.
+	AnyHealthy BalancingStrategy = "any-healthy"
+)
+
+// UpstreamConfig lists the replicas of a datasource and how requests are
+// balanced between them.
+type UpstreamConfig struct {
+	// Endpoints are the URLs of the replicas, they must share the same path.
+	Endpoints []string `yaml:"endpoints,omitempty"`
+	// DNS discovers the replicas from A or SRV records instead.
+	DNS      *DNSDiscovery     `yaml:"dns,omitempty"`
+	Strategy BalancingStrategy `yaml:"strategy,omitempty"`
+	// HealthCheck actively probes the replicas, it is disabled without a path.
+	HealthCheck HealthCheckConfig `yaml:"healthCheck,omitempty"`
+	// Ejection removes failing replicas from the rotation for a while.
+	Ejection EjectionConfig `yaml:"ejection,omitempty"`
+}
+
+type DNSDiscovery struct {
+	// Name is resolved to its A records, or to its SRV records if SRV is set.
+	Name string `yaml:"name"`
+	SRV  bool   `yaml:"srv,omitempty"`
+	// Scheme and Port of the discovered endpoints, the port is taken from the
+	// SRV records when they are used.
+	Scheme string `yaml:"scheme,omitempty"`
+	Port   int    `yaml:"port,omitempty"`
+	// Path prefix of the discovered endpoints.
+	Path            string        `yaml:"path,omitempty"`
+	RefreshInterval time.Duration `yaml:"refreshInterval,omitempty"`
+	// ServerName is verified in the certificates of the endpoints, as the
+	// service certificates have no IP address. It defaults to Name for the A
+	// records.
+	ServerName string `yaml:"serverName,omitempty"`
+}
+
+// serverName returns the name verified in the certificates of the discovered
+// endpoints, or an empty string to verify their host.
+func (d *DNSDiscovery) serverName() string {
+	switch {
+	case d == nil:
+		return ""
+	case d.ServerName != "" || d.SRV:
+		return d.ServerName
+	default:
+		return d.Name
+	}
+}
+
+type HealthCheckConfig struct {
+	Path     string        `yaml:"path,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	Timeout  time.Duration `yaml:"timeout,omitempty"`
+}
+
+type EjectionConfig struct {
+	// ConsecutiveFailures ejects a replica after that many failed requests.
+	ConsecutiveFailures int           `yaml:"consecutiveFailures,omitempty"`
+	Duration            time.Duration `yaml:"duration,omitempty"`
+}
+
+func (c UpstreamConfig) withDefaults() UpstreamConfig {
+	if c.Strategy == "" {
+		c.Strategy = RoundRobin
+	}
+	if c.DNS != nil {
+		dns := *c.DNS
+		if dns.Scheme == "" {
+			dns.Scheme = "https"
+		}
+		if dns.RefreshInterval == 0 {
+			dns.RefreshInterval = 30 * time.Second
+		}
+		c.DNS = &dns
+	}
+	if c.HealthCheck.Interval == 0 {
+		c.HealthCheck.Interval = 10 * time.Second
+	}
+	if c.HealthCheck.Timeout == 0 {
+		c.HealthCheck.Timeout = 5 * time.Second
+	}
+	if c.Ejection.ConsecutiveFailures == 0 {
+		c.Ejection.ConsecutiveFailures = 3
+	}
+	if c.Ejection.Duration == 0 {
+		c.Ejection.Duration = 30 * time.Second
+	}
+	return c
+}
+
+// endpoint is a single replica of an upstream.
+type endpoint struct {
+	url          *url.URL
+	active       atomic.Int64
+	mu           sync.Mutex
+	healthy      bool
+	failures     int
+	ejectedUntil time.Time
+}
+
+func (e *endpoint) available(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.healthy && now.After(e.ejectedUntil)
+}
+
+// balancer is a RoundTripper sending each request to one of the replicas of an
+// upstream. Idempotent requests failing with a connection error or a 502, 503
+// or 504 status are retried on the other replicas.
+type balancer struct {
+	kind   KindType
+	config UpstreamConfig
+	next   http.RoundTripper
+	probes http.RoundTripper
+	now    func() time.Time
+
+	mu        sync.RWMutex
+	endpoints []*endpoint
+	counter   atomic.Uint64
+	current   atomic.Int64
+}
+
+// ExternalURL returns the URL of the first replica of a datasource, for the
+// links pointing to it. It is empty when the replicas are only discovered
+// through DNS.
+func ExternalURL(kind KindType, proxyUrl string, config ProxyConfig) (*url.URL, error) {
+	endpoints := upstreamEndpoints(proxyUrl, config.Upstreams[kind])
+	if len(endpoints) == 0 {
+		return &url.URL{}, nil
+	}
+	return url.Parse(endpoints[0])
+}
+
+// upstreamEndpoints returns the configured replicas of an upstream, falling back
+// to the comma separated URLs of the datasource flag.
+func upstreamEndpoints(proxyUrl string, config UpstreamConfig) []string {
+	if len(config.Endpoints) > 0 || config.DNS != nil {
+		return config.Endpoints
+	}
+	var endpoints []string
+	for _, u := range strings.Split(proxyUrl, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			endpoints = append(endpoints, u)
+		}
+	}
+	return endpoints
+}
+
+func newBalancer(ctx context.Context, kind KindType, endpoints []string, config UpstreamConfig, next http.RoundTripper, probes http.RoundTripper) (*balancer, error) {
+	b := &balancer{
+		kind:   kind,
+		config: config.withDefaults(),
+		next:   next,
+		probes: probes,
+		now:    time.Now,
+	}
+	if err := b.setEndpoints(endpoints); err != nil {
+		return nil, err
+	}
+
+	if b.config.DNS != nil {
+		if err := b.resolve(ctx); err != nil {
+			return nil, err
+		}
+		go b.refreshLoop(ctx)
+	}
+	if b.config.HealthCheck.Path != "" {
+		go b.healthCheckLoop(ctx)
+	}
+	return b, nil
+}
+
+// setEndpoints replaces the replicas, keeping the state of the known ones.
+func (b *balancer) setEndpoints(urls []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	known := map[string]*endpoint{}
+	for _, e := range b.endpoints {
+		known[e.url.String()] = e
+	}
+
+	endpoints := make([]*endpoint, 0, len(urls))
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("invalid %s endpoint %q: %w", b.kind, u, err)
+		}
+		e, ok := known[parsed.String()]
+		if !ok {
+			e = &endpoint{url: parsed, healthy: true}
+			endpointHealthy.WithLabelValues(string(b.kind), parsed.Host).Set(1)
+		}
+		endpoints = append(endpoints, e)
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].url.String() < endpoints[j].url.String() })
+
+	b.endpoints = endpoints
+	return nil
+}
+
+func (b *balancer) resolve(ctx context.Context) error {
+	dns := b.config.DNS
+	var hosts []string
+	if dns.SRV {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", dns.Name)
+		if err != nil {
+			return fmt.Errorf("cannot resolve SRV records of %s: %w", dns.Name, err)
+		}
+		for _, r := range records {
+			hosts = append(hosts, net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))))
+		}
+	} else {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, dns.Name)
+		if err != nil {
+			return fmt.Errorf("cannot resolve %s: %w", dns.Name, err)
+		}
+		for _, a := range addrs {
+			hosts = append(hosts, net.JoinHostPort(a, strconv.Itoa(dns.Port)))
+		}
+	}
+	if len(hosts) == 0 {
+		return fmt.Errorf("no %s endpoints found for %s", b.kind, dns.Name)
+	}
+
+	urls := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		urls = append(urls, (&url.URL{Scheme: dns.Scheme, Host: h, Path: dns.Path}).String())
+	}
+	return b.setEndpoints(urls)
+}
+
+func (b *balancer) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(b.config.DNS.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.resolve(ctx); err != nil {
+				log.WithError(err).Warnf("cannot refresh %s endpoints", b.kind)
+			}
+		}
+	}
+}
+
+func (b *balancer) healthCheckLoop(ctx context.Context) {
+	ticker := time.NewTicker(b.config.HealthCheck.Interval)
+	defer ticker.Stop()
+	for {
+		b.mu.RLock()
+		endpoints := append([]*endpoint(nil), b.endpoints...)
+		b.mu.RUnlock()
+
+		for _, e := range endpoints {
+			b.setHealthy(e, b.probe(ctx, e))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *balancer) probe(ctx context.Context, e *endpoint) bool {
+	ctx, cancel := context.WithTimeout(ctx, b.config.HealthCheck.Timeout)
+	defer cancel()
+
+	target := e.url.JoinPath(b.config.HealthCheck.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := b.probes.RoundTrip(req)
+	if err != nil {
+		log.WithError(err).Debugf("%s endpoint %s health check failed", b.kind, e.url.Host)
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
+
+func (b *balancer) setHealthy(e *endpoint, healthy bool) {
+	e.mu.Lock()
+	changed := e.healthy != healthy
+	e.healthy = healthy
+	e.mu.Unlock()
+
+	if changed {
+		log.Infof("%s endpoint %s is now healthy=%t", b.kind, e.url.Host, healthy)
+	}
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	endpointHealthy.WithLabelValues(string(b.kind), e.url.Host).Set(value)
+}
+
+// record tracks the outcome of a request for passive ejection.
+func (b *balancer) record(e *endpoint, success bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if success {
+		e.failures = 0
+		return
+	}
+	e.failures++
+	if e.failures >= b.config.Ejection.ConsecutiveFailures {
+		log.Warnf("ejecting %s endpoint %s for %s after %d failures", b.kind, e.url.Host, b.config.Ejection.Duration, e.failures)
+		e.ejectedUntil = b.now().Add(b.config.Ejection.Duration)
+		e.failures = 0
+	}
+}
+
+// pick returns the replicas in the order they should be tried. Replicas which
+// are unhealthy or ejected come last, so that they are only used when nothing
+// else is left.
+func (b *balancer) pick() []*endpoint {
+	b.mu.RLock()
+	endpoints := append([]*endpoint(nil), b.endpoints...)
+	b.mu.RUnlock()
+
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	var offset int
+	switch b.config.Strategy {
+	case AnyHealthy:
+		offset = int(b.current.Load()) % len(endpoints)
+	default:
+		offset = int(b.counter.Add(1)-1) % len(endpoints)
+	}
+	ordered := append(endpoints[offset:], endpoints[:offset]...)
+
+	now := b.now()
+	var available, unavailable []*endpoint
+	for _, e := range ordered {
+		if e.available(now) {
+			available = append(available, e)
+		} else {
+			unavailable = append(unavailable, e)
+		}
+	}
+	if b.config.Strategy == LeastConnections {
+		sort.SliceStable(available, func(i, j int) bool {
+			return available[i].active.Load() < available[j].active.Load()
+		})
+	}
+	return append(available, unavailable...)
+}
+
+func (b *balancer) RoundTrip(req *http.Request) (*http.Response, error) {
+	candidates := b.pick()
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no %s endpoints available", b.kind)
+	}
+	if !isIdempotent(req) {
+		candidates = candidates[:1]
+	}
+
+	var (
+		resp *http.Response
+		err  error
+	)
+	for i, e := range candidates {
+		out := req.Clone(req.Context())
+		out.URL.Scheme = e.url.Scheme
+		out.URL.Host = e.url.Host
+		if i > 0 && req.GetBody != nil {
+			if out.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		e.active.Add(1)
+		resp, err = b.next.RoundTrip(out)
+		e.active.Add(-1)
+
+		if req.Context().Err() != nil {
+			return resp, err
+		}
+		failed := err != nil || isRetryableStatus(resp.StatusCode)
+		b.record(e, !failed)
+		if !failed {
+			if b.config.Strategy == AnyHealthy {
+				b.stick(e)
+			}
+			return resp, nil
+		}
+		if i < len(candidates)-1 {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			log.WithError(err).Debugf("%s endpoint %s failed, trying the next replica", b.kind, e.url.Host)
+		}
+	}
+	return resp, err
+}
+
+// stick makes e the replica used by the AnyHealthy strategy.
+func (b *balancer) stick(e *endpoint) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for i, known := range b.endpoints {
+		if known == e {
+			b.current.Store(int64(i))
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package monitoring
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBalancer(t *testing.T) {
+	down := map[string]bool{}
+	var hosts []string
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		hosts = append(hosts, req.URL.Host)
+		if down[req.URL.Host] {
+			return nil, errors.New("connection refused")
+		}
+		return httptest.NewRecorder().Result(), nil
+	})
+
+	newTestBalancer := func(strategy BalancingStrategy) *balancer {
+		b, err := newBalancer(context.Background(), AlertManagerKind, []string{"https://am-0:9095", "https://am-1:9095", "https://am-2:9095"},
+			UpstreamConfig{Strategy: strategy, Ejection: EjectionConfig{ConsecutiveFailures: 1}}, next, next)
+		require.NoError(t, err)
+		return b
+	}
+	roundTrip := func(b *balancer, method string) {
+		_, err := b.RoundTrip(httptest.NewRequest(method, "https://am-0:9095/api/v2/alerts", nil))
+		require.NoError(t, err)
+	}
+
+	b := newTestBalancer(RoundRobin)
+	for i := 0; i < 3; i++ {
+		roundTrip(b, http.MethodGet)
+	}
+	require.Equal(t, []string{"am-0:9095", "am-1:9095", "am-2:9095"}, hosts)
+
+	// A failing replica is skipped and then ejected.
+	hosts = nil
+	down["am-0:9095"] = true
+	roundTrip(b, http.MethodGet)
+	roundTrip(b, http.MethodGet)
+	roundTrip(b, http.MethodGet)
+	require.Equal(t, []string{"am-0:9095", "am-1:9095", "am-1:9095", "am-2:9095"}, hosts)
+
+	// Any healthy replica is used until it fails.
+	hosts = nil
+	down = map[string]bool{}
+	b = newTestBalancer(AnyHealthy)
+	roundTrip(b, http.MethodGet)
+	roundTrip(b, http.MethodGet)
+	down["am-0:9095"] = true
+	roundTrip(b, http.MethodGet)
+	roundTrip(b, http.MethodGet)
+	require.Equal(t, []string{"am-0:9095", "am-0:9095", "am-0:9095", "am-1:9095", "am-1:9095"}, hosts)
+}
+
+func TestExternalURL(t *testing.T) {
+	u, err := ExternalURL(AlertManagerKind, "https://am-0.am:9095,https://am-1.am:9095", ProxyConfig{})
+	require.NoError(t, err)
+	require.Equal(t, "https://am-0.am:9095", u.String())
+
+	config := ProxyConfig{Upstreams: map[KindType]UpstreamConfig{AlertManagerKind: {Endpoints: []string{"https://am.example:9095"}}}}
+	u, err = ExternalURL(AlertManagerKind, "https://ignored:9095", config)
+	require.NoError(t, err)
+	require.Equal(t, "https://am.example:9095", u.String())
+}
+
+func TestDNSServerName(t *testing.T) {
+	var none *DNSDiscovery
+	require.Empty(t, none.serverName())
+	// The A records are IP addresses, the certificates have the service name.
+	require.Equal(t, "thanos.ns.svc", (&DNSDiscovery{Name: "thanos.ns.svc"}).serverName())
+	require.Equal(t, "thanos.example.com", (&DNSDiscovery{Name: "thanos.ns.svc", ServerName: "thanos.example.com"}).serverName())
+	require.Empty(t, (&DNSDiscovery{Name: "_web._tcp.thanos.ns.svc", SRV: true}).serverName())
+}
@@ -0,0 +1,438 @@
+package monitoring
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// CacheHeader reports whether a response was served from the proxy cache.
+const CacheHeader = "X-Monitoring-Plugin-Cache"
+
+const (
+	// maxCachePoints is the limit of points per series of the Prometheus API,
+	// the larger range queries are rejected upstream.
+	maxCachePoints = 11000
+	// maxCacheChunks bounds the chunks of a range query, the longer ranges
+	// are passed through uncached.
+	maxCacheChunks = 1000
+)
+
+// CacheConfig configures the in-process cache of Thanos query responses.
+type CacheConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// TTL is the lifetime of a cached response.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+	// MaxSizeBytes bounds the memory used by cached responses.
+	MaxSizeBytes int64 `yaml:"maxSizeBytes,omitempty"`
+	// ChunkInterval is the length of the step aligned windows range queries are
+	// split into, so that overlapping windows reuse cached chunks.
+	ChunkInterval time.Duration `yaml:"chunkInterval,omitempty"`
+	// MaxFreshness is the age below which samples are never cached, as the
+	// most recent data may still change.
+	MaxFreshness time.Duration `yaml:"maxFreshness,omitempty"`
+}
+
+func (c CacheConfig) withDefaults() CacheConfig {
+	if c.TTL == 0 {
+		c.TTL = 5 * time.Minute
+	}
+	if c.MaxSizeBytes == 0 {
+		c.MaxSizeBytes = 64 << 20
+	}
+	if c.ChunkInterval == 0 {
+		c.ChunkInterval = time.Hour
+	}
+	if c.MaxFreshness == 0 {
+		c.MaxFreshness = 10 * time.Minute
+	}
+	return c
+}
+
+// responseCache is a size bounded LRU cache with expiring entries.
+type responseCache struct {
+	mu      sync.Mutex
+	kind    string
+	ttl     time.Duration
+	maxSize int64
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newResponseCache(kind string, maxSize int64, ttl time.Duration) *responseCache {
+	return &responseCache{
+		kind:    kind,
+		ttl:     ttl,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+		now:     time.Now,
+	}
+}
+
+func (c *responseCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		cacheRequests.WithLabelValues(c.kind, "miss").Inc()
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if c.now().After(entry.expires) {
+		c.remove(elem)
+		cacheRequests.WithLabelValues(c.kind, "miss").Inc()
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	cacheRequests.WithLabelValues(c.kind, "hit").Inc()
+	return entry.value, true
+}
+
+func (c *responseCache) set(key string, value []byte) {
+	if int64(len(value)) > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	entry := &cacheEntry{key: key, value: value, expires: c.now().Add(c.ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += int64(len(value))
+
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+	c.updateMetrics()
+}
+
+func (c *responseCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.value))
+	c.updateMetrics()
+}
+
+// clear removes every entry of the cache.
+func (c *responseCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.entries = map[string]*list.Element{}
+	c.size = 0
+	c.updateMetrics()
+}
+
+func (c *responseCache) updateMetrics() {
+	cacheSizeBytes.WithLabelValues(c.kind).Set(float64(c.size))
+	cacheEntries.WithLabelValues(c.kind).Set(float64(len(c.entries)))
+}
+
+// queryCache serves Thanos instant and range queries from a responseCache.
+// Range queries are aligned to their step and split in chunks of
+// ChunkInterval, only the chunks missing from the cache are queried upstream.
+type queryCache struct {
+	config CacheConfig
+	store  *responseCache
+	next   http.Handler
+	now    func() time.Time
+}
+
+func cacheMiddleware(config CacheConfig, next http.Handler) http.Handler {
+	if !config.Enabled {
+		return next
+	}
+	config = config.withDefaults()
+
+	return &queryCache{
+		config: config,
+		store:  newResponseCache(string(ThanosQuerierKind), config.MaxSizeBytes, config.TTL),
+		next:   next,
+		now:    time.Now,
+	}
+}
+
+func (c *queryCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, queryRangePath):
+		c.serveRange(w, r)
+	case strings.HasSuffix(r.URL.Path, queryPath):
+		c.serveInstant(w, r)
+	default:
+		c.next.ServeHTTP(w, r)
+	}
+}
+
+func (c *queryCache) serveInstant(w http.ResponseWriter, r *http.Request) {
+	params, err := requestParams(r)
+	if err != nil {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	// Queries without time are evaluated at the current time, and recent
+	// samples may still change.
+	evalTime, err := parseTime(params.Get("time"))
+	if err != nil || !evalTime.Before(c.now().Add(-c.config.MaxFreshness)) {
+		c.next.ServeHTTP(w, subRequest(r, params))
+		return
+	}
+
+	key := cacheKey(r, params)
+	if body, ok := c.store.get(key); ok {
+		w.Header().Set(CacheHeader, "hit")
+		writeJSON(w, body)
+		return
+	}
+
+	resp := newBufferedResponse()
+	c.next.ServeHTTP(resp, subRequest(r, params))
+
+	if resp.statusCode() == http.StatusOK {
+		var decoded apiResponse
+		if err := json.Unmarshal(resp.body.Bytes(), &decoded); err == nil && decoded.Status == "success" && len(decoded.Warnings) == 0 {
+			c.store.set(key, resp.body.Bytes())
+		}
+	}
+	resp.header.Set(CacheHeader, "miss")
+	resp.copyTo(w)
+}
+
+// rangeChunk is a step aligned part of a range query, start and end are
+// inclusive unix timestamps in milliseconds.
+type rangeChunk struct {
+	start     int64
+	end       int64
+	key       string
+	cacheable bool
+	cached    bool
+	result    []series
+}
+
+func (c *queryCache) serveRange(w http.ResponseWriter, r *http.Request) {
+	params, err := requestParams(r)
+	if err != nil {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	rng, err := parseRangeParams(params)
+	if err != nil || rng.step < time.Millisecond || rng.end.Sub(rng.start)/rng.step > maxCachePoints {
+		// Let Thanos report invalid parameters.
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	chunks := c.splitRange(r, params, rng)
+	if chunks == nil {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	hits := 0
+	for i := range chunks {
+		if !chunks[i].cacheable {
+			continue
+		}
+		body, ok := c.store.get(chunks[i].key)
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(body, &chunks[i].result); err != nil {
+			continue
+		}
+		chunks[i].cached = true
+		hits++
+	}
+
+	var warnings, infos []string
+	for i := 0; i < len(chunks); {
+		if chunks[i].cached {
+			i++
+			continue
+		}
+		// Query consecutive missing chunks at once.
+		j := i
+		for j+1 < len(chunks) && !chunks[j+1].cached {
+			j++
+		}
+
+		resp, result, upstream := fetchRange(c.next, r, params, chunks[i].start, chunks[j].end)
+		if resp == nil {
+			upstream.copyTo(w)
+			return
+		}
+		warnings = append(warnings, resp.Warnings...)
+		infos = append(infos, resp.Infos...)
+
+		for k := i; k <= j; k++ {
+			chunks[k].result = sliceMatrix(result, msToSeconds(chunks[k].start), msToSeconds(chunks[k].end))
+			if chunks[k].cacheable && len(resp.Warnings) == 0 {
+				if encoded, err := json.Marshal(chunks[k].result); err == nil {
+					c.store.set(chunks[k].key, encoded)
+				}
+			}
+		}
+		i = j + 1
+	}
+
+	results := make([][]series, 0, len(chunks))
+	for _, chunk := range chunks {
+		results = append(results, chunk.result)
+	}
+	body, err := encodeMatrix(mergeMatrices(results...), warnings, infos)
+	if err != nil {
+		writePrometheusError(w, http.StatusInternalServerError, errorInternal, err.Error())
+		return
+	}
+
+	switch {
+	case hits == len(chunks):
+		w.Header().Set(CacheHeader, "hit")
+	case hits > 0:
+		w.Header().Set(CacheHeader, "partial")
+	default:
+		w.Header().Set(CacheHeader, "miss")
+	}
+	writeJSON(w, body)
+}
+
+// splitRange aligns the range to multiples of the step and splits it in
+// chunks of ChunkInterval. Only chunks fully covered by the range and older
+// than MaxFreshness can be cached. It returns nil above maxCacheChunks.
+func (c *queryCache) splitRange(r *http.Request, params url.Values, rng queryRange) []rangeChunk {
+	step := rng.step.Milliseconds()
+	interval := c.config.ChunkInterval.Milliseconds()
+	start := floorTo(rng.start.UnixMilli(), step)
+	end := floorTo(rng.end.UnixMilli(), step)
+	fresh := c.now().Add(-c.config.MaxFreshness).UnixMilli()
+
+	if (floorTo(end, interval)-floorTo(start, interval))/interval >= maxCacheChunks {
+		return nil
+	}
+	base := cacheKey(r, params, "start", "end")
+
+	var chunks []rangeChunk
+	for cs := floorTo(start, interval); cs <= end; cs += interval {
+		first := ceilTo(cs, step)
+		last := floorTo(cs+interval-1, step)
+		s, e := max(start, first), min(end, last)
+		if s > e {
+			continue
+		}
+		chunks = append(chunks, rangeChunk{
+			start:     s,
+			end:       e,
+			key:       fmt.Sprintf("%s|%d|%d", base, s, e),
+			cacheable: s == first && e == last && e < fresh,
+		})
+	}
+	return chunks
+}
+
+// fetchRange queries the [start, end] range upstream. When the upstream
+// response is not a successful matrix, it is returned as is with a nil
+// apiResponse.
+func fetchRange(next http.Handler, r *http.Request, params url.Values, start, end int64) (*apiResponse, []series, *bufferedResponse) {
+	sub := url.Values{}
+	for k, v := range params {
+		sub[k] = v
+	}
+	sub.Set("start", strconv.FormatFloat(msToSeconds(start), 'f', -1, 64))
+	sub.Set("end", strconv.FormatFloat(msToSeconds(end), 'f', -1, 64))
+
+	resp := newBufferedResponse()
+	next.ServeHTTP(resp, subRequest(r, sub))
+	if resp.statusCode() != http.StatusOK {
+		return nil, nil, resp
+	}
+
+	decoded, result, err := decodeMatrix(resp.body.Bytes())
+	if err != nil {
+		log.WithError(err).Debug("cannot decode range query response")
+		return nil, nil, resp
+	}
+	return decoded, result, resp
+}
+
+// cacheKey identifies a request by tenant, path and normalized parameters,
+// leaving out the excluded parameters.
+func cacheKey(r *http.Request, params url.Values, exclude ...string) string {
+	normalized := url.Values{}
+	for k, v := range params {
+		normalized[k] = v
+	}
+	for _, k := range exclude {
+		normalized.Del(k)
+	}
+	if q := normalized.Get("query"); q != "" {
+		normalized.Set("query", normalizeQuery(q))
+	}
+	return tenantKey(r) + "|" + r.URL.Path + "?" + normalized.Encode()
+}
+
+// tenantKey identifies the credentials of a request, every header forwarded
+// upstream on behalf of the user, so that responses are never shared between
+// users.
+func tenantKey(r *http.Request) string {
+	h := sha256.New()
+	for _, header := range credentialHeaders {
+		h.Write([]byte(r.Header.Get(header)))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func normalizeQuery(query string) string {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return strings.TrimSpace(query)
+	}
+	return expr.String()
+}
+
+func floorTo(v, m int64) int64 {
+	r := v % m
+	if r < 0 {
+		r += m
+	}
+	return v - r
+}
+
+func ceilTo(v, m int64) int64 {
+	f := floorTo(v, m)
+	if f == v {
+		return v
+	}
+	return f + m
+}
+
+func msToSeconds(ms int64) float64 {
+	return float64(ms) / 1000
+}
@@ -0,0 +1,167 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRangeUpstream answers range queries with a single series whose value at
+// every step is its timestamp, and records the requested ranges.
+type fakeRangeUpstream struct {
+	requests []url.Values
+}
+
+func (f *fakeRangeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	f.requests = append(f.requests, r.Form)
+
+	if r.URL.Path == queryPath {
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+		return
+	}
+
+	start, _ := strconv.ParseFloat(r.Form.Get("start"), 64)
+	end, _ := strconv.ParseFloat(r.Form.Get("end"), 64)
+	step, _ := strconv.ParseFloat(r.Form.Get("step"), 64)
+
+	s := series{Metric: map[string]string{"__name__": "up"}}
+	for t := start; t <= end; t += step {
+		s.Values = append(s.Values, samplePair{T: t, V: json.RawMessage(strconv.Quote(strconv.FormatFloat(t, 'f', -1, 64)))})
+	}
+	body, _ := encodeMatrix([]series{s}, nil, nil)
+	w.Write(body)
+}
+
+func rangeRequest(start, end int, auth string) *http.Request {
+	params := url.Values{
+		"query": {"up"},
+		"start": {strconv.Itoa(start)},
+		"end":   {strconv.Itoa(end)},
+		"step":  {"300"},
+	}
+	req := httptest.NewRequest(http.MethodGet, queryRangePath+"?"+params.Encode(), nil)
+	req.Header.Set("Authorization", auth)
+	return req
+}
+
+func TestQueryCacheRange(t *testing.T) {
+	upstream := &fakeRangeUpstream{}
+	handler := cacheMiddleware(CacheConfig{Enabled: true}, upstream).(*queryCache)
+	handler.now = func() time.Time { return time.Unix(100000, 0) }
+
+	// 0 to 6h, the chunks older than 10 minutes are cached.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, rangeRequest(0, 6*3600, "Bearer a"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "miss", rec.Header().Get(CacheHeader))
+	require.Len(t, upstream.requests, 1)
+
+	_, result, err := decodeMatrix(rec.Body.Bytes())
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	require.Len(t, result[0].Values, 6*12+1)
+
+	// Shifting the window by one hour only queries the new hour and the
+	// partial chunk at the end.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, rangeRequest(3600, 7*3600, "Bearer a"))
+	require.Equal(t, "partial", rec.Header().Get(CacheHeader))
+	require.Len(t, upstream.requests, 2)
+	require.Equal(t, "21600", upstream.requests[1].Get("start"))
+	require.Equal(t, "25200", upstream.requests[1].Get("end"))
+
+	_, result, err = decodeMatrix(rec.Body.Bytes())
+	require.NoError(t, err)
+	require.Len(t, result[0].Values, 6*12+1)
+	require.Equal(t, float64(3600), result[0].Values[0].T)
+	require.Equal(t, float64(7*3600), result[0].Values[len(result[0].Values)-1].T)
+
+	// Another tenant never gets the cached chunks.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, rangeRequest(3600, 7*3600, "Bearer b"))
+	require.Equal(t, "miss", rec.Header().Get(CacheHeader))
+	require.Len(t, upstream.requests, 3)
+
+	// Ranges of too many points or chunks are passed through uncached.
+	for _, params := range []url.Values{
+		{"query": {"up"}, "start": {"0"}, "end": {"1e12"}, "step": {"1e9"}},
+		{"query": {"up"}, "start": {"0"}, "end": {"86400"}, "step": {"1"}},
+	} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryRangePath+"?"+params.Encode(), nil))
+		require.Empty(t, rec.Header().Get(CacheHeader))
+	}
+	require.Len(t, upstream.requests, 5)
+}
+
+func TestQueryCacheInstant(t *testing.T) {
+	upstream := &fakeRangeUpstream{}
+	handler := cacheMiddleware(CacheConfig{Enabled: true, TTL: time.Minute}, upstream).(*queryCache)
+	now := time.Unix(100000, 0)
+	handler.store.now = func() time.Time { return now }
+	handler.now = func() time.Time { return now }
+
+	evalTime := "90000"
+	get := func(query string) *httptest.ResponseRecorder {
+		params := url.Values{"query": {query}}
+		if evalTime != "" {
+			params.Set("time", evalTime)
+		}
+		req := httptest.NewRequest(http.MethodGet, queryPath+"?"+params.Encode(), nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, "miss", get("sum(up)").Header().Get(CacheHeader))
+	// The query is normalized before the lookup.
+	require.Equal(t, "hit", get("sum( up )").Header().Get(CacheHeader))
+	require.Len(t, upstream.requests, 1)
+
+	now = now.Add(2 * time.Minute)
+	require.Equal(t, "miss", get("sum(up)").Header().Get(CacheHeader))
+	require.Len(t, upstream.requests, 2)
+
+	// Queries of the current time and of recent samples are not cached.
+	for _, evalTime = range []string{"", strconv.Itoa(int(now.Unix()) - 60)} {
+		require.Empty(t, get("sum(up)").Header().Get(CacheHeader))
+		require.Empty(t, get("sum(up)").Header().Get(CacheHeader))
+	}
+	require.Len(t, upstream.requests, 6)
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	cache := newResponseCache("test", 10, time.Minute)
+	cache.set("a", []byte("12345"))
+	cache.set("b", []byte("12345"))
+	_, ok := cache.get("a")
+	require.True(t, ok)
+
+	// "b" is the least recently used entry.
+	cache.set("c", []byte("12345"))
+	_, ok = cache.get("b")
+	require.False(t, ok)
+	_, ok = cache.get("a")
+	require.True(t, ok)
+	require.Equal(t, int64(10), cache.size)
+}
+
+func TestTenantKey(t *testing.T) {
+	keys := map[string]bool{}
+	for _, header := range credentialHeaders {
+		for _, value := range []string{"alice", "bob"} {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+			r.Header.Set(header, value)
+			keys[tenantKey(r)] = true
+		}
+	}
+	keys[tenantKey(httptest.NewRequest(http.MethodGet, "/api/v1/query", nil))] = true
+	require.Len(t, keys, 2*len(credentialHeaders)+1)
+}
@@ -0,0 +1,138 @@
+package monitoring
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// maxDedupBytes bounds the response shared with the waiting requests, a larger
+// response is only streamed to the request that started it and every waiter
+// sends its own upstream request instead.
+const maxDedupBytes = 1 << 20
+
+// dedupMiddleware coalesces identical concurrent GET requests of the same
+// tenant and feature, so that only one of them reaches the upstream. The
+// response is streamed to the request that started it and buffered for the
+// waiters, including its status and headers, as long as it fits in
+// maxDedupBytes.
+func dedupMiddleware(enabled bool, next http.Handler) http.Handler {
+	if !enabled {
+		return next
+	}
+
+	d := &dedup{next: next, calls: map[string]*dedupCall{}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The query limits depend on the feature of the request.
+		key := r.Method + " " + r.URL.RequestURI() + "|" + r.Header.Get("Accept") + "|" + r.Header.Get(FeatureHeader) + "|" + tenantKey(r)
+		d.serve(w, r, key)
+	})
+}
+
+type dedup struct {
+	next http.Handler
+
+	mu    sync.Mutex
+	calls map[string]*dedupCall
+}
+
+// dedupCall is an upstream request in flight, done is closed once resp holds
+// the complete response or is known to exceed maxDedupBytes, in which case
+// resp stays nil.
+type dedupCall struct {
+	done chan struct{}
+	once sync.Once
+	resp *bufferedResponse
+}
+
+func (d *dedup) serve(w http.ResponseWriter, r *http.Request, key string) {
+	d.mu.Lock()
+	if call, ok := d.calls[key]; ok {
+		d.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-r.Context().Done():
+			return
+		}
+		if call.resp == nil {
+			d.next.ServeHTTP(w, r)
+			return
+		}
+		log.Debugf("deduplicated %s %s", r.Method, r.URL.Path)
+		call.resp.copyTo(w)
+		return
+	}
+	call := &dedupCall{done: make(chan struct{})}
+	d.calls[key] = call
+	d.mu.Unlock()
+
+	finish := func(resp *bufferedResponse) {
+		call.once.Do(func() {
+			d.mu.Lock()
+			delete(d.calls, key)
+			d.mu.Unlock()
+			call.resp = resp
+			close(call.done)
+		})
+	}
+	defer finish(nil)
+
+	// The upstream request must outlive the client that started it while
+	// other clients may be waiting for its response.
+	sub := r.Clone(context.WithoutCancel(r.Context()))
+	sub.Header.Del("Accept-Encoding")
+	tee := &teeResponse{ResponseWriter: w, buffer: newBufferedResponse(), overflow: func() { finish(nil) }}
+	d.next.ServeHTTP(tee, sub)
+	if tee.buffer != nil {
+		finish(tee.buffer)
+	}
+}
+
+// teeResponse writes the response to the client and copies it to buffer until
+// it exceeds maxDedupBytes, when overflow is called and buffer dropped.
+type teeResponse struct {
+	http.ResponseWriter
+	buffer   *bufferedResponse
+	overflow func()
+}
+
+func (t *teeResponse) WriteHeader(status int) {
+	if t.buffer != nil {
+		for k, v := range t.ResponseWriter.Header() {
+			t.buffer.header[k] = v
+		}
+		t.buffer.WriteHeader(status)
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *teeResponse) Write(data []byte) (int, error) {
+	if t.buffer != nil {
+		if t.buffer.status == 0 {
+			t.WriteHeader(http.StatusOK)
+		}
+		if t.buffer.body.Len()+len(data) > maxDedupBytes {
+			t.buffer = nil
+			t.overflow()
+		} else {
+			t.buffer.Write(data)
+		}
+	}
+	n, err := t.ResponseWriter.Write(data)
+	if err != nil && t.buffer != nil {
+		// The client of the request is gone, the upstream response is still
+		// read for the waiters.
+		return len(data), nil
+	}
+	return n, err
+}
+
+func (t *teeResponse) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,67 @@
+package monitoring
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupMiddleware(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		size     int
+		features []string
+		calls    int32
+	}{
+		{name: "shared response", size: 1024, features: []string{"metrics", "metrics"}, calls: 1},
+		{name: "response over the limit", size: maxDedupBytes + 1, features: []string{"metrics", "metrics"}, calls: 2},
+		{name: "different features", size: 1024, features: []string{"metrics", "alerting"}, calls: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := bytes.Repeat([]byte("x"), tc.size)
+			started := make(chan struct{})
+			release := make(chan struct{})
+			var calls atomic.Int32
+			handler := dedupMiddleware(true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					close(started)
+					<-release
+				}
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(http.StatusAccepted)
+				w.Write(body)
+			}))
+
+			var wg sync.WaitGroup
+			recs := []*httptest.ResponseRecorder{httptest.NewRecorder(), httptest.NewRecorder()}
+			request := func(i int) {
+				defer wg.Done()
+				req := httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil)
+				req.Header.Set(FeatureHeader, tc.features[i])
+				handler.ServeHTTP(recs[i], req)
+			}
+			wg.Add(1)
+			go request(0)
+			<-started
+			wg.Add(1)
+			go request(1)
+			// Let the second request wait for the first one.
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			require.Equal(t, tc.calls, calls.Load())
+			for _, rec := range recs {
+				require.Equal(t, http.StatusAccepted, rec.Code)
+				require.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+				require.Equal(t, tc.size, rec.Body.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// FederationConfig configures the fan-out of Thanos queries to several
+// Prometheus compatible backends, e.g. the Thanos Queriers of other clusters.
+type FederationConfig struct {
+	Enabled  bool                `yaml:"enabled,omitempty"`
+	Backends []FederationBackend `yaml:"backends,omitempty"`
+	// ClusterLabel is added to every series with the name of its backend,
+	// unless the series already has it.
+	ClusterLabel string `yaml:"clusterLabel,omitempty"`
+	// LocalName is the backend name of the default upstream, which is
+	// queried with the backends. Defaults to local-cluster.
+	LocalName string `yaml:"localName,omitempty"`
+}
+
+type FederationBackend struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// CAFile is the CA bundle of the backend, defaults to the service CA.
+	CAFile string `yaml:"caFile,omitempty"`
+	// BearerTokenFile replaces the credentials of the user, for backends
+	// which do not trust the tokens of this cluster. Every user allowed by
+	// the default upstream shares the access of the token to the backend,
+	// the requests it fails to serve get no results of the backends.
+	BearerTokenFile string `yaml:"bearerTokenFile,omitempty"`
+}
+
+var federatedPaths = []string{
+	queryPath,
+	queryRangePath,
+	"/api/v1/series",
+	"/api/v1/labels",
+}
+
+func isFederatedPath(path string) bool {
+	for _, p := range federatedPaths {
+		if strings.HasSuffix(path, p) {
+			return true
+		}
+	}
+	return labelValuesName(path) != ""
+}
+
+// labelValuesName returns the label name of a /api/v1/label/{name}/values path.
+func labelValuesName(path string) string {
+	i := strings.LastIndex(path, "/api/v1/label/")
+	if i < 0 || !strings.HasSuffix(path, "/values") {
+		return ""
+	}
+	return strings.TrimSuffix(path[i+len("/api/v1/label/"):], "/values")
+}
+
+type federationBackend struct {
+	FederationBackend
+	handler http.Handler
+}
+
+// federation sends the query APIs to every backend and the default upstream in
+// parallel and merges the responses, other requests are sent to the default
+// upstream.
+type federation struct {
+	config   FederationConfig
+	backends []federationBackend
+	next     http.Handler
+	// sharedToken is set when a backend is queried with a bearer token file
+	// instead of the credentials of the user.
+	sharedToken bool
+}
+
+func federationHandler(config FederationConfig, serviceCAfile string, proxyConfig ProxyConfig, next http.Handler) (http.Handler, error) {
+	if !config.Enabled {
+		return next, nil
+	}
+	if len(config.Backends) == 0 {
+		return nil, fmt.Errorf("federation is enabled without any backends")
+	}
+	if config.LocalName == "" {
+		config.LocalName = "local-cluster"
+	}
+
+	// Backends get their own proxy, without the balancing of the default upstream.
+	backendConfig := ProxyConfig{Retry: proxyConfig.Retry, CircuitBreaker: proxyConfig.CircuitBreaker}
+	f := &federation{config: config, next: next}
+	for _, b := range config.Backends {
+		if b.Name == config.LocalName {
+			return nil, fmt.Errorf("federated backend %s has the name of the default upstream", b.Name)
+		}
+		caFile := b.CAFile
+		if caFile == "" {
+			caFile = serviceCAfile
+		}
+		proxy, err := createProxy(ThanosQuerierKind, []string{b.URL}, caFile, backendConfig)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create proxy for federated backend %s: %w", b.Name, err)
+		}
+		log.Infof("federating %s queries to %s at %s", ThanosQuerierKind, b.Name, b.URL)
+		f.backends = append(f.backends, federationBackend{FederationBackend: b, handler: proxy})
+		f.sharedToken = f.sharedToken || b.BearerTokenFile != ""
+	}
+	// The default upstream is queried with the credentials of the user.
+	f.backends = append(f.backends, federationBackend{FederationBackend: FederationBackend{Name: config.LocalName}, handler: next})
+	return f, nil
+}
+
+type backendResult struct {
+	name     string
+	resp     *apiResponse
+	upstream *bufferedResponse
+	err      error
+}
+
+func (f *federation) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isFederatedPath(r.URL.Path) {
+		f.next.ServeHTTP(w, r)
+		return
+	}
+
+	params, err := requestParams(r)
+	if err != nil {
+		writePrometheusError(w, http.StatusBadRequest, errorBadData, err.Error())
+		return
+	}
+
+	results := make([]backendResult, len(f.backends))
+	var wg sync.WaitGroup
+	for i, b := range f.backends {
+		wg.Add(1)
+		go func(i int, b federationBackend) {
+			defer wg.Done()
+			results[i] = f.query(r, params, b)
+		}(i, b)
+	}
+	wg.Wait()
+
+	// Users the default upstream fails to serve must not get the results of
+	// the backends queried with a shared token.
+	for _, res := range results {
+		if !f.sharedToken || res.name != f.config.LocalName || res.err == nil {
+			continue
+		}
+		if res.upstream != nil && res.upstream.statusCode() != http.StatusOK {
+			res.upstream.copyTo(w)
+			return
+		}
+		writePrometheusError(w, http.StatusBadGateway, errorUnavailable, fmt.Sprintf("backend %s failed: %v", res.name, res.err))
+		return
+	}
+
+	var (
+		warnings, infos []string
+		succeeded       []backendResult
+		lastFailure     *bufferedResponse
+	)
+	for _, res := range results {
+		if res.err != nil {
+			warnings = append(warnings, fmt.Sprintf("backend %s failed: %v", res.name, res.err))
+			lastFailure = res.upstream
+			continue
+		}
+		warnings = append(warnings, res.resp.Warnings...)
+		infos = append(infos, res.resp.Infos...)
+		succeeded = append(succeeded, res)
+	}
+
+	if len(succeeded) == 0 {
+		if lastFailure != nil && lastFailure.statusCode() != http.StatusOK {
+			lastFailure.copyTo(w)
+			return
+		}
+		writePrometheusError(w, http.StatusBadGateway, errorUnavailable, strings.Join(warnings, "; "))
+		return
+	}
+
+	data, err := f.merge(r.URL.Path, succeeded)
+	if err != nil {
+		writePrometheusError(w, http.StatusInternalServerError, errorInternal, err.Error())
+		return
+	}
+	body, err := json.Marshal(apiResponse{Status: "success", Data: data, Warnings: warnings, Infos: infos})
+	if err != nil {
+		writePrometheusError(w, http.StatusInternalServerError, errorInternal, err.Error())
+		return
+	}
+	writeJSON(w, body)
+}
+
+func (f *federation) query(r *http.Request, params url.Values, b federationBackend) backendResult {
+	sub := subRequest(r, params)
+	if b.BearerTokenFile != "" {
+		token, err := os.ReadFile(b.BearerTokenFile)
+		if err != nil {
+			return backendResult{name: b.Name, err: fmt.Errorf("cannot read bearer token: %w", err)}
+		}
+		sub.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp := newBufferedResponse()
+	b.handler.ServeHTTP(resp, sub)
+
+	var decoded apiResponse
+	if err := json.Unmarshal(resp.body.Bytes(), &decoded); err != nil {
+		return backendResult{name: b.Name, upstream: resp, err: fmt.Errorf("unexpected response with status %d", resp.statusCode())}
+	}
+	if decoded.Status != "success" {
+		return backendResult{name: b.Name, upstream: resp, err: fmt.Errorf("%s: %s", decoded.ErrorType, decoded.Error)}
+	}
+	return backendResult{name: b.Name, resp: &decoded, upstream: resp}
+}
+
+// vectorSample is a single element of a vector result.
+type vectorSample struct {
+	Metric    map[string]string `json:"metric"`
+	Value     *samplePair       `json:"value,omitempty"`
+	Histogram *samplePair       `json:"histogram,omitempty"`
+}
+
+func (f *federation) merge(path string, results []backendResult) (json.RawMessage, error) {
+	switch {
+	case strings.HasSuffix(path, queryPath), strings.HasSuffix(path, queryRangePath):
+		return f.mergeQuery(results)
+	case strings.HasSuffix(path, "/api/v1/series"):
+		return f.mergeSeries(results)
+	case strings.HasSuffix(path, "/api/v1/labels"):
+		return f.mergeStrings(results, f.config.ClusterLabel)
+	default:
+		if name := labelValuesName(path); name != "" && name == f.config.ClusterLabel {
+			var extra []string
+			for _, res := range results {
+				extra = append(extra, res.name)
+			}
+			return f.mergeStrings(results, extra...)
+		}
+		return f.mergeStrings(results)
+	}
+}
+
+func (f *federation) withCluster(metric map[string]string, name string) map[string]string {
+	if f.config.ClusterLabel == "" {
+		return metric
+	}
+	if _, ok := metric[f.config.ClusterLabel]; ok {
+		return metric
+	}
+	if metric == nil {
+		metric = map[string]string{}
+	}
+	metric[f.config.ClusterLabel] = name
+	return metric
+}
+
+func (f *federation) mergeQuery(results []backendResult) (json.RawMessage, error) {
+	var resultType string
+	var matrices [][]series
+	var vector []vectorSample
+	seen := map[string]bool{}
+
+	for _, res := range results {
+		var data queryData
+		if err := json.Unmarshal(res.resp.Data, &data); err != nil {
+			return nil, err
+		}
+		if resultType == "" {
+			resultType = data.ResultType
+		}
+		if data.ResultType != resultType {
+			return nil, fmt.Errorf("backends returned different result types %q and %q", resultType, data.ResultType)
+		}
+
+		switch data.ResultType {
+		case "matrix":
+			var m []series
+			if err := json.Unmarshal(data.Result, &m); err != nil {
+				return nil, err
+			}
+			for i := range m {
+				m[i].Metric = f.withCluster(m[i].Metric, res.name)
+			}
+			matrices = append(matrices, m)
+		case "vector":
+			var v []vectorSample
+			if err := json.Unmarshal(data.Result, &v); err != nil {
+				return nil, err
+			}
+			for _, s := range v {
+				s.Metric = f.withCluster(s.Metric, res.name)
+				key := labels.FromMap(s.Metric).String()
+				if !seen[key] {
+					seen[key] = true
+					vector = append(vector, s)
+				}
+			}
+		default:
+			// Scalars and strings do not depend on the backend.
+			return res.resp.Data, nil
+		}
+	}
+
+	var result interface{}
+	if resultType == "matrix" {
+		result = mergeMatrices(matrices...)
+	} else {
+		if vector == nil {
+			vector = []vectorSample{}
+		}
+		result = vector
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(queryData{ResultType: resultType, Result: encoded})
+}
+
+func (f *federation) mergeSeries(results []backendResult) (json.RawMessage, error) {
+	merged := []map[string]string{}
+	seen := map[string]bool{}
+	for _, res := range results {
+		var s []map[string]string
+		if err := json.Unmarshal(res.resp.Data, &s); err != nil {
+			return nil, err
+		}
+		for _, metric := range s {
+			metric = f.withCluster(metric, res.name)
+			key := labels.FromMap(metric).String()
+			if !seen[key] {
+				seen[key] = true
+				merged = append(merged, metric)
+			}
+		}
+	}
+	return json.Marshal(merged)
+}
+
+func (f *federation) mergeStrings(results []backendResult, extra ...string) (json.RawMessage, error) {
+	set := map[string]bool{}
+	for _, e := range extra {
+		if e != "" {
+			set[e] = true
+		}
+	}
+	for _, res := range results {
+		var values []string
+		if err := json.Unmarshal(res.resp.Data, &values); err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			set[v] = true
+		}
+	}
+	merged := make([]string, 0, len(set))
+	for v := range set {
+		merged = append(merged, v)
+	}
+	sort.Strings(merged)
+	return json.Marshal(merged)
+}
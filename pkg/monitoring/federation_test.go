@@ -0,0 +1,96 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func staticBackend(name string, status int, body string) federationBackend {
+	return federationBackend{
+		FederationBackend: FederationBackend{Name: name},
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}),
+	}
+}
+
+func TestFederation(t *testing.T) {
+	f := &federation{
+		config: FederationConfig{Enabled: true, ClusterLabel: "cluster", LocalName: "local-cluster"},
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+	}
+
+	serve := func(path string) (*httptest.ResponseRecorder, apiResponse) {
+		rec := httptest.NewRecorder()
+		f.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp apiResponse
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		}
+		return rec, resp
+	}
+
+	f.backends = []federationBackend{
+		staticBackend("hub", http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"a"},"value":[1,"1"]}]}}`),
+		staticBackend("spoke", http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"a"},"value":[1,"2"]},{"metric":{"job":"b","cluster":"east"},"value":[1,"3"]}]}}`),
+		staticBackend("down", http.StatusServiceUnavailable, `{"status":"error","errorType":"unavailable","error":"no store"}`),
+	}
+	rec, resp := serve("/api/v1/query?query=up")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, []string{"backend down failed: unavailable: no store"}, resp.Warnings)
+
+	var data queryData
+	require.NoError(t, json.Unmarshal(resp.Data, &data))
+	var vector []vectorSample
+	require.NoError(t, json.Unmarshal(data.Result, &vector))
+	require.Len(t, vector, 3)
+	require.Equal(t, map[string]string{"job": "a", "cluster": "hub"}, vector[0].Metric)
+	require.Equal(t, map[string]string{"job": "a", "cluster": "spoke"}, vector[1].Metric)
+	require.Equal(t, map[string]string{"job": "b", "cluster": "east"}, vector[2].Metric)
+
+	f.backends = []federationBackend{
+		staticBackend("hub", http.StatusOK, `{"status":"success","data":["cluster","job"]}`),
+		staticBackend("spoke", http.StatusOK, `{"status":"success","data":["instance","job"]}`),
+	}
+	_, resp = serve("/api/v1/labels")
+	require.JSONEq(t, `["cluster","instance","job"]`, string(resp.Data))
+
+	f.backends = []federationBackend{
+		staticBackend("hub", http.StatusOK, `{"status":"success","data":[]}`),
+		staticBackend("spoke", http.StatusOK, `{"status":"success","data":["east"]}`),
+	}
+	_, resp = serve("/api/v1/label/cluster/values")
+	require.JSONEq(t, `["east","hub","spoke"]`, string(resp.Data))
+
+	// The default upstream is queried with the backends, and with a shared
+	// token the users it fails to serve get no federated results.
+	f.backends = []federationBackend{
+		staticBackend("spoke", http.StatusOK, `{"status":"success","data":["east"]}`),
+		staticBackend("local-cluster", http.StatusOK, `{"status":"success","data":["west"]}`),
+	}
+	_, resp = serve("/api/v1/label/cluster/values")
+	require.JSONEq(t, `["east","local-cluster","spoke","west"]`, string(resp.Data))
+	f.backends[1] = staticBackend("local-cluster", http.StatusServiceUnavailable, `{"status":"error","errorType":"unavailable","error":"breaker open"}`)
+	rec, resp = serve("/api/v1/label/cluster/values")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `["east","spoke"]`, string(resp.Data))
+
+	f.sharedToken = true
+	rec, _ = serve("/api/v1/label/cluster/values")
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	f.backends[1] = staticBackend("local-cluster", http.StatusForbidden, `Forbidden`)
+	rec, _ = serve("/api/v1/label/cluster/values")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Equal(t, "Forbidden", rec.Body.String())
+
+	// Other paths go to the default upstream.
+	rec, _ = serve("/api/v1/rules")
+	require.Equal(t, http.StatusTeapot, rec.Code)
+}
@@ -0,0 +1,62 @@
+package monitoring
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "monitoring_plugin"
+
+var (
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "proxy_cache",
+		Name:      "requests_total",
+		Help:      "Number of lookups in the proxy response cache, partitioned by result (hit or miss).",
+	}, []string{"kind", "result"})
+
+	cacheSizeBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "proxy_cache",
+		Name:      "size_bytes",
+		Help:      "Size of the responses held in the proxy response cache.",
+	}, []string{"kind"})
+
+	cacheEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "proxy_cache",
+		Name:      "entries",
+		Help:      "Number of responses held in the proxy response cache.",
+	}, []string{"kind"})
+
+	upstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "upstream",
+		Name:      "retries_total",
+		Help:      "Number of retried upstream requests.",
+	}, []string{"kind"})
+
+	breakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "upstream",
+		Name:      "circuit_breaker_state",
+		Help:      "State of the upstream circuit breaker: 0 closed, 1 half-open, 2 open.",
+	}, []string{"kind", "upstream"})
+
+	endpointHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "upstream",
+		Name:      "endpoint_healthy",
+		Help:      "Whether an upstream replica passes its health checks.",
+	}, []string{"kind", "endpoint"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		cacheRequests,
+		cacheSizeBytes,
+		cacheEntries,
+		upstreamRetries,
+		breakerState,
+		endpointHealthy,
+	)
+}
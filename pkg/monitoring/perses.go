@@ -0,0 +1,386 @@
+package monitoring
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	PersesKind KindType  = "perses"
+	PersesPort ProxyPort = 9446
+
+	persesAPIPrefix      = "/api/v1/"
+	persesProjectsPath   = "/api/v1/projects"
+	persesDashboardsPath = "/api/v1/dashboards"
+
+	// maxPersesBodySize bounds the project bodies read by the proxy.
+	maxPersesBodySize = 1 << 20
+)
+
+// persesProjectLists are the Perses endpoints listing the resources of every
+// project, filtered down to the projects of the user.
+var persesProjectLists = map[string]bool{
+	persesProjectsPath:            true,
+	persesDashboardsPath:          true,
+	"/api/v1/datasources":         true,
+	"/api/v1/variables":           true,
+	"/api/v1/secrets":             true,
+	"/api/v1/ephemeraldashboards": true,
+}
+
+// persesGlobalReads are the global endpoints served with the credentials of
+// the proxy, which hide the user from Perses. The other global endpoints,
+// like the global secrets, datasources and roles, are forbidden then.
+var persesGlobalReads = map[string]bool{
+	"/api/config":     true,
+	"/api/v1/health":  true,
+	"/api/v1/plugins": true,
+}
+
+// PersesConfig configures the proxy to the Perses API used by the
+// perses-dashboards feature.
+type PersesConfig struct {
+	// CAFile verifies the certificate of Perses in place of the service CA.
+	CAFile string `yaml:"caFile,omitempty"`
+	// BearerTokenFile and BasicAuth authenticate the proxy to Perses, the
+	// token of the user is forwarded otherwise.
+	BearerTokenFile string           `yaml:"bearerTokenFile,omitempty"`
+	BasicAuth       *PersesBasicAuth `yaml:"basicAuth,omitempty"`
+	// CacheTTL is the lifetime of the cached project and dashboard lists.
+	CacheTTL time.Duration `yaml:"cacheTTL,omitempty"`
+	// CacheMaxSizeBytes bounds the memory used by the cached lists.
+	CacheMaxSizeBytes int64 `yaml:"cacheMaxSizeBytes,omitempty"`
+	// DisableRBAC serves every Perses project to every user, instead of the
+	// projects matching the namespaces they can access.
+	DisableRBAC bool `yaml:"disableRBAC,omitempty"`
+}
+
+// PersesBasicAuth holds the basic auth credentials of the Perses proxy.
+type PersesBasicAuth struct {
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"passwordFile"`
+}
+
+func (c PersesConfig) withDefaults() PersesConfig {
+	if c.CacheTTL == 0 {
+		c.CacheTTL = 30 * time.Second
+	}
+	if c.CacheMaxSizeBytes == 0 {
+		c.CacheMaxSizeBytes = 16 << 20
+	}
+	return c
+}
+
+// NamespaceAccess resolves the permissions of the user of a request on the
+// Kubernetes namespaces, which back the Perses projects of the same name.
+type NamespaceAccess interface {
+	// Namespaces returns the namespaces the user can list.
+	Namespaces(r *http.Request) (map[string]bool, error)
+	// Allowed reports whether the user can apply verb to the Perses
+	// dashboards of namespace.
+	Allowed(r *http.Request, verb, namespace string) (bool, error)
+}
+
+// NewPersesHandler proxies the Perses API, caching the project and dashboard
+// lists and restricting the projects to the namespaces of the user. A nil
+// access disables the RBAC, as DisableRBAC does.
+func NewPersesHandler(access NamespaceAccess, serviceCAfile string, proxyUrl string, config ProxyConfig) *ProxyHandler {
+	persesConfig := config.Perses.withDefaults()
+	caFile := serviceCAfile
+	if persesConfig.CAFile != "" {
+		caFile = persesConfig.CAFile
+	}
+
+	proxy, err := getProxy(PersesKind, proxyUrl, caFile, config)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var handler http.Handler = persesAuthMiddleware(persesConfig, proxy)
+	handler = persesCacheMiddleware(persesConfig, handler)
+	if persesConfig.DisableRBAC || access == nil {
+		log.Warn("perses proxy serves every project to every user")
+	} else {
+		handler = &persesRBAC{access: access, next: handler}
+	}
+	if persesConfig.BearerTokenFile != "" || persesConfig.BasicAuth != nil {
+		handler = persesGlobalMiddleware(handler)
+	}
+	handler = dedupMiddleware(config.Deduplicate, handler)
+
+	return &ProxyHandler{
+		proxy:   proxy,
+		handler: handler,
+	}
+}
+
+// persesAuthMiddleware replaces the credentials of the user with the ones of
+// the proxy when configured. The files are read on every request so that
+// rotated credentials are picked up.
+func persesAuthMiddleware(config PersesConfig, next http.Handler) http.Handler {
+	if config.BearerTokenFile == "" && config.BasicAuth == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+		r.Header.Del("Cookie")
+		if config.BearerTokenFile != "" {
+			token, err := os.ReadFile(config.BearerTokenFile)
+			if err != nil {
+				log.WithError(err).Error("cannot read perses bearer token file")
+				writePersesError(w, http.StatusInternalServerError, "cannot authenticate to perses")
+				return
+			}
+			r.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+		} else {
+			password, err := os.ReadFile(config.BasicAuth.PasswordFile)
+			if err != nil {
+				log.WithError(err).Error("cannot read perses password file")
+				writePersesError(w, http.StatusInternalServerError, "cannot authenticate to perses")
+				return
+			}
+			r.SetBasicAuth(config.BasicAuth.Username, strings.TrimSpace(string(password)))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// persesGlobalMiddleware restricts the Perses API to the project endpoints,
+// checked by the RBAC, and to the reads of persesGlobalReads, as Perses cannot
+// authorize the users behind the credentials of the proxy.
+func persesGlobalMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		read := r.Method == http.MethodGet || r.Method == http.MethodHead
+		switch {
+		case !strings.HasPrefix(path+"/", "/api/"):
+		case path == persesProjectsPath || strings.HasPrefix(path, persesProjectsPath+"/"):
+		case persesProjectLists[path] && read:
+		case persesGlobalReads[path] && read:
+		default:
+			writePersesError(w, http.StatusForbidden, "global perses resources are not available through the proxy")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// persesCache caches the project and dashboard lists per tenant. Any
+// successful write clears the cache, as it can change the lists of every
+// tenant.
+type persesCache struct {
+	store *responseCache
+	next  http.Handler
+}
+
+func persesCacheMiddleware(config PersesConfig, next http.Handler) http.Handler {
+	return &persesCache{
+		store: newResponseCache(string(PersesKind), config.CacheMaxSizeBytes, config.CacheTTL),
+		next:  next,
+	}
+}
+
+func (c *persesCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		resp := newBufferedResponse()
+		c.next.ServeHTTP(resp, r)
+		if resp.statusCode() < http.StatusBadRequest {
+			c.store.clear()
+		}
+		resp.copyTo(w)
+		return
+	}
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if r.Method != http.MethodGet || (path != persesProjectsPath && path != persesDashboardsPath) {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	key := tenantKey(r) + "|" + path + "?" + r.URL.Query().Encode()
+	if body, ok := c.store.get(key); ok {
+		w.Header().Set(CacheHeader, "hit")
+		writeJSON(w, body)
+		return
+	}
+
+	resp := newBufferedResponse()
+	sub := r.Clone(r.Context())
+	sub.Header.Del("Accept-Encoding")
+	c.next.ServeHTTP(resp, sub)
+	if resp.statusCode() == http.StatusOK {
+		c.store.set(key, resp.body.Bytes())
+	}
+	resp.header.Set(CacheHeader, "miss")
+	resp.copyTo(w)
+}
+
+// persesRBAC maps the Perses projects to the Kubernetes namespaces of the
+// same name. The project lists are filtered down to the namespaces of the
+// user, the reads of a project require access to its namespace and the
+// writes the permission on its Perses dashboards.
+type persesRBAC struct {
+	access NamespaceAccess
+	next   http.Handler
+}
+
+func (p *persesRBAC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if !strings.HasPrefix(path, persesAPIPrefix) {
+		// Perses configuration, health and migration endpoints.
+		p.next.ServeHTTP(w, r)
+		return
+	}
+
+	project := ""
+	switch parts := strings.Split(strings.TrimPrefix(path, persesAPIPrefix), "/"); {
+	case parts[0] == "projects" && len(parts) > 1:
+		project = parts[1]
+	case persesProjectLists[path] && r.Method == http.MethodGet:
+		p.filterList(w, r, path)
+		return
+	case path == persesProjectsPath && r.Method == http.MethodPost:
+		name, err := persesResourceName(r)
+		if err != nil {
+			writePersesError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		project = name
+	default:
+		// Global resources are left to the authorization of Perses, unless
+		// the proxy credentials hide the user, see persesGlobalMiddleware.
+		p.next.ServeHTTP(w, r)
+		return
+	}
+
+	var allowed bool
+	var err error
+	if verb := persesVerb(r.Method); verb == "get" {
+		var namespaces map[string]bool
+		namespaces, err = p.access.Namespaces(r)
+		allowed = namespaces[project]
+	} else {
+		allowed, err = p.access.Allowed(r, verb, project)
+	}
+	if err != nil {
+		log.WithError(err).Warnf("cannot check access to perses project %s", project)
+		writePersesError(w, http.StatusInternalServerError, "cannot check access to the project")
+		return
+	}
+	if !allowed {
+		writePersesError(w, http.StatusForbidden, fmt.Sprintf("access to project %q is forbidden", project))
+		return
+	}
+	p.next.ServeHTTP(w, r)
+}
+
+// filterList removes the resources of the projects the user cannot access
+// from a list response.
+func (p *persesRBAC) filterList(w http.ResponseWriter, r *http.Request, path string) {
+	namespaces, err := p.access.Namespaces(r)
+	if err != nil {
+		log.WithError(err).Warn("cannot list the namespaces of the user")
+		writePersesError(w, http.StatusInternalServerError, "cannot list the projects of the user")
+		return
+	}
+
+	resp := newBufferedResponse()
+	sub := r.Clone(r.Context())
+	sub.Header.Del("Accept-Encoding")
+	p.next.ServeHTTP(resp, sub)
+	if resp.statusCode() != http.StatusOK {
+		resp.copyTo(w)
+		return
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(resp.body.Bytes(), &items); err != nil {
+		writePersesError(w, http.StatusBadGateway, "cannot decode perses response")
+		return
+	}
+	filtered := make([]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		var resource persesResource
+		if err := json.Unmarshal(item, &resource); err != nil {
+			continue
+		}
+		project := resource.Metadata.Project
+		if path == persesProjectsPath {
+			project = resource.Metadata.Name
+		}
+		if namespaces[project] {
+			filtered = append(filtered, item)
+		}
+	}
+	body, err := json.Marshal(filtered)
+	if err != nil {
+		writePersesError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if cache := resp.header.Get(CacheHeader); cache != "" {
+		w.Header().Set(CacheHeader, cache)
+	}
+	writeJSON(w, body)
+}
+
+// persesResource holds the metadata shared by the Perses resources.
+type persesResource struct {
+	Metadata struct {
+		Name    string `json:"name"`
+		Project string `json:"project"`
+	} `json:"metadata"`
+}
+
+// persesResourceName reads the name of the resource created by a request,
+// leaving the body readable for the proxy.
+func persesResourceName(r *http.Request) (string, error) {
+	if r.Body == nil {
+		return "", errors.New("invalid project: empty body")
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxPersesBodySize))
+	if err != nil {
+		return "", fmt.Errorf("cannot read project: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+
+	var resource persesResource
+	if err := json.Unmarshal(data, &resource); err != nil {
+		return "", fmt.Errorf("invalid project: %w", err)
+	}
+	if resource.Metadata.Name == "" {
+		return "", errors.New("invalid project: missing metadata.name")
+	}
+	return resource.Metadata.Name, nil
+}
+
+func persesVerb(method string) string {
+	switch method {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "get"
+	}
+}
+
+// writePersesError writes an error in the format of the Perses API.
+func writePersesError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(struct {
+		Message string `json:"message"`
+	}{message}); err != nil {
+		log.WithError(err).Debug("cannot write response")
+	}
+}
@@ -0,0 +1,140 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNamespaceAccess struct {
+	namespaces map[string]bool
+	writable   map[string]bool
+}
+
+func (f fakeNamespaceAccess) Namespaces(*http.Request) (map[string]bool, error) {
+	return f.namespaces, nil
+}
+
+func (f fakeNamespaceAccess) Allowed(_ *http.Request, _ string, namespace string) (bool, error) {
+	return f.writable[namespace], nil
+}
+
+// fakePerses serves the projects and dashboards lists and counts the
+// requests it receives.
+type fakePerses struct {
+	requests int
+}
+
+func (f *fakePerses) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests++
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case persesProjectsPath:
+		w.Write([]byte(`[{"kind":"Project","metadata":{"name":"team-a"}},{"kind":"Project","metadata":{"name":"team-b"}}]`))
+	case persesDashboardsPath:
+		w.Write([]byte(`[{"kind":"Dashboard","metadata":{"name":"d1","project":"team-a"}},{"kind":"Dashboard","metadata":{"name":"d2","project":"team-b"}}]`))
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func TestPersesProxy(t *testing.T) {
+	upstream := &fakePerses{}
+	config := PersesConfig{}.withDefaults()
+	handler := &persesRBAC{
+		access: fakeNamespaceAccess{
+			namespaces: map[string]bool{"team-a": true, "team-b": true},
+			writable:   map[string]bool{"team-a": true},
+		},
+		next: persesCacheMiddleware(config, upstream),
+	}
+	do := func(method, target, auth, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodGet, persesDashboardsPath, "Bearer alice", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "miss", rec.Header().Get(CacheHeader))
+	rec = do(http.MethodGet, persesDashboardsPath, "Bearer alice", "")
+	require.Equal(t, "hit", rec.Header().Get(CacheHeader))
+	require.Equal(t, 1, upstream.requests)
+
+	// The cache is per tenant.
+	do(http.MethodGet, persesDashboardsPath, "Bearer bob", "")
+	require.Equal(t, 2, upstream.requests)
+
+	// Writes are checked against the namespace and clear the cache.
+	rec = do(http.MethodPut, "/api/v1/projects/team-b/dashboards/d2", "Bearer alice", "{}")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Equal(t, 2, upstream.requests)
+	rec = do(http.MethodPut, "/api/v1/projects/team-a/dashboards/d1", "Bearer alice", "{}")
+	require.Equal(t, http.StatusOK, rec.Code)
+	do(http.MethodGet, persesDashboardsPath, "Bearer alice", "")
+	require.Equal(t, 4, upstream.requests)
+
+	rec = do(http.MethodPost, persesProjectsPath, "Bearer alice", `{"kind":"Project","metadata":{"name":"team-c"}}`)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	rec = do(http.MethodPost, persesProjectsPath, "Bearer alice", `{"kind":"Project"}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPersesRBACFilter(t *testing.T) {
+	handler := &persesRBAC{
+		access: fakeNamespaceAccess{namespaces: map[string]bool{"team-a": true}},
+		next:   &fakePerses{},
+	}
+	list := func(path string) []persesResource {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resources []persesResource
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resources))
+		return resources
+	}
+
+	projects := list(persesProjectsPath)
+	require.Len(t, projects, 1)
+	require.Equal(t, "team-a", projects[0].Metadata.Name)
+	dashboards := list(persesDashboardsPath + "/")
+	require.Len(t, dashboards, 1)
+	require.Equal(t, "d1", dashboards[0].Metadata.Name)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/projects/team-b/dashboards", nil))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/projects/team-a/dashboards", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestPersesGlobalMiddleware(t *testing.T) {
+	handler := persesGlobalMiddleware(&fakePerses{})
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/api/config", http.StatusOK},
+		{http.MethodGet, "/api/v1/health", http.StatusOK},
+		{http.MethodGet, persesDashboardsPath, http.StatusOK},
+		{http.MethodGet, "/api/v1/projects/team-a/dashboards", http.StatusOK},
+		{http.MethodGet, "/api/v1/globalsecrets", http.StatusForbidden},
+		{http.MethodPost, "/api/v1/globaldatasources", http.StatusForbidden},
+		{http.MethodPut, "/api/v1/users/alice", http.StatusForbidden},
+		{http.MethodPost, "/api/v1/globalrolebindings", http.StatusForbidden},
+		{http.MethodPost, "/api/v1/health", http.StatusForbidden},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		require.Equal(t, tc.status, rec.Code, "%s %s", tc.method, tc.path)
+	}
+}
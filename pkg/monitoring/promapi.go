@@ -0,0 +1,251 @@
+package monitoring
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// apiResponse is the envelope of every Prometheus HTTP API response.
+type apiResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	ErrorType string          `json:"errorType,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Warnings  []string        `json:"warnings,omitempty"`
+	Infos     []string        `json:"infos,omitempty"`
+}
+
+// queryData is the data of an instant or range query response.
+type queryData struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// series is a single element of a matrix result.
+type series struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []samplePair      `json:"values,omitempty"`
+	Histograms []samplePair      `json:"histograms,omitempty"`
+}
+
+// samplePair is a [timestamp, value] pair of a matrix result. The value is kept
+// as raw JSON so that float samples and native histograms are handled alike.
+type samplePair struct {
+	T float64
+	V json.RawMessage
+}
+
+func (p samplePair) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('[')
+	b.WriteString(strconv.FormatFloat(p.T, 'f', -1, 64))
+	b.WriteByte(',')
+	b.Write(p.V)
+	b.WriteByte(']')
+	return b.Bytes(), nil
+}
+
+func (p *samplePair) UnmarshalJSON(data []byte) error {
+	var pair []json.RawMessage
+	if err := json.Unmarshal(data, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return fmt.Errorf("invalid sample pair %s", data)
+	}
+	if err := json.Unmarshal(pair[0], &p.T); err != nil {
+		return err
+	}
+	p.V = pair[1]
+	return nil
+}
+
+// decodeMatrix returns the series of a successful range query response body.
+func decodeMatrix(body []byte) (*apiResponse, []series, error) {
+	var resp apiResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, nil, err
+	}
+	if resp.Status != "success" {
+		return &resp, nil, fmt.Errorf("query failed with %s: %s", resp.ErrorType, resp.Error)
+	}
+	var data queryData
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return nil, nil, err
+	}
+	if data.ResultType != "matrix" {
+		return nil, nil, fmt.Errorf("unexpected result type %q", data.ResultType)
+	}
+	var result []series
+	if err := json.Unmarshal(data.Result, &result); err != nil {
+		return nil, nil, err
+	}
+	return &resp, result, nil
+}
+
+// encodeMatrix builds a successful range query response body.
+func encodeMatrix(result []series, warnings []string, infos []string) ([]byte, error) {
+	if result == nil {
+		result = []series{}
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(queryData{ResultType: "matrix", Result: encoded})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(apiResponse{
+		Status:   "success",
+		Data:     data,
+		Warnings: warnings,
+		Infos:    infos,
+	})
+}
+
+// mergeMatrices combines the series of several range query results, joining
+// the samples of series with the same labels and dropping duplicate timestamps.
+func mergeMatrices(results ...[]series) []series {
+	merged := map[string]*series{}
+	for _, result := range results {
+		for _, s := range result {
+			key := labels.FromMap(s.Metric).String()
+			existing, ok := merged[key]
+			if !ok {
+				existing = &series{Metric: s.Metric}
+				merged[key] = existing
+			}
+			existing.Values = append(existing.Values, s.Values...)
+			existing.Histograms = append(existing.Histograms, s.Histograms...)
+		}
+	}
+
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]series, 0, len(keys))
+	for _, k := range keys {
+		s := merged[k]
+		s.Values = sortSamples(s.Values)
+		s.Histograms = sortSamples(s.Histograms)
+		out = append(out, *s)
+	}
+	return out
+}
+
+func sortSamples(samples []samplePair) []samplePair {
+	if len(samples) == 0 {
+		return nil
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].T < samples[j].T })
+	deduped := samples[:1]
+	for _, p := range samples[1:] {
+		if p.T != deduped[len(deduped)-1].T {
+			deduped = append(deduped, p)
+		}
+	}
+	return deduped
+}
+
+// sliceMatrix returns the samples of a matrix with timestamps within [start, end].
+func sliceMatrix(result []series, start, end float64) []series {
+	var out []series
+	for _, s := range result {
+		sliced := series{
+			Metric:     s.Metric,
+			Values:     sliceSamples(s.Values, start, end),
+			Histograms: sliceSamples(s.Histograms, start, end),
+		}
+		if len(sliced.Values) > 0 || len(sliced.Histograms) > 0 {
+			out = append(out, sliced)
+		}
+	}
+	return out
+}
+
+func sliceSamples(samples []samplePair, start, end float64) []samplePair {
+	var out []samplePair
+	for _, p := range samples {
+		if p.T >= start && p.T <= end {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// bufferedResponse captures the response of the upstream proxy so that it can
+// be inspected or merged before anything is written to the client.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: http.Header{}}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(data)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) statusCode() int {
+	if b.status == 0 {
+		return http.StatusOK
+	}
+	return b.status
+}
+
+func (b *bufferedResponse) copyTo(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(b.statusCode())
+	if _, err := w.Write(b.body.Bytes()); err != nil {
+		log.WithError(err).Debug("cannot write buffered response")
+	}
+}
+
+// writeJSON writes a successful Prometheus API response body.
+func writeJSON(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body); err != nil {
+		log.WithError(err).Debug("cannot write response")
+	}
+}
+
+// subRequest clones a Prometheus API request with a different set of
+// parameters. Compression is left to the transport so that the response body
+// can be decoded.
+func subRequest(r *http.Request, params url.Values) *http.Request {
+	sub := r.Clone(r.Context())
+	sub.Header.Del("Accept-Encoding")
+	setRequestParams(sub, params)
+	return sub
+}
@@ -10,6 +10,7 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	oscrypto "github.com/openshift/library-go/pkg/crypto"
@@ -20,7 +21,27 @@ import (
 var log = logrus.WithField("module", "proxy")
 
 type ProxyHandler struct {
-	proxy *httputil.ReverseProxy
+	proxy   *httputil.ReverseProxy
+	handler http.Handler
+}
+
+// ProxyConfig holds the optional behaviour of the datasource proxies, it is
+// read from the plugin configuration file.
+type ProxyConfig struct {
+	QueryLimits QueryPolicy `yaml:"queryLimits,omitempty"`
+	Cache       CacheConfig `yaml:"cache,omitempty"`
+	Split       SplitConfig `yaml:"split,omitempty"`
+	// Deduplicate coalesces identical concurrent GET requests.
+	Deduplicate    bool                 `yaml:"deduplicate,omitempty"`
+	Retry          RetryConfig          `yaml:"retry,omitempty"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuitBreaker,omitempty"`
+	Federation     FederationConfig     `yaml:"federation,omitempty"`
+	// AlertmanagerAggregation merges the alerts and silences of several Alertmanagers.
+	AlertmanagerAggregation AlertmanagerAggregationConfig `yaml:"alertmanagerAggregation,omitempty"`
+	// Perses configures the proxy of the perses-dashboards feature.
+	Perses PersesConfig `yaml:"perses,omitempty"`
+	// Upstreams configures the replicas of each datasource kind.
+	Upstreams map[KindType]UpstreamConfig `yaml:"upstreams,omitempty"`
 }
 
 type KindType string
@@ -37,15 +58,34 @@ const (
 	ThanosQuerierPort ProxyPort = 9445
 )
 
-func NewProxyHandler(k8sclient *dynamic.DynamicClient, serviceCAfile string, kind KindType, proxyUrl string) *ProxyHandler {
+func NewProxyHandler(k8sclient *dynamic.DynamicClient, serviceCAfile string, kind KindType, proxyUrl string, config ProxyConfig) *ProxyHandler {
 
-	proxy, err := getProxy(kind, proxyUrl, serviceCAfile)
+	proxy, err := getProxy(kind, proxyUrl, serviceCAfile, config)
 	if err != nil {
 		log.Panic(err)
 	}
 
+	var handler http.Handler = proxy
+	if kind == ThanosQuerierKind {
+		handler, err = federationHandler(config.Federation, serviceCAfile, config, handler)
+		if err != nil {
+			log.Panic(err)
+		}
+		handler = splitMiddleware(config.Split, handler)
+		handler = cacheMiddleware(config.Cache, handler)
+		handler = queryPolicyMiddleware(config.QueryLimits, handler)
+	}
+	if kind == AlertManagerKind {
+		handler, err = alertmanagerAggregationHandler(config.AlertmanagerAggregation, serviceCAfile, config, handler)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	handler = dedupMiddleware(config.Deduplicate, handler)
+
 	return &ProxyHandler{
-		proxy: proxy,
+		proxy:   proxy,
+		handler: handler,
 	}
 }
 
@@ -72,30 +112,36 @@ func FilterHeaders(r *http.Response) error {
 	return nil
 }
 
-func createProxy(proxyUrl *url.URL, serviceCAfile string) (*httputil.ReverseProxy, error) {
-	// TODO: allow custom CA per datasource
-	serviceCertPEM, err := os.ReadFile(serviceCAfile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read certificate file: tried '%s' and got %v", serviceCAfile, err)
-	}
-	serviceProxyRootCAs := x509.NewCertPool()
-	if !serviceProxyRootCAs.AppendCertsFromPEM(serviceCertPEM) {
-		return nil, fmt.Errorf("no CA found for Kubernetes services, proxy to datasources will fail")
+func createProxy(kind KindType, endpoints []string, serviceCAfile string, config ProxyConfig) (*httputil.ReverseProxy, error) {
+	// The system roots verify the datasources when no CA file is set, as for
+	// a Perses instance running outside of the cluster.
+	var serviceProxyRootCAs *x509.CertPool
+	if serviceCAfile != "" {
+		serviceCertPEM, err := os.ReadFile(serviceCAfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate file: tried '%s' and got %v", serviceCAfile, err)
+		}
+		serviceProxyRootCAs = x509.NewCertPool()
+		if !serviceProxyRootCAs.AppendCertsFromPEM(serviceCertPEM) {
+			return nil, fmt.Errorf("no CA found for Kubernetes services, proxy to datasources will fail")
+		}
 	}
+	upstream := config.Upstreams[kind]
 	serviceProxyTLSConfig := oscrypto.SecureTLSConfig(&tls.Config{
-		RootCAs: serviceProxyRootCAs,
+		RootCAs:    serviceProxyRootCAs,
+		ServerName: upstream.DNS.serverName(),
 	})
 
 	const (
 		dialerKeepalive       = 30 * time.Second
-		dialerTimeout         = 5 * time.Minute // Maximum request timeout for most browsers.
 		tlsHandshakeTimeout   = 10 * time.Second
 		websocketPingInterval = 30 * time.Second
 		websocketTimeout      = 30 * time.Second
 	)
 
+	timeouts := config.Retry.withDefaults()
 	dialer := &net.Dialer{
-		Timeout:   dialerTimeout,
+		Timeout:   timeouts.DialTimeout,
 		KeepAlive: dialerKeepalive,
 	}
 
@@ -104,25 +150,47 @@ func createProxy(proxyUrl *url.URL, serviceCAfile string) (*httputil.ReverseProx
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 			return dialer.DialContext(ctx, network, addr)
 		},
-		TLSClientConfig:     serviceProxyTLSConfig,
-		TLSHandshakeTimeout: tlsHandshakeTimeout,
+		TLSClientConfig:       serviceProxyTLSConfig,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: timeouts.ResponseHeaderTimeout,
+	}
+
+	upstreamTransport := newResilientTransport(kind, transport, config.Retry, config.CircuitBreaker)
+
+	var proxyUrl *url.URL
+	if len(endpoints) == 1 && upstream.DNS == nil {
+		var err error
+		proxyUrl, err = url.Parse(endpoints[0])
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// The requests are sent to the first replica by the director and
+		// moved to the one picked by the balancer in the transport.
+		balancer, err := newBalancer(context.Background(), kind, endpoints, upstream, upstreamTransport, transport)
+		if err != nil {
+			return nil, err
+		}
+		if len(balancer.endpoints) == 0 {
+			return nil, fmt.Errorf("no %s endpoints configured", kind)
+		}
+		proxyUrl = balancer.endpoints[0].url
+		upstreamTransport = balancer
 	}
 
 	reverseProxy := httputil.NewSingleHostReverseProxy(proxyUrl)
 	reverseProxy.FlushInterval = time.Millisecond * 100
-	reverseProxy.Transport = transport
+	reverseProxy.Transport = upstreamTransport
 	reverseProxy.ModifyResponse = FilterHeaders
+	reverseProxy.ErrorHandler = proxyErrorHandler(kind)
 	return reverseProxy, nil
 }
 
-func getProxy(kind KindType, proxyUrlString string, serviceCAfile string) (*httputil.ReverseProxy, error) {
-	log.Info(fmt.Sprintf("Proxy of Type: %s Points to Url: %s", kind, proxyUrlString))
-	proxyURL, err := url.Parse(proxyUrlString)
-	if err != nil {
-		return nil, err
-	}
+func getProxy(kind KindType, proxyUrlString string, serviceCAfile string, config ProxyConfig) (*httputil.ReverseProxy, error) {
+	endpoints := upstreamEndpoints(proxyUrlString, config.Upstreams[kind])
+	log.Info(fmt.Sprintf("Proxy of Type: %s Points to Urls: %s", kind, strings.Join(endpoints, ", ")))
 
-	proxy, err := createProxy(proxyURL, serviceCAfile)
+	proxy, err := createProxy(kind, endpoints, serviceCAfile, config)
 	if err != nil {
 		return nil, err
 	}
@@ -131,5 +199,5 @@ func getProxy(kind KindType, proxyUrlString string, serviceCAfile string) (*http
 }
 
 func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.proxy.ServeHTTP(w, r)
+	h.handler.ServeHTTP(w, r)
 }
@@ -0,0 +1,319 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// FeatureHeader is set by the frontend to identify which plugin feature issued a
+// query, so that per-feature limits can be applied by the Thanos proxy. The
+// frontend sends alerting, incidents, legacy-dashboards, metrics and targets.
+const FeatureHeader = "X-Monitoring-Plugin-Feature"
+
+const (
+	queryPath      = "/api/v1/query"
+	queryRangePath = "/api/v1/query_range"
+)
+
+// QueryLimits bounds the cost of the PromQL queries forwarded to Thanos.
+// A zero value for any limit disables that check.
+type QueryLimits struct {
+	// MaxPoints is the maximum number of points per series, (end-start)/step.
+	MaxPoints int `yaml:"maxPoints,omitempty"`
+	// MaxRange is the maximum allowed end-start duration of a range query.
+	MaxRange time.Duration `yaml:"maxRange,omitempty"`
+	// MinStep is the smallest allowed resolution of a range query.
+	MinStep time.Duration `yaml:"minStep,omitempty"`
+	// RequireMatchers rejects queries with selectors that only match on a metric name.
+	RequireMatchers bool `yaml:"requireMatchers,omitempty"`
+	// Clamp adjusts out of bounds range queries instead of rejecting them.
+	Clamp bool `yaml:"clamp,omitempty"`
+}
+
+// QueryPolicy holds the default query limits and the per feature overrides,
+// keyed by the feature names passed through the FeatureHeader. The header is
+// set by the client, so the feature limits can only tighten the default ones.
+type QueryPolicy struct {
+	Default  QueryLimits            `yaml:"default,omitempty"`
+	Features map[string]QueryLimits `yaml:"features,omitempty"`
+}
+
+func (p *QueryPolicy) limitsFor(r *http.Request) QueryLimits {
+	if feature := r.Header.Get(FeatureHeader); feature != "" {
+		if limits, ok := p.Features[strings.ToLower(feature)]; ok {
+			return p.Default.tighten(limits)
+		}
+	}
+	return p.Default
+}
+
+// tighten returns the stricter of both limits for every check. Clamping still
+// enforces the limits, so the mode of the override is kept.
+func (l QueryLimits) tighten(o QueryLimits) QueryLimits {
+	return QueryLimits{
+		MaxPoints:       minLimit(l.MaxPoints, o.MaxPoints),
+		MaxRange:        minLimit(l.MaxRange, o.MaxRange),
+		MinStep:         max(l.MinStep, o.MinStep),
+		RequireMatchers: l.RequireMatchers || o.RequireMatchers,
+		Clamp:           o.Clamp,
+	}
+}
+
+// minLimit returns the smallest of two limits where zero disables the limit.
+func minLimit[T int | time.Duration](a, b T) T {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func (p *QueryPolicy) isEmpty() bool {
+	return p.Default == (QueryLimits{}) && len(p.Features) == 0
+}
+
+// queryPolicyMiddleware rejects or clamps PromQL queries which exceed the
+// configured limits before they are proxied to Thanos.
+func queryPolicyMiddleware(policy QueryPolicy, next http.Handler) http.Handler {
+	if policy.isEmpty() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isRange := strings.HasSuffix(r.URL.Path, queryRangePath)
+		if !isRange && !strings.HasSuffix(r.URL.Path, queryPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		params, err := requestParams(r)
+		if err != nil {
+			writePrometheusError(w, http.StatusBadRequest, errorBadData, err.Error())
+			return
+		}
+
+		limits := policy.limitsFor(r)
+
+		if limits.RequireMatchers {
+			if err := checkLabelMatchers(params.Get("query")); err != nil {
+				writePrometheusError(w, http.StatusBadRequest, errorBadData, err.Error())
+				return
+			}
+		}
+
+		if isRange {
+			changed, err := limits.apply(params)
+			if err != nil {
+				writePrometheusError(w, http.StatusBadRequest, errorBadData, err.Error())
+				return
+			}
+			if changed {
+				log.Debugf("clamped query_range parameters to start=%s end=%s step=%s", params.Get("start"), params.Get("end"), params.Get("step"))
+				setRequestParams(r, params)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apply checks the range query parameters against the limits. When clamping is
+// enabled the parameters are modified in place and changed is set to true.
+func (l QueryLimits) apply(params url.Values) (changed bool, err error) {
+	rng, err := parseRangeParams(params)
+	if err != nil {
+		return false, err
+	}
+	start, end, step := rng.start, rng.end, rng.step
+
+	if l.MaxRange > 0 && end.Sub(start) > l.MaxRange {
+		if !l.Clamp {
+			return false, fmt.Errorf("query range %s exceeds the maximum of %s", end.Sub(start), l.MaxRange)
+		}
+		start = end.Add(-l.MaxRange)
+		changed = true
+	}
+
+	if l.MinStep > 0 && step < l.MinStep {
+		if !l.Clamp {
+			return false, fmt.Errorf("query resolution %s is below the minimum of %s", step, l.MinStep)
+		}
+		step = l.MinStep
+		changed = true
+	}
+
+	if l.MaxPoints > 0 {
+		if int64(end.Sub(start)/step) > int64(l.MaxPoints) {
+			if !l.Clamp {
+				return false, fmt.Errorf("exceeded maximum resolution of %d points per timeseries, try decreasing the query resolution (?step=XX)", l.MaxPoints)
+			}
+			step = time.Duration(math.Ceil(end.Sub(start).Seconds()/float64(l.MaxPoints))) * time.Second
+			changed = true
+		}
+	}
+
+	if changed {
+		params.Set("start", formatTime(start))
+		params.Set("end", formatTime(end))
+		params.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+	}
+
+	return changed, nil
+}
+
+// checkLabelMatchers returns an error when any vector selector of the query
+// does not have at least one label matcher besides the metric name.
+func checkLabelMatchers(query string) error {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		// Leave reporting of invalid queries to Thanos.
+		return nil
+	}
+
+	var unscoped string
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok || unscoped != "" {
+			return nil
+		}
+		for _, m := range vs.LabelMatchers {
+			if m.Name != labels.MetricName {
+				return nil
+			}
+		}
+		unscoped = vs.String()
+		return nil
+	})
+
+	if unscoped != "" {
+		return fmt.Errorf("selector %s must have at least one label matcher", unscoped)
+	}
+	return nil
+}
+
+type queryRange struct {
+	start time.Time
+	end   time.Time
+	step  time.Duration
+}
+
+func parseRangeParams(params url.Values) (queryRange, error) {
+	start, err := parseTime(params.Get("start"))
+	if err != nil {
+		return queryRange{}, fmt.Errorf("invalid parameter \"start\": %w", err)
+	}
+	end, err := parseTime(params.Get("end"))
+	if err != nil {
+		return queryRange{}, fmt.Errorf("invalid parameter \"end\": %w", err)
+	}
+	if end.Before(start) {
+		return queryRange{}, fmt.Errorf("end timestamp must not be before start time")
+	}
+	step, err := parseDuration(params.Get("step"))
+	if err != nil {
+		return queryRange{}, fmt.Errorf("invalid parameter \"step\": %w", err)
+	}
+	if step <= 0 {
+		return queryRange{}, fmt.Errorf("zero or negative query resolution step widths are not accepted. Try a positive integer")
+	}
+	return queryRange{start: start, end: end, step: step}, nil
+}
+
+// parseTime accepts the same formats as the Prometheus HTTP API: a unix
+// timestamp in seconds, optionally with a decimal fraction, or RFC3339.
+func parseTime(s string) (time.Time, error) {
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(t)
+		return time.Unix(int64(sec), int64(math.Round(frac*1000))*int64(time.Millisecond)).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// parseDuration accepts a float number of seconds or a Prometheus duration string.
+func parseDuration(s string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(d * float64(time.Second)), nil
+	}
+	if d, err := model.ParseDuration(s); err == nil {
+		return time.Duration(d), nil
+	}
+	return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+}
+
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', -1, 64)
+}
+
+// requestParams returns the query parameters of a Prometheus API request,
+// including form encoded parameters of POST requests. The body of POST
+// requests is restored so that the request can still be proxied.
+func requestParams(r *http.Request) (url.Values, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	if r.Method == http.MethodPost {
+		encoded := r.PostForm.Encode()
+		r.Body = io.NopCloser(strings.NewReader(encoded))
+		r.ContentLength = int64(len(encoded))
+	}
+	params := url.Values{}
+	for k, v := range r.Form {
+		params[k] = append([]string(nil), v...)
+	}
+	return params, nil
+}
+
+// setRequestParams replaces the parameters of a Prometheus API request,
+// keeping the original method and encoding.
+func setRequestParams(r *http.Request, params url.Values) {
+	encoded := params.Encode()
+	if r.Method == http.MethodPost {
+		r.URL.RawQuery = ""
+		r.PostForm = params
+		r.Form = params
+		r.Body = io.NopCloser(strings.NewReader(encoded))
+		r.ContentLength = int64(len(encoded))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return
+	}
+	r.URL.RawQuery = encoded
+	r.Form = params
+}
+
+const (
+	errorBadData     = "bad_data"
+	errorInternal    = "internal"
+	errorUnavailable = "unavailable"
+)
+
+// writePrometheusError writes an error in the format of the Prometheus HTTP
+// API so that the console shows it like any other query error.
+func writePrometheusError(w http.ResponseWriter, status int, errorType string, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(struct {
+		Status    string `json:"status"`
+		ErrorType string `json:"errorType"`
+		Error     string `json:"error"`
+	}{
+		Status:    "error",
+		ErrorType: errorType,
+		Error:     msg,
+	})
+	if err != nil {
+		log.WithError(err).Error("cannot write error response")
+	}
+}
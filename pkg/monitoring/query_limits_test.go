@@ -0,0 +1,146 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryPolicyMiddleware(t *testing.T) {
+	policy := QueryPolicy{
+		Default: QueryLimits{
+			MaxPoints: 100,
+			MaxRange:  24 * time.Hour,
+			MinStep:   15 * time.Second,
+		},
+		Features: map[string]QueryLimits{
+			"cluster-health-analyzer": {
+				MaxPoints:       100,
+				MaxRange:        24 * time.Hour,
+				Clamp:           true,
+				RequireMatchers: true,
+			},
+			"alerting": {
+				MaxPoints: 10000,
+				MaxRange:  7 * 24 * time.Hour,
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name       string
+		method     string
+		feature    string
+		path       string
+		params     url.Values
+		status     int
+		wantParams url.Values
+	}{
+		{
+			name:   "within limits",
+			path:   "/api/v1/query_range",
+			params: url.Values{"query": {"up"}, "start": {"0"}, "end": {"1500"}, "step": {"15"}},
+			status: http.StatusOK,
+		},
+		{
+			name:   "too many points",
+			path:   "/api/v1/query_range",
+			params: url.Values{"query": {"up"}, "start": {"0"}, "end": {"3600"}, "step": {"15s"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:    "feature limits do not loosen the default",
+			feature: "alerting",
+			path:    "/api/v1/query_range",
+			params:  url.Values{"query": {"up"}, "start": {"0"}, "end": {"3600"}, "step": {"15s"}},
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:   "range too long",
+			path:   "/api/v1/query_range",
+			params: url.Values{"query": {"up"}, "start": {"2024-01-01T00:00:00Z"}, "end": {"2024-01-03T00:00:00Z"}, "step": {"1h"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "step too small",
+			path:   "/api/v1/query_range",
+			params: url.Values{"query": {"up"}, "start": {"0"}, "end": {"60"}, "step": {"1"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "other paths are not checked",
+			path:   "/api/v1/labels",
+			params: url.Values{"start": {"0"}, "end": {"3600"}},
+			status: http.StatusOK,
+		},
+		{
+			name:    "clamped for feature",
+			feature: "cluster-health-analyzer",
+			path:    "/api/v1/query_range",
+			params:  url.Values{"query": {`up{job="a"}`}, "start": {"0"}, "end": {"172800"}, "step": {"60"}},
+			status:  http.StatusOK,
+			wantParams: url.Values{
+				"query": {`up{job="a"}`}, "start": {"86400"}, "end": {"172800"}, "step": {"864"},
+			},
+		},
+		{
+			name:    "clamped POST request",
+			method:  http.MethodPost,
+			feature: "cluster-health-analyzer",
+			path:    "/api/v1/query_range",
+			params:  url.Values{"query": {`up{job="a"}`}, "start": {"0"}, "end": {"1000"}, "step": {"1"}},
+			status:  http.StatusOK,
+			wantParams: url.Values{
+				"query": {`up{job="a"}`}, "start": {"0"}, "end": {"1000"}, "step": {"15"},
+			},
+		},
+		{
+			name:    "missing label matchers",
+			feature: "cluster-health-analyzer",
+			path:    "/api/v1/query",
+			params:  url.Values{"query": {`sum(up{job="a"}) / count(up)`}},
+			status:  http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got url.Values
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.NoError(t, r.ParseForm())
+				got = r.Form
+				w.WriteHeader(http.StatusOK)
+			})
+
+			var req *http.Request
+			if tc.method == http.MethodPost {
+				req = httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.params.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(http.MethodGet, tc.path+"?"+tc.params.Encode(), nil)
+			}
+			if tc.feature != "" {
+				req.Header.Set(FeatureHeader, tc.feature)
+			}
+
+			rec := httptest.NewRecorder()
+			queryPolicyMiddleware(policy, next).ServeHTTP(rec, req)
+
+			require.Equal(t, tc.status, rec.Code)
+			if tc.status != http.StatusOK {
+				var body map[string]string
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+				require.Equal(t, "error", body["status"])
+				require.Equal(t, errorBadData, body["errorType"])
+				return
+			}
+			if tc.wantParams != nil {
+				require.Equal(t, tc.wantParams, got)
+			}
+		})
+	}
+}
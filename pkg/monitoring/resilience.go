@@ -0,0 +1,347 @@
+package monitoring
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RetryConfig configures the retries of idempotent upstream requests which
+// failed with a connection error or a 502, 503 or 504 status, and the
+// timeouts detecting unresponsive upstreams.
+type RetryConfig struct {
+	// DialTimeout bounds the connection to an upstream.
+	DialTimeout time.Duration `yaml:"dialTimeout,omitempty"`
+	// ResponseHeaderTimeout bounds the wait for the response headers of an
+	// upstream once the request is sent, it should exceed the query timeout
+	// of the upstreams.
+	ResponseHeaderTimeout time.Duration `yaml:"responseHeaderTimeout,omitempty"`
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int `yaml:"maxRetries,omitempty"`
+	// Backoff is the initial wait between attempts, doubled on every retry.
+	Backoff time.Duration `yaml:"backoff,omitempty"`
+	// MaxBackoff caps the wait between attempts.
+	MaxBackoff time.Duration `yaml:"maxBackoff,omitempty"`
+}
+
+func (c RetryConfig) withDefaults() RetryConfig {
+	if c.DialTimeout == 0 {
+		c.DialTimeout = 5 * time.Second
+	}
+	if c.ResponseHeaderTimeout == 0 {
+		c.ResponseHeaderTimeout = 3 * time.Minute
+	}
+	if c.Backoff == 0 {
+		c.Backoff = 100 * time.Millisecond
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = 2 * time.Second
+	}
+	return c
+}
+
+// CircuitBreakerConfig configures the circuit breaker kept for each upstream.
+type CircuitBreakerConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// FailureThreshold is the number of consecutive failures opening the breaker.
+	FailureThreshold int `yaml:"failureThreshold,omitempty"`
+	// OpenDuration is how long requests fail fast before a probe is let through.
+	OpenDuration time.Duration `yaml:"openDuration,omitempty"`
+}
+
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.FailureThreshold == 0 {
+		c.FailureThreshold = 5
+	}
+	if c.OpenDuration == 0 {
+		c.OpenDuration = 30 * time.Second
+	}
+	return c
+}
+
+// errCircuitOpen is returned by the transport while the breaker of the
+// upstream is open.
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+func (s BreakerState) metricValue() float64 {
+	switch s {
+	case BreakerOpen:
+		return 2
+	case BreakerHalfOpen:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// circuitBreaker fails requests fast after FailureThreshold consecutive
+// failures. Once OpenDuration has passed, a single probe request is allowed and
+// its outcome closes or reopens the breaker.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	kind     KindType
+	upstream string
+	config   CircuitBreakerConfig
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+}
+
+func newCircuitBreaker(kind KindType, upstream string, config CircuitBreakerConfig) *circuitBreaker {
+	b := &circuitBreaker{
+		kind:     kind,
+		upstream: upstream,
+		config:   config,
+		state:    BreakerClosed,
+		now:      time.Now,
+	}
+	b.setState(BreakerClosed)
+	return b
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.config.OpenDuration {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			log.Infof("%s upstream %s recovered, closing circuit breaker", b.kind, b.upstream)
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.config.FailureThreshold {
+		if b.state != BreakerOpen {
+			log.Warnf("%s upstream %s failed %d times, opening circuit breaker", b.kind, b.upstream, b.failures)
+		}
+		b.openedAt = b.now()
+		b.setState(BreakerOpen)
+	}
+}
+
+// release ends a probe without an outcome, e.g. when the client went away.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *circuitBreaker) setState(state BreakerState) {
+	b.state = state
+	breakerState.WithLabelValues(string(b.kind), b.upstream).Set(state.metricValue())
+}
+
+func (b *circuitBreaker) status() UpstreamStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return UpstreamStatus{
+		Kind:     b.kind,
+		Upstream: b.upstream,
+		State:    b.state,
+		Failures: b.failures,
+	}
+}
+
+// UpstreamStatus is the circuit breaker state of a proxied upstream.
+type UpstreamStatus struct {
+	Kind     KindType     `json:"kind"`
+	Upstream string       `json:"upstream"`
+	State    BreakerState `json:"state"`
+	Failures int          `json:"failures"`
+}
+
+var breakers = struct {
+	sync.Mutex
+	byKey map[string]*circuitBreaker
+}{byKey: map[string]*circuitBreaker{}}
+
+func getCircuitBreaker(kind KindType, upstream string, config CircuitBreakerConfig) *circuitBreaker {
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	key := string(kind) + "|" + upstream
+	b, ok := breakers.byKey[key]
+	if !ok {
+		b = newCircuitBreaker(kind, upstream, config)
+		breakers.byKey[key] = b
+	}
+	return b
+}
+
+// UpstreamStatuses returns the circuit breaker state of every upstream.
+func UpstreamStatuses() []UpstreamStatus {
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	statuses := make([]UpstreamStatus, 0, len(breakers.byKey))
+	for _, b := range breakers.byKey {
+		statuses = append(statuses, b.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Kind != statuses[j].Kind {
+			return statuses[i].Kind < statuses[j].Kind
+		}
+		return statuses[i].Upstream < statuses[j].Upstream
+	})
+	return statuses
+}
+
+// resilientTransport retries idempotent requests and keeps a circuit breaker
+// for every upstream host.
+type resilientTransport struct {
+	next    http.RoundTripper
+	kind    KindType
+	retry   RetryConfig
+	breaker CircuitBreakerConfig
+}
+
+func newResilientTransport(kind KindType, next http.RoundTripper, retry RetryConfig, breaker CircuitBreakerConfig) http.RoundTripper {
+	if retry.MaxRetries == 0 && !breaker.Enabled {
+		return next
+	}
+	return &resilientTransport{
+		next:    next,
+		kind:    kind,
+		retry:   retry.withDefaults(),
+		breaker: breaker.withDefaults(),
+	}
+}
+
+func (t *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var breaker *circuitBreaker
+	if t.breaker.Enabled {
+		breaker = getCircuitBreaker(t.kind, req.URL.Host, t.breaker)
+		if !breaker.allow() {
+			return nil, fmt.Errorf("%s upstream %s: %w", t.kind, req.URL.Host, errCircuitOpen)
+		}
+	}
+
+	retries := 0
+	if isIdempotent(req) {
+		retries = t.retry.MaxRetries
+	}
+
+	backoff := t.retry.Backoff
+	attemptReq := req
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(attemptReq)
+		if req.Context().Err() != nil {
+			// Cancelled requests tell nothing about the upstream health.
+			if breaker != nil {
+				breaker.release()
+			}
+			return resp, err
+		}
+
+		failed := err != nil || isRetryableStatus(resp.StatusCode)
+		if !failed || attempt >= retries {
+			if breaker != nil {
+				breaker.record(!failed)
+			}
+			return resp, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+		upstreamRetries.WithLabelValues(string(t.kind)).Inc()
+		log.WithError(err).Debugf("retrying %s %s, attempt %d", req.Method, req.URL.Path, attempt+1)
+
+		// Full jitter keeps the retries of concurrent requests apart.
+		wait := time.Duration(rand.Int63n(int64(backoff) + 1))
+		select {
+		case <-req.Context().Done():
+			if breaker != nil {
+				breaker.release()
+			}
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, t.retry.MaxBackoff)
+
+		// Round trippers must not modify the request, every attempt is sent
+		// with a clone reading the body again.
+		attemptReq = req.Clone(req.Context())
+		if req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				if breaker != nil {
+					breaker.release()
+				}
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+	}
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	default:
+		return false
+	}
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// proxyErrorHandler reports upstream failures as JSON, failing fast with a 503
+// while the circuit breaker of the upstream is open.
+func proxyErrorHandler(kind KindType) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, errCircuitOpen) {
+			writePrometheusError(w, http.StatusServiceUnavailable, errorUnavailable,
+				fmt.Sprintf("%s is unavailable: %v", kind, err))
+			return
+		}
+		log.WithError(err).Warnf("%s proxy request %s failed", kind, r.URL.Path)
+		writePrometheusError(w, http.StatusBadGateway, errorUnavailable,
+			fmt.Sprintf("%s upstream request failed: %v", kind, err))
+	}
+}
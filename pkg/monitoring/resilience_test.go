@@ -0,0 +1,100 @@
+package monitoring
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestResilientTransportRetries(t *testing.T) {
+	calls := 0
+	transport := newResilientTransport("test-retries", roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls < 3 {
+			rec := httptest.NewRecorder()
+			rec.WriteHeader(http.StatusServiceUnavailable)
+			return rec.Result(), nil
+		}
+		return httptest.NewRecorder().Result(), nil
+	}), RetryConfig{MaxRetries: 3, Backoff: time.Millisecond}, CircuitBreakerConfig{})
+
+	resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://thanos/api/v1/query", nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 3, calls)
+
+	// POST requests are not retried.
+	calls = 0
+	resp, err = transport.RoundTrip(httptest.NewRequest(http.MethodPost, "http://thanos/api/v2/silences", nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, 1, calls)
+
+	// Every attempt reads the body of a clone of the request.
+	calls = 0
+	var bodies []string
+	var attempts []*http.Request
+	transport = newResilientTransport("test-retries", roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		body, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		attempts = append(attempts, req)
+		return nil, errors.New("connection reset")
+	}), RetryConfig{MaxRetries: 1, Backoff: time.Millisecond}, CircuitBreakerConfig{})
+	req := httptest.NewRequest(http.MethodGet, "http://thanos/api/v1/query", nil)
+	req.Body = io.NopCloser(strings.NewReader("query=up"))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("query=up")), nil }
+	body := req.Body
+	_, err = transport.RoundTrip(req)
+	require.Error(t, err)
+	require.Equal(t, []string{"query=up", "query=up"}, bodies)
+	require.Same(t, req, attempts[0])
+	require.NotSame(t, req, attempts[1])
+	require.Equal(t, body, req.Body)
+}
+
+func TestResilientTransportCircuitBreaker(t *testing.T) {
+	healthy := false
+	calls := 0
+	transport := newResilientTransport("test-breaker", roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		if !healthy {
+			return nil, errors.New("connection refused")
+		}
+		return httptest.NewRecorder().Result(), nil
+	}), RetryConfig{}, CircuitBreakerConfig{Enabled: true, FailureThreshold: 2, OpenDuration: time.Minute})
+
+	roundTrip := func() error {
+		_, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://thanos/api/v1/query", nil))
+		return err
+	}
+
+	require.Error(t, roundTrip())
+	breaker := getCircuitBreaker("test-breaker", "thanos", CircuitBreakerConfig{})
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	require.Error(t, roundTrip())
+	require.Equal(t, BreakerOpen, breaker.status().State)
+
+	// Requests fail fast while the breaker is open.
+	require.ErrorIs(t, roundTrip(), errCircuitOpen)
+	require.Equal(t, 2, calls)
+
+	// A successful probe closes the breaker.
+	now = now.Add(time.Minute)
+	healthy = true
+	require.NoError(t, roundTrip())
+	require.Equal(t, BreakerClosed, breaker.status().State)
+}
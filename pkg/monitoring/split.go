@@ -0,0 +1,141 @@
+package monitoring
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSplitRanges bounds the sub-queries of a range query, the longer ranges
+// are not split.
+const maxSplitRanges = 100
+
+// SplitConfig configures the splitting of long range queries into day aligned
+// sub-queries that are executed in parallel.
+type SplitConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Interval is the length of the sub-queries, ranges not longer than it are
+	// not split.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// MaxParallelism bounds the number of sub-queries running at once.
+	MaxParallelism int `yaml:"maxParallelism,omitempty"`
+}
+
+func (c SplitConfig) withDefaults() SplitConfig {
+	if c.Interval == 0 {
+		c.Interval = 24 * time.Hour
+	}
+	if c.MaxParallelism == 0 {
+		c.MaxParallelism = 4
+	}
+	return c
+}
+
+// splitMiddleware splits range queries longer than the configured interval in
+// sub-queries aligned to the interval, usually UTC days. The sub-queries run
+// with bounded parallelism and their results are merged in a single matrix.
+// Failed sub-queries are reported as warnings unless all of them failed.
+func splitMiddleware(config SplitConfig, next http.Handler) http.Handler {
+	if !config.Enabled {
+		return next
+	}
+	config = config.withDefaults()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodPost) || !strings.HasSuffix(r.URL.Path, queryRangePath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		params, err := requestParams(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rng, err := parseRangeParams(params)
+		if err != nil || rng.step < time.Millisecond || rng.end.Sub(rng.start) <= config.Interval {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		step := rng.step.Milliseconds()
+		interval := config.Interval.Milliseconds()
+		start, end := rng.start.UnixMilli(), rng.end.UnixMilli()
+		if (floorTo(end, interval)-floorTo(start, interval))/interval >= maxSplitRanges {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Each sub-query starts at the first sample of its interval that is on
+		// the step grid of the original query.
+		var ranges [][2]int64
+		for s := start; s <= end; {
+			e := min(end, s+(floorTo(s, interval)+interval-1-s)/step*step)
+			ranges = append(ranges, [2]int64{s, e})
+			s = e + step
+		}
+
+		type subResult struct {
+			resp     *apiResponse
+			result   []series
+			upstream *bufferedResponse
+		}
+		results := make([]subResult, len(ranges))
+		sem := make(chan struct{}, config.MaxParallelism)
+		var wg sync.WaitGroup
+		for i, rng := range ranges {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(i int, start, end int64) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				resp, result, upstream := fetchRange(next, r, params, start, end)
+				results[i] = subResult{resp: resp, result: result, upstream: upstream}
+			}(i, rng[0], rng[1])
+		}
+		wg.Wait()
+
+		var (
+			warnings, infos []string
+			matrices        [][]series
+			failed          *bufferedResponse
+		)
+		for i, res := range results {
+			if res.resp == nil {
+				failed = res.upstream
+				warnings = append(warnings, fmt.Sprintf("partial result: query for range %s to %s failed: %s",
+					time.UnixMilli(ranges[i][0]).UTC().Format(time.RFC3339), time.UnixMilli(ranges[i][1]).UTC().Format(time.RFC3339), upstreamError(res.upstream)))
+				continue
+			}
+			warnings = append(warnings, res.resp.Warnings...)
+			infos = append(infos, res.resp.Infos...)
+			matrices = append(matrices, res.result)
+		}
+
+		if len(matrices) == 0 {
+			failed.copyTo(w)
+			return
+		}
+
+		body, err := encodeMatrix(mergeMatrices(matrices...), warnings, infos)
+		if err != nil {
+			writePrometheusError(w, http.StatusInternalServerError, errorInternal, err.Error())
+			return
+		}
+		writeJSON(w, body)
+	})
+}
+
+// upstreamError describes a failed upstream response, using the Prometheus
+// error message when there is one.
+func upstreamError(resp *bufferedResponse) string {
+	if resp == nil {
+		return "no response"
+	}
+	if decoded, _, err := decodeMatrix(resp.body.Bytes()); decoded != nil && err != nil {
+		return decoded.Error
+	}
+	return fmt.Sprintf("unexpected status %d", resp.statusCode())
+}
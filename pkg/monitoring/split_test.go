@@ -0,0 +1,68 @@
+package monitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitMiddleware(t *testing.T) {
+	var mu sync.Mutex
+	upstream := &fakeRangeUpstream{}
+	failStart := ""
+	handler := splitMiddleware(SplitConfig{Enabled: true}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Query().Get("start") == failStart {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"status":"error","errorType":"unavailable","error":"store unavailable"}`))
+			return
+		}
+		upstream.ServeHTTP(w, r)
+	}))
+
+	query := func(end string) *httptest.ResponseRecorder {
+		params := url.Values{
+			"query": {"up"},
+			"start": {"1800"},
+			"end":   {end},
+			"step":  {"3600"},
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, queryRangePath+"?"+params.Encode(), nil))
+		return rec
+	}
+
+	rec := query("260000")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, upstream.requests, 3)
+
+	resp, result, err := decodeMatrix(rec.Body.Bytes())
+	require.NoError(t, err)
+	require.Empty(t, resp.Warnings)
+	require.Len(t, result, 1)
+	require.Len(t, result[0].Values, 72)
+	require.Equal(t, float64(1800), result[0].Values[0].T)
+	require.Equal(t, float64(1800+71*3600), result[0].Values[71].T)
+
+	// The second day fails and is reported as a warning.
+	failStart = "88200"
+	rec = query("260000")
+	require.Equal(t, http.StatusOK, rec.Code)
+	resp, result, err = decodeMatrix(rec.Body.Bytes())
+	require.NoError(t, err)
+	require.Len(t, resp.Warnings, 1)
+	require.Contains(t, resp.Warnings[0], "store unavailable")
+	require.Len(t, result[0].Values, 48)
+
+	// Ranges of too many days are not split.
+	failStart = ""
+	rec = query("20000000")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, upstream.requests, 6)
+	require.Equal(t, "20000000", upstream.requests[5].Get("end"))
+}
@@ -0,0 +1,137 @@
+package monitoring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Upstream sends requests through a datasource proxy handler. It is used by
+// the backend APIs which combine the responses of several upstream requests.
+type Upstream struct {
+	Kind    KindType
+	Handler http.Handler
+}
+
+// UpstreamError is returned for upstream responses with a non 2xx status.
+type UpstreamError struct {
+	Kind   KindType
+	Status int
+	Body   string
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("%s responded with status %d: %s", e.Kind, e.Status, strings.TrimSpace(e.Body))
+}
+
+// credentialHeaders are copied from the incoming request so that upstream
+// requests are made on behalf of the user.
+var credentialHeaders = []string{"Authorization", "Cookie", "X-Forwarded-Access-Token"}
+
+// NewRequest creates an upstream request carrying the credentials of r. A nil
+// r creates a request without credentials, for background work.
+func (u Upstream) NewRequest(r *http.Request, method string, path string, query url.Values, body interface{}) (*http.Request, error) {
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	target := path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if r != nil {
+		for _, h := range credentialHeaders {
+			if v := r.Header.Get(h); v != "" {
+				req.Header.Set(h, v)
+			}
+		}
+	}
+	return req, nil
+}
+
+// Do sends the request and decodes the JSON response in out, unless out is nil.
+func (u Upstream) Do(req *http.Request, out interface{}) error {
+	resp := newBufferedResponse()
+	u.Handler.ServeHTTP(resp, req)
+
+	if resp.statusCode() < 200 || resp.statusCode() > 299 {
+		return &UpstreamError{Kind: u.Kind, Status: resp.statusCode(), Body: resp.body.String()}
+	}
+	if out == nil || resp.body.Len() == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.body.Bytes(), out); err != nil {
+		return fmt.Errorf("cannot decode %s response: %w", u.Kind, err)
+	}
+	return nil
+}
+
+// Get sends a GET request on behalf of the user of r.
+func (u Upstream) Get(r *http.Request, path string, query url.Values, out interface{}) error {
+	req, err := u.NewRequest(r, http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+	return u.Do(req, out)
+}
+
+// Query runs a Prometheus query and decodes the data of the response in out.
+func (u Upstream) Query(r *http.Request, path string, params url.Values, out interface{}) error {
+	var resp apiResponse
+	if err := u.Get(r, path, params, &resp); err != nil {
+		var upstreamErr *UpstreamError
+		if errors.As(err, &upstreamErr) {
+			if json.Unmarshal([]byte(upstreamErr.Body), &resp) == nil && resp.Error != "" {
+				return fmt.Errorf("%s query failed: %s", u.Kind, resp.Error)
+			}
+		}
+		return err
+	}
+	if resp.Status != "success" {
+		return fmt.Errorf("%s query failed: %s", u.Kind, resp.Error)
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+// TenantKey identifies the credentials of r, for the APIs which cache upstream
+// responses per user.
+func TenantKey(r *http.Request) string {
+	return tenantKey(r)
+}
+
+// ParseTime parses a timestamp of the Prometheus API, as a float number of
+// seconds or in the RFC3339 format.
+func ParseTime(s string) (time.Time, error) {
+	return parseTime(s)
+}
+
+// ParseDuration parses a duration of the Prometheus API, as a float number of
+// seconds or a Prometheus duration string.
+func ParseDuration(s string) (time.Duration, error) {
+	return parseDuration(s)
+}
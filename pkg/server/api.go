@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/openshift/monitoring-plugin/pkg/alerting"
+	"github.com/openshift/monitoring-plugin/pkg/dashboards"
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+	"github.com/openshift/monitoring-plugin/pkg/simulation"
+)
+
+// backend holds the datasource proxies of the ACM mode and the services of
+// the backend APIs built on top of them.
+type backend struct {
+	alertmanager  monitoring.Upstream
+	thanosQuerier monitoring.Upstream
+	rules         *alerting.Resources
+	amConfigs     *alerting.AlertmanagerConfigs
+	silences      *alerting.Silences
+	// The optional services are nil unless enabled in the plugin config.
+	scheduler *alerting.Scheduler
+	templates *alerting.Templates
+	audit     *alerting.Audit
+}
+
+func newBackend(ctx context.Context, cfg *Config, k8sconfig *rest.Config, k8sclient *dynamic.DynamicClient, pluginConfig *PluginConfig) (*backend, error) {
+	if pluginConfig == nil {
+		pluginConfig = &PluginConfig{}
+	}
+	proxyConfig := pluginConfig.Proxy
+
+	var alertmanager, thanosQuerier http.Handler
+	if cfg.Simulation != "" || cfg.Dev {
+		scenario := simulation.Dev(time.Now())
+		if cfg.Simulation != "" {
+			var err error
+			if scenario, err = simulation.Load(cfg.Simulation, time.Now()); err != nil {
+				return nil, err
+			}
+		}
+		sim := simulation.NewServer(scenario)
+		alertmanager, thanosQuerier = sim.AlertmanagerHandler(), sim.PrometheusHandler()
+	} else {
+		alertmanager = monitoring.NewProxyHandler(k8sclient, cfg.CertFile, monitoring.AlertManagerKind, cfg.AlertmanagerUrl, proxyConfig)
+		thanosQuerier = monitoring.NewProxyHandler(k8sclient, cfg.CertFile, monitoring.ThanosQuerierKind, cfg.ThanosQuerierUrl, proxyConfig)
+	}
+	b := &backend{
+		thanosQuerier: monitoring.Upstream{Kind: monitoring.ThanosQuerierKind, Handler: thanosQuerier},
+		rules:         alerting.NewPrometheusRules(userClient(k8sconfig)),
+	}
+
+	if history := pluginConfig.SilenceHistory; history.Enabled {
+		audit, err := alerting.NewAudit(history, k8sclient, userResolver(k8sconfig), silenceAccess(k8sconfig, history.AccessAttributes()))
+		if err != nil {
+			return nil, err
+		}
+		// Audit every silence change, from the proxy server and the backend APIs.
+		alertmanager = audit.Middleware(alertmanager)
+		go audit.Run(ctx)
+		b.audit = audit
+		log.Info("silence history enabled")
+	}
+	b.silences = alerting.NewSilences(monitoring.Upstream{Kind: monitoring.AlertManagerKind, Handler: alertmanager}, b.thanosQuerier)
+	// Drop the cached silences on the writes of the proxy server and the
+	// silence schedules.
+	b.alertmanager = monitoring.Upstream{Kind: monitoring.AlertManagerKind, Handler: b.silences.Middleware(alertmanager)}
+
+	amURL, err := monitoring.ExternalURL(monitoring.AlertManagerKind, cfg.AlertmanagerUrl, proxyConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alertmanager URL: %w", err)
+	}
+	if b.amConfigs, err = alerting.NewAlertmanagerConfigs(userClient(k8sconfig), amURL); err != nil {
+		return nil, err
+	}
+
+	if templates := pluginConfig.SilenceTemplates; templates.Enabled {
+		t, err := alerting.NewTemplates(templates, k8sclient, silenceAccess(k8sconfig, templates.AccessAttributes()))
+		if err != nil {
+			return nil, err
+		}
+		b.templates = t
+		log.Info("silence templates enabled")
+	}
+
+	if schedules := pluginConfig.SilenceSchedules; schedules.Enabled {
+		store, err := alerting.NewScheduleStore(schedules, k8sclient)
+		if err != nil {
+			return nil, err
+		}
+		b.scheduler = alerting.NewScheduler(schedules, store, b.alertmanager, silenceAccess(k8sconfig, schedules.AccessAttributes()))
+		if schedules.File == "" && k8sconfig != nil {
+			// The replicas sharing the ConfigMap elect the one managing the silences.
+			client, err := kubernetes.NewForConfig(k8sconfig)
+			if err != nil {
+				return nil, err
+			}
+			if err := b.scheduler.Elect(ctx, client); err != nil {
+				return nil, err
+			}
+		}
+		go b.scheduler.Run(ctx)
+		log.Info("silence schedules enabled")
+	}
+
+	return b, nil
+}
+
+// setupAPIRoutes registers the backend APIs, which combine the responses of
+// the upstreams on behalf of the frontend.
+func setupAPIRoutes(router *mux.Router, b *backend) {
+	api := router.PathPrefix("/api/v1").Subrouter()
+
+	api.Path("/alerts").Methods("GET").HandlerFunc(alerting.AlertsHandler(b.thanosQuerier, b.alertmanager))
+	api.Path("/silences").Methods("GET").HandlerFunc(b.silences.ListHandler())
+	api.Path("/silences/bulk").Methods("POST").HandlerFunc(b.silences.BulkHandler())
+	api.Path("/alertmanager/routing").Methods("GET", "POST").HandlerFunc(alerting.RoutingHandler(b.alertmanager))
+	incidents := alerting.NewIncidents(b.thanosQuerier)
+	api.Path("/incidents").Methods("GET").HandlerFunc(incidents.ListHandler())
+	api.Path("/incidents/{group}").Methods("GET").HandlerFunc(incidents.GetHandler())
+	api.Path("/rules/preview").Methods("GET", "POST").HandlerFunc(alerting.PreviewHandler(b.thanosQuerier))
+
+	api.Path("/namespaces/{namespace}/prometheusrules").Methods("GET").HandlerFunc(b.rules.ListHandler())
+	api.Path("/namespaces/{namespace}/prometheusrules").Methods("POST").HandlerFunc(b.rules.CreateHandler())
+	api.Path("/namespaces/{namespace}/prometheusrules/{name}").Methods("GET").HandlerFunc(b.rules.GetHandler())
+	api.Path("/namespaces/{namespace}/prometheusrules/{name}").Methods("PUT").HandlerFunc(b.rules.UpdateHandler())
+	api.Path("/namespaces/{namespace}/prometheusrules/{name}").Methods("DELETE").HandlerFunc(b.rules.DeleteHandler())
+
+	api.Path("/namespaces/{namespace}/alertmanagerconfigs").Methods("GET").HandlerFunc(b.amConfigs.ListHandler())
+	api.Path("/namespaces/{namespace}/alertmanagerconfigs").Methods("POST").HandlerFunc(b.amConfigs.CreateHandler())
+	api.Path("/namespaces/{namespace}/alertmanagerconfigs/{name}").Methods("GET").HandlerFunc(b.amConfigs.GetHandler())
+	api.Path("/namespaces/{namespace}/alertmanagerconfigs/{name}").Methods("PUT").HandlerFunc(b.amConfigs.UpdateHandler())
+	api.Path("/namespaces/{namespace}/alertmanagerconfigs/{name}").Methods("DELETE").HandlerFunc(b.amConfigs.DeleteHandler())
+	api.Path("/namespaces/{namespace}/alertmanagerconfigs/{name}/receivers/{receiver}/test").Methods("POST").HandlerFunc(b.amConfigs.TestReceiverHandler())
+
+	if b.scheduler != nil {
+		api.Path("/silence-schedules").Methods("GET").HandlerFunc(b.scheduler.ListHandler())
+		api.Path("/silence-schedules/{name}").Methods("PUT").HandlerFunc(b.scheduler.PutHandler())
+		api.Path("/silence-schedules/{name}").Methods("DELETE").HandlerFunc(b.scheduler.DeleteHandler())
+	}
+	if b.templates != nil {
+		api.Path("/silence-templates").Methods("GET").HandlerFunc(b.templates.ListHandler())
+		api.Path("/silence-templates/{name}").Methods("PUT").HandlerFunc(b.templates.PutHandler())
+		api.Path("/silence-templates/{name}").Methods("DELETE").HandlerFunc(b.templates.DeleteHandler())
+	}
+	if b.audit != nil {
+		api.Path("/silence-history").Methods("GET").HandlerFunc(b.audit.ListHandler())
+	}
+}
+
+// dashboardsAPI holds the services of the dashboards features, which unlike
+// the backend do not depend on the ACM mode.
+type dashboardsAPI struct {
+	converter *dashboards.Converter
+	discovery *dashboards.Discovery
+	transfer  *dashboards.Transfer
+}
+
+// newDashboardsAPI returns nil unless a dashboards feature is enabled. The
+// discovery is disabled without k8sconfig, and the perses handler is nil
+// unless the Perses proxy is configured.
+func newDashboardsAPI(cfg *Config, k8sconfig *rest.Config, perses http.Handler) *dashboardsAPI {
+	legacy := cfg.Features[LegacyDashboards]
+	if !legacy && !cfg.Features[PersesDashboards] {
+		return nil
+	}
+	var persesUpstream *monitoring.Upstream
+	if perses != nil {
+		persesUpstream = &monitoring.Upstream{Kind: monitoring.PersesKind, Handler: perses}
+	}
+	d := &dashboardsAPI{}
+	var client alerting.ClientFunc
+	if legacy {
+		d.converter = dashboards.NewConverter(persesUpstream)
+		if k8sconfig != nil {
+			client = userClient(k8sconfig)
+			d.discovery = dashboards.NewDiscovery(client, newNamespaceAccess(k8sconfig).Namespaces)
+		}
+	}
+	if client != nil || persesUpstream != nil {
+		d.transfer = dashboards.NewTransfer(client, persesUpstream)
+	}
+	return d
+}
+
+func setupDashboardRoutes(router *mux.Router, d *dashboardsAPI) {
+	api := router.PathPrefix("/api/v1").Subrouter()
+
+	if d.converter != nil {
+		api.Path("/legacy-dashboards/convert").Methods("POST").HandlerFunc(d.converter.ConvertHandler())
+	}
+	if d.discovery != nil {
+		api.Path("/legacy-dashboards").Methods("GET").HandlerFunc(d.discovery.ListHandler())
+		api.Path("/legacy-dashboards/validate").Methods("POST").HandlerFunc(d.discovery.ValidateHandler())
+	}
+	if d.transfer != nil {
+		api.Path("/dashboards/export").Methods("GET").HandlerFunc(d.transfer.ExportHandler())
+		api.Path("/dashboards/import").Methods("POST").HandlerFunc(d.transfer.ImportHandler())
+	}
+}
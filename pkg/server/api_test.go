@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/monitoring-plugin/pkg/alerting"
+	"github.com/openshift/monitoring-plugin/pkg/dashboards"
+)
+
+// TestDevBackend runs the backend APIs of the ACM mode against the upstreams
+// of the dev mode.
+func TestDevBackend(t *testing.T) {
+	cfg := &Config{Dev: true, Features: map[Feature]bool{AcmAlerting: true, LegacyDashboards: true}}
+	b, err := newBackend(context.Background(), cfg, nil, nil, nil)
+	require.NoError(t, err)
+	router := setupRoutes(cfg, http.NotFound, b, newDashboardsAPI(cfg, nil, nil))
+
+	get := func(target string, out interface{}) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), out))
+	}
+
+	var alerts alerting.AlertsResponse
+	get("/api/v1/alerts", &alerts)
+	require.Empty(t, alerts.Warnings)
+	require.NotZero(t, alerts.Total)
+	states := map[string]string{}
+	for _, a := range alerts.Data {
+		states[a.Labels["alertname"]] = a.State
+	}
+	require.Equal(t, alerting.StateFiring, states["etcdMembersDown"])
+	require.Equal(t, alerting.StateSilenced, states["KubeDeploymentReplicasMismatch"])
+
+	var incidents alerting.IncidentsResponse
+	get("/api/v1/incidents?days=3", &incidents)
+	require.Len(t, incidents.Data, 4)
+	require.Equal(t, "critical", incidents.Data[3].Severity)
+	require.True(t, incidents.Data[3].Firing)
+
+	var routing alerting.RoutingResponse
+	get("/api/v1/alertmanager/routing?label=alertname=etcdMembersDown&label=severity=critical", &routing)
+	require.Equal(t, "critical", routing.Routes[0].Receiver)
+}
+
+// TestConvertRoute checks that the dashboards APIs are served next to the
+// backend APIs, and that the dashboards cannot be applied without Perses.
+func TestConvertRoute(t *testing.T) {
+	cfg := &Config{Dev: true, Features: map[Feature]bool{AcmAlerting: true, LegacyDashboards: true}}
+	b, err := newBackend(context.Background(), cfg, nil, nil, nil)
+	require.NoError(t, err)
+	router := setupRoutes(cfg, http.NotFound, b, newDashboardsAPI(cfg, nil, nil))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/legacy-dashboards/convert", strings.NewReader(body)))
+		return rec
+	}
+	rec := post(`{"dashboards":[{"name":"etcd","data":"{\"title\":\"etcd\",\"rows\":[]}"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resp dashboards.ConvertResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "etcd", resp.Results[0].Dashboard.Metadata.Name)
+
+	rec = post(`{"apply":true,"project":"ns","dashboards":[{"name":"etcd","data":{}}]}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestDashboardsAPI(t *testing.T) {
+	require.Nil(t, newDashboardsAPI(&Config{Features: map[Feature]bool{}}, nil, nil))
+
+	// Without the Perses proxy nor the API server, there is nothing to export.
+	d := newDashboardsAPI(&Config{Features: map[Feature]bool{PersesDashboards: true}}, nil, nil)
+	require.NotNil(t, d)
+	require.Nil(t, d.converter)
+	require.Nil(t, d.transfer)
+
+	cfg := &Config{Features: map[Feature]bool{PersesDashboards: true}}
+	router := setupRoutes(cfg, http.NotFound, nil, newDashboardsAPI(cfg, nil, http.NotFoundHandler()))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/dashboards/export?legacy=ns", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "legacy dashboards are not available")
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/openshift/monitoring-plugin/pkg/alerting"
+)
+
+const (
+	userCacheTTL = 5 * time.Minute
+	unknownUser  = "unknown"
+)
+
+type cachedUser struct {
+	name    string
+	expires time.Time
+}
+
+// userResolver identifies the user of a request from its bearer token with a
+// SelfSubjectReview. Requests which cannot be identified are recorded as made
+// by an unknown user, client headers such as X-Forwarded-User are not trusted.
+func userResolver(config *rest.Config) alerting.UserFunc {
+	var (
+		mu    sync.Mutex
+		users = map[string]cachedUser{}
+	)
+	return func(r *http.Request) string {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if config == nil || token == "" {
+			return unknownUser
+		}
+
+		sum := sha256.Sum256([]byte(token))
+		key := hex.EncodeToString(sum[:])
+		now := time.Now()
+		mu.Lock()
+		cached, ok := users[key]
+		mu.Unlock()
+		if ok && now.Before(cached.expires) {
+			return cached.name
+		}
+
+		userConfig := rest.AnonymousClientConfig(config)
+		userConfig.BearerToken = token
+		client, err := kubernetes.NewForConfig(userConfig)
+		if err != nil {
+			log.WithError(err).Warn("cannot create client to identify user")
+			return unknownUser
+		}
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 10*time.Second)
+		defer cancel()
+		review, err := client.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+		if err != nil {
+			log.WithError(err).Warn("cannot identify user")
+			return unknownUser
+		}
+
+		name := review.Status.UserInfo.Username
+		mu.Lock()
+		defer mu.Unlock()
+		for k, u := range users {
+			if now.After(u.expires) {
+				delete(users, k)
+			}
+		}
+		users[key] = cachedUser{name: name, expires: now.Add(userCacheTTL)}
+		return name
+	}
+}
+
+// silenceAccess authorizes the users who can create silences, with the
+// SelfSubjectAccessReview of the attributes checked by kube-rbac-proxy in
+// front of Alertmanager, and returns their name.
+func silenceAccess(config *rest.Config, attributes authorizationv1.ResourceAttributes) alerting.AccessFunc {
+	return func(r *http.Request) (string, error) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if config == nil || token == "" {
+			return "", apierrors.NewUnauthorized("the request has no bearer token")
+		}
+		userConfig := rest.AnonymousClientConfig(config)
+		userConfig.BearerToken = token
+		client, err := kubernetes.NewForConfig(userConfig)
+		if err != nil {
+			return "", err
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		access, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attributes},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return "", err
+		}
+		if !access.Status.Allowed {
+			resource := schema.GroupResource{Group: attributes.Group, Resource: attributes.Resource + "/" + attributes.Subresource}
+			return "", apierrors.NewForbidden(resource, attributes.Name, errors.New("the user cannot create silences"))
+		}
+		review, err := client.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+		if err != nil {
+			return "", err
+		}
+		return review.Status.UserInfo.Username, nil
+	}
+}
+
+// userClient returns a dynamic client using the bearer token of the request,
+// so that the API server applies the permissions of the user.
+func userClient(config *rest.Config) alerting.ClientFunc {
+	return func(r *http.Request) (dynamic.Interface, error) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if config == nil || token == "" {
+			return nil, errors.New("the request has no bearer token")
+		}
+		userConfig := rest.AnonymousClientConfig(config)
+		userConfig.BearerToken = token
+		return dynamic.NewForConfig(userConfig)
+	}
+}
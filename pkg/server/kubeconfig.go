@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// KubeConfig selects the Kubernetes client config of the ACM mode.
+type KubeConfig struct {
+	// Path is the kubeconfig file of the --kubeconfig flag.
+	Path string
+	// Context is the kubeconfig context to use instead of the current one.
+	Context string
+	// ImpersonateUser and ImpersonateGroups make the requests of the backend
+	// on behalf of another user, as kubectl --as and --as-group do.
+	ImpersonateUser   string
+	ImpersonateGroups []string
+}
+
+// kubeConfigSource is where a client config was loaded from, named in the
+// errors and logs.
+type kubeConfigSource string
+
+const (
+	kubeconfigFlag kubeConfigSource = "--kubeconfig flag"
+	kubeconfigEnv  kubeConfigSource = "KUBECONFIG environment variable"
+	inCluster      kubeConfigSource = "in-cluster config"
+)
+
+// load returns the client config of the --kubeconfig flag, the KUBECONFIG
+// environment variable or the in-cluster config, in that order. Simulations
+// run without a cluster unless a kubeconfig is set, and get a nil config.
+func (k KubeConfig) load(simulated bool) (*rest.Config, error) {
+	if len(k.ImpersonateGroups) > 0 && k.ImpersonateUser == "" {
+		return nil, fmt.Errorf("cannot impersonate groups %v without a user", k.ImpersonateGroups)
+	}
+
+	rules := &clientcmd.ClientConfigLoadingRules{}
+	var source kubeConfigSource
+	switch env := os.Getenv(clientcmd.RecommendedConfigPathEnvVar); {
+	case k.Path != "":
+		rules.ExplicitPath = k.Path
+		source = kubeconfigFlag
+	case env != "":
+		rules.Precedence = filepath.SplitList(env)
+		source = kubeconfigEnv
+	case simulated:
+		return nil, nil
+	default:
+		return k.inCluster()
+	}
+
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: k.Context,
+		AuthInfo: clientcmdapi.AuthInfo{
+			Impersonate:       k.ImpersonateUser,
+			ImpersonateGroups: k.ImpersonateGroups,
+		},
+	}
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+	config, err := loader.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("cannot load kubeconfig from the %s: %w", source, err)
+	}
+
+	context := k.Context
+	if context == "" {
+		raw, err := loader.RawConfig()
+		if err != nil {
+			return nil, fmt.Errorf("cannot load kubeconfig from the %s: %w", source, err)
+		}
+		context = raw.CurrentContext
+	}
+	log.Infof("using context %q of the kubeconfig from the %s", context, source)
+	return config, nil
+}
+
+func (k KubeConfig) inCluster() (*rest.Config, error) {
+	if k.Context != "" {
+		return nil, fmt.Errorf("cannot select context %q with the %s, set a kubeconfig", k.Context, inCluster)
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get %s: %w", inCluster, err)
+	}
+	config.Impersonate = rest.ImpersonationConfig{
+		UserName: k.ImpersonateUser,
+		Groups:   k.ImpersonateGroups,
+	}
+	log.Infof("using the %s", inCluster)
+	return config, nil
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster: {server: https://dev.example.com:6443}
+- name: prod
+  cluster: {server: https://prod.example.com:6443}
+users:
+- name: admin
+  user: {token: secret}
+contexts:
+- name: dev
+  context: {cluster: dev, user: admin}
+- name: prod
+  context: {cluster: prod, user: admin}
+current-context: dev
+`
+
+func TestKubeConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kubeconfig")
+	require.NoError(t, os.WriteFile(path, []byte(testKubeconfig), 0o600))
+	t.Setenv("KUBECONFIG", "")
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	// Out of a cluster, only simulations run without a kubeconfig.
+	_, err := KubeConfig{}.load(false)
+	require.ErrorContains(t, err, "cannot get in-cluster config")
+	config, err := KubeConfig{}.load(true)
+	require.NoError(t, err)
+	require.Nil(t, config)
+
+	t.Setenv("KUBECONFIG", path)
+	config, err = KubeConfig{}.load(true)
+	require.NoError(t, err)
+	require.Equal(t, "https://dev.example.com:6443", config.Host)
+	require.Equal(t, "secret", config.BearerToken)
+
+	config, err = KubeConfig{Context: "prod", ImpersonateUser: "alice", ImpersonateGroups: []string{"sre"}}.load(false)
+	require.NoError(t, err)
+	require.Equal(t, "https://prod.example.com:6443", config.Host)
+	require.Equal(t, "alice", config.Impersonate.UserName)
+	require.Equal(t, []string{"sre"}, config.Impersonate.Groups)
+
+	_, err = KubeConfig{Context: "staging"}.load(false)
+	require.ErrorContains(t, err, "KUBECONFIG environment variable")
+
+	// The flag takes precedence over the environment variable.
+	_, err = KubeConfig{Path: filepath.Join(dir, "missing")}.load(false)
+	require.ErrorContains(t, err, "--kubeconfig flag")
+
+	_, err = KubeConfig{ImpersonateGroups: []string{"sre"}}.load(false)
+	require.ErrorContains(t, err, "without a user")
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// namespaceCacheTTL is shorter than userCacheTTL, so that new projects show
+// up quickly in the Perses dashboards.
+const namespaceCacheTTL = time.Minute
+
+var projectsResource = schema.GroupVersionResource{Group: "project.openshift.io", Version: "v1", Resource: "projects"}
+
+type cachedNamespaces struct {
+	namespaces map[string]bool
+	expires    time.Time
+}
+
+// namespaceAccess implements monitoring.NamespaceAccess with the bearer token
+// of the request, so that the API server applies the permissions of the user.
+type namespaceAccess struct {
+	config *rest.Config
+	mu     sync.Mutex
+	cache  map[string]cachedNamespaces
+}
+
+func newNamespaceAccess(config *rest.Config) *namespaceAccess {
+	return &namespaceAccess{config: config, cache: map[string]cachedNamespaces{}}
+}
+
+func (a *namespaceAccess) userConfig(r *http.Request) (*rest.Config, string, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if a.config == nil || token == "" {
+		return nil, "", errors.New("the request has no bearer token")
+	}
+	userConfig := rest.AnonymousClientConfig(a.config)
+	userConfig.BearerToken = token
+	sum := sha256.Sum256([]byte(token))
+	return userConfig, hex.EncodeToString(sum[:]), nil
+}
+
+// Namespaces lists the OpenShift projects of the user, which are the
+// namespaces they can access, or the namespaces on plain Kubernetes.
+func (a *namespaceAccess) Namespaces(r *http.Request) (map[string]bool, error) {
+	userConfig, key, err := a.userConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	a.mu.Lock()
+	cached, ok := a.cache[key]
+	a.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.namespaces, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 10*time.Second)
+	defer cancel()
+	namespaces := map[string]bool{}
+	client, err := dynamic.NewForConfig(userConfig)
+	if err != nil {
+		return nil, err
+	}
+	projects, err := client.Resource(projectsResource).List(ctx, metav1.ListOptions{})
+	switch {
+	case err == nil:
+		for _, p := range projects.Items {
+			namespaces[p.GetName()] = true
+		}
+	case apierrors.IsNotFound(err):
+		clientset, err := kubernetes.NewForConfig(userConfig)
+		if err != nil {
+			return nil, err
+		}
+		list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range list.Items {
+			namespaces[ns.Name] = true
+		}
+	default:
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, c := range a.cache {
+		if now.After(c.expires) {
+			delete(a.cache, k)
+		}
+	}
+	a.cache[key] = cachedNamespaces{namespaces: namespaces, expires: now.Add(namespaceCacheTTL)}
+	return namespaces, nil
+}
+
+// Allowed checks the permission of the user on the PersesDashboard resources
+// of namespace with a SelfSubjectAccessReview.
+func (a *namespaceAccess) Allowed(r *http.Request, verb, namespace string) (bool, error) {
+	userConfig, _, err := a.userConfig(r)
+	if err != nil {
+		return false, err
+	}
+	clientset, err := kubernetes.NewForConfig(userConfig)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 10*time.Second)
+	defer cancel()
+	review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      verb,
+				Group:     "perses.dev",
+				Resource:  "persesdashboards",
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
@@ -21,6 +22,7 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
 
+	"github.com/openshift/monitoring-plugin/pkg/alerting"
 	"github.com/openshift/monitoring-plugin/pkg/monitoring"
 )
 
@@ -36,9 +38,24 @@ type Config struct {
 	PluginConfigPath string
 	AlertmanagerUrl  string
 	ThanosQuerierUrl string
-	TLSMinVersion    uint16
-	TLSMaxVersion    uint16
-	TLSCipherSuites  []uint16
+	// PersesUrl is the Perses API proxied for the perses-dashboards feature.
+	PersesUrl string
+	// Simulation is the path of an alert scenario served in place of the
+	// Alertmanager and Thanos Querier upstreams of the ACM mode.
+	Simulation string
+	// Dev serves the built-in scenario of the simulation package in place of
+	// the upstreams, unless Simulation is set, for local development.
+	Dev bool
+	// MetricsAddress is the address serving the metrics of the backend over
+	// http, which are not served when empty. It should be bound to localhost
+	// behind kube-rbac-proxy.
+	MetricsAddress string
+	// KubeConfig selects the client config of the ACM mode and of the Perses
+	// proxy, which use the in-cluster config by default.
+	KubeConfig      KubeConfig
+	TLSMinVersion   uint16
+	TLSMaxVersion   uint16
+	TLSCipherSuites []uint16
 }
 
 func (c *Config) IsTLSEnabled() bool {
@@ -52,6 +69,14 @@ type PluginServer struct {
 
 type PluginConfig struct {
 	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Proxy configures the ACM mode proxies, it is not exposed to the frontend.
+	Proxy monitoring.ProxyConfig `json:"-" yaml:"proxy,omitempty"`
+	// SilenceSchedules configures the recurring silences of the ACM mode.
+	SilenceSchedules alerting.ScheduleConfig `json:"-" yaml:"silenceSchedules,omitempty"`
+	// SilenceTemplates configures the store of reusable silence templates.
+	SilenceTemplates alerting.TemplatesConfig `json:"-" yaml:"silenceTemplates,omitempty"`
+	// SilenceHistory configures the audit log of the silence changes.
+	SilenceHistory alerting.AuditConfig `json:"-" yaml:"silenceHistory,omitempty"`
 }
 
 type Feature string
@@ -119,41 +144,56 @@ func createHTTPServer(ctx context.Context, cfg *Config) (*http.Server, error) {
 
 	acmMode := cfg.Features[AcmAlerting]
 	acmLocationsLength := len(cfg.AlertmanagerUrl) + len(cfg.ThanosQuerierUrl)
+	simulated := cfg.Simulation != "" || cfg.Dev
 
 	if acmLocationsLength > 0 && !acmMode {
 		return nil, fmt.Errorf("alertmanager and thanos-querier cannot be set without the 'acm-alerting' feature flag")
 	}
-	if acmLocationsLength == 0 && acmMode {
+	if simulated && !acmMode {
+		return nil, fmt.Errorf("simulation and dev mode cannot be set without the 'acm-alerting' feature flag")
+	}
+	if simulated && acmLocationsLength > 0 {
+		return nil, fmt.Errorf("alertmanager and thanos-querier cannot be set with a simulation or in dev mode")
+	}
+	if acmLocationsLength == 0 && acmMode && !simulated {
 		return nil, fmt.Errorf("alertmanager and thanos-querier must be set to use the 'acm-alerting' feature flag")
 	}
+	if cfg.PersesUrl != "" && !cfg.Features[PersesDashboards] {
+		return nil, fmt.Errorf("perses cannot be set without the 'perses-dashboards' feature flag")
+	}
 
-	if cfg.Port == int(monitoring.AlertmanagerPort) || cfg.Port == int(monitoring.ThanosQuerierPort) {
+	if cfg.Port == int(monitoring.AlertmanagerPort) || cfg.Port == int(monitoring.ThanosQuerierPort) || cfg.Port == int(monitoring.PersesPort) {
 		return nil, fmt.Errorf("cannot set default port to reserved port %d", cfg.Port)
 	}
 
-	// Uncomment the following line for local development:
-	// k8sconfig, err := clientcmd.BuildConfigFromFlags("", "$HOME/.kube/config")
-
-	// Comment the following line for local development:
 	var k8sclient *dynamic.DynamicClient
-	if acmMode {
-
-		k8sconfig, err := rest.InClusterConfig()
-
+	var k8sconfig *rest.Config
+	if acmMode || cfg.PersesUrl != "" {
+		var err error
+		k8sconfig, err = cfg.KubeConfig.load(simulated)
 		if err != nil {
-			return nil, fmt.Errorf("cannot get in cluster config: %w", err)
+			return nil, err
 		}
-
+	} else if cfg.Features[LegacyDashboards] {
+		// The legacy dashboards discovery is optional, the other dashboards
+		// endpoints do not need the API server.
+		var err error
+		if k8sconfig, err = cfg.KubeConfig.load(simulated); err != nil {
+			log.WithError(err).Warn("legacy dashboards discovery disabled")
+		}
+	}
+	if k8sconfig != nil {
+		var err error
 		k8sclient, err = dynamic.NewForConfig(k8sconfig)
 		if err != nil {
 			return nil, fmt.Errorf("error creating dynamicClient: %w", err)
 		}
-	} else {
-		k8sclient = nil
+	}
+	if cfg.Dev {
+		log.Warn("dev mode enabled, alertmanager and thanos-querier are simulated")
 	}
 
-	router, pluginConfig := setupRoutes(cfg)
-	router.Use(corsHeaderMiddleware())
+	configHandlerFunc, pluginConfig := configHandler(cfg)
 
 	tlsConfig := &tls.Config{}
 
@@ -221,6 +261,35 @@ func createHTTPServer(ctx context.Context, cfg *Config) (*http.Server, error) {
 		timeout = pluginConfig.Timeout
 	}
 
+	// The proxies are shared by the proxy servers and the backend APIs in ACM
+	// mode. Simulations are served without TLS for local runs.
+	var b *backend
+	if acmMode && (tlsEnabled || simulated) {
+		var err error
+		b, err = newBackend(ctx, cfg, k8sconfig, k8sclient, pluginConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The Perses proxy is shared by its proxy server and the dashboards APIs.
+	var persesHandler http.Handler
+	if cfg.PersesUrl != "" {
+		var proxyConfig monitoring.ProxyConfig
+		if pluginConfig != nil {
+			proxyConfig = pluginConfig.Proxy
+		}
+		var access monitoring.NamespaceAccess
+		if k8sconfig != nil {
+			access = newNamespaceAccess(k8sconfig)
+		}
+		persesHandler = monitoring.NewPersesHandler(access, cfg.CertFile, cfg.PersesUrl, proxyConfig)
+	}
+	d := newDashboardsAPI(cfg, k8sconfig, persesHandler)
+
+	router := setupRoutes(cfg, configHandlerFunc, b, d)
+	router.Use(corsHeaderMiddleware())
+
 	httpServer := &http.Server{
 		Handler:      router,
 		Addr:         fmt.Sprintf(":%d", cfg.Port),
@@ -235,47 +304,44 @@ func createHTTPServer(ctx context.Context, cfg *Config) (*http.Server, error) {
 	}
 
 	// Start proxy servers if in ACM mode
-	if tlsEnabled && acmMode {
-		startProxy(cfg, k8sclient, tlsConfig, timeout, monitoring.AlertManagerKind, monitoring.AlertmanagerPort)
-		startProxy(cfg, k8sclient, tlsConfig, timeout, monitoring.ThanosQuerierKind, monitoring.ThanosQuerierPort)
+	if b != nil {
+		startProxy(cfg, b.alertmanager.Handler, tlsConfig, timeout, monitoring.AlertManagerKind, monitoring.AlertmanagerPort)
+		startProxy(cfg, b.thanosQuerier.Handler, tlsConfig, timeout, monitoring.ThanosQuerierKind, monitoring.ThanosQuerierPort)
+	}
+	if persesHandler != nil {
+		startProxy(cfg, persesHandler, tlsConfig, timeout, monitoring.PersesKind, monitoring.PersesPort)
+	}
+	if cfg.MetricsAddress != "" {
+		startMetrics(cfg.MetricsAddress, timeout)
 	}
 
 	return httpServer, nil
 }
 
-func setupRoutes(cfg *Config) (*mux.Router, *PluginConfig) {
-	configHandlerFunc, pluginConfig := configHandler(cfg)
-
+func setupRoutes(cfg *Config, configHandlerFunc http.HandlerFunc, b *backend, d *dashboardsAPI) *mux.Router {
 	router := mux.NewRouter()
 
 	router.Path("/health").HandlerFunc(healthHandler())
+	router.Path("/status").HandlerFunc(statusHandler())
 
 	router.Path("/plugin-manifest.json").Handler(manifestHandler(cfg))
 
 	router.Path("/features").HandlerFunc(featuresHandler(cfg))
 	router.Path("/config").HandlerFunc(configHandlerFunc)
+	if b != nil {
+		setupAPIRoutes(router, b)
+	}
+	if d != nil {
+		setupDashboardRoutes(router, d)
+	}
 	router.PathPrefix("/").Handler(filesHandler(http.Dir(cfg.StaticPath)))
 
-	return router, pluginConfig
+	return router
 }
 
-func setupProxyRoutes(cfg *Config, k8sclient *dynamic.DynamicClient, kind monitoring.KindType) *mux.Router {
+func setupProxyRoutes(handler http.Handler) *mux.Router {
 	router := mux.NewRouter()
-	var proxyUrl string
-	switch kind {
-	case monitoring.AlertManagerKind:
-		proxyUrl = cfg.AlertmanagerUrl
-	case monitoring.ThanosQuerierKind:
-		proxyUrl = cfg.ThanosQuerierUrl
-	}
-
-	router.PathPrefix("/").Handler(monitoring.NewProxyHandler(
-		k8sclient,
-		cfg.CertFile,
-		kind,
-		proxyUrl,
-	))
-
+	router.PathPrefix("/").Handler(handler)
 	return router
 }
 
@@ -315,6 +381,33 @@ func healthHandler() http.HandlerFunc {
 	})
 }
 
+// statusHandler reports the circuit breaker state of the proxied upstreams.
+// Open breakers degrade the ACM features but the plugin itself keeps serving,
+// so it is not a readiness probe and always responds with 200.
+func statusHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreams := monitoring.UpstreamStatuses()
+		status := "ok"
+		for _, u := range upstreams {
+			if u.State != monitoring.BreakerClosed {
+				status = "degraded"
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(struct {
+			Status    string                      `json:"status"`
+			Upstreams []monitoring.UpstreamStatus `json:"upstreams"`
+		}{
+			Status:    status,
+			Upstreams: upstreams,
+		})
+		if err != nil {
+			log.WithError(err).Error("cannot write status response")
+		}
+	})
+}
+
 func corsHeaderMiddleware() func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -377,8 +470,25 @@ func configHandler(cfg *Config) (http.HandlerFunc, *PluginConfig) {
 	}), &pluginConfig
 }
 
-func startProxy(cfg *Config, k8sclient *dynamic.DynamicClient, tlsConfig *tls.Config, timeout time.Duration, kind monitoring.KindType, port monitoring.ProxyPort) {
-	proxyRouter := setupProxyRoutes(cfg, k8sclient, kind)
+// startMetrics serves the metrics apart from the plugin port, which is open
+// to every user of the console.
+func startMetrics(addr string, timeout time.Duration) {
+	router := mux.NewRouter()
+	router.Path("/metrics").Handler(promhttp.Handler())
+	metricsServer := &http.Server{
+		Handler:      router,
+		Addr:         addr,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	}
+	log.Infof("metrics listening for http on %s", metricsServer.Addr)
+	go func() {
+		panic(metricsServer.ListenAndServe())
+	}()
+}
+
+func startProxy(cfg *Config, handler http.Handler, tlsConfig *tls.Config, timeout time.Duration, kind monitoring.KindType, port monitoring.ProxyPort) {
+	proxyRouter := setupProxyRoutes(handler)
 	proxyRouter.Use(corsHeaderMiddleware())
 	proxyServer := &http.Server{
 		Handler:      proxyRouter,
@@ -387,6 +497,13 @@ func startProxy(cfg *Config, k8sclient *dynamic.DynamicClient, tlsConfig *tls.Co
 		ReadTimeout:  timeout,
 		WriteTimeout: timeout,
 	}
+	if !cfg.IsTLSEnabled() {
+		log.Infof("%s proxy listening for http on %s", kind, proxyServer.Addr)
+		go func() {
+			panic(proxyServer.ListenAndServe())
+		}()
+		return
+	}
 	log.Infof("%s proxy listening for https on %s", kind, proxyServer.Addr)
 
 	go func() {
@@ -66,6 +66,30 @@ func TestCreateHTTPServer(t *testing.T) {
 			},
 			err: true,
 		},
+		{
+			// Simulations replace the upstreams of the ACM mode.
+			cfg: &Config{
+				Simulation: "../../docs/incident_detection/simulate_scenarios/complete-test-data.csv",
+				Features:   defaultFeatures,
+			},
+			err: true,
+		},
+		{
+			cfg: &Config{
+				Simulation:      "../../docs/incident_detection/simulate_scenarios/complete-test-data.csv",
+				AlertmanagerUrl: "https://alertmanager.example.com",
+				Features:        map[Feature]bool{AcmAlerting: true},
+			},
+			err: true,
+		},
+		{
+			// The Perses proxy belongs to the perses-dashboards feature.
+			cfg: &Config{
+				PersesUrl: "https://perses.example.com",
+				Features:  defaultFeatures,
+			},
+			err: true,
+		},
 	} {
 		t.Run("", func(t *testing.T) {
 			_, err := createHTTPServer(context.Background(), tc.cfg)
@@ -123,9 +147,14 @@ func TestServerRunning(t *testing.T) {
 	tmpDir := prepareServerAssets(t)
 	defer os.RemoveAll(tmpDir)
 
+	metricsPort, err := getFreePort(testHostname)
+	require.NoError(t, err)
+	metricsURL := fmt.Sprintf("http://%s:%d/metrics", testHostname, metricsPort)
+
 	_, cleanup := startTestServer(t, &Config{
-		Port:     testPort,
-		Features: defaultFeatures,
+		Port:           testPort,
+		MetricsAddress: fmt.Sprintf("%s:%d", testHostname, metricsPort),
+		Features:       defaultFeatures,
 	})
 	defer cleanup()
 
@@ -152,10 +181,22 @@ func TestServerRunning(t *testing.T) {
 		t.Fatalf("Failed: could not fetch features endpoint: %v", err)
 	}
 
+	if _, err = getRequestResults(t, httpClient, serverURL+"/status"); err != nil {
+		t.Fatalf("Failed: could not fetch status endpoint: %v", err)
+	}
+
 	// sanity check - make sure we cannot get to a bogus context path
 	if _, err = getRequestResults(t, httpClient, serverURL+"/badroot"); err == nil {
 		t.Fatalf("Failed: Should have failed going to /badroot")
 	}
+
+	// The metrics are only served on the metrics address.
+	if _, err = getRequestResults(t, httpClient, metricsURL); err != nil {
+		t.Fatalf("Failed: could not fetch metrics: %v", err)
+	}
+	if _, err = getRequestResults(t, httpClient, serverURL+"/metrics"); err == nil {
+		t.Fatalf("Failed: Should have failed going to /metrics on the server port")
+	}
 }
 
 func TestSecureServerRunning(t *testing.T) {
@@ -0,0 +1,364 @@
+package simulation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	amconfig "github.com/prometheus/alertmanager/config"
+	amlabels "github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/common/model"
+
+	"github.com/openshift/monitoring-plugin/pkg/alerting"
+	"github.com/openshift/monitoring-plugin/pkg/api"
+)
+
+const (
+	// alertResolveTimeout is added to the endsAt of the firing alerts, as
+	// Prometheus does when sending them to Alertmanager.
+	alertResolveTimeout = 4 * time.Minute
+	// seededSilenceDuration is the duration of the silences of the silenced
+	// alerts of the scenario, after its end.
+	seededSilenceDuration = 24 * time.Hour
+	alertmanagerVersion   = "0.27.0"
+)
+
+// alertmanagerConfig is the configuration reported by the status endpoint. It
+// routes the alerts of the scenario to receivers without integrations, so
+// that the routing and inhibition APIs can be exercised.
+const alertmanagerConfig = `global:
+  resolve_timeout: 5m
+route:
+  receiver: default
+  group_by: [namespace]
+  routes:
+  - receiver: critical
+    matchers: [severity="critical"]
+receivers:
+- name: default
+- name: critical
+inhibit_rules:
+- source_matchers: [severity="critical"]
+  target_matchers: [severity=~"warning|info"]
+  equal: [namespace, alertname]
+`
+
+var amConfig = func() *amconfig.Config {
+	c, err := amconfig.Load(alertmanagerConfig)
+	if err != nil {
+		panic(fmt.Sprintf("simulation: invalid alertmanager config: %v", err))
+	}
+	return c
+}()
+
+// amAlert is an alert of the Alertmanager /api/v2/alerts response.
+type amAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	UpdatedAt    time.Time         `json:"updatedAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+	Receivers    []amReceiver      `json:"receivers"`
+	Status       amAlertStatus     `json:"status"`
+}
+
+type amReceiver struct {
+	Name string `json:"name"`
+}
+
+type amAlertStatus struct {
+	State       string   `json:"state"`
+	SilencedBy  []string `json:"silencedBy"`
+	InhibitedBy []string `json:"inhibitedBy"`
+}
+
+// AlertmanagerHandler serves the alerts, silences, receivers and status
+// endpoints of the Alertmanager v2 API. The alerts are routed, inhibited and
+// silenced as Alertmanager does with the configuration of the status.
+func (s *Server) AlertmanagerHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v2/alerts", s.alerts)
+	mux.HandleFunc("GET /api/v2/silences", s.listSilences)
+	mux.HandleFunc("POST /api/v2/silences", s.postSilence)
+	mux.HandleFunc("GET /api/v2/silence/{id}", s.getSilence)
+	mux.HandleFunc("DELETE /api/v2/silence/{id}", s.expireSilence)
+	mux.HandleFunc("GET /api/v2/receivers", s.receivers)
+	mux.HandleFunc("GET /api/v2/status", s.status)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		api.WriteError(w, http.StatusNotFound, errors.New("unsupported by the simulation: "+r.Method+" "+r.URL.Path))
+	})
+	return mux
+}
+
+// firingAlerts returns the alerts firing now, with the receivers of their
+// routes and the silences and alerts muting them.
+func (s *Server) firingAlerts(now time.Time) []amAlert {
+	firing := s.scenario.Firing(now)
+	active := make([]model.LabelSet, 0, len(firing))
+	for _, a := range firing {
+		active = append(active, labelSet(a.AlertLabels()))
+	}
+
+	s.mu.Lock()
+	silences := make([]alerting.CompiledSilence, 0, len(s.silences))
+	for _, silence := range s.silences {
+		if silenceState(*silence, now) == alerting.SilenceActive {
+			silences = append(silences, alerting.CompileSilence(*silence))
+		}
+	}
+	s.mu.Unlock()
+
+	alerts := make([]amAlert, 0, len(firing))
+	for i, a := range firing {
+		lset := a.AlertLabels()
+		status := amAlertStatus{State: "active", SilencedBy: []string{}, InhibitedBy: []string{}}
+		for _, silence := range silences {
+			if silence.Matches(lset) {
+				status.SilencedBy = append(status.SilencedBy, silence.ID)
+			}
+		}
+		for _, inhibition := range alerting.Inhibitions(amConfig, active[i], active) {
+			status.InhibitedBy = append(status.InhibitedBy, fingerprint(inhibition.Source))
+		}
+		if len(status.SilencedBy) > 0 || len(status.InhibitedBy) > 0 {
+			status.State = "suppressed"
+		}
+		sort.Strings(status.SilencedBy)
+
+		receivers := []amReceiver{}
+		for _, route := range alerting.Route(amConfig, active[i], now) {
+			receivers = append(receivers, amReceiver{Name: route.Receiver})
+		}
+		alerts = append(alerts, amAlert{
+			Labels:      lset,
+			Annotations: alertAnnotations(a),
+			StartsAt:    a.Start,
+			EndsAt:      now.Add(alertResolveTimeout),
+			UpdatedAt:   now,
+			Fingerprint: fingerprint(lset),
+			Receivers:   receivers,
+			Status:      status,
+		})
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Fingerprint < alerts[j].Fingerprint })
+	return alerts
+}
+
+func (s *Server) alerts(w http.ResponseWriter, r *http.Request) {
+	var filters []*amlabels.Matcher
+	for _, f := range r.URL.Query()["filter"] {
+		m, err := amlabels.ParseMatcher(f)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		filters = append(filters, m)
+	}
+	query := r.URL.Query()
+	include := func(param string) bool {
+		return query.Get(param) != "false"
+	}
+
+	alerts := []amAlert{}
+	for _, a := range s.firingAlerts(s.now()) {
+		if !matchFilters(a.Labels, filters) {
+			continue
+		}
+		silenced, inhibited := len(a.Status.SilencedBy) > 0, len(a.Status.InhibitedBy) > 0
+		if (silenced && !include("silenced")) || (inhibited && !include("inhibited")) || (!silenced && !inhibited && !include("active")) {
+			continue
+		}
+		alerts = append(alerts, a)
+	}
+	api.WriteJSON(w, http.StatusOK, alerts)
+}
+
+func matchFilters(lset map[string]string, filters []*amlabels.Matcher) bool {
+	for _, m := range filters {
+		if !m.Matches(lset[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// seedSilences creates a silence for each name, namespace and severity of the
+// silenced alerts of the scenario, lasting a day after its end.
+func (s *Server) seedSilences() {
+	for _, a := range s.scenario.Alerts {
+		id := silenceID(a)
+		if !a.Silenced || s.silences[id] != nil {
+			continue
+		}
+		updated := s.scenario.Start
+		s.silences[id] = &alerting.Silence{
+			ID: id,
+			Matchers: []alerting.Matcher{
+				{Name: "alertname", Value: a.Name},
+				{Name: "namespace", Value: a.Namespace},
+				{Name: "severity", Value: a.Severity},
+			},
+			StartsAt:  s.scenario.Start,
+			EndsAt:    s.scenario.End.Add(seededSilenceDuration),
+			CreatedBy: "simulation",
+			Comment:   "Silence of the " + s.scenario.Name + " scenario",
+			UpdatedAt: &updated,
+		}
+	}
+}
+
+// silenceID returns the identifier of the seeded silence matching the alert,
+// which is shared by the alerts of the same name, namespace and severity.
+func silenceID(a Alert) string {
+	return fingerprint(map[string]string{
+		"alertname": a.Name,
+		"namespace": a.Namespace,
+		"severity":  a.Severity,
+	})
+}
+
+func silenceState(silence alerting.Silence, now time.Time) string {
+	switch {
+	case now.Before(silence.StartsAt):
+		return alerting.SilencePending
+	case !now.Before(silence.EndsAt):
+		return alerting.SilenceExpired
+	default:
+		return alerting.SilenceActive
+	}
+}
+
+// withStatus returns a copy of the silence with its state at now.
+func withStatus(silence alerting.Silence, now time.Time) alerting.Silence {
+	silence.Status = &alerting.SilenceStatus{State: silenceState(silence, now)}
+	return silence
+}
+
+func (s *Server) listSilences(w http.ResponseWriter, r *http.Request) {
+	now := s.now()
+	s.mu.Lock()
+	silences := make([]alerting.Silence, 0, len(s.silences))
+	for _, silence := range s.silences {
+		silences = append(silences, withStatus(*silence, now))
+	}
+	s.mu.Unlock()
+
+	sort.Slice(silences, func(i, j int) bool { return silences[i].ID < silences[j].ID })
+	api.WriteJSON(w, http.StatusOK, silences)
+}
+
+func (s *Server) getSilence(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	silence, ok := s.silences[r.PathValue("id")]
+	var result alerting.Silence
+	if ok {
+		result = withStatus(*silence, s.now())
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("silence %s not found", r.PathValue("id")))
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, result)
+}
+
+// postSilence creates a silence, or updates the silence of the given ID.
+// Unlike Alertmanager, updates keep the ID of the silence.
+func (s *Server) postSilence(w http.ResponseWriter, r *http.Request) {
+	var silence alerting.Silence
+	if err := json.NewDecoder(r.Body).Decode(&silence); err != nil {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid silence: %w", err))
+		return
+	}
+	if len(silence.Matchers) == 0 {
+		api.WriteError(w, http.StatusBadRequest, errors.New("silence has no matchers"))
+		return
+	}
+	if !silence.EndsAt.After(silence.StartsAt) {
+		api.WriteError(w, http.StatusBadRequest, errors.New("silence ends before it starts"))
+		return
+	}
+	now := s.now()
+	if silence.EndsAt.Before(now) {
+		api.WriteError(w, http.StatusBadRequest, errors.New("silence ends in the past"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if silence.ID == "" {
+		silence.ID = uuid.NewString()
+	} else if _, ok := s.silences[silence.ID]; !ok {
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("silence %s not found", silence.ID))
+		return
+	}
+	if silence.StartsAt.Before(now) {
+		silence.StartsAt = now
+	}
+	silence.UpdatedAt = &now
+	silence.Status = nil
+	s.silences[silence.ID] = &silence
+	api.WriteJSON(w, http.StatusOK, map[string]string{"silenceID": silence.ID})
+}
+
+// expireSilence ends a silence now, pending silences are expired at once.
+func (s *Server) expireSilence(w http.ResponseWriter, r *http.Request) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	silence, ok := s.silences[r.PathValue("id")]
+	if !ok {
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("silence %s not found", r.PathValue("id")))
+		return
+	}
+	if silenceState(*silence, now) == alerting.SilenceExpired {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("silence %s already expired", silence.ID))
+		return
+	}
+	if silence.StartsAt.After(now) {
+		silence.StartsAt = now
+	}
+	silence.EndsAt = now
+	silence.UpdatedAt = &now
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) receivers(w http.ResponseWriter, r *http.Request) {
+	receivers := make([]amReceiver, 0, len(amConfig.Receivers))
+	for _, receiver := range amConfig.Receivers {
+		receivers = append(receivers, amReceiver{Name: receiver.Name})
+	}
+	api.WriteJSON(w, http.StatusOK, receivers)
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"cluster": map[string]interface{}{
+			"name":   "simulation",
+			"status": "ready",
+			"peers":  []interface{}{},
+		},
+		"config":      map[string]string{"original": alertmanagerConfig},
+		"uptime":      s.started,
+		"versionInfo": map[string]string{"version": alertmanagerVersion, "branch": "simulation"},
+	})
+}
+
+func labelSet(lset map[string]string) model.LabelSet {
+	result := make(model.LabelSet, len(lset))
+	for k, v := range lset {
+		result[model.LabelName(k)] = model.LabelValue(v)
+	}
+	return result
+}
+
+func fingerprint(lset map[string]string) string {
+	return model.Fingerprint(model.LabelsToSignature(lset)).String()
+}
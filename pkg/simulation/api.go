@@ -0,0 +1,221 @@
+package simulation
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/openshift/monitoring-plugin/pkg/alerting"
+	"github.com/openshift/monitoring-plugin/pkg/api"
+	"github.com/openshift/monitoring-plugin/pkg/monitoring"
+)
+
+const (
+	queryTimeout  = 2 * time.Minute
+	maxSamples    = 50000000
+	lookbackDelta = 5 * time.Minute
+	// maxPoints is the limit of points per series of the Prometheus API.
+	maxPoints = 11000
+)
+
+// Server serves the Prometheus and Alertmanager APIs from a scenario.
+type Server struct {
+	scenario *Scenario
+	engine   *promql.Engine
+	now      func() time.Time
+	started  time.Time
+
+	mu sync.Mutex
+	// silences are created through the Alertmanager API, starting with the
+	// silences of the silenced alerts of the scenario.
+	silences map[string]*alerting.Silence
+}
+
+// NewServer returns the API server of the scenario.
+func NewServer(scenario *Scenario) *Server {
+	s := &Server{
+		scenario: scenario,
+		engine: promql.NewEngine(promql.EngineOpts{
+			MaxSamples:           maxSamples,
+			Timeout:              queryTimeout,
+			LookbackDelta:        lookbackDelta,
+			EnableAtModifier:     true,
+			EnableNegativeOffset: true,
+		}),
+		now:      time.Now,
+		started:  time.Now(),
+		silences: map[string]*alerting.Silence{},
+	}
+	s.seedSilences()
+	return s
+}
+
+// promResponse is the envelope of the Prometheus HTTP API responses.
+type promResponse struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType string      `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Warnings  []string    `json:"warnings,omitempty"`
+}
+
+func writePromError(w http.ResponseWriter, status int, errorType string, err error) {
+	api.WriteJSON(w, status, promResponse{Status: "error", ErrorType: errorType, Error: err.Error()})
+}
+
+// PrometheusHandler serves the query, query_range and rules endpoints of the
+// Prometheus API, as the Thanos Querier proxy does.
+func (s *Server) PrometheusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/query", s.query)
+	mux.HandleFunc("/api/v1/query_range", s.queryRange)
+	mux.HandleFunc("/api/v1/rules", s.rules)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writePromError(w, http.StatusNotFound, "not_found", errors.New("unsupported by the simulation: "+r.URL.Path))
+	})
+	return mux
+}
+
+func (s *Server) query(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writePromError(w, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	ts := s.now()
+	if t := r.Form.Get("time"); t != "" {
+		var err error
+		if ts, err = monitoring.ParseTime(t); err != nil {
+			writePromError(w, http.StatusBadRequest, "bad_data", err)
+			return
+		}
+	}
+	q, err := s.engine.NewInstantQuery(r.Context(), s.scenario, nil, r.Form.Get("query"), ts)
+	s.exec(w, r, q, err)
+}
+
+func (s *Server) queryRange(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writePromError(w, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	start, err := monitoring.ParseTime(r.Form.Get("start"))
+	if err != nil {
+		writePromError(w, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	end, err := monitoring.ParseTime(r.Form.Get("end"))
+	if err != nil {
+		writePromError(w, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	step, err := monitoring.ParseDuration(r.Form.Get("step"))
+	if err != nil || step <= 0 {
+		writePromError(w, http.StatusBadRequest, "bad_data", errors.New("invalid step"))
+		return
+	}
+	if end.Before(start) {
+		writePromError(w, http.StatusBadRequest, "bad_data", errors.New("end timestamp must not be before start time"))
+		return
+	}
+	if end.Sub(start)/step > maxPoints {
+		writePromError(w, http.StatusBadRequest, "bad_data", errors.New("exceeded maximum resolution of 11,000 points per timeseries"))
+		return
+	}
+	q, err := s.engine.NewRangeQuery(r.Context(), s.scenario, nil, r.Form.Get("query"), start, end, step)
+	s.exec(w, r, q, err)
+}
+
+func (s *Server) exec(w http.ResponseWriter, r *http.Request, q promql.Query, err error) {
+	if err != nil {
+		writePromError(w, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	defer q.Close()
+
+	res := q.Exec(r.Context())
+	if res.Err != nil {
+		writePromError(w, http.StatusUnprocessableEntity, "execution", res.Err)
+		return
+	}
+	warnings, _ := res.Warnings.AsStrings(r.Form.Get("query"), 0, 0)
+	api.WriteJSON(w, http.StatusOK, promResponse{
+		Status: "success",
+		Data: struct {
+			ResultType string      `json:"resultType"`
+			Result     interface{} `json:"result"`
+		}{
+			ResultType: string(res.Value.Type()),
+			Result:     res.Value,
+		},
+		Warnings: warnings,
+	})
+}
+
+// rules serves an alerting rule per alert name and namespace of the scenario,
+// with the alerts firing now.
+func (s *Server) rules(w http.ResponseWriter, r *http.Request) {
+	now := s.now()
+	firing := map[string][]alerting.RuleAlert{}
+	for _, a := range s.scenario.Firing(now) {
+		activeAt := a.Start
+		key := a.Namespace + "/" + a.Name + "/" + a.Severity
+		firing[key] = append(firing[key], alerting.RuleAlert{
+			Labels:      a.AlertLabels(),
+			Annotations: alertAnnotations(a),
+			State:       alerting.StateFiring,
+			ActiveAt:    &activeAt,
+			Value:       "1e+00",
+		})
+	}
+
+	groups := map[string]*alerting.RuleGroup{}
+	seen := map[string]bool{}
+	for _, a := range s.scenario.Alerts {
+		key := a.Namespace + "/" + a.Name + "/" + a.Severity
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		g, ok := groups[a.Namespace]
+		if !ok {
+			g = &alerting.RuleGroup{Name: a.Namespace, File: s.scenario.Name + "/" + a.Namespace + ".yaml", Interval: ScrapeInterval.Seconds()}
+			groups[a.Namespace] = g
+		}
+		state := "inactive"
+		if len(firing[key]) > 0 {
+			state = alerting.StateFiring
+		}
+		g.Rules = append(g.Rules, alerting.Rule{
+			Name:        a.Name,
+			Query:       `vector(1)`,
+			Labels:      map[string]string{"severity": a.Severity, "namespace": a.Namespace},
+			Annotations: alertAnnotations(a),
+			Alerts:      firing[key],
+			Health:      "ok",
+			State:       state,
+			Type:        "alerting",
+		})
+	}
+
+	result := make([]alerting.RuleGroup, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	api.WriteJSON(w, http.StatusOK, promResponse{
+		Status: "success",
+		Data:   map[string]interface{}{"groups": result},
+	})
+}
+
+func alertAnnotations(a Alert) map[string]string {
+	return map[string]string{
+		"summary":     a.Name + " is firing in " + a.Namespace + ".",
+		"description": "Simulated alert of the " + a.Labels["component"] + " component.",
+	}
+}
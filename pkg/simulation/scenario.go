@@ -0,0 +1,326 @@
+// Package simulation serves the Prometheus and Alertmanager APIs from the
+// alert scenarios of docs/incident_detection/simulate_scenarios, so that the
+// alerting and incidents pages can run without a cluster.
+package simulation
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.WithField("module", "simulation")
+
+const (
+	// ScrapeInterval is the interval of the synthesized samples, matching the
+	// minute resolution of the scenarios.
+	ScrapeInterval = time.Minute
+	// groupGap is the largest gap between two alerts of the same incident.
+	groupGap = 5 * time.Minute
+
+	alertsMetric    = "ALERTS"
+	incidentsMetric = "cluster_health_components_map"
+)
+
+// csvHeader are the columns of a scenario file. The trailing labels column is
+// a JSON object which is not quoted, so it spans the remaining commas.
+var csvHeader = []string{"start", "end", "alertname", "namespace", "severity", "silenced", "labels"}
+
+// Alert is an alert of a scenario, firing from Start to End.
+type Alert struct {
+	Name      string
+	Namespace string
+	Severity  string
+	Silenced  bool
+	Labels    map[string]string
+	Start     time.Time
+	End       time.Time
+	// Ongoing alerts last until the end of the scenario and keep firing.
+	Ongoing bool
+	// GroupID identifies the incident of the alert.
+	GroupID string
+}
+
+// AlertLabels returns the labels of the ALERTS series of the alert.
+func (a Alert) AlertLabels() map[string]string {
+	lset := make(map[string]string, len(a.Labels)+3)
+	for k, v := range a.Labels {
+		lset[k] = v
+	}
+	lset[labels.AlertName] = a.Name
+	lset["namespace"] = a.Namespace
+	lset["severity"] = a.Severity
+	return lset
+}
+
+// Scenario is a set of alerts anchored so that the scenario ends at End.
+type Scenario struct {
+	Name   string
+	Alerts []Alert
+	Start  time.Time
+	End    time.Time
+
+	series []staticSeries
+	now    func() time.Time
+}
+
+// devScenario is the scenario of the development mode: three days of
+// incidents of every severity, with silenced and ongoing alerts.
+//
+//go:embed dev.csv
+var devScenario []byte
+
+// Dev returns the built-in scenario of the development mode, ending at now.
+func Dev(now time.Time) *Scenario {
+	s, err := Parse("dev", bytes.NewReader(devScenario), now)
+	if err != nil {
+		panic(fmt.Sprintf("simulation: invalid dev scenario: %v", err))
+	}
+	return s
+}
+
+// Load reads the scenario file at path, ending at now.
+func Load(path string, now time.Time) (*Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open scenario: %w", err)
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	s, err := Parse(name, f, now)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	log.Infof("loaded scenario %q with %d alerts from %s to %s", s.Name, len(s.Alerts), s.Start.Format(time.RFC3339), s.End.Format(time.RFC3339))
+	return s, nil
+}
+
+// row is a line of a scenario file, with its times in minutes.
+type row struct {
+	start, end int64
+	alert      Alert
+}
+
+// Parse reads a scenario in the CSV format of the simulation script of the
+// cluster-health-analyzer. The start and end columns are minutes from the
+// beginning of the scenario, which is shifted so that its last minute is now.
+func Parse(name string, r io.Reader, now time.Time) (*Scenario, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("cannot read header: %w", cmp.Or(scanner.Err(), io.ErrUnexpectedEOF))
+	}
+	if header := strings.TrimSpace(scanner.Text()); header != strings.Join(csvHeader, ",") {
+		return nil, fmt.Errorf("unexpected header %q, expected %q", header, strings.Join(csvHeader, ","))
+	}
+
+	var rows []row
+	var last int64
+	for line := 2; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		rw, err := parseRow(strings.SplitN(text, ",", len(csvHeader)))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rows = append(rows, rw)
+		last = max(last, rw.end)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no alerts")
+	}
+
+	end := now.UTC().Truncate(ScrapeInterval)
+	minute := func(m int64) time.Time {
+		return end.Add(-time.Duration(last-m) * time.Minute)
+	}
+	s := &Scenario{Name: name, Start: end, End: end, now: time.Now}
+	for _, rw := range rows {
+		a := rw.alert
+		a.Start, a.End = minute(rw.start), minute(rw.end)
+		a.Ongoing = rw.end == last
+		s.Alerts = append(s.Alerts, a)
+		if a.Start.Before(s.Start) {
+			s.Start = a.Start
+		}
+	}
+	s.group()
+	s.series = s.buildSeries()
+	return s, nil
+}
+
+func parseRow(record []string) (row, error) {
+	if len(record) != len(csvHeader) {
+		return row{}, fmt.Errorf("expected %d fields, got %d", len(csvHeader), len(record))
+	}
+	var rw row
+	var err error
+	if rw.start, err = strconv.ParseInt(record[0], 10, 64); err != nil || rw.start < 0 {
+		return row{}, fmt.Errorf("invalid start %q", record[0])
+	}
+	if rw.end, err = strconv.ParseInt(record[1], 10, 64); err != nil || rw.end < rw.start {
+		return row{}, fmt.Errorf("invalid end %q", record[1])
+	}
+	if record[2] == "" {
+		return row{}, errors.New("missing alertname")
+	}
+	silenced, err := strconv.ParseBool(record[5])
+	if err != nil {
+		return row{}, fmt.Errorf("invalid silenced %q", record[5])
+	}
+
+	extra := map[string]string{}
+	if raw := record[6]; strings.TrimSpace(raw) != "" {
+		if err := json.Unmarshal([]byte(raw), &extra); err != nil {
+			return row{}, fmt.Errorf("invalid labels %s: %w", raw, err)
+		}
+	}
+	for k := range extra {
+		if !model.LabelName(k).IsValid() {
+			return row{}, fmt.Errorf("invalid label name %q", k)
+		}
+	}
+
+	rw.alert = Alert{
+		Name:      record[2],
+		Namespace: record[3],
+		Severity:  record[4],
+		Silenced:  silenced,
+		Labels:    extra,
+	}
+	return rw, nil
+}
+
+// group assigns the alerts to incidents, as the cluster-health-analyzer
+// groups alerts which fire at the same time.
+func (s *Scenario) group() {
+	order := make([]int, len(s.Alerts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return s.Alerts[order[i]].Start.Before(s.Alerts[order[j]].Start)
+	})
+
+	var id string
+	var groupEnd time.Time
+	for _, i := range order {
+		a := &s.Alerts[i]
+		if id == "" || a.Start.After(groupEnd.Add(groupGap)) {
+			id = groupID(s.Name, a, a.Start.Sub(s.Start))
+			groupEnd = a.End
+		}
+		if a.End.After(groupEnd) {
+			groupEnd = a.End
+		}
+		a.GroupID = id
+	}
+}
+
+// groupID derives a stable UUID-formatted identifier from the first alert of
+// an incident, so that reloading a scenario keeps the incident links valid.
+func groupID(scenario string, first *Alert, offset time.Duration) string {
+	sum := sha256.Sum256([]byte(scenario + "/" + first.Name + "/" + first.Namespace + "/" + offset.String()))
+	h := hex.EncodeToString(sum[:16])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// severityRank is the value of the components map series, as in the
+// cluster-health-analyzer.
+func severityRank(severity string) float64 {
+	switch severity {
+	case "critical":
+		return 2
+	case "warning":
+		return 1
+	default:
+		return 0
+	}
+}
+
+// buildSeries synthesizes the ALERTS series and the components map series of
+// the cluster-health-analyzer for every alert of the scenario.
+func (s *Scenario) buildSeries() []staticSeries {
+	result := make([]staticSeries, 0, 2*len(s.Alerts))
+	for _, a := range s.Alerts {
+		mint, maxt := a.Start.UnixMilli(), a.End.UnixMilli()
+		if a.Ongoing {
+			maxt = openEnded
+		}
+
+		alertLabels := a.AlertLabels()
+		alertLabels[labels.MetricName] = alertsMetric
+		alertLabels["alertstate"] = "firing"
+		result = append(result, staticSeries{
+			labels: labels.FromMap(alertLabels),
+			value:  1,
+			mint:   mint,
+			maxt:   maxt,
+		})
+
+		component := a.Labels["component"]
+		if component == "" {
+			component = "Others"
+		}
+		layer := a.Labels["layer"]
+		if layer == "" {
+			layer = "core"
+		}
+		result = append(result, staticSeries{
+			labels: labels.FromMap(map[string]string{
+				labels.MetricName: incidentsMetric,
+				"group_id":        a.GroupID,
+				"component":       component,
+				"layer":           layer,
+				"type":            "alert",
+				"src_alertname":   a.Name,
+				"src_namespace":   a.Namespace,
+				"src_severity":    a.Severity,
+				"silenced":        strconv.FormatBool(a.Silenced),
+			}),
+			value: severityRank(a.Severity),
+			mint:  mint,
+			maxt:  maxt,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return labels.Compare(result[i].labels, result[j].labels) < 0
+	})
+	return result
+}
+
+// Firing returns the alerts which are firing at t.
+func (s *Scenario) Firing(t time.Time) []Alert {
+	var firing []Alert
+	for _, a := range s.Alerts {
+		if t.Before(a.Start) {
+			continue
+		}
+		if a.Ongoing || !t.After(a.End) {
+			firing = append(firing, a)
+		}
+	}
+	return firing
+}
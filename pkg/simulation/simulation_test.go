@@ -0,0 +1,204 @@
+package simulation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/monitoring-plugin/pkg/alerting"
+)
+
+const testScenario = `start,end,alertname,namespace,severity,silenced,labels
+0,60,AlertA_Info,openshift-logging,info,false,{"component": "logging"}
+120,180,AlertB_Info,openshift-monitoring,info,false,{"component": "monitoring", "layer": "compute"}
+130,180,AlertB_Critical,openshift-monitoring,critical,false,{"component": "monitoring"}
+240,240,AlertC_Short,openshift-apiserver,warning,false,{"component": "api-server"}
+200,300,AlertD_Silenced,openshift-operators,warning,true,{"component": "operators"}
+280,300,AlertD_Firing,openshift-storage,critical,false,{}
+`
+
+func TestScenarios(t *testing.T) {
+	files, err := filepath.Glob("../../docs/incident_detection/simulate_scenarios/*.csv")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	for _, f := range files {
+		s, err := Load(f, time.Now())
+		require.NoError(t, err, f)
+		require.NotEmpty(t, s.Alerts, f)
+	}
+	require.NotEmpty(t, Dev(time.Now()).Firing(time.Now()))
+
+	_, err = Parse("bad", strings.NewReader("start,end,alertname,namespace,severity,silenced,labels\n10,5,A,ns,info,false,{}\n"), time.Now())
+	require.ErrorContains(t, err, `line 2: invalid end "5"`)
+}
+
+type promResult struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+			Values [][]interface{}   `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+func TestServer(t *testing.T) {
+	now := time.Unix(1700000000, 0).UTC()
+	s, err := Parse("test", strings.NewReader(testScenario), now)
+	require.NoError(t, err)
+	s.now = func() time.Time { return now }
+	end := now.Truncate(time.Minute)
+	require.Equal(t, end, s.End)
+	require.Equal(t, end.Add(-300*time.Minute), s.Start)
+
+	groups := map[string]string{}
+	for _, a := range s.Alerts {
+		groups[a.Name] = a.GroupID
+	}
+	require.Equal(t, groups["AlertB_Info"], groups["AlertB_Critical"])
+	require.Equal(t, groups["AlertC_Short"], groups["AlertD_Silenced"])
+	require.NotEqual(t, groups["AlertA_Info"], groups["AlertB_Info"])
+	require.NotEqual(t, groups["AlertB_Info"], groups["AlertC_Short"])
+
+	srv := NewServer(s)
+	srv.now = s.now
+	prom := srv.PrometheusHandler()
+	query := func(path string, params url.Values) promResult {
+		rec := httptest.NewRecorder()
+		prom.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"?"+params.Encode(), nil))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var res promResult
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res
+	}
+	unix := func(offset int) string {
+		return strconv.FormatInt(s.Start.Add(time.Duration(offset)*time.Minute).Unix(), 10)
+	}
+
+	// The components map has the severity of the alerts as value.
+	res := query("/api/v1/query_range", url.Values{
+		"query": {`max by (group_id, layer) (cluster_health_components_map{src_alertname=~"AlertB_.*"})`},
+		"start": {unix(120)}, "end": {unix(200)}, "step": {"600"},
+	})
+	require.Equal(t, "matrix", res.Data.ResultType)
+	require.Len(t, res.Data.Result, 2)
+	require.Equal(t, "compute", res.Data.Result[0].Metric["layer"])
+	require.Equal(t, "0", res.Data.Result[0].Values[0][1])
+	require.Equal(t, "2", res.Data.Result[1].Values[0][1])
+	// The samples stop at the end of the alerts, within the 5m lookback.
+	require.Len(t, res.Data.Result[0].Values, 7)
+	require.Len(t, res.Data.Result[1].Values, 6)
+
+	res = query("/api/v1/query", url.Values{"query": {`ALERTS{alertname="AlertC_Short"}`}, "time": {unix(242)}})
+	require.Len(t, res.Data.Result, 1)
+	require.Equal(t, "firing", res.Data.Result[0].Metric["alertstate"])
+	require.Equal(t, "api-server", res.Data.Result[0].Metric["component"])
+
+	// The alerts lasting until the end of the scenario keep firing.
+	s.now = func() time.Time { return now.Add(time.Hour) }
+	srv.now = s.now
+	res = query("/api/v1/query", url.Values{"query": {`count(ALERTS)`}})
+	require.Equal(t, "2", res.Data.Result[0].Value[1])
+
+	rec := httptest.NewRecorder()
+	prom.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/query?query=sum(", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	prom.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/rules", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var rules struct {
+		Data struct {
+			Groups []alerting.RuleGroup `json:"groups"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rules))
+	require.Len(t, rules.Data.Groups, 5)
+	require.Equal(t, "openshift-apiserver", rules.Data.Groups[0].Name)
+	require.Equal(t, "inactive", rules.Data.Groups[0].Rules[0].State)
+	require.Equal(t, alerting.StateFiring, rules.Data.Groups[4].Rules[0].State)
+
+	am := srv.AlertmanagerHandler()
+	rec = httptest.NewRecorder()
+	am.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/alerts", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var alerts []amAlert
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &alerts))
+	require.Len(t, alerts, 2)
+
+	rec = httptest.NewRecorder()
+	am.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/alerts?silenced=false&filter="+url.QueryEscape(`namespace=~"openshift-.*"`), nil))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &alerts))
+	require.Len(t, alerts, 1)
+	require.Equal(t, "AlertD_Firing", alerts[0].Labels["alertname"])
+
+	rec = httptest.NewRecorder()
+	am.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/silences", nil))
+	var silences []alerting.Silence
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &silences))
+	require.Len(t, silences, 1)
+	require.True(t, alerting.IsSilenced(map[string]string{"alertname": "AlertD_Silenced", "namespace": "openshift-operators", "severity": "warning"}, silences[0]))
+	require.Equal(t, alerting.SilenceActive, silences[0].State())
+}
+
+func TestAlertmanagerSilences(t *testing.T) {
+	now := time.Unix(1700000000, 0).UTC()
+	s, err := Parse("test", strings.NewReader(testScenario), now)
+	require.NoError(t, err)
+	srv := NewServer(s)
+	srv.now = func() time.Time { return now }
+	am := srv.AlertmanagerHandler()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		am.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	alerts := func() map[string]amAlert {
+		var alerts []amAlert
+		require.NoError(t, json.Unmarshal(do(http.MethodGet, "/api/v2/alerts", "").Body.Bytes(), &alerts))
+		result := map[string]amAlert{}
+		for _, a := range alerts {
+			result[a.Labels["alertname"]] = a
+		}
+		return result
+	}
+
+	firing := alerts()["AlertD_Firing"]
+	require.Equal(t, "active", firing.Status.State)
+	require.Equal(t, []amReceiver{{Name: "critical"}}, firing.Receivers)
+
+	rec := do(http.MethodPost, "/api/v2/silences", `{"matchers":[{"name":"namespace","value":"openshift-storage","isRegex":false}],"startsAt":"2023-11-14T00:00:00Z","endsAt":"2023-11-15T00:00:00Z","createdBy":"me","comment":"maintenance"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var created struct {
+		SilenceID string `json:"silenceID"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	firing = alerts()["AlertD_Firing"]
+	require.Equal(t, "suppressed", firing.Status.State)
+	require.Equal(t, []string{created.SilenceID}, firing.Status.SilencedBy)
+
+	rec = do(http.MethodGet, "/api/v2/silence/"+created.SilenceID, "")
+	var silence alerting.Silence
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &silence))
+	require.Equal(t, now, silence.StartsAt)
+	require.Equal(t, alerting.SilenceActive, silence.State())
+
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/api/v2/silence/"+created.SilenceID, "").Code)
+	require.Equal(t, "active", alerts()["AlertD_Firing"].Status.State)
+	require.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/api/v2/silence/"+created.SilenceID, "").Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/v2/silences", `{"id":"unknown","matchers":[{"name":"a","value":"b"}],"startsAt":"2023-11-14T00:00:00Z","endsAt":"2023-11-15T00:00:00Z"}`).Code)
+
+	rec = do(http.MethodGet, "/api/v2/status", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"original":"global:`)
+}
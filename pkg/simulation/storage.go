@@ -0,0 +1,152 @@
+package simulation
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/util/annotations"
+)
+
+// openEnded is the end of the series of ongoing alerts, which keep producing
+// samples as time goes by.
+const openEnded = math.MaxInt64
+
+// staticSeries has a constant value sampled every ScrapeInterval from mint
+// to maxt, in milliseconds. The samples are not materialized, so that long
+// scenarios and ongoing alerts are cheap to serve.
+type staticSeries struct {
+	labels labels.Labels
+	value  float64
+	mint   int64
+	maxt   int64
+}
+
+// Querier implements storage.Queryable over the series of the scenario.
+func (s *Scenario) Querier(mint, maxt int64) (storage.Querier, error) {
+	return &querier{series: s.series, mint: mint, maxt: maxt, now: s.now().UnixMilli()}, nil
+}
+
+type querier struct {
+	series     []staticSeries
+	mint, maxt int64
+	// now bounds the samples of the ongoing alerts.
+	now int64
+}
+
+func (q *querier) Select(_ context.Context, _ bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	mint, maxt := q.mint, q.maxt
+	if hints != nil {
+		mint, maxt = hints.Start, hints.End
+	}
+	// The series are sorted by labels already.
+	var result []storage.Series
+	for _, s := range q.series {
+		if s.maxt < mint || s.mint > maxt || !matchAll(s.labels, matchers) {
+			continue
+		}
+		samples := newStaticSamples(s, min(maxt, q.now))
+		result = append(result, &storage.SeriesEntry{
+			Lset: s.labels,
+			SampleIteratorFn: func(chunkenc.Iterator) chunkenc.Iterator {
+				return storage.NewListSeriesIterator(samples)
+			},
+		})
+	}
+	return &seriesSet{series: result, i: -1}
+}
+
+func (q *querier) LabelValues(_ context.Context, name string, _ *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	values := map[string]struct{}{}
+	for _, s := range q.series {
+		if v := s.labels.Get(name); v != "" && matchAll(s.labels, matchers) {
+			values[v] = struct{}{}
+		}
+	}
+	return sortedKeys(values), nil, nil
+}
+
+func (q *querier) LabelNames(_ context.Context, _ *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	names := map[string]struct{}{}
+	for _, s := range q.series {
+		if matchAll(s.labels, matchers) {
+			s.labels.Range(func(l labels.Label) { names[l.Name] = struct{}{} })
+		}
+	}
+	return sortedKeys(names), nil, nil
+}
+
+func (q *querier) Close() error {
+	return nil
+}
+
+func matchAll(lset labels.Labels, matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type seriesSet struct {
+	series []storage.Series
+	i      int
+}
+
+func (s *seriesSet) Next() bool {
+	s.i++
+	return s.i < len(s.series)
+}
+
+func (s *seriesSet) At() storage.Series                { return s.series[s.i] }
+func (s *seriesSet) Err() error                        { return nil }
+func (s *seriesSet) Warnings() annotations.Annotations { return nil }
+
+// staticSamples are the samples of a staticSeries up to a query end. They
+// are computed on access, with the iterators of the storage package.
+type staticSamples struct {
+	series staticSeries
+	n      int
+}
+
+func newStaticSamples(s staticSeries, maxt int64) staticSamples {
+	maxt = min(maxt, s.maxt)
+	if maxt < s.mint {
+		return staticSamples{series: s}
+	}
+	return staticSamples{series: s, n: int((maxt-s.mint)/ScrapeInterval.Milliseconds()) + 1}
+}
+
+func (s staticSamples) Get(i int) chunks.Sample {
+	return sample{t: s.series.mint + int64(i)*ScrapeInterval.Milliseconds(), f: s.series.value}
+}
+
+func (s staticSamples) Len() int {
+	return s.n
+}
+
+type sample struct {
+	t int64
+	f float64
+}
+
+func (s sample) T() int64                      { return s.t }
+func (s sample) F() float64                    { return s.f }
+func (s sample) H() *histogram.Histogram       { return nil }
+func (s sample) FH() *histogram.FloatHistogram { return nil }
+func (s sample) Type() chunkenc.ValueType      { return chunkenc.ValFloat }
@@ -228,6 +228,7 @@ export const Graph: FC<GraphProps> = ({
   return (
     <QueryBrowser
       defaultTimespan={timespan}
+      feature="alerting"
       filterLabels={filterLabels}
       formatSeriesTitle={formatSeriesTitle}
       GraphLink={GraphLink}
@@ -215,7 +215,7 @@ const IncidentsPage = () => {
     incidentsActiveFilters.groupId,
   ]);
 
-  const safeFetch = useSafeFetch();
+  const safeFetch = useSafeFetch('incidents');
   const title = t('Incidents');
 
   useEffect(() => {
@@ -93,7 +93,7 @@ const LegacyDashboardsVariableDropdown: FC<VariableDropdownProps> = ({
   const dispatch = useDispatch();
 
   // eslint-disable-next-line react-hooks/exhaustive-deps
-  const safeFetch = useCallback(useSafeFetch(), []);
+  const safeFetch = useCallback(useSafeFetch('legacy-dashboards'), []);
 
   const [isError, setIsError] = useState(false);
 
@@ -47,6 +47,7 @@ const Graph: FC<Props> = ({
     <QueryBrowser
       customDataSource={customDataSource}
       defaultSamples={DEFAULT_GRAPH_SAMPLES}
+      feature="legacy-dashboards"
       fixedEndTime={endTime}
       formatSeriesTitle={formatSeriesTitle}
       hideControls
@@ -113,7 +113,7 @@ const SingleStat: FC<Props> = ({ customDataSource, namespace, panel, pollInterva
   const [value, setValue] = useState<string>();
 
   // eslint-disable-next-line react-hooks/exhaustive-deps
-  const safeFetch = useCallback(useSafeFetch(), []);
+  const safeFetch = useCallback(useSafeFetch('legacy-dashboards'), []);
 
   const url = buildPrometheusUrl({
     prometheusUrlProps: {
@@ -71,7 +71,7 @@ const Table: FC<Props> = ({ customDataSource, panel, pollInterval, queries, name
   const onSort = (e, index: ISortBy['index'], direction: ISortBy['direction']) =>
     setSortBy({ index, direction });
   // eslint-disable-next-line react-hooks/exhaustive-deps
-  const safeFetch = useCallback(useSafeFetch(), []);
+  const safeFetch = useCallback(useSafeFetch('legacy-dashboards'), []);
 
   const tick = () => {
     if (accessCheckLoading) {
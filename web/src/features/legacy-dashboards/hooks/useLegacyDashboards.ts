@@ -20,7 +20,7 @@ export const useLegacyDashboards = (urlBoard: string) => {
   const { project } = useLegacyDashboardsProject(urlBoard);
 
   // eslint-disable-next-line react-hooks/exhaustive-deps
-  const safeFetch = useCallback(useSafeFetch(), []);
+  const safeFetch = useCallback(useSafeFetch('legacy-dashboards'), []);
   // eslint-disable-next-line @typescript-eslint/no-explicit-any
   const [unfilteredLegacyDashboards, setUnfilteredLegacyDashboards] = useState<any>([]);
   const [legacyDashboardsError, setLegacyDashboardsError] = useState<string>();
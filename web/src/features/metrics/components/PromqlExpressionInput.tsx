@@ -340,7 +340,7 @@ export const PromQLExpressionInput: FC<PromQLExpressionInputProps> = ({
   const placeholder = t('Expression (press Shift+Enter for newlines)');
 
   // eslint-disable-next-line react-hooks/exhaustive-deps
-  const safeFetch = useCallback(useSafeFetch(), []);
+  const safeFetch = useCallback(useSafeFetch('metrics'), []);
 
   useEffect(() => {
     if (accessCheckLoading) {
@@ -613,7 +613,7 @@ const QueryTable: FC<QueryTableProps> = ({ index, namespace, customDatasource, u
   );
 
   // eslint-disable-next-line react-hooks/exhaustive-deps
-  const safeFetch = useCallback(useSafeFetch(), []);
+  const safeFetch = useCallback(useSafeFetch('metrics'), []);
 
   // If the namespace is defined getPrometheusURL will use
   // the PROMETHEUS_TENANCY_BASE_PATH for requests in the developer view
@@ -1171,6 +1171,7 @@ const QueryBrowserWrapper: FC<{
     <QueryBrowser
       customDataSource={customDataSource}
       disabledSeries={disabledSeries}
+      feature="metrics"
       queries={queryStrings}
       units={units}
       showStackedControl
@@ -695,7 +695,7 @@ const TargetsPage_: FC = () => {
   });
 
   // eslint-disable-next-line react-hooks/exhaustive-deps
-  const safeFetch = useCallback(useSafeFetch(), []);
+  const safeFetch = useCallback(useSafeFetch('targets'), []);
 
   const tick = () =>
     safeFetch<PrometheusTargetsResponse>(
@@ -75,7 +75,7 @@ import {
 } from '@/shared/console/utils/datetime';
 import { usePoll } from '@/shared/console/utils/poll-hook';
 import { useRefWidth } from '@/shared/console/utils/ref-width-hook';
-import { useSafeFetch } from '@/shared/console/utils/safe-fetch-hook';
+import { QueryFeature, useSafeFetch } from '@/shared/console/utils/safe-fetch-hook';
 import { DataTestIDs } from '@/shared/constants/data-test';
 import { useBoolean } from '@/shared/hooks/useBoolean';
 import { useMonitoring } from '@/shared/hooks/useMonitoring';
@@ -591,6 +591,7 @@ const QueryBrowser_: FC<QueryBrowserProps> = ({
   defaultTimespan = parsePrometheusDuration('30m'),
   disabledSeries,
   disableZoom,
+  feature,
   filterLabels,
   fixedEndTime,
   formatSeriesTitle,
@@ -651,7 +652,7 @@ const QueryBrowser_: FC<QueryBrowserProps> = ({
 
   const endTime = xDomain?.[1];
 
-  const safeFetch = useSafeFetch();
+  const safeFetch = useSafeFetch(feature);
 
   const [isStacked, setIsStacked] = useState(isStack);
   const [showDisconnectedValues, setIsShowDisconnectedValues] = useState(false);
@@ -1110,6 +1111,7 @@ export type QueryBrowserProps = {
   defaultTimespan?: number;
   disabledSeries?: PrometheusLabels[][];
   disableZoom?: boolean;
+  feature?: QueryFeature;
   filterLabels?: PrometheusLabels;
   fixedEndTime?: number;
   formatSeriesTitle?: FormatSeriesTitle;
@@ -4,7 +4,13 @@ import { useEffect, useRef } from 'react';
 // Disable client-side timeout (-1) to let the backend control query timeouts
 const NO_TIMEOUT = -1;
 
-export const useSafeFetch = () => {
+// Identifies the feature issuing a query, so that the backend applies the
+// query limits of the feature
+export const QUERY_FEATURE_HEADER = 'X-Monitoring-Plugin-Feature';
+
+export type QueryFeature = 'alerting' | 'incidents' | 'legacy-dashboards' | 'metrics' | 'targets';
+
+export const useSafeFetch = (feature?: QueryFeature) => {
   const controller = useRef<AbortController>();
   useEffect(() => {
     controller.current = new AbortController();
@@ -12,5 +18,13 @@ export const useSafeFetch = () => {
   }, []);
 
   return <T>(url: string): Promise<T> =>
-    consoleFetchJSON(url, 'GET', { signal: controller.current.signal as AbortSignal }, NO_TIMEOUT);
+    consoleFetchJSON(
+      url,
+      'GET',
+      {
+        signal: controller.current.signal as AbortSignal,
+        ...(feature && { headers: { [QUERY_FEATURE_HEADER]: feature } }),
+      },
+      NO_TIMEOUT,
+    );
 };